@@ -3,6 +3,7 @@ package common
 import (
 	"fmt"
 	"net"
+	"sort"
 
 	"github.com/gogo/protobuf/proto"
 	"godropbox/errors"
@@ -77,6 +78,8 @@ func KglbAddrToFamily(kglbIP *kglb_pb.IP) kglb_pb.AddressFamily {
 	}
 }
 
+// Compares upstreams ignoring weight and hostname, connection thresholds are
+// compared as well.
 func UpstreamsEqual(s, o *kglb_pb.UpstreamState) bool {
 	s = proto.Clone(s).(*kglb_pb.UpstreamState)
 	o = proto.Clone(o).(*kglb_pb.UpstreamState)
@@ -87,10 +90,65 @@ func UpstreamsEqual(s, o *kglb_pb.UpstreamState) bool {
 	return proto.Equal(s, o)
 }
 
+// Compares all attributes of services including scheduler, flags and
+// persistence settings. Order and duplicates of flags are ignored.
 func IPVSServicesEqual(s, o *kglb_pb.IpvsService) bool {
+	if s == nil || o == nil {
+		return s == o
+	}
+	s = proto.Clone(s).(*kglb_pb.IpvsService)
+	o = proto.Clone(o).(*kglb_pb.IpvsService)
+	s.Flags = NormalizeIpvsFlags(s.Flags)
+	o.Flags = NormalizeIpvsFlags(o.Flags)
 	return proto.Equal(s, o)
 }
 
+// Returns prefix length of full address of the service, it's persistence
+// netmask when it's not set (32 for ipv4 and 128 for ipv6).
+func FullPersistenceNetmask(service *kglb_pb.IpvsService) uint32 {
+	family := kglb_pb.AddressFamily_AF_INET
+	switch attr := service.GetAttributes().(type) {
+	case *kglb_pb.IpvsService_TcpAttributes:
+		family = KglbAddrToFamily(attr.TcpAttributes.GetAddress())
+	case *kglb_pb.IpvsService_UdpAttributes:
+		family = KglbAddrToFamily(attr.UdpAttributes.GetAddress())
+	case *kglb_pb.IpvsService_FwmarkAttributes:
+		family = attr.FwmarkAttributes.GetAddressFamily()
+	}
+	if family == kglb_pb.AddressFamily_AF_INET6 {
+		return 128
+	}
+	return 32
+}
+
+// Returns copy of the service with defaults resolved into values reported
+// by ipvs, so it can be compared with the service read from the kernel.
+func NormalizeIpvsService(service *kglb_pb.IpvsService) *kglb_pb.IpvsService {
+	result := proto.Clone(service).(*kglb_pb.IpvsService)
+	result.Flags = NormalizeIpvsFlags(result.Flags)
+	if result.PersistenceNetmask == 0 {
+		result.PersistenceNetmask = FullPersistenceNetmask(result)
+	}
+	return result
+}
+
+// Returns sorted flags without duplicates (IPVS reports flags in ascending
+// order).
+func NormalizeIpvsFlags(flags []kglb_pb.IpvsService_Flag) []kglb_pb.IpvsService_Flag {
+	if len(flags) == 0 {
+		return nil
+	}
+	result := append([]kglb_pb.IpvsService_Flag{}, flags...)
+	sort.Slice(result, func(i, j int) bool { return result[i] < result[j] })
+	unique := result[:1]
+	for _, flag := range result[1:] {
+		if flag != unique[len(unique)-1] {
+			unique = append(unique, flag)
+		}
+	}
+	return unique
+}
+
 func GetIpvsServiceFromLbService(
 	lbService *kglb_pb.LoadBalancerService) (*kglb_pb.IpvsService, error) {
 
@@ -173,6 +231,11 @@ func GetKeyFromLbService(lb *kglb_pb.LoadBalancerService) (string, error) {
 		return "", err
 	}
 
+	return GetKeyFromIpvsService(ipvsService)
+}
+
+// Returns identity of ipvs service based on vip, port and protocol or fwmark.
+func GetKeyFromIpvsService(ipvsService *kglb_pb.IpvsService) (string, error) {
 	// extract vip, port, fwmark
 	switch attr := ipvsService.Attributes.(type) {
 	case *kglb_pb.IpvsService_TcpAttributes:
@@ -1,6 +1,7 @@
 package common
 
 import (
+	"github.com/gogo/protobuf/proto"
 	. "gopkg.in/check.v1"
 
 	kglb_pb "dropbox/proto/kglb"
@@ -74,3 +75,56 @@ func (s *DataTypesSuite) TestGetKeyFromLbService(c *C) {
 	c.Assert(err, IsNil)
 	c.Assert(key, Equals, "fwmark:1010:v4")
 }
+
+func (s *DataTypesSuite) TestIPVSServicesEqual(c *C) {
+	service := &kglb_pb.IpvsService{
+		Attributes: &kglb_pb.IpvsService_TcpAttributes{
+			TcpAttributes: &kglb_pb.IpvsTcpAttributes{
+				Address: &kglb_pb.IP{Address: &kglb_pb.IP_Ipv4{Ipv4: "172.0.0.1"}},
+				Port:    443,
+			}},
+		Flags:               []kglb_pb.IpvsService_Flag{kglb_pb.IpvsService_PERSISTENT},
+		PersistenceTimeoutS: 300,
+	}
+	other := proto.Clone(service).(*kglb_pb.IpvsService)
+	c.Assert(IPVSServicesEqual(service, other), Equals, true)
+
+	other.PersistenceTimeoutS = 600
+	c.Assert(IPVSServicesEqual(service, other), Equals, false)
+
+	// order and duplicates of flags are ignored.
+	other = proto.Clone(service).(*kglb_pb.IpvsService)
+	service.Flags = []kglb_pb.IpvsService_Flag{
+		kglb_pb.IpvsService_ONEPACKET,
+		kglb_pb.IpvsService_PERSISTENT,
+		kglb_pb.IpvsService_ONEPACKET,
+	}
+	other.Flags = []kglb_pb.IpvsService_Flag{
+		kglb_pb.IpvsService_PERSISTENT,
+		kglb_pb.IpvsService_ONEPACKET,
+	}
+	c.Assert(IPVSServicesEqual(service, other), Equals, true)
+	c.Assert(service.Flags, HasLen, 3)
+	other.Flags = []kglb_pb.IpvsService_Flag{kglb_pb.IpvsService_PERSISTENT}
+	c.Assert(IPVSServicesEqual(service, other), Equals, false)
+}
+
+func (s *DataTypesSuite) TestUpstreamsEqual(c *C) {
+	upstream := &kglb_pb.UpstreamState{
+		Hostname:   "host1",
+		Port:       443,
+		Address:    &kglb_pb.IP{Address: &kglb_pb.IP_Ipv4{Ipv4: "10.0.0.1"}},
+		Weight:     100,
+		UThreshold: 1000,
+	}
+	other := proto.Clone(upstream).(*kglb_pb.UpstreamState)
+	other.Hostname = ""
+	other.Weight = 50
+	c.Assert(UpstreamsEqual(upstream, other), Equals, true)
+
+	other.UThreshold = 2000
+	c.Assert(UpstreamsEqual(upstream, other), Equals, false)
+	other.UThreshold = 1000
+	other.LThreshold = 500
+	c.Assert(UpstreamsEqual(upstream, other), Equals, false)
+}
@@ -81,6 +81,12 @@ func PrettyDynamicRoute(routing *kglb_pb.DynamicRoute) (string, error) {
 			strings.Replace(bgp.GetCommunity(), " ", ",", -1),
 			KglbAddrToNetIp(bgp.GetPrefix()),
 			bgp.GetPrefixlen()), nil
+	case *kglb_pb.DynamicRoute_L2Attributes:
+		l2 := routing.GetL2Attributes()
+		return fmt.Sprintf(
+			"l2 %s %s",
+			l2.GetInterface(),
+			KglbAddrToNetIp(l2.GetAddress())), nil
 	default:
 		return "", errors.Newf("Unknown routing attribute: %+v", routing)
 	}
@@ -100,6 +106,9 @@ func PrettyUpstreamState(up *kglb_pb.UpstreamState) (string, error) {
 	if err != nil {
 		return "", err
 	}
+	if tunnel := up.GetTunnel(); tunnel != nil {
+		fw += "/" + tunnel.GetType().String()
+	}
 	return fmt.Sprintf(
 		"%s|%s:%d %s %d",
 		up.GetHostname(),
@@ -154,6 +163,8 @@ func PrettyForwardMethod(fw kglb_pb.ForwardMethods) (string, error) {
 		return "Tunnel", nil
 	case kglb_pb.ForwardMethods_MASQ:
 		return "Masq", nil
+	case kglb_pb.ForwardMethods_DR:
+		return "Route", nil
 	default:
 		return "", errors.Newf("unknown ForwardMethod: %v", fw)
 	}
@@ -168,6 +179,28 @@ func PrettyIpvsScheduler(scheduler kglb_pb.IpvsService_Scheduler) (string, error
 		return "wrr", nil
 	case kglb_pb.IpvsService_IP_VS_SCH:
 		return "ip_vs_sch", nil
+	case kglb_pb.IpvsService_LC:
+		return "lc", nil
+	case kglb_pb.IpvsService_WLC:
+		return "wlc", nil
+	case kglb_pb.IpvsService_SH:
+		return "sh", nil
+	case kglb_pb.IpvsService_MH:
+		return "mh", nil
+	case kglb_pb.IpvsService_SED:
+		return "sed", nil
+	case kglb_pb.IpvsService_NQ:
+		return "nq", nil
+	case kglb_pb.IpvsService_LBLC:
+		return "lblc", nil
+	case kglb_pb.IpvsService_LBLCR:
+		return "lblcr", nil
+	case kglb_pb.IpvsService_DH:
+		return "dh", nil
+	case kglb_pb.IpvsService_FO:
+		return "fo", nil
+	case kglb_pb.IpvsService_OVF:
+		return "ovf", nil
 	default:
 		return "", errors.Newf("unknown kglb ipvs scheduler: %v", scheduler)
 	}
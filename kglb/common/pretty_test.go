@@ -15,6 +15,9 @@ func (m *PrettySuite) TestPrettyScheduler(c *C) {
 	pretty, err := PrettyIpvsScheduler(kglb_pb.IpvsService_RR)
 	c.Assert(err, IsNil)
 	c.Assert(pretty, Equals, "rr")
+	pretty, err = PrettyIpvsScheduler(kglb_pb.IpvsService_LBLCR)
+	c.Assert(err, IsNil)
+	c.Assert(pretty, Equals, "lblcr")
 }
 
 func (m *PrettySuite) TestPrettyForwardMethod(c *C) {
@@ -33,6 +36,20 @@ func (m *PrettySuite) TestPrettyUpstreamState(c *C) {
 	})
 	c.Assert(err, IsNil)
 	c.Assert(pretty, Equals, "hostname1|10.0.0.1:443 Tunnel 50")
+
+	pretty, err = PrettyUpstreamState(&kglb_pb.UpstreamState{
+		Address:       &kglb_pb.IP{Address: &kglb_pb.IP_Ipv4{Ipv4: "10.0.0.1"}},
+		Port:          443,
+		Hostname:      "hostname1",
+		Weight:        50,
+		ForwardMethod: kglb_pb.ForwardMethods_TUNNEL,
+		Tunnel: &kglb_pb.TunnelEncapsulation{
+			Type: kglb_pb.TunnelEncapsulation_GUE,
+			Port: 6080,
+		},
+	})
+	c.Assert(err, IsNil)
+	c.Assert(pretty, Equals, "hostname1|10.0.0.1:443 Tunnel/GUE 50")
 }
 
 func (m *PrettySuite) TestPrettyLoadBalancerService(c *C) {
@@ -53,58 +53,52 @@ var (
 		},
 	}
 
-	// Comparable implementation for BgpRoutingAttributes.
+	// Comparable implementation for BgpRoutingAttributes, route is identified
+	// by peer and prefix, so change of path attributes re-advertises it.
 	BgpRoutingAttributesComparable = &comparable.ComparableImpl{
-		KeyFunc: func(item interface{}) string {
-			attr := item.(*kglb_pb.BgpRouteAttributes)
-			// construct a key.
-			community := strings.Replace(
-				attr.GetCommunity(),
-				",",
-				" ",
-				-1)
-			return fmt.Sprintf(
-				"%d:%d:%s:%v:%v",
-				attr.GetLocalAsn(),
-				attr.GetPeerAsn(),
-				community,
-				attr.GetPrefix().String(),
-				attr.GetPrefixlen())
-		},
+		KeyFunc: bgpRouteAttributesKey,
 		EqualFunc: func(item1, item2 interface{}) bool {
 			// compare items.
 			attr1 := item1.(*kglb_pb.BgpRouteAttributes)
-			// construct a key.
-			community1 := strings.Replace(
-				attr1.GetCommunity(),
-				",",
-				" ",
-				-1)
 			attr2 := item2.(*kglb_pb.BgpRouteAttributes)
-			// construct a key.
-			community2 := strings.Replace(
-				attr2.GetCommunity(),
-				",",
-				" ",
-				-1)
-			return attr1.GetLocalAsn() == attr2.GetLocalAsn() &&
-				attr1.GetPeerAsn() == attr2.GetPeerAsn() &&
-				community1 == community2 &&
-				attr1.GetPrefix().String() == attr2.GetPrefix().String() &&
-				attr1.GetPrefixlen() == attr2.GetPrefixlen()
+			return bgpRouteAttributesKey(attr1) == bgpRouteAttributesKey(attr2) &&
+				bgpPathAttributesKey(attr1) == bgpPathAttributesKey(attr2)
+		},
+	}
+
+	// Comparable implementation for L2RouteAttributes.
+	L2RouteAttributesComparable = &comparable.ComparableImpl{
+		KeyFunc: l2RouteAttributesKey,
+		EqualFunc: func(item1, item2 interface{}) bool {
+			// all attributes are part of the key.
+			return l2RouteAttributesKey(item1) == l2RouteAttributesKey(item2)
 		},
 	}
 
 	// Comparable implementation for DynamicRouting.
 	DynamicRoutingComparable = &comparable.ComparableImpl{
 		KeyFunc: func(item interface{}) string {
+			route := item.(*kglb_pb.DynamicRoute)
+			if route.GetL2Attributes() != nil {
+				return L2RouteAttributesComparable.KeyFunc(
+					route.GetL2Attributes())
+			}
 			return BgpRoutingAttributesComparable.KeyFunc(
-				item.(*kglb_pb.DynamicRoute).GetBgpAttributes())
+				route.GetBgpAttributes())
 		},
 		EqualFunc: func(item1, item2 interface{}) bool {
+			route1 := item1.(*kglb_pb.DynamicRoute)
+			route2 := item2.(*kglb_pb.DynamicRoute)
+			if route1.GetL2Attributes() != nil || route2.GetL2Attributes() != nil {
+				return route1.GetL2Attributes() != nil &&
+					route2.GetL2Attributes() != nil &&
+					L2RouteAttributesComparable.EqualFunc(
+						route1.GetL2Attributes(),
+						route2.GetL2Attributes())
+			}
 			return BgpRoutingAttributesComparable.EqualFunc(
-				item1.(*kglb_pb.DynamicRoute).GetBgpAttributes(),
-				item2.(*kglb_pb.DynamicRoute).GetBgpAttributes())
+				route1.GetBgpAttributes(),
+				route2.GetBgpAttributes())
 		},
 	}
 
@@ -119,8 +113,14 @@ var (
 			balancer1 := item1.(*kglb_pb.BalancerState)
 			balancer2 := item2.(*kglb_pb.BalancerState)
 
-			// compare just name and reals since key equality means equality of
-			// LoadBalancerService.
+			// key equality means equality of identity of LoadBalancerService
+			// only, so its mutable attributes are compared as well.
+			if !LoadBalancerServiceComparable.Equal(
+				balancer1.GetLbService(),
+				balancer2.GetLbService()) {
+
+				return false
+			}
 
 			//compare reals
 			realsDiff := CompareUpstreamState(
@@ -134,16 +134,26 @@ var (
 
 	// Comparable implementation for LoadBalancerService.
 	LoadBalancerServiceComparable = &comparable.ComparableImpl{
-		// all fields of the LoadBalancerService proto is part of key.
+		// key is identity of the service (vip, port, protocol or fwmark),
+		// other attributes (scheduler, flags, etc) can be changed in place.
 		KeyFunc: func(item interface{}) string {
 			srv := item.(*kglb_pb.LoadBalancerService)
-			return srv.String()
+			key, err := GetKeyFromLbService(srv)
+			if err != nil {
+				return srv.String()
+			}
+			return key
 		},
 
 		EqualFunc: func(item1, item2 interface{}) bool {
-			return proto.Equal(
-				item1.(*kglb_pb.LoadBalancerService),
-				item2.(*kglb_pb.LoadBalancerService))
+			srv1 := item1.(*kglb_pb.LoadBalancerService)
+			srv2 := item2.(*kglb_pb.LoadBalancerService)
+			ipvsService1, err1 := GetIpvsServiceFromLbService(srv1)
+			ipvsService2, err2 := GetIpvsServiceFromLbService(srv2)
+			if err1 != nil || err2 != nil {
+				return proto.Equal(srv1, srv2)
+			}
+			return IPVSServicesEqual(ipvsService1, ipvsService2)
 		},
 	}
 
@@ -183,8 +193,78 @@ var (
 				item2.(*kglb_pb.LinkAddress))
 		},
 	}
+
+	// kernel runs single sync daemon of each role, so role is the key.
+	IpvsSyncDaemonComparable = &comparable.ComparableImpl{
+		KeyFunc: func(item interface{}) string {
+			return item.(*kglb_pb.IpvsSyncDaemon).GetRole().String()
+		},
+
+		EqualFunc: func(item1, item2 interface{}) bool {
+			return proto.Equal(
+				item1.(*kglb_pb.IpvsSyncDaemon),
+				item2.(*kglb_pb.IpvsSyncDaemon))
+		},
+	}
+
+	// Comparable implementation for VrrpInstance, vrid is unique per address
+	// family.
+	VrrpInstanceComparable = &comparable.ComparableImpl{
+		KeyFunc: func(item interface{}) string {
+			instance := item.(*kglb_pb.VrrpInstance)
+			family := "ipv4"
+			if len(instance.GetAddresses()) > 0 &&
+				instance.GetAddresses()[0].GetIpv6() != "" {
+
+				family = "ipv6"
+			}
+			return fmt.Sprintf("%s/%d", family, instance.GetVrid())
+		},
+
+		EqualFunc: func(item1, item2 interface{}) bool {
+			return proto.Equal(
+				item1.(*kglb_pb.VrrpInstance),
+				item2.(*kglb_pb.VrrpInstance))
+		},
+	}
 )
 
+func bgpRouteAttributesKey(item interface{}) string {
+	attr := item.(*kglb_pb.BgpRouteAttributes)
+	return fmt.Sprintf(
+		"%d:%d:%v:%v",
+		attr.GetLocalAsn(),
+		attr.GetPeerAsn(),
+		attr.GetPrefix().String(),
+		attr.GetPrefixlen())
+}
+
+// Returns key of path attributes of bgp route.
+func bgpPathAttributesKey(attr *kglb_pb.BgpRouteAttributes) string {
+	community := strings.Replace(
+		attr.GetCommunity(),
+		",",
+		" ",
+		-1)
+	return fmt.Sprintf(
+		"community=%s:med=%v:local_pref=%v:prepend=%d:large=%s:extended=%s:next_hop=%v",
+		community,
+		attr.GetMed(),
+		attr.GetLocalPref(),
+		attr.GetAsPathPrepend(),
+		strings.Join(attr.GetLargeCommunities(), " "),
+		strings.Join(attr.GetExtendedCommunities(), " "),
+		attr.GetNextHop().String())
+}
+
+func l2RouteAttributesKey(item interface{}) string {
+	attr := item.(*kglb_pb.L2RouteAttributes)
+	return fmt.Sprintf(
+		"l2:%v:%s",
+		attr.GetAddress().String(),
+		attr.GetInterface())
+}
+
 func CompareDynamicRouting(
 	oldSet,
 	newSet []*kglb_pb.DynamicRoute) *comparable.ComparableResult {
@@ -314,3 +394,59 @@ func LinkAddressStateConvBack(set []interface{}) []*kglb_pb.LinkAddress {
 
 	return setConv
 }
+
+func CompareIpvsSyncDaemons(
+	oldSet,
+	newSet []*kglb_pb.IpvsSyncDaemon) *comparable.ComparableResult {
+
+	return comparable.CompareArrays(
+		IpvsSyncDaemonConv(oldSet),
+		IpvsSyncDaemonConv(newSet),
+		IpvsSyncDaemonComparable)
+}
+
+func IpvsSyncDaemonConv(set []*kglb_pb.IpvsSyncDaemon) []interface{} {
+	setConv := make([]interface{}, len(set))
+	for i, val := range set {
+		setConv[i] = val
+	}
+
+	return setConv
+}
+
+func IpvsSyncDaemonConvBack(set []interface{}) []*kglb_pb.IpvsSyncDaemon {
+	setConv := make([]*kglb_pb.IpvsSyncDaemon, len(set))
+	for i, val := range set {
+		setConv[i] = val.(*kglb_pb.IpvsSyncDaemon)
+	}
+
+	return setConv
+}
+
+func CompareVrrpInstances(
+	oldSet,
+	newSet []*kglb_pb.VrrpInstance) *comparable.ComparableResult {
+
+	return comparable.CompareArrays(
+		VrrpInstanceConv(oldSet),
+		VrrpInstanceConv(newSet),
+		VrrpInstanceComparable)
+}
+
+func VrrpInstanceConv(set []*kglb_pb.VrrpInstance) []interface{} {
+	setConv := make([]interface{}, len(set))
+	for i, val := range set {
+		setConv[i] = val
+	}
+
+	return setConv
+}
+
+func VrrpInstanceConvBack(set []interface{}) []*kglb_pb.VrrpInstance {
+	setConv := make([]*kglb_pb.VrrpInstance, len(set))
+	for i, val := range set {
+		setConv[i] = val.(*kglb_pb.VrrpInstance)
+	}
+
+	return setConv
+}
@@ -542,3 +542,140 @@ func (m *ComparatorsSuite) TestBgpRouting(c *C) {
 	c.Assert(len(diff.Unchanged), Equals, 1)
 	c.Assert(len(diff.Changed), Equals, 0)
 }
+
+func (m *ComparatorsSuite) TestL2Routing(c *C) {
+	bgpRoute := &kglb_pb.DynamicRoute{
+		Attributes: &kglb_pb.DynamicRoute_BgpAttributes{
+			BgpAttributes: &kglb_pb.BgpRouteAttributes{
+				LocalAsn:  1000,
+				PeerAsn:   1000,
+				Community: "10000:10000",
+				Prefix: &kglb_pb.IP{
+					Address: &kglb_pb.IP_Ipv4{Ipv4: "172.0.0.1"},
+				},
+				Prefixlen: 32,
+			},
+		},
+	}
+	l2Route := func(iface string) *kglb_pb.DynamicRoute {
+		return &kglb_pb.DynamicRoute{
+			Attributes: &kglb_pb.DynamicRoute_L2Attributes{
+				L2Attributes: &kglb_pb.L2RouteAttributes{
+					Address: &kglb_pb.IP{
+						Address: &kglb_pb.IP_Ipv4{Ipv4: "172.0.0.1"},
+					},
+					Interface: iface,
+				},
+			},
+		}
+	}
+
+	diff := CompareDynamicRouting(
+		[]*kglb_pb.DynamicRoute{bgpRoute, l2Route("eth0")},
+		[]*kglb_pb.DynamicRoute{bgpRoute, l2Route("eth0")})
+	c.Assert(diff.IsChanged(), Equals, false)
+	c.Assert(len(diff.Unchanged), Equals, 2)
+
+	// switching from bgp to l2 announcement of the same address.
+	diff = CompareDynamicRouting(
+		[]*kglb_pb.DynamicRoute{bgpRoute},
+		[]*kglb_pb.DynamicRoute{l2Route("eth0")})
+	c.Assert(len(diff.Added), Equals, 1)
+	c.Assert(len(diff.Deleted), Equals, 1)
+
+	diff = CompareDynamicRouting(
+		[]*kglb_pb.DynamicRoute{l2Route("eth0")},
+		[]*kglb_pb.DynamicRoute{l2Route("eth1")})
+	c.Assert(len(diff.Added), Equals, 1)
+	c.Assert(len(diff.Deleted), Equals, 1)
+}
+
+func (m *ComparatorsSuite) TestLoadBalancerServiceAttributes(c *C) {
+	service := func(scheduler kglb_pb.IpvsService_Scheduler) *kglb_pb.LoadBalancerService {
+		return &kglb_pb.LoadBalancerService{
+			Service: &kglb_pb.LoadBalancerService_IpvsService{
+				IpvsService: &kglb_pb.IpvsService{
+					Attributes: &kglb_pb.IpvsService_TcpAttributes{
+						TcpAttributes: &kglb_pb.IpvsTcpAttributes{
+							Address: &kglb_pb.IP{Address: &kglb_pb.IP_Ipv4{Ipv4: "172.0.0.1"}},
+							Port:    443,
+						},
+					},
+					Scheduler: scheduler,
+				},
+			},
+		}
+	}
+
+	// change of scheduler doesn't change identity of the service.
+	diff := CompareLoadBalancerService(
+		[]*kglb_pb.LoadBalancerService{service(kglb_pb.IpvsService_RR)},
+		[]*kglb_pb.LoadBalancerService{service(kglb_pb.IpvsService_WLC)})
+	c.Assert(len(diff.Added), Equals, 0)
+	c.Assert(len(diff.Deleted), Equals, 0)
+	c.Assert(len(diff.Changed), Equals, 1)
+
+	// balancer with changed service is changed as well.
+	balancersDiff := CompareBalancerState(
+		[]*kglb_pb.BalancerState{{
+			Name:      "TestName1",
+			LbService: service(kglb_pb.IpvsService_RR),
+		}},
+		[]*kglb_pb.BalancerState{{
+			Name:      "TestName1",
+			LbService: service(kglb_pb.IpvsService_WLC),
+		}})
+	c.Assert(len(balancersDiff.Added), Equals, 0)
+	c.Assert(len(balancersDiff.Deleted), Equals, 0)
+	c.Assert(len(balancersDiff.Changed), Equals, 1)
+}
+
+func (m *ComparatorsSuite) TestBgpRoutingPathAttributes(c *C) {
+	route := &kglb_pb.BgpRouteAttributes{
+		LocalAsn:  1000,
+		PeerAsn:   1000,
+		Community: "10000:10000",
+		Prefix: &kglb_pb.IP{
+			Address: &kglb_pb.IP_Ipv4{Ipv4: "172.0.0.1"},
+		},
+		Prefixlen: 32,
+	}
+	c.Assert(
+		BgpRoutingAttributesComparable.Key(route),
+		Equals,
+		"1000:1000:"+route.GetPrefix().String()+":32")
+
+	// change of path attributes re-advertises the route in place.
+	for _, update := range []func(*kglb_pb.BgpRouteAttributes){
+		func(r *kglb_pb.BgpRouteAttributes) { r.Community = "10000:10001" },
+		func(r *kglb_pb.BgpRouteAttributes) { r.Med = &kglb_pb.UInt32Value{Value: 10} },
+		func(r *kglb_pb.BgpRouteAttributes) { r.Med = &kglb_pb.UInt32Value{Value: 0} },
+		func(r *kglb_pb.BgpRouteAttributes) { r.LocalPref = &kglb_pb.UInt32Value{Value: 200} },
+		func(r *kglb_pb.BgpRouteAttributes) { r.LocalPref = &kglb_pb.UInt32Value{Value: 0} },
+		func(r *kglb_pb.BgpRouteAttributes) { r.AsPathPrepend = 2 },
+		func(r *kglb_pb.BgpRouteAttributes) { r.LargeCommunities = []string{"1000:1:2"} },
+		func(r *kglb_pb.BgpRouteAttributes) { r.ExtendedCommunities = []string{"rt:1000:1"} },
+		func(r *kglb_pb.BgpRouteAttributes) {
+			r.NextHop = &kglb_pb.IP{Address: &kglb_pb.IP_Ipv4{Ipv4: "10.0.0.1"}}
+		},
+	} {
+		updated := *route
+		update(&updated)
+		c.Assert(
+			BgpRoutingAttributesComparable.Key(route),
+			Equals,
+			BgpRoutingAttributesComparable.Key(&updated))
+		c.Assert(BgpRoutingAttributesComparable.Equal(route, &updated), IsFalse)
+
+		diff := CompareDynamicRouting(
+			[]*kglb_pb.DynamicRoute{
+				{Attributes: &kglb_pb.DynamicRoute_BgpAttributes{BgpAttributes: route}},
+			},
+			[]*kglb_pb.DynamicRoute{
+				{Attributes: &kglb_pb.DynamicRoute_BgpAttributes{BgpAttributes: &updated}},
+			})
+		c.Assert(diff.Added, HasLen, 0)
+		c.Assert(diff.Deleted, HasLen, 0)
+		c.Assert(diff.Changed, HasLen, 1)
+	}
+}
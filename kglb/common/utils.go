@@ -1,7 +1,13 @@
 package common
 
 import (
+	"encoding/binary"
+	"net"
+	"strconv"
+	"strings"
+
 	pb "dropbox/proto/kglb"
+	"godropbox/errors"
 )
 
 // Returns alive ratio of set of upstreams.
@@ -21,3 +27,114 @@ func AliveUpstreamsRatio(ups []*pb.UpstreamState) float32 {
 
 	return float32(alive) / float32(all)
 }
+
+// Well-known communities (RFC 1997).
+var bgpWellKnownCommunities = map[string]uint32{
+	"no-export":           0xffffff01,
+	"no-advertise":        0xffffff02,
+	"no-export-subconfed": 0xffffff03,
+}
+
+// Parses space or comma separated standard communities in "asn:value" format
+// or well-known community names.
+func ParseBgpCommunities(communities string) ([]uint32, error) {
+	var result []uint32
+	fields := strings.FieldsFunc(communities, func(r rune) bool {
+		return r == ' ' || r == ','
+	})
+	for _, field := range fields {
+		if community, ok := bgpWellKnownCommunities[strings.ToLower(field)]; ok {
+			result = append(result, community)
+			continue
+		}
+		parts := strings.Split(field, ":")
+		if len(parts) != 2 {
+			return nil, errors.Newf("invalid bgp community: %s", field)
+		}
+		asn, err := strconv.ParseUint(parts[0], 10, 16)
+		if err != nil {
+			return nil, errors.Newf("invalid bgp community: %s", field)
+		}
+		value, err := strconv.ParseUint(parts[1], 10, 16)
+		if err != nil {
+			return nil, errors.Newf("invalid bgp community: %s", field)
+		}
+		result = append(result, uint32(asn<<16|value))
+	}
+	return result, nil
+}
+
+// Parses large community in "global:local1:local2" format.
+func ParseBgpLargeCommunity(community string) ([3]uint32, error) {
+	var result [3]uint32
+	parts := strings.Split(community, ":")
+	if len(parts) != len(result) {
+		return result, errors.Newf("invalid bgp large community: %s", community)
+	}
+	for i, part := range parts {
+		value, err := strconv.ParseUint(part, 10, 32)
+		if err != nil {
+			return result, errors.Newf("invalid bgp large community: %s", community)
+		}
+		result[i] = uint32(value)
+	}
+	return result, nil
+}
+
+// Extended community types (RFC 4360, RFC 5668).
+const (
+	bgpExtCommunityTwoOctetAs  = 0x00
+	bgpExtCommunityIpv4        = 0x01
+	bgpExtCommunityFourOctetAs = 0x02
+
+	bgpExtCommunityRouteTarget = 0x02
+	bgpExtCommunityRouteOrigin = 0x03
+)
+
+// Parses extended community in "rt:administrator:value" or
+// "soo:administrator:value" format, administrator is asn or ipv4 address.
+// Value of 2-octet asn is 4-octet, 2-octet otherwise.
+func ParseBgpExtendedCommunity(community string) (uint64, error) {
+	invalidErr := errors.Newf("invalid bgp extended community: %s", community)
+	// ipv4 administrator doesn't contain colons.
+	parts := strings.Split(community, ":")
+	if len(parts) != 3 {
+		return 0, invalidErr
+	}
+
+	var subtype uint64
+	switch strings.ToLower(parts[0]) {
+	case "rt":
+		subtype = bgpExtCommunityRouteTarget
+	case "soo":
+		subtype = bgpExtCommunityRouteOrigin
+	default:
+		return 0, invalidErr
+	}
+
+	if ip := net.ParseIP(parts[1]).To4(); ip != nil {
+		value, err := strconv.ParseUint(parts[2], 10, 16)
+		if err != nil {
+			return 0, invalidErr
+		}
+		return bgpExtCommunityIpv4<<56 | subtype<<48 |
+			uint64(binary.BigEndian.Uint32(ip))<<16 | value, nil
+	}
+
+	asn, err := strconv.ParseUint(parts[1], 10, 32)
+	if err != nil {
+		return 0, invalidErr
+	}
+	if asn <= 0xffff {
+		value, err := strconv.ParseUint(parts[2], 10, 32)
+		if err != nil {
+			return 0, invalidErr
+		}
+		return bgpExtCommunityTwoOctetAs<<56 | subtype<<48 | asn<<32 | value, nil
+	}
+	value, err := strconv.ParseUint(parts[2], 10, 16)
+	if err != nil {
+		return 0, invalidErr
+	}
+	return bgpExtCommunityFourOctetAs<<56 | subtype<<48 | asn<<16 | value, nil
+}
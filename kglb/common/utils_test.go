@@ -21,3 +21,50 @@ func (s *UtilsSuite) TestAliveUpstreamsRatio(c *C) {
 		{Weight: 0}, {Weight: 0},
 	}), Equals, float32(0))
 }
+
+func (s *UtilsSuite) TestParseBgpCommunities(c *C) {
+	communities, err := ParseBgpCommunities("65001:100, 65002:200 no-export")
+	c.Assert(err, IsNil)
+	c.Assert(communities, DeepEquals, []uint32{
+		65001<<16 | 100,
+		65002<<16 | 200,
+		0xffffff01,
+	})
+
+	communities, err = ParseBgpCommunities("")
+	c.Assert(err, IsNil)
+	c.Assert(communities, HasLen, 0)
+
+	for _, invalid := range []string{"my_community", "65536:1", "1:2:3", "1:-1"} {
+		_, err = ParseBgpCommunities(invalid)
+		c.Assert(err, NotNil)
+	}
+
+	large, err := ParseBgpLargeCommunity("4200000000:1:2")
+	c.Assert(err, IsNil)
+	c.Assert(large, Equals, [3]uint32{4200000000, 1, 2})
+	for _, invalid := range []string{"1:2", "1:2:3:4", "4294967296:1:2", "a:1:2"} {
+		_, err = ParseBgpLargeCommunity(invalid)
+		c.Assert(err, NotNil)
+	}
+
+	extended, err := ParseBgpExtendedCommunity("rt:65001:100000")
+	c.Assert(err, IsNil)
+	c.Assert(extended, Equals, uint64(0x0002fde9000186a0))
+	extended, err = ParseBgpExtendedCommunity("soo:4200000000:100")
+	c.Assert(err, IsNil)
+	c.Assert(extended, Equals, uint64(0x0203fa56ea000064))
+	extended, err = ParseBgpExtendedCommunity("rt:10.0.0.1:100")
+	c.Assert(err, IsNil)
+	c.Assert(extended, Equals, uint64(0x01020a0000010064))
+	for _, invalid := range []string{
+		"rt:65001",
+		"xx:65001:100",
+		"rt:4200000000:65536",
+		"rt:10.0.0.1:65536",
+		"rt:fc00::1:100",
+	} {
+		_, err = ParseBgpExtendedCommunity(invalid)
+		c.Assert(err, NotNil)
+	}
+}
@@ -1,6 +1,7 @@
 package common
 
 import (
+	"fmt"
 	"math"
 	"net"
 	"strings"
@@ -15,6 +16,10 @@ func ValidateDataPlaneState(s *pb.DataPlaneState) error {
 		if err := ValidateBalancerState(b); err != nil {
 			return errors.Wrapf(err, "Invalid BalancerState %+v", b)
 		}
+		err := validateConnectionCleanup(b.GetConnectionCleanup(), s.GetKernelSettings())
+		if err != nil {
+			return errors.Wrapf(err, "Invalid BalancerState %s", b.GetName())
+		}
 	}
 
 	_, err := ValidateLinkAddresses(s.GetLinkAddresses())
@@ -25,6 +30,39 @@ func ValidateDataPlaneState(s *pb.DataPlaneState) error {
 			s.GetLinkAddresses())
 	}
 
+	if err := ValidateKernelSettings(s.GetKernelSettings()); err != nil {
+		return errors.Wrapf(err, "Invalid KernelSettings %+v", s.GetKernelSettings())
+	}
+
+	// kernel runs single daemon of each role.
+	roles := make(map[pb.IpvsSyncDaemon_Role]bool)
+	for _, d := range s.GetSyncDaemons() {
+		if err := ValidateIpvsSyncDaemon(d); err != nil {
+			return errors.Wrapf(err, "Invalid IpvsSyncDaemon %+v", d)
+		}
+		if roles[d.GetRole()] {
+			return errors.Newf("duplicate ipvs sync daemon role: %s", d.GetRole())
+		}
+		roles[d.GetRole()] = true
+	}
+
+	localAsn := &bgpLocalAsn{}
+	for _, r := range s.GetDynamicRoutes() {
+		if err := localAsn.add(r.GetBgpAttributes()); err != nil {
+			return err
+		}
+	}
+
+	groups := newVrrpGroupSet()
+	for _, v := range s.GetVrrpInstances() {
+		if err := ValidateVrrpInstance(v); err != nil {
+			return errors.Wrapf(err, "Invalid VrrpInstance %+v", v)
+		}
+		if err := groups.add(v.GetVrid(), v.GetAddresses()); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
@@ -47,7 +85,10 @@ func ValidateUpstreamState(s *pb.UpstreamState) error {
 		return errors.New("UpstreamState.Hostname cannot be empty")
 	}
 
-	if s.GetPort() == 0 && s.GetForwardMethod() != pb.ForwardMethods_TUNNEL {
+	if s.GetPort() == 0 &&
+		s.GetForwardMethod() != pb.ForwardMethods_TUNNEL &&
+		s.GetForwardMethod() != pb.ForwardMethods_DR {
+
 		return errors.New("UpstreamState.Port cannot be equal 0")
 	}
 
@@ -55,6 +96,14 @@ func ValidateUpstreamState(s *pb.UpstreamState) error {
 		return errors.Wrapf(err, "Invalid UpstreamState.Address %+v", s.GetAddress())
 	}
 
+	if err := validateThresholds(s.GetUThreshold(), s.GetLThreshold()); err != nil {
+		return errors.Wrap(err, "Invalid UpstreamState thresholds: ")
+	}
+
+	if err := validateTunnel(s.GetForwardMethod(), s.GetTunnel()); err != nil {
+		return errors.Wrapf(err, "Invalid UpstreamState.Tunnel %+v", s.GetTunnel())
+	}
+
 	return nil
 }
 
@@ -93,10 +142,18 @@ func ValidateControlPlaneConfig(c *pb.ControlPlaneConfig) error {
 	// ip rules and link addresses which will break tunnelled health checks.
 	fwmarkPerVipMap := make(map[string]bool)
 	names := make(map[string]struct{})
+	localAsn := &bgpLocalAsn{}
 	for _, b := range c.Balancers {
 		if err := ValidateBalancer(b); err != nil {
 			return errors.Wrapf(err, "Invalid BalancerConfig %s", b.GetName())
 		}
+		if err := localAsn.add(b.GetDynamicRouting().GetBgpAttributes()); err != nil {
+			return errors.Wrapf(err, "Invalid BalancerConfig %s", b.GetName())
+		}
+		err := validateConnectionCleanup(b.GetConnectionCleanup(), c.GetKernelSettings())
+		if err != nil {
+			return errors.Wrapf(err, "Invalid BalancerConfig %s", b.GetName())
+		}
 
 		key, err := GetKeyFromLbService(b.GetLbService())
 		if err != nil {
@@ -132,6 +189,26 @@ func ValidateControlPlaneConfig(c *pb.ControlPlaneConfig) error {
 			fwmarkPerVipMap[vip] = fwmarkEnabled
 		}
 	}
+
+	if err := ValidateKernelSettings(c.GetKernelSettings()); err != nil {
+		return errors.Wrapf(err, "Invalid KernelSettings %+v", c.GetKernelSettings())
+	}
+
+	if c.GetIpvsSync() != nil {
+		if err := ValidateIpvsSyncConfig(c.GetIpvsSync()); err != nil {
+			return errors.Wrapf(err, "Invalid IpvsSyncConfig %+v", c.GetIpvsSync())
+		}
+	}
+
+	groups := newVrrpGroupSet()
+	for _, g := range c.GetVrrpGroups() {
+		if err := ValidateVrrpGroupConfig(g); err != nil {
+			return errors.Wrapf(err, "Invalid VrrpGroupConfig %+v", g)
+		}
+		if err := groups.add(g.GetVrid(), g.GetAddresses()); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
@@ -167,6 +244,80 @@ func ValidateBalancer(c *pb.BalancerConfig) error {
 			c.GetDynamicRouting())
 	}
 
+	if err := ValidatePassiveHealth(c.GetPassiveHealth()); err != nil {
+		return errors.Wrapf(err, "Invalid BalancerConfig.PassiveHealth %+v",
+			c.GetPassiveHealth())
+	}
+
+	if err := ValidateSlowStart(c.GetSlowStart()); err != nil {
+		return errors.Wrapf(err, "Invalid BalancerConfig.SlowStart %+v",
+			c.GetSlowStart())
+	}
+
+	if err := ValidateFailsafe(c.GetFailsafe()); err != nil {
+		return errors.Wrapf(err, "Invalid BalancerConfig.Failsafe %+v",
+			c.GetFailsafe())
+	}
+
+	return nil
+}
+
+// Validates optional Failsafe config.
+func ValidateFailsafe(m *pb.Failsafe) error {
+	if m == nil {
+		return nil
+	}
+
+	if _, ok := pb.Failsafe_Mode_name[int32(m.GetMode())]; !ok {
+		return errors.Newf("Unknown Mode: %v", m.GetMode())
+	}
+
+	if m.GetKeepLastCount() > 0 && m.GetMode() != pb.Failsafe_KEEP_LAST_HEALTHY {
+		return errors.Newf(
+			"KeepLastCount is allowed only with KEEP_LAST_HEALTHY mode: %v",
+			m.GetMode())
+	}
+
+	return nil
+}
+
+// Validates optional SlowStart config.
+func ValidateSlowStart(m *pb.SlowStart) error {
+	if m == nil {
+		return nil
+	}
+
+	if m.GetMinWeightPercent() > 100 {
+		return errors.Newf(
+			"MinWeightPercent should not exceed 100: %d",
+			m.GetMinWeightPercent())
+	}
+
+	if _, ok := pb.SlowStart_Mode_name[int32(m.GetMode())]; !ok {
+		return errors.Newf("Unknown Mode: %v", m.GetMode())
+	}
+
+	return nil
+}
+
+// Validates optional PassiveHealth config.
+func ValidatePassiveHealth(m *pb.PassiveHealth) error {
+	if m == nil {
+		return nil
+	}
+
+	if m.GetOutlierWeightPercent() > 100 {
+		return errors.Newf(
+			"OutlierWeightPercent should not exceed 100: %d",
+			m.GetOutlierWeightPercent())
+	}
+
+	if m.GetMaxOutlierRatio() < 0 || m.GetMaxOutlierRatio() > 1 {
+		return errors.Newf(
+			"MaxOutlierRatio should be in [0, 1] range: %v",
+			m.GetMaxOutlierRatio())
+	}
+
 	return nil
 }
 
@@ -233,6 +384,59 @@ func ValidateIpvsService(m *pb.IpvsService) error {
 		return errors.Newf("Unsupported IpvsService.Service.Attributes type %s", attr)
 	}
 
+	if _, ok := pb.IpvsService_Scheduler_name[int32(m.GetScheduler())]; !ok {
+		return errors.Newf("Unknown Scheduler: %v", m.GetScheduler())
+	}
+
+	for _, flag := range m.GetFlags() {
+		switch flag {
+		case pb.IpvsService_PERSISTENT, pb.IpvsService_ONEPACKET:
+		case pb.IpvsService_SH_FALLBACK, pb.IpvsService_SH_PORT:
+			// scheduler specific flags share the same bits in IPVS.
+			if m.GetScheduler() != pb.IpvsService_SH &&
+				m.GetScheduler() != pb.IpvsService_MH {
+
+				return errors.Newf(
+					"%s flag is supported by SH and MH schedulers only, got %s",
+					flag,
+					m.GetScheduler())
+			}
+		default:
+			return errors.Newf("Unsupported flag: %s", flag)
+		}
+	}
+
+	if m.GetPersistenceTimeoutS() == 0 &&
+		m.GetPersistenceNetmask() == 0 &&
+		m.GetPeName() == "" {
+
+		return nil
+	}
+
+	persistent := false
+	for _, flag := range m.GetFlags() {
+		if flag == pb.IpvsService_PERSISTENT {
+			persistent = true
+		}
+	}
+	if !persistent {
+		return errors.New("Persistence settings require PERSISTENT flag")
+	}
+
+	switch m.GetPeName() {
+	case "", "sip":
+	default:
+		return errors.Newf("Unknown persistence engine: %s", m.GetPeName())
+	}
+
+	maxNetmask := FullPersistenceNetmask(m)
+	if m.GetPersistenceNetmask() > maxNetmask {
+		return errors.Newf(
+			"PersistenceNetmask should be in [0, %d] range, got %d",
+			maxNetmask,
+			m.GetPersistenceNetmask())
+	}
+
 	return nil
 }
 
@@ -279,10 +483,91 @@ func ValidateUpstreamChecker(c *pb.BalancerConfig) error {
 			return errors.Newf("syslog port value is out of bound: %+v", attr)
 		}
 	case *hc_pb.HealthCheckerAttributes_Tcp:
+	case *hc_pb.HealthCheckerAttributes_Exec:
+		if c.GetEnableFwmarks() {
+			return errors.New("exec checker doesn't support fwmarks.")
+		}
+		if !strings.HasPrefix(attr.Exec.GetPath(), "/") {
+			return errors.Newf("exec checker path should be absolute: %+v", attr)
+		}
+	case *hc_pb.HealthCheckerAttributes_Redis:
+	case *hc_pb.HealthCheckerAttributes_Mysql:
+		if attr.Mysql.GetQuery() && attr.Mysql.GetUser() == "" {
+			return errors.Newf("mysql checker requires user to run query: %+v", attr)
+		}
+	case *hc_pb.HealthCheckerAttributes_Postgres:
+		if attr.Postgres.GetUser() == "" {
+			return errors.Newf("postgres checker requires user: %+v", attr)
+		}
+	case *hc_pb.HealthCheckerAttributes_Agent:
+		if int(attr.Agent.GetPort()) > math.MaxUint16 {
+			return errors.Newf("agent port value is out of bound: %+v", attr)
+		}
 	default:
 		return errors.Newf("Unsupported UpstreamChecker attributes %s", attr)
 	}
 
+	if err := ValidateFlapDamping(m.GetFlapDamping()); err != nil {
+		return errors.Wrapf(err, "Invalid UpstreamChecker.FlapDamping %+v",
+			m.GetFlapDamping())
+	}
+
+	if err := ValidateCheckIntervals(m.GetIntervals()); err != nil {
+		return errors.Wrapf(err, "Invalid UpstreamChecker.Intervals %+v",
+			m.GetIntervals())
+	}
+
+	return nil
+}
+
+// Validates optional CheckIntervals config.
+func ValidateCheckIntervals(m *hc_pb.CheckIntervals) error {
+	if m == nil {
+		return nil
+	}
+
+	if m.GetMaxUnhealthyMs() > 0 && m.GetMaxUnhealthyMs() < m.GetUnhealthyMs() {
+		return errors.Newf(
+			"MaxUnhealthyMs should not be less than UnhealthyMs: %d < %d",
+			m.GetMaxUnhealthyMs(),
+			m.GetUnhealthyMs())
+	}
+
+	if m.GetJitterPercent() > 50 {
+		return errors.Newf(
+			"JitterPercent should not exceed 50: %d",
+			m.GetJitterPercent())
+	}
+
+	return nil
+}
+
+// Validates optional FlapDamping config.
+func ValidateFlapDamping(m *hc_pb.FlapDamping) error {
+	if m == nil {
+		return nil
+	}
+
+	if m.GetReuseThreshold() > 0 &&
+		m.GetSuppressThreshold() > 0 &&
+		m.GetReuseThreshold() >= m.GetSuppressThreshold() {
+
+		return errors.Newf(
+			"ReuseThreshold should be less than SuppressThreshold: %d >= %d",
+			m.GetReuseThreshold(),
+			m.GetSuppressThreshold())
+	}
+
+	if m.GetMaxSuppressMs() > 0 &&
+		m.GetHalfLifeMs() > 0 &&
+		m.GetMaxSuppressMs() < m.GetHalfLifeMs() {
+
+		return errors.Newf(
+			"MaxSuppressMs should not be less than HalfLifeMs: %d < %d",
+			m.GetMaxSuppressMs(),
+			m.GetHalfLifeMs())
+	}
+
 	return nil
 }
 
@@ -291,8 +576,185 @@ func ValidateUpstreamRouting(m *pb.UpstreamRouting) error {
 		return errors.New("UpstreamRouting is required")
 	}
 
-	// TODO(verm666): validate UpstreamRouting
+	if _, ok := pb.ForwardMethods_name[int32(m.GetForwardMethod())]; !ok {
+		return errors.Newf("Unknown ForwardMethod: %v", m.GetForwardMethod())
+	}
+
+	if err := validateTunnel(m.GetForwardMethod(), m.GetTunnel()); err != nil {
+		return errors.Wrapf(err, "Invalid UpstreamRouting.Tunnel %+v", m.GetTunnel())
+	}
 
+	if err := ValidateConnectionThresholds(m.GetConnectionThresholds()); err != nil {
+		return errors.Wrapf(err, "Invalid UpstreamRouting.ConnectionThresholds %+v",
+			m.GetConnectionThresholds())
+	}
+
+	for hostname, thresholds := range m.GetHostConnectionThresholds() {
+		if len(hostname) == 0 {
+			return errors.New(
+				"UpstreamRouting.HostConnectionThresholds hostname cannot be empty")
+		}
+		if thresholds == nil {
+			return errors.Newf(
+				"UpstreamRouting.HostConnectionThresholds of %s cannot be empty",
+				hostname)
+		}
+		if err := ValidateConnectionThresholds(thresholds); err != nil {
+			return errors.Wrapf(
+				err,
+				"Invalid UpstreamRouting.HostConnectionThresholds of %s %+v",
+				hostname,
+				thresholds)
+		}
+	}
+
+	return nil
+}
+
+// Validates tunnel encapsulation, nil value means IPIP.
+func ValidateTunnelEncapsulation(m *pb.TunnelEncapsulation) error {
+	switch m.GetType() {
+	case pb.TunnelEncapsulation_IPIP:
+		if m.GetPort() != 0 || m.GetCsum() || m.GetRemcsum() {
+			return errors.New("IPIP encapsulation doesn't support port and flags")
+		}
+	case pb.TunnelEncapsulation_GUE:
+		if m.GetPort() == 0 || m.GetPort() > 65535 {
+			return errors.Newf(
+				"GUE encapsulation requires port in [1, 65535] range, got %d",
+				m.GetPort())
+		}
+	case pb.TunnelEncapsulation_GRE:
+		if m.GetPort() != 0 {
+			return errors.New("GRE encapsulation doesn't support port")
+		}
+		if m.GetRemcsum() {
+			return errors.New("remcsum is supported by GUE encapsulation only")
+		}
+	default:
+		return errors.Newf("Unknown tunnel type: %v", m.GetType())
+	}
+
+	return nil
+}
+
+// Encapsulation is supported by TUNNEL forwarding method only.
+func validateTunnel(fwd pb.ForwardMethods, tunnel *pb.TunnelEncapsulation) error {
+	if tunnel != nil && fwd != pb.ForwardMethods_TUNNEL {
+		return errors.Newf("encapsulation requires TUNNEL forward method, got %s", fwd)
+	}
+	return ValidateTunnelEncapsulation(tunnel)
+}
+
+// Validates connection thresholds, nil value means no thresholds.
+func ValidateConnectionThresholds(m *pb.ConnectionThresholds) error {
+	return validateThresholds(m.GetUpper(), m.GetLower())
+}
+
+// Lower threshold makes sense only with upper one and should be less than it.
+func validateThresholds(upper, lower uint32) error {
+	if lower == 0 {
+		return nil
+	}
+	if upper == 0 {
+		return errors.Newf("lower threshold %d requires upper threshold", lower)
+	}
+	if lower >= upper {
+		return errors.Newf(
+			"lower threshold %d should be less than upper threshold %d",
+			lower,
+			upper)
+	}
+	return nil
+}
+
+// Kernel keeps ipvs timeouts in jiffies, so they are limited to fit int with
+// HZ up to 1000.
+const maxIpvsTimeoutS = math.MaxInt32 / 1000
+
+// Validates kernel settings, nil value means unmanaged settings.
+// Kernel expires IPVS connection entries of deleted reals only with
+// expire_nodest_conn, so flushing of them relies on the setting.
+func validateConnectionCleanup(m *pb.ConnectionCleanup, settings *pb.KernelSettings) error {
+	if m.GetFlushIpvs() && !settings.GetExpireNodestConn().GetValue() {
+		return errors.New(
+			"ConnectionCleanup.FlushIpvs requires KernelSettings.ExpireNodestConn")
+	}
+	return nil
+}
+
+func ValidateKernelSettings(m *pb.KernelSettings) error {
+	timeouts := map[string]uint32{
+		"TcpTimeoutS":    m.GetTcpTimeoutS(),
+		"TcpFinTimeoutS": m.GetTcpFinTimeoutS(),
+		"UdpTimeoutS":    m.GetUdpTimeoutS(),
+	}
+	for name, value := range timeouts {
+		if value > maxIpvsTimeoutS {
+			return errors.Newf(
+				"%s %d exceeds max timeout %d", name, value, maxIpvsTimeoutS)
+		}
+	}
+	return nil
+}
+
+func ValidateIpvsSyncConfig(m *pb.IpvsSyncConfig) error {
+	return validateIpvsSync(
+		m.GetInterface(),
+		m.GetSyncId(),
+		m.GetMcastGroup(),
+		m.GetMcastPort(),
+		m.GetMcastTtl(),
+		m.GetSyncMaxlen())
+}
+
+func ValidateIpvsSyncDaemon(m *pb.IpvsSyncDaemon) error {
+	if _, ok := pb.IpvsSyncDaemon_Role_name[int32(m.GetRole())]; !ok {
+		return errors.Newf("unknown role: %d", m.GetRole())
+	}
+	return validateIpvsSync(
+		m.GetInterface(),
+		m.GetSyncId(),
+		m.GetMcastGroup(),
+		m.GetMcastPort(),
+		m.GetMcastTtl(),
+		m.GetSyncMaxlen())
+}
+
+// Validates attributes of ipvs sync daemon, zero values of optional
+// attributes mean kernel defaults.
+func validateIpvsSync(
+	iface string,
+	syncId uint32,
+	group *pb.IP,
+	port, ttl, maxlen uint32) error {
+
+	// IFNAMSIZ includes terminating null.
+	if len(iface) == 0 || len(iface) >= 16 {
+		return errors.Newf("invalid interface: '%s'", iface)
+	}
+	if syncId > math.MaxUint8 {
+		return errors.Newf("sync_id %d exceeds %d", syncId, math.MaxUint8)
+	}
+	if group != nil {
+		if err := ValidateIP(group); err != nil {
+			return errors.Wrapf(err, "Invalid mcast_group %+v", group)
+		}
+		if !KglbAddrToNetIp(group).IsMulticast() {
+			return errors.Newf(
+				"mcast_group should be multicast address: %s",
+				KglbAddrToNetIp(group))
+		}
+	}
+	if port > math.MaxUint16 {
+		return errors.Newf("invalid mcast_port: %d", port)
+	}
+	if ttl > math.MaxUint8 {
+		return errors.Newf("invalid mcast_ttl: %d", ttl)
+	}
+	if maxlen > math.MaxUint16 {
+		return errors.Newf("invalid sync_maxlen: %d", maxlen)
+	}
 	return nil
 }
 
@@ -337,6 +799,10 @@ func ValidateDynamicRouting(m *pb.DynamicRouting) error {
 		if err := ValidateBgpRouteAttributes(m.GetBgpAttributes()); err != nil {
 			return errors.Wrapf(err, "Invalid DynamicRouting.BgpAttributes")
 		}
+	case *pb.DynamicRouting_L2Attributes:
+		if err := ValidateL2RouteAttributes(m.GetL2Attributes()); err != nil {
+			return errors.Wrapf(err, "Invalid DynamicRouting.L2Attributes")
+		}
 	default:
 		return errors.Newf("Unsupported DynamicRouting.Attributes type %s", attr)
 	}
@@ -344,6 +810,9 @@ func ValidateDynamicRouting(m *pb.DynamicRouting) error {
 	return nil
 }
 
+// max number of additional local asn in as path of the route.
+const maxBgpAsPathPrepend = 32
+
 func ValidateBgpRouteAttributes(m *pb.BgpRouteAttributes) error {
 	if m == nil {
 		return errors.New("Message is empty")
@@ -361,6 +830,10 @@ func ValidateBgpRouteAttributes(m *pb.BgpRouteAttributes) error {
 		return errors.New("BgpRoutingAttributes.Community cannot be empty")
 	}
 
+	// community which isn't a list of standard communities is accepted as
+	// legacy opaque value for bgp modules announcing it as is, embedded bgp
+	// speaker rejects routes with such communities.
+
 	if err := ValidateIP(m.GetPrefix()); err != nil {
 		return errors.Wrapf(err, "Invalid BgpRoutingAttributes.Prefix")
 	}
@@ -369,6 +842,177 @@ func ValidateBgpRouteAttributes(m *pb.BgpRouteAttributes) error {
 		return errors.New("BgpRoutingAttributes.Prefixlen cannot be 0")
 	}
 
+	if m.GetAsPathPrepend() > maxBgpAsPathPrepend {
+		return errors.Newf(
+			"BgpRoutingAttributes.AsPathPrepend cannot exceed %d",
+			maxBgpAsPathPrepend)
+	}
+
+	for _, community := range m.GetLargeCommunities() {
+		if _, err := ParseBgpLargeCommunity(community); err != nil {
+			return errors.Wrapf(err, "Invalid BgpRoutingAttributes.LargeCommunities")
+		}
+	}
+
+	for _, community := range m.GetExtendedCommunities() {
+		if _, err := ParseBgpExtendedCommunity(community); err != nil {
+			return errors.Wrapf(err, "Invalid BgpRoutingAttributes.ExtendedCommunities")
+		}
+	}
+
+	if m.GetNextHop() != nil {
+		if err := ValidateIP(m.GetNextHop()); err != nil {
+			return errors.Wrapf(err, "Invalid BgpRoutingAttributes.NextHop")
+		}
+		prefixIpv4 := KglbAddrToNetIp(m.GetPrefix()).To4() != nil
+		nextHopIpv4 := KglbAddrToNetIp(m.GetNextHop()).To4() != nil
+		if prefixIpv4 != nextHopIpv4 {
+			return errors.New(
+				"BgpRoutingAttributes.NextHop should be of the prefix family")
+		}
+	}
+
+	return nil
+}
+
+// min and max vrrp advertisement intervals, interval is sent in centiseconds
+// as 12-bit value.
+const (
+	minVrrpAdvertIntervalMs = 10
+	maxVrrpAdvertIntervalMs = 0xfff * 10
+)
+
+func ValidateVrrpGroupConfig(m *pb.VrrpGroupConfig) error {
+	return validateVrrp(
+		m.GetVrid(),
+		m.GetInterface(),
+		m.GetAddresses(),
+		m.GetAdvertIntervalMs())
+}
+
+func ValidateVrrpInstance(m *pb.VrrpInstance) error {
+	// 0 and 255 priorities are reserved for master releasing the group and
+	// owner of the addresses.
+	if m.GetPriority() == 0 || m.GetPriority() >= 255 {
+		return errors.Newf("invalid priority: %d", m.GetPriority())
+	}
+	return validateVrrp(
+		m.GetVrid(),
+		m.GetInterface(),
+		m.GetAddresses(),
+		m.GetAdvertIntervalMs())
+}
+
+func validateVrrp(
+	vrid uint32,
+	iface string,
+	addresses []*pb.IP,
+	advertIntervalMs uint32) error {
+
+	if vrid == 0 || vrid > math.MaxUint8 {
+		return errors.Newf("invalid vrid: %d", vrid)
+	}
+	// IFNAMSIZ includes terminating null.
+	if len(iface) == 0 || len(iface) >= 16 {
+		return errors.Newf("invalid interface: '%s'", iface)
+	}
+	if len(addresses) == 0 {
+		return errors.New("addresses cannot be empty")
+	}
+	// advertisement contains count of addresses as 8-bit value.
+	if len(addresses) > math.MaxUint8 {
+		return errors.Newf("too many addresses: %d", len(addresses))
+	}
+	for _, addr := range addresses {
+		if err := ValidateIP(addr); err != nil {
+			return errors.Wrapf(err, "Invalid address %+v", addr)
+		}
+		if (addr.GetIpv4() == "") != (addresses[0].GetIpv4() == "") {
+			return errors.Newf("addresses of different families: %+v", addresses)
+		}
+	}
+	if advertIntervalMs != 0 &&
+		(advertIntervalMs < minVrrpAdvertIntervalMs ||
+			advertIntervalMs > maxVrrpAdvertIntervalMs) {
+
+		return errors.Newf("invalid advert_interval_ms: %d", advertIntervalMs)
+	}
+	return nil
+}
+
+// Set of vrrp groups, vrid is unique per address family and address belongs
+// to single group.
+type vrrpGroupSet struct {
+	vrids map[string]bool
+	addrs map[string]bool
+}
+
+func newVrrpGroupSet() *vrrpGroupSet {
+	return &vrrpGroupSet{
+		vrids: make(map[string]bool),
+		addrs: make(map[string]bool),
+	}
+}
+
+// Adds valid group to the set.
+func (s *vrrpGroupSet) add(vrid uint32, addresses []*pb.IP) error {
+	family := "ipv6"
+	if addresses[0].GetIpv4() != "" {
+		family = "ipv4"
+	}
+	key := fmt.Sprintf("%s/%d", family, vrid)
+	if s.vrids[key] {
+		return errors.Newf("duplicate vrid of %s: %d", family, vrid)
+	}
+	s.vrids[key] = true
+
+	for _, addr := range addresses {
+		ip := KglbAddrToNetIp(addr).String()
+		if s.addrs[ip] {
+			return errors.Newf("address belongs to multiple vrrp groups: %s", ip)
+		}
+		s.addrs[ip] = true
+	}
+	return nil
+}
+
+// Local asn shared by bgp routes, bgp sessions are established with single
+// local asn, so routes with different ones would restart them.
+type bgpLocalAsn struct {
+	asn uint32
+}
+
+// Adds local asn of the route, nil attributes are ignored.
+func (a *bgpLocalAsn) add(attr *pb.BgpRouteAttributes) error {
+	if attr == nil {
+		return nil
+	}
+	if a.asn != 0 && a.asn != attr.GetLocalAsn() {
+		return errors.Newf(
+			"bgp routes cannot have different local asn: %d and %d",
+			a.asn,
+			attr.GetLocalAsn())
+	}
+	a.asn = attr.GetLocalAsn()
+	return nil
+}
+
+func ValidateL2RouteAttributes(m *pb.L2RouteAttributes) error {
+	if m == nil {
+		return errors.New("Message is empty")
+	}
+
+	if err := ValidateIP(m.GetAddress()); err != nil {
+		return errors.Wrapf(err, "Invalid L2RouteAttributes.Address")
+	}
+
+	// IFNAMSIZ includes terminating null.
+	if len(m.GetInterface()) == 0 || len(m.GetInterface()) >= 16 {
+		return errors.Newf(
+			"invalid L2RouteAttributes.Interface: '%s'",
+			m.GetInterface())
+	}
+
 	return nil
 }
 
@@ -184,6 +184,19 @@ func (s *ConfigSuite) TestDupBalancerAndVips(c *C) {
 	err := ValidateControlPlaneConfig(cfg)
 	c.Assert(err, IsNil)
 
+	// bgp routes with different local asn are not allowed.
+	cfg.Balancers[1].GetDynamicRouting().GetBgpAttributes().LocalAsn = 2000
+	c.Assert(ValidateControlPlaneConfig(cfg), NotNil)
+	cfg.Balancers[1].GetDynamicRouting().GetBgpAttributes().LocalAsn = 1000
+
+	// flushing of ipvs entries requires expire_nodest_conn.
+	cfg.Balancers[1].ConnectionCleanup = &pb.ConnectionCleanup{FlushIpvs: true}
+	c.Assert(ValidateControlPlaneConfig(cfg), NotNil)
+	cfg.KernelSettings = &pb.KernelSettings{
+		ExpireNodestConn: &pb.BoolValue{Value: true},
+	}
+	c.Assert(ValidateControlPlaneConfig(cfg), IsNil)
+
 	// different name with the same vip:vport is not allowed.
 	cfg = &pb.ControlPlaneConfig{
 		Balancers: []*pb.BalancerConfig{
@@ -396,7 +409,87 @@ func (s *ConfigSuite) TestValidateBalancer(c *C) {
 	c.Assert(errors.GetMessage(err), Equals, "Name cannot be empty")
 }
 
-func (s *ConfigSuite) TestValidateIpvsService(c *C) {}
+func (s *ConfigSuite) TestValidateIpvsService(c *C) {
+	m := &pb.IpvsService{
+		Attributes: &pb.IpvsService_TcpAttributes{
+			TcpAttributes: &pb.IpvsTcpAttributes{
+				Address: &pb.IP{Address: &pb.IP_Ipv4{Ipv4: "10.0.0.1"}},
+				Port:    443,
+			},
+		},
+		Scheduler: pb.IpvsService_MH,
+		Flags: []pb.IpvsService_Flag{
+			pb.IpvsService_SH_FALLBACK,
+			pb.IpvsService_SH_PORT,
+		},
+	}
+	c.Assert(ValidateIpvsService(m), IsNil)
+
+	// sh flags with unrelated scheduler.
+	m.Scheduler = pb.IpvsService_WLC
+	c.Assert(
+		errors.GetMessage(ValidateIpvsService(m)),
+		Equals,
+		"SH_FALLBACK flag is supported by SH and MH schedulers only, got WLC")
+
+	// unsorted and duplicated flags are normalized by comparators.
+	m.Scheduler = pb.IpvsService_SH
+	m.Flags = []pb.IpvsService_Flag{
+		pb.IpvsService_SH_PORT,
+		pb.IpvsService_SH_FALLBACK,
+		pb.IpvsService_SH_PORT,
+	}
+	c.Assert(ValidateIpvsService(m), IsNil)
+
+	// flags which are not supported by data plane.
+	m.Flags = []pb.IpvsService_Flag{pb.IpvsService_HASHED}
+	c.Assert(errors.GetMessage(ValidateIpvsService(m)), Equals, "Unsupported flag: HASHED")
+
+	// unknown scheduler.
+	m.Flags = nil
+	m.Scheduler = pb.IpvsService_Scheduler(100)
+	c.Assert(errors.GetMessage(ValidateIpvsService(m)), Equals, "Unknown Scheduler: 100")
+
+	// persistence.
+	m.Scheduler = pb.IpvsService_WRR
+	m.PersistenceTimeoutS = 300
+	c.Assert(
+		errors.GetMessage(ValidateIpvsService(m)),
+		Equals,
+		"Persistence settings require PERSISTENT flag")
+	m.Flags = []pb.IpvsService_Flag{pb.IpvsService_PERSISTENT}
+	m.PersistenceNetmask = 24
+	c.Assert(ValidateIpvsService(m), IsNil)
+	m.PersistenceNetmask = 32
+	c.Assert(ValidateIpvsService(m), IsNil)
+	m.PersistenceNetmask = 33
+	c.Assert(
+		errors.GetMessage(ValidateIpvsService(m)),
+		Equals,
+		"PersistenceNetmask should be in [0, 32] range, got 33")
+	m.GetTcpAttributes().Address = &pb.IP{Address: &pb.IP_Ipv6{Ipv6: "fc00::1"}}
+	m.PersistenceNetmask = 128
+	c.Assert(ValidateIpvsService(m), IsNil)
+	m.PersistenceNetmask = 64
+	c.Assert(ValidateIpvsService(m), IsNil)
+
+	// persistence engine.
+	m.PeName = "sip"
+	c.Assert(ValidateIpvsService(m), IsNil)
+	m.PeName = "http"
+	c.Assert(
+		errors.GetMessage(ValidateIpvsService(m)),
+		Equals,
+		"Unknown persistence engine: http")
+	m.PeName = "sip"
+	m.Flags = nil
+	m.PersistenceTimeoutS = 0
+	m.PersistenceNetmask = 0
+	c.Assert(
+		errors.GetMessage(ValidateIpvsService(m)),
+		Equals,
+		"Persistence settings require PERSISTENT flag")
+}
 
 func (s *ConfigSuite) TestValidateUpstreamDiscovery(c *C) {
 	m := &pb.UpstreamDiscovery{
@@ -460,6 +553,62 @@ func (s *ConfigSuite) TestValidateDataPlaneState(c *C) {
 		},
 	})
 	c.Assert(err, IsNil)
+
+	route := func(localAsn uint32, prefix string) *pb.DynamicRoute {
+		return &pb.DynamicRoute{
+			Attributes: &pb.DynamicRoute_BgpAttributes{
+				BgpAttributes: &pb.BgpRouteAttributes{
+					LocalAsn:  localAsn,
+					PeerAsn:   1000,
+					Community: "10000:10000",
+					Prefix:    &pb.IP{Address: &pb.IP_Ipv4{Ipv4: prefix}},
+					Prefixlen: 32,
+				},
+			},
+		}
+	}
+	l2Route := &pb.DynamicRoute{
+		Attributes: &pb.DynamicRoute_L2Attributes{
+			L2Attributes: &pb.L2RouteAttributes{
+				Address:   &pb.IP{Address: &pb.IP_Ipv4{Ipv4: "172.0.0.3"}},
+				Interface: "eth0",
+			},
+		},
+	}
+	err = ValidateDataPlaneState(&pb.DataPlaneState{
+		DynamicRoutes: []*pb.DynamicRoute{
+			route(1000, "172.0.0.1"),
+			l2Route,
+			route(1000, "172.0.0.2"),
+		},
+	})
+	c.Assert(err, IsNil)
+
+	// bgp sessions are established with single local asn.
+	err = ValidateDataPlaneState(&pb.DataPlaneState{
+		DynamicRoutes: []*pb.DynamicRoute{
+			route(1000, "172.0.0.1"),
+			route(2000, "172.0.0.2"),
+		},
+	})
+	c.Assert(err, NotNil)
+
+	// ipvs entries of deleted reals are expired with expire_nodest_conn only.
+	state := &pb.DataPlaneState{
+		Balancers: []*pb.BalancerState{
+			{
+				Name:              "balancer-1",
+				ConnectionCleanup: &pb.ConnectionCleanup{FlushIpvs: true},
+			},
+		},
+	}
+	c.Assert(ValidateDataPlaneState(state), NotNil)
+	state.KernelSettings = &pb.KernelSettings{
+		ExpireNodestConn: &pb.BoolValue{Value: false},
+	}
+	c.Assert(ValidateDataPlaneState(state), NotNil)
+	state.KernelSettings.ExpireNodestConn.Value = true
+	c.Assert(ValidateDataPlaneState(state), IsNil)
 }
 
 func (s *ConfigSuite) TestValidateUpstreamCheckerSyslog(c *C) {
@@ -685,3 +834,478 @@ func (s *ConfigSuite) TestValidateUpstreamCheckerDns(c *C) {
 	c.Assert(err, NotNil)
 
 }
+
+func (s *ConfigSuite) TestValidateUpstreamCheckerExec(c *C) {
+	checker := func(path string) *hc_pb.UpstreamChecker {
+		return &hc_pb.UpstreamChecker{
+			RiseCount:  1,
+			FallCount:  1,
+			IntervalMs: 1000,
+			Checker: &hc_pb.HealthCheckerAttributes{
+				Attributes: &hc_pb.HealthCheckerAttributes_Exec{
+					Exec: &hc_pb.ExecCheckerAttributes{
+						Path: path,
+					},
+				},
+			},
+		}
+	}
+
+	// 1. valid.
+	err := ValidateUpstreamChecker(&pb.BalancerConfig{
+		Name:            "balancer-1",
+		UpstreamChecker: checker("/usr/local/bin/check"),
+	})
+	c.Assert(err, IsNil)
+
+	// 2. relative path.
+	err = ValidateUpstreamChecker(&pb.BalancerConfig{
+		Name:            "balancer-1",
+		UpstreamChecker: checker("check"),
+	})
+	c.Assert(err, NotNil)
+
+	// 3. unsupported fwmark.
+	err = ValidateUpstreamChecker(&pb.BalancerConfig{
+		Name:            "balancer-1",
+		UpstreamChecker: checker("/usr/local/bin/check"),
+		EnableFwmarks:   true,
+	})
+	c.Assert(err, NotNil)
+}
+
+func (s *ConfigSuite) TestValidateUpstreamCheckerDatabases(c *C) {
+	config := func(attr *hc_pb.HealthCheckerAttributes) *pb.BalancerConfig {
+		return &pb.BalancerConfig{
+			Name: "balancer-1",
+			UpstreamChecker: &hc_pb.UpstreamChecker{
+				RiseCount:  1,
+				FallCount:  1,
+				IntervalMs: 1000,
+				Checker:    attr,
+			},
+		}
+	}
+
+	// 1. redis.
+	err := ValidateUpstreamChecker(config(&hc_pb.HealthCheckerAttributes{
+		Attributes: &hc_pb.HealthCheckerAttributes_Redis{
+			Redis: &hc_pb.RedisCheckerAttributes{
+				Role: hc_pb.RedisCheckerAttributes_MASTER,
+			},
+		},
+	}))
+	c.Assert(err, IsNil)
+
+	// 2. mysql query without user.
+	err = ValidateUpstreamChecker(config(&hc_pb.HealthCheckerAttributes{
+		Attributes: &hc_pb.HealthCheckerAttributes_Mysql{
+			Mysql: &hc_pb.MysqlCheckerAttributes{Query: true},
+		},
+	}))
+	c.Assert(err, NotNil)
+
+	// 3. postgres without user.
+	err = ValidateUpstreamChecker(config(&hc_pb.HealthCheckerAttributes{
+		Attributes: &hc_pb.HealthCheckerAttributes_Postgres{
+			Postgres: &hc_pb.PostgresCheckerAttributes{},
+		},
+	}))
+	c.Assert(err, NotNil)
+
+	// 4. valid postgres.
+	err = ValidateUpstreamChecker(config(&hc_pb.HealthCheckerAttributes{
+		Attributes: &hc_pb.HealthCheckerAttributes_Postgres{
+			Postgres: &hc_pb.PostgresCheckerAttributes{
+				User: "kglb",
+				Role: hc_pb.PostgresCheckerAttributes_PRIMARY,
+			},
+		},
+	}))
+	c.Assert(err, IsNil)
+}
+
+func (s *ConfigSuite) TestValidatePassiveHealth(c *C) {
+	c.Assert(ValidatePassiveHealth(nil), IsNil)
+	c.Assert(ValidatePassiveHealth(&pb.PassiveHealth{
+		Enabled:              true,
+		OutlierWeightPercent: 10,
+		MaxOutlierRatio:      0.3,
+	}), IsNil)
+	c.Assert(ValidatePassiveHealth(&pb.PassiveHealth{
+		OutlierWeightPercent: 101,
+	}), NotNil)
+	c.Assert(ValidatePassiveHealth(&pb.PassiveHealth{
+		MaxOutlierRatio: 1.5,
+	}), NotNil)
+}
+
+func (s *ConfigSuite) TestValidateSlowStart(c *C) {
+	c.Assert(ValidateSlowStart(nil), IsNil)
+	c.Assert(ValidateSlowStart(&pb.SlowStart{
+		WindowMs:         60000,
+		Mode:             pb.SlowStart_EXPONENTIAL,
+		MinWeightPercent: 5,
+	}), IsNil)
+	c.Assert(ValidateSlowStart(&pb.SlowStart{
+		WindowMs:         60000,
+		MinWeightPercent: 120,
+	}), NotNil)
+	c.Assert(ValidateSlowStart(&pb.SlowStart{
+		WindowMs: 60000,
+		Mode:     pb.SlowStart_Mode(10),
+	}), NotNil)
+}
+
+func (s *ConfigSuite) TestValidateUpstreamRouting(c *C) {
+	c.Assert(ValidateUpstreamRouting(nil), NotNil)
+	c.Assert(ValidateUpstreamRouting(&pb.UpstreamRouting{}), IsNil)
+	c.Assert(ValidateUpstreamRouting(&pb.UpstreamRouting{
+		ConnectionThresholds: &pb.ConnectionThresholds{Upper: 1000, Lower: 500},
+		HostConnectionThresholds: map[string]*pb.ConnectionThresholds{
+			"host1": {Upper: 100},
+		},
+	}), IsNil)
+	// lower threshold without upper one.
+	c.Assert(ValidateUpstreamRouting(&pb.UpstreamRouting{
+		ConnectionThresholds: &pb.ConnectionThresholds{Lower: 500},
+	}), NotNil)
+	// lower threshold exceeds upper one.
+	c.Assert(ValidateUpstreamRouting(&pb.UpstreamRouting{
+		HostConnectionThresholds: map[string]*pb.ConnectionThresholds{
+			"host1": {Upper: 100, Lower: 100},
+		},
+	}), NotNil)
+	// empty hostname.
+	c.Assert(ValidateUpstreamRouting(&pb.UpstreamRouting{
+		HostConnectionThresholds: map[string]*pb.ConnectionThresholds{
+			"": {Upper: 100},
+		},
+	}), NotNil)
+
+	// tunnel encapsulation.
+	c.Assert(ValidateUpstreamRouting(&pb.UpstreamRouting{
+		Tunnel: &pb.TunnelEncapsulation{
+			Type:    pb.TunnelEncapsulation_GUE,
+			Port:    6080,
+			Csum:    true,
+			Remcsum: true,
+		},
+	}), IsNil)
+	c.Assert(ValidateUpstreamRouting(&pb.UpstreamRouting{
+		Tunnel: &pb.TunnelEncapsulation{
+			Type: pb.TunnelEncapsulation_GRE,
+			Csum: true,
+		},
+	}), IsNil)
+	// GUE without port.
+	c.Assert(ValidateUpstreamRouting(&pb.UpstreamRouting{
+		Tunnel: &pb.TunnelEncapsulation{Type: pb.TunnelEncapsulation_GUE},
+	}), NotNil)
+	// remote checksum offload of GRE.
+	c.Assert(ValidateUpstreamRouting(&pb.UpstreamRouting{
+		Tunnel: &pb.TunnelEncapsulation{
+			Type:    pb.TunnelEncapsulation_GRE,
+			Remcsum: true,
+		},
+	}), NotNil)
+	// flags of IPIP.
+	c.Assert(ValidateUpstreamRouting(&pb.UpstreamRouting{
+		Tunnel: &pb.TunnelEncapsulation{Csum: true},
+	}), NotNil)
+	// encapsulation of non-tunnel forwarding method.
+	c.Assert(ValidateUpstreamRouting(&pb.UpstreamRouting{
+		ForwardMethod: pb.ForwardMethods_DR,
+		Tunnel: &pb.TunnelEncapsulation{
+			Type: pb.TunnelEncapsulation_GRE,
+		},
+	}), NotNil)
+	c.Assert(ValidateUpstreamRouting(&pb.UpstreamRouting{
+		ForwardMethod: pb.ForwardMethods_DR,
+	}), IsNil)
+	c.Assert(ValidateUpstreamRouting(&pb.UpstreamRouting{
+		ForwardMethod: pb.ForwardMethods(10),
+	}), NotNil)
+}
+
+func (s *ConfigSuite) TestValidateKernelSettings(c *C) {
+	c.Assert(ValidateKernelSettings(nil), IsNil)
+	c.Assert(ValidateKernelSettings(&pb.KernelSettings{
+		Conntrack:      &pb.BoolValue{Value: true},
+		TcpTimeoutS:    900,
+		TcpFinTimeoutS: 120,
+		UdpTimeoutS:    300,
+	}), IsNil)
+	// timeout doesn't fit into jiffies.
+	c.Assert(ValidateKernelSettings(&pb.KernelSettings{
+		UdpTimeoutS: maxIpvsTimeoutS + 1,
+	}), NotNil)
+	c.Assert(ValidateDataPlaneState(&pb.DataPlaneState{
+		KernelSettings: &pb.KernelSettings{TcpTimeoutS: maxIpvsTimeoutS + 1},
+	}), NotNil)
+}
+
+func (s *ConfigSuite) TestValidateIpvsSync(c *C) {
+	c.Assert(ValidateIpvsSyncConfig(&pb.IpvsSyncConfig{
+		Interface: "eth0",
+		SyncId:    10,
+	}), IsNil)
+	c.Assert(ValidateIpvsSyncConfig(&pb.IpvsSyncConfig{
+		Interface:  "eth0",
+		McastGroup: &pb.IP{Address: &pb.IP_Ipv6{Ipv6: "ff02::81"}},
+		McastPort:  8849,
+	}), IsNil)
+	// interface is required.
+	c.Assert(ValidateIpvsSyncConfig(&pb.IpvsSyncConfig{}), NotNil)
+	c.Assert(ValidateIpvsSyncConfig(&pb.IpvsSyncConfig{
+		Interface: "very_long_interface",
+	}), NotNil)
+	c.Assert(ValidateIpvsSyncConfig(&pb.IpvsSyncConfig{
+		Interface:  "eth0",
+		McastGroup: &pb.IP{Address: &pb.IP_Ipv4{Ipv4: "10.0.0.1"}},
+	}), NotNil)
+	c.Assert(ValidateIpvsSyncConfig(&pb.IpvsSyncConfig{
+		Interface: "eth0",
+		SyncId:    256,
+	}), NotNil)
+
+	c.Assert(ValidateIpvsSyncDaemon(&pb.IpvsSyncDaemon{
+		Role:      pb.IpvsSyncDaemon_MASTER,
+		Interface: "eth0",
+	}), IsNil)
+	c.Assert(ValidateIpvsSyncDaemon(&pb.IpvsSyncDaemon{
+		Role:      pb.IpvsSyncDaemon_Role(10),
+		Interface: "eth0",
+	}), NotNil)
+	// single daemon per role.
+	c.Assert(ValidateDataPlaneState(&pb.DataPlaneState{
+		SyncDaemons: []*pb.IpvsSyncDaemon{
+			{Role: pb.IpvsSyncDaemon_BACKUP, Interface: "eth0"},
+			{Role: pb.IpvsSyncDaemon_BACKUP, Interface: "eth1"},
+		},
+	}), NotNil)
+}
+
+func (s *ConfigSuite) TestValidateL2RouteAttributes(c *C) {
+	c.Assert(ValidateDynamicRouting(&pb.DynamicRouting{
+		Attributes: &pb.DynamicRouting_L2Attributes{
+			L2Attributes: &pb.L2RouteAttributes{
+				Address:   &pb.IP{Address: &pb.IP_Ipv6{Ipv6: "fc00::1"}},
+				Interface: "eth0",
+			},
+		},
+	}), IsNil)
+	c.Assert(ValidateL2RouteAttributes(&pb.L2RouteAttributes{
+		Interface: "eth0",
+	}), NotNil)
+	c.Assert(ValidateL2RouteAttributes(&pb.L2RouteAttributes{
+		Address: &pb.IP{Address: &pb.IP_Ipv4{Ipv4: "10.0.0.1"}},
+	}), NotNil)
+}
+
+func (s *ConfigSuite) TestValidateBgpRouteAttributes(c *C) {
+	route := func() *pb.BgpRouteAttributes {
+		return &pb.BgpRouteAttributes{
+			LocalAsn:            65001,
+			PeerAsn:             65002,
+			Community:           "65001:100",
+			Prefix:              &pb.IP{Address: &pb.IP_Ipv4{Ipv4: "172.0.0.1"}},
+			Prefixlen:           32,
+			Med:                 &pb.UInt32Value{Value: 10},
+			LocalPref:           &pb.UInt32Value{Value: 200},
+			AsPathPrepend:       2,
+			LargeCommunities:    []string{"65001:1:2"},
+			ExtendedCommunities: []string{"rt:65001:100", "soo:10.0.0.1:100"},
+			NextHop:             &pb.IP{Address: &pb.IP_Ipv4{Ipv4: "10.0.0.1"}},
+		}
+	}
+	c.Assert(ValidateBgpRouteAttributes(route()), IsNil)
+
+	// zero med and local pref are sent.
+	valid := route()
+	valid.Med = &pb.UInt32Value{Value: 0}
+	valid.LocalPref = &pb.UInt32Value{Value: 0}
+	c.Assert(ValidateBgpRouteAttributes(valid), IsNil)
+
+	// legacy free-form community is accepted.
+	valid = route()
+	valid.Community = "my_community"
+	c.Assert(ValidateBgpRouteAttributes(valid), IsNil)
+
+	invalid := route()
+	invalid.Community = ""
+	c.Assert(ValidateBgpRouteAttributes(invalid), NotNil)
+
+	invalid = route()
+	invalid.AsPathPrepend = 33
+	c.Assert(ValidateBgpRouteAttributes(invalid), NotNil)
+
+	invalid = route()
+	invalid.LargeCommunities = []string{"65001:1"}
+	c.Assert(ValidateBgpRouteAttributes(invalid), NotNil)
+
+	invalid = route()
+	invalid.ExtendedCommunities = []string{"65001:100"}
+	c.Assert(ValidateBgpRouteAttributes(invalid), NotNil)
+
+	// next hop of other family.
+	invalid = route()
+	invalid.NextHop = &pb.IP{Address: &pb.IP_Ipv6{Ipv6: "fc00::1"}}
+	c.Assert(ValidateBgpRouteAttributes(invalid), NotNil)
+
+	invalid = route()
+	invalid.NextHop = &pb.IP{Address: &pb.IP_Ipv4{Ipv4: "10.0.0"}}
+	c.Assert(ValidateBgpRouteAttributes(invalid), NotNil)
+}
+
+func (s *ConfigSuite) TestValidateVrrp(c *C) {
+	v4 := func(addr string) *pb.IP {
+		return &pb.IP{Address: &pb.IP_Ipv4{Ipv4: addr}}
+	}
+	v6 := func(addr string) *pb.IP {
+		return &pb.IP{Address: &pb.IP_Ipv6{Ipv6: addr}}
+	}
+
+	c.Assert(ValidateVrrpGroupConfig(&pb.VrrpGroupConfig{
+		Vrid:      10,
+		Interface: "eth0",
+		Addresses: []*pb.IP{v4("172.0.0.1"), v4("172.0.0.2")},
+	}), IsNil)
+	c.Assert(ValidateVrrpGroupConfig(&pb.VrrpGroupConfig{
+		Vrid:             10,
+		Interface:        "eth0",
+		Addresses:        []*pb.IP{v6("fc00::1")},
+		AdvertIntervalMs: 100,
+	}), IsNil)
+	// vrid is required.
+	c.Assert(ValidateVrrpGroupConfig(&pb.VrrpGroupConfig{
+		Interface: "eth0",
+		Addresses: []*pb.IP{v4("172.0.0.1")},
+	}), NotNil)
+	c.Assert(ValidateVrrpGroupConfig(&pb.VrrpGroupConfig{
+		Vrid:      256,
+		Interface: "eth0",
+		Addresses: []*pb.IP{v4("172.0.0.1")},
+	}), NotNil)
+	c.Assert(ValidateVrrpGroupConfig(&pb.VrrpGroupConfig{
+		Vrid:      10,
+		Addresses: []*pb.IP{v4("172.0.0.1")},
+	}), NotNil)
+	c.Assert(ValidateVrrpGroupConfig(&pb.VrrpGroupConfig{
+		Vrid:      10,
+		Interface: "eth0",
+	}), NotNil)
+	// addresses of different families.
+	c.Assert(ValidateVrrpGroupConfig(&pb.VrrpGroupConfig{
+		Vrid:      10,
+		Interface: "eth0",
+		Addresses: []*pb.IP{v4("172.0.0.1"), v6("fc00::1")},
+	}), NotNil)
+	c.Assert(ValidateVrrpGroupConfig(&pb.VrrpGroupConfig{
+		Vrid:             10,
+		Interface:        "eth0",
+		Addresses:        []*pb.IP{v4("172.0.0.1")},
+		AdvertIntervalMs: 5,
+	}), NotNil)
+
+	c.Assert(ValidateVrrpInstance(&pb.VrrpInstance{
+		Vrid:      10,
+		Interface: "eth0",
+		Addresses: []*pb.IP{v4("172.0.0.1")},
+		Priority:  100,
+	}), IsNil)
+	// owner and release priorities are reserved.
+	c.Assert(ValidateVrrpInstance(&pb.VrrpInstance{
+		Vrid:      10,
+		Interface: "eth0",
+		Addresses: []*pb.IP{v4("172.0.0.1")},
+		Priority:  255,
+	}), NotNil)
+	c.Assert(ValidateVrrpInstance(&pb.VrrpInstance{
+		Vrid:      10,
+		Interface: "eth0",
+		Addresses: []*pb.IP{v4("172.0.0.1")},
+	}), NotNil)
+
+	// vrid is unique per address family.
+	c.Assert(ValidateDataPlaneState(&pb.DataPlaneState{
+		VrrpInstances: []*pb.VrrpInstance{
+			{Vrid: 10, Interface: "eth0", Addresses: []*pb.IP{v4("172.0.0.1")}, Priority: 100},
+			{Vrid: 10, Interface: "eth0", Addresses: []*pb.IP{v6("fc00::1")}, Priority: 100},
+		},
+	}), IsNil)
+	c.Assert(ValidateDataPlaneState(&pb.DataPlaneState{
+		VrrpInstances: []*pb.VrrpInstance{
+			{Vrid: 10, Interface: "eth0", Addresses: []*pb.IP{v4("172.0.0.1")}, Priority: 100},
+			{Vrid: 10, Interface: "eth1", Addresses: []*pb.IP{v4("172.0.0.2")}, Priority: 100},
+		},
+	}), NotNil)
+	// address belongs to single group.
+	c.Assert(ValidateDataPlaneState(&pb.DataPlaneState{
+		VrrpInstances: []*pb.VrrpInstance{
+			{Vrid: 10, Interface: "eth0", Addresses: []*pb.IP{v4("172.0.0.1")}, Priority: 100},
+			{Vrid: 11, Interface: "eth0", Addresses: []*pb.IP{v4("172.0.0.1")}, Priority: 100},
+		},
+	}), NotNil)
+}
+
+func (s *ConfigSuite) TestValidateFailsafe(c *C) {
+	c.Assert(ValidateFailsafe(nil), IsNil)
+	c.Assert(ValidateFailsafe(&pb.Failsafe{
+		Mode:          pb.Failsafe_KEEP_LAST_HEALTHY,
+		MinUpstreams:  3,
+		KeepLastCount: 2,
+	}), IsNil)
+	c.Assert(ValidateFailsafe(&pb.Failsafe{
+		Mode:          pb.Failsafe_ALL_UP,
+		KeepLastCount: 2,
+	}), NotNil)
+	c.Assert(ValidateFailsafe(&pb.Failsafe{
+		Mode: pb.Failsafe_Mode(10),
+	}), NotNil)
+}
+
+func (s *ConfigSuite) TestValidateFlapDamping(c *C) {
+	c.Assert(ValidateFlapDamping(nil), IsNil)
+	c.Assert(ValidateFlapDamping(&hc_pb.FlapDamping{
+		Enabled:           true,
+		SuppressThreshold: 3000,
+		ReuseThreshold:    1000,
+		HalfLifeMs:        30000,
+		MaxSuppressMs:     120000,
+	}), IsNil)
+	c.Assert(ValidateFlapDamping(&hc_pb.FlapDamping{
+		Enabled:           true,
+		SuppressThreshold: 1000,
+		ReuseThreshold:    1000,
+	}), NotNil)
+	c.Assert(ValidateFlapDamping(&hc_pb.FlapDamping{
+		Enabled:       true,
+		HalfLifeMs:    30000,
+		MaxSuppressMs: 10000,
+	}), NotNil)
+}
+
+func (s *ConfigSuite) TestValidateCheckIntervals(c *C) {
+	c.Assert(ValidateCheckIntervals(nil), IsNil)
+	c.Assert(ValidateCheckIntervals(&hc_pb.CheckIntervals{
+		HealthyMs:      5000,
+		UnhealthyMs:    10000,
+		MaxUnhealthyMs: 300000,
+		TransitionMs:   1000,
+		JitterPercent:  10,
+	}), IsNil)
+	c.Assert(ValidateCheckIntervals(&hc_pb.CheckIntervals{
+		UnhealthyMs:    10000,
+		MaxUnhealthyMs: 5000,
+	}), NotNil)
+	c.Assert(ValidateCheckIntervals(&hc_pb.CheckIntervals{
+		JitterPercent: 50,
+	}), IsNil)
+	c.Assert(ValidateCheckIntervals(&hc_pb.CheckIntervals{
+		JitterPercent: 51,
+	}), NotNil)
+	c.Assert(ValidateCheckIntervals(&hc_pb.CheckIntervals{
+		JitterPercent: 150,
+	}), NotNil)
+}
@@ -3,6 +3,7 @@ package control_plane
 import (
 	"context"
 	"fmt"
+	"math"
 	"net"
 	"sync"
 	"sync/atomic"
@@ -17,6 +18,7 @@ import (
 	"dropbox/kglb/utils/dns_resolver"
 	"dropbox/kglb/utils/fwmark"
 	"dropbox/kglb/utils/health_manager"
+	"dropbox/kglb/utils/host_override"
 	pb "dropbox/proto/kglb"
 	"dropbox/vortex2/v2stats"
 	"godropbox/errors"
@@ -27,6 +29,12 @@ const (
 	DefaultWeightDown = uint32(0)
 	// default number of concurrent health checks.
 	DefaultCheckerConcurrency = 100
+	// default max ratio of upstreams down-weighted by passive health signals.
+	DefaultMaxOutlierRatio = float32(0.5)
+	// default weight of outliers in percents of weight up.
+	DefaultOutlierWeightPercent = uint32(10)
+	// default delay before restoring weight of former outliers.
+	DefaultOutlierRestoreDelay = time.Minute
 	// default delay between updating state retry attempts in case of failed dns
 	// resolution.
 	DefaultUpdateRetryWaitTime = 5 * time.Second
@@ -52,6 +60,16 @@ type BalancerParams struct {
 	// wait time between updating state retry attempts in case of failed
 	// dns resolution.
 	UpdateRetryWaitTime time.Duration
+
+	// (Optional) passive health signals from data plane.
+	PassiveHealth PassiveHealthProvider
+
+	// (Optional) scheduler to share health checks results between balancers
+	// with the same reals and checker configuration.
+	CheckScheduler *health_manager.CheckScheduler
+
+	// (Optional) manual overrides of upstreams health status.
+	HostOverrides *host_override.Store
 }
 
 // Discovers, health checks, resolves hostnames and generates []*pb.BalancerState
@@ -96,6 +114,36 @@ type Balancer struct {
 
 	// Weights of healthy reals.
 	weightUp uint32
+
+	// notifications about changes of passive health signals.
+	passiveHealthUpdates      <-chan struct{}
+	statPassiveHealthOutliers v2stats.Gauge
+	// time when down-weighted outliers were flagged last time by HostPort key.
+	outliers map[string]time.Time
+	// timer to regenerate state when the nearest outlier restore delay
+	// elapses.
+	outlierTimer *time.Timer
+
+	// time when upstreams became healthy by HostPort key (zero time for
+	// upstreams healthy since balancer initialization).
+	healthySince map[string]time.Time
+	// timer to regenerate state while upstreams are slow starting.
+	slowStartTimer         *time.Timer
+	statSlowStartUpstreams v2stats.Gauge
+
+	// time when upstreams were healthy last time by HostPort key (used by
+	// KEEP_LAST_HEALTHY failsafe mode).
+	lastHealthy map[string]time.Time
+
+	// notifications about changes of host overrides.
+	hostOverrideUpdates <-chan struct{}
+	statHostOverrides   *v2stats.GaugeGroup
+
+	// upstreams removed from discovery which are draining by HostPort key.
+	draining map[string]*drainingUpstream
+	// timer to regenerate state when the nearest drain timeout elapses.
+	drainTimer            *time.Timer
+	statDrainingUpstreams v2stats.Gauge
 }
 
 func getAddressFamilyFromVip(vip string) pb.AddressFamily {
@@ -133,6 +181,23 @@ func newBalancer(
 		// v2 stats
 		statBalancerState:  v2stats.NewGaugeGroup(balancerStateGauge),
 		statUpstreamsCount: v2stats.NewGaugeGroup(upstreamsCountGauge),
+		statHostOverrides:  v2stats.NewGaugeGroup(hostOverridesGauge),
+		statPassiveHealthOutliers: passiveHealthOutliersGauge.Must(v2stats.KV{
+			"setup":   params.BalancerConfig.GetSetupName(),
+			"service": params.BalancerConfig.GetName(),
+		}),
+		outliers:     make(map[string]time.Time),
+		healthySince: make(map[string]time.Time),
+		lastHealthy:  make(map[string]time.Time),
+		statSlowStartUpstreams: slowStartUpstreamsGauge.Must(v2stats.KV{
+			"setup":   params.BalancerConfig.GetSetupName(),
+			"service": params.BalancerConfig.GetName(),
+		}),
+		draining: make(map[string]*drainingUpstream),
+		statDrainingUpstreams: drainingUpstreamsGauge.Must(v2stats.KV{
+			"setup":   params.BalancerConfig.GetSetupName(),
+			"service": params.BalancerConfig.GetName(),
+		}),
 	}
 
 	if up.weightUp == 0 {
@@ -179,12 +244,24 @@ func newBalancer(
 		AddressFamily:             getAddressFamilyFromVip(vip),
 		UpstreamCheckerAttributes: up.config.GetUpstreamChecker(),
 	}
+	// health checks through fwmarks verify path through specific vip, so
+	// they can't be shared with other balancers.
+	if !up.config.GetEnableFwmarks() {
+		healthManagerParams.CheckScheduler = params.CheckScheduler
+	}
 
 	up.healthMng, err = health_manager.NewHealthManager(up.ctx, healthManagerParams)
 	if err != nil {
 		return nil, errors.Wrapf(err, "fails to create health manager: ")
 	}
 
+	if params.PassiveHealth != nil {
+		up.passiveHealthUpdates = params.PassiveHealth.Subscribe()
+	}
+	if params.HostOverrides != nil {
+		up.hostOverrideUpdates = params.HostOverrides.Subscribe()
+	}
+
 	// balancer is in initial state since there was no any healthy upstreams.
 	up.state.Store(&BalancerState{
 		InitialState: true,
@@ -230,6 +307,11 @@ func (u *Balancer) GetState() *BalancerState {
 	return u.state.Load().(*BalancerState)
 }
 
+// Returns diagnostics of the balancer health manager.
+func (u *Balancer) GetDiagnostics() health_manager.HealthManagerDiagnostics {
+	return u.healthMng.GetDiagnostics()
+}
+
 // Returns channel provided in params. Balancer sends new states into it when
 // it's writable.
 func (u *Balancer) Updates() <-chan *BalancerState {
@@ -338,6 +420,27 @@ func (u *Balancer) Close() {
 	// canceling context which will close manager and update channel.
 	u.cancelFunc()
 	u.resolver.Close()
+	if u.params.PassiveHealth != nil {
+		u.params.PassiveHealth.Unsubscribe(u.passiveHealthUpdates)
+	}
+	if u.params.HostOverrides != nil {
+		u.params.HostOverrides.Unsubscribe(u.hostOverrideUpdates)
+	}
+
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+	if u.slowStartTimer != nil {
+		u.slowStartTimer.Stop()
+		u.slowStartTimer = nil
+	}
+	if u.drainTimer != nil {
+		u.drainTimer.Stop()
+		u.drainTimer = nil
+	}
+	if u.outlierTimer != nil {
+		u.outlierTimer.Stop()
+		u.outlierTimer = nil
+	}
 }
 
 func getAddressString(state *pb.UpstreamState) string {
@@ -360,7 +463,10 @@ func (u *Balancer) manageFwmarkAllocations(upstreamStates []*pb.UpstreamState) {
 		found := false
 		if len(balancerStates.States) > 0 {
 			for _, oldState := range balancerStates.States[0].Upstreams {
-
+				// fwmarks of draining upstreams are already released.
+				if oldState.GetDraining() {
+					continue
+				}
 				if addr == getAddressString(oldState) {
 					found = true
 					break
@@ -380,6 +486,9 @@ func (u *Balancer) manageFwmarkAllocations(upstreamStates []*pb.UpstreamState) {
 	if len(balancerStates.States) > 0 {
 		// what was removed:
 		for _, oldState := range balancerStates.States[0].Upstreams {
+			if oldState.GetDraining() {
+				continue
+			}
 			addr := getAddressString(oldState)
 			found := false
 			for _, state := range upstreamStates {
@@ -437,11 +546,140 @@ func (u *Balancer) generateFwmarkService(
 				// mark as UP, otherwise ipvs drops health checks
 				Weight:        u.weightUp,
 				ForwardMethod: upstreamState.GetForwardMethod(),
+				// health checks are encapsulated the same way as traffic.
+				Tunnel: upstreamState.GetTunnel(),
 			},
 		},
 	}
 }
 
+// Returns connection thresholds of the host, per-host thresholds take
+// precedence over default ones.
+func connectionThresholds(
+	routing *pb.UpstreamRouting,
+	hostname string) *pb.ConnectionThresholds {
+
+	if thresholds, ok := routing.GetHostConnectionThresholds()[hostname]; ok {
+		return thresholds
+	}
+	return routing.GetConnectionThresholds()
+}
+
+// Returns tunnel encapsulation of reals, IPIP encapsulation is represented by
+// nil value since it's reported by IPVS that way.
+func tunnelEncapsulation(routing *pb.UpstreamRouting) *pb.TunnelEncapsulation {
+	if routing.GetForwardMethod() != pb.ForwardMethods_TUNNEL ||
+		routing.GetTunnel().GetType() == pb.TunnelEncapsulation_IPIP {
+
+		return nil
+	}
+	return routing.GetTunnel()
+}
+
+// Returns weight scaled by provided percents. Non-zero percents never
+// produce zero weight to keep upstream alive.
+func scaleWeight(weight uint32, percent uint32) uint32 {
+	scaled := uint64(weight) * uint64(percent) / 100
+	if scaled == 0 && percent > 0 && weight > 0 {
+		return 1
+	}
+	if scaled > math.MaxUint32 {
+		return math.MaxUint32
+	}
+	return uint32(scaled)
+}
+
+// Down-weights healthy upstreams flagged as outliers by passive health
+// detector of data plane. Outliers keep reduced weight during restore delay
+// after they are not flagged anymore, since reduced traffic makes their stats
+// look normal. Should be called under u.mutex.
+func (u *Balancer) applyPassiveHealth(upstreams []*pb.UpstreamState, now time.Time) {
+	conf := u.config.GetPassiveHealth()
+	if !conf.GetEnabled() || u.params.PassiveHealth == nil {
+		u.outliers = make(map[string]time.Time)
+		return
+	}
+	service, err := common.GetKeyFromLbService(u.config.GetLbService())
+	if err != nil {
+		exclog.Report(
+			errors.Wrapf(err, "fails to get service key of %s balancer: ", u.name),
+			exclog.Noncritical, "")
+		return
+	}
+
+	maxOutlierRatio := conf.GetMaxOutlierRatio()
+	if maxOutlierRatio == 0 {
+		maxOutlierRatio = DefaultMaxOutlierRatio
+	}
+	maxOutliers := int(maxOutlierRatio * float32(len(upstreams)))
+	weightPercent := conf.GetOutlierWeightPercent()
+	if weightPercent == 0 {
+		weightPercent = DefaultOutlierWeightPercent
+	}
+	restoreDelay := DefaultOutlierRestoreDelay
+	if delayMs := conf.GetRestoreDelayMs(); delayMs > 0 {
+		restoreDelay = time.Duration(delayMs) * time.Millisecond
+	}
+
+	// time when upstreams were flagged last time.
+	outliers := make(map[string]time.Time)
+	var nextRestore time.Time
+	for _, upstream := range upstreams {
+		if upstream.Weight == DefaultWeightDown {
+			continue
+		}
+		key := upstreamKey(upstream)
+		lastFlagged, ok := u.outliers[key]
+		if u.params.PassiveHealth.IsOutlier(
+			service,
+			common.KglbAddrToNetIp(upstream.GetAddress()),
+			upstream.GetPort()) {
+
+			lastFlagged, ok = now, true
+		}
+		if !ok || !now.Before(lastFlagged.Add(restoreDelay)) {
+			continue
+		}
+		if len(outliers) >= maxOutliers {
+			dlog.Infof(
+				"passive health: max number of outliers is reached in %s balancer, "+
+					"keeping weight of %s",
+				u.name,
+				upstream.GetHostname())
+			continue
+		}
+		outliers[key] = lastFlagged
+		if restore := lastFlagged.Add(restoreDelay); nextRestore.IsZero() ||
+			restore.Before(nextRestore) {
+
+			nextRestore = restore
+		}
+		upstream.Weight = scaleWeight(upstream.Weight, weightPercent)
+		dlog.Infof(
+			"passive health: %s upstream of %s balancer is down-weighted to %d",
+			upstream.GetHostname(),
+			u.name,
+			upstream.Weight)
+	}
+	u.outliers = outliers
+
+	u.statPassiveHealthOutliers.Set(float64(len(outliers)))
+
+	// regenerating state when the nearest restore delay elapses.
+	if u.outlierTimer != nil {
+		u.outlierTimer.Stop()
+		u.outlierTimer = nil
+	}
+	if !nextRestore.IsZero() {
+		u.outlierTimer = time.AfterFunc(nextRestore.Sub(now), func() {
+			select {
+			case u.updatesConf <- struct{}{}:
+			default:
+			}
+		})
+	}
+}
+
 // Updates manager's state.
 func (u *Balancer) updateState(
 	state health_manager.HealthManagerState) {
@@ -451,6 +689,14 @@ func (u *Balancer) updateState(
 
 	// generate UpstreamState based on provided HealthManagerState state.
 	upstreamStates := []*pb.UpstreamState{}
+	// number of healthy upstreams including drained ones (with zero weight).
+	healthyCnt := 0
+	// healthy upstreams and number of slow starting ones.
+	healthy := make(map[string]struct{})
+	rampingCnt := 0
+	now := time.Now()
+	// upstreams with active host overrides by HostPort key.
+	overrides := make(map[string]host_override.Action)
 	for _, entry := range state {
 		if !entry.HostPort.Enabled {
 			// skip hosts which are disabled in service discovery
@@ -490,14 +736,48 @@ func (u *Balancer) updateState(
 			// forward method.
 			ForwardMethod: u.config.GetUpstreamRouting().GetForwardMethod(),
 		}
-		if entry.Status.IsHealthy() {
-			upstream.Weight = u.weightUp
+		thresholds := connectionThresholds(
+			u.config.GetUpstreamRouting(),
+			entry.HostPort.Host)
+		upstream.UThreshold = thresholds.GetUpper()
+		upstream.LThreshold = thresholds.GetLower()
+		upstream.Tunnel = tunnelEncapsulation(u.config.GetUpstreamRouting())
+		key := entry.HostPort.String()
+		isHealthy := entry.Status.IsHealthy()
+		// connections of unhealthy upstreams are flushed by data plane,
+		// upstreams forced down by operator keep them.
+		upstream.Unhealthy = !isHealthy
+		if action, ok := u.hostOverride(entry.HostPort.Host, now); ok {
+			overrides[key] = action
+			switch action {
+			case host_override.ForceUp:
+				isHealthy = true
+			case host_override.ForceDown:
+				isHealthy = false
+			}
+		}
+
+		if isHealthy {
+			healthyCnt++
+			upstream.Weight = scaleWeight(u.weightUp, entry.Status.WeightPercent())
+
+			healthy[key] = struct{}{}
+			var ramping bool
+			upstream.Weight, ramping = u.applySlowStart(key, upstream.Weight, now)
+			if ramping {
+				rampingCnt++
+			}
 		} else {
 			upstream.Weight = DefaultWeightDown
 		}
 		upstreamStates = append(upstreamStates, upstream)
 	}
 
+	u.updateSlowStart(healthy, rampingCnt)
+	u.updateLastHealthy(upstreamStates, healthy, now)
+	u.applyPassiveHealth(upstreamStates, now)
+	u.applyHostOverrides(upstreamStates, overrides)
+
 	// main state.
 	aliveRatio := common.AliveUpstreamsRatio(upstreamStates)
 	if u.initialState && aliveRatio > 0 {
@@ -514,16 +794,26 @@ func (u *Balancer) updateState(
 			u.name)
 	}
 
-	// marking all backends as healthy when all of them failing healthcheck,
-	// because it
-	if !u.initialState && aliveRatio == 0 && upstreamCnt > 0 {
-		dlog.Error("failsafe mode is enabled: ", u.name)
-		u.updateBalancerStateGauge(1, "failsafe")
-		for _, state := range upstreamStates {
-			state.Weight = u.weightUp
+	// marking backends as healthy according to failsafe policy when all of
+	// them failing healthcheck. Healthy upstreams drained by health checker
+	// don't trigger failsafe mode.
+	failsafe := false
+	failClosed := false
+	if !u.initialState && aliveRatio == 0 && healthyCnt == 0 && upstreamCnt > 0 {
+		if u.applyFailsafe(upstreamStates) {
+			failsafe = true
+			dlog.Error("failsafe mode is enabled: ", u.name)
+			u.updateBalancerStateGauge(1, "failsafe")
+			// failsafe policy doesn't bring back upstreams forced down or
+			// drained by operator.
+			u.applyHostOverrides(upstreamStates, overrides)
+			// updating alive ratio since weight was modified.
+			aliveRatio = common.AliveUpstreamsRatio(upstreamStates)
+		} else {
+			failClosed = true
+			dlog.Error("balancer fails closed: ", u.name)
+			u.updateBalancerStateGauge(1, "fail_closed")
 		}
-		// updating alive ratio since weight was modified.
-		aliveRatio = common.AliveUpstreamsRatio(upstreamStates)
 	} else {
 		dlog.Info("failsafe mode is disabled: ", u.name)
 		if upstreamCnt == 0 {
@@ -536,16 +826,24 @@ func (u *Balancer) updateState(
 	// Report alive/dead upstream counters
 	u.updateUpstreamCountGauge(upstreamStates)
 
+	// draining upstreams don't affect alive ratio and fwmark services.
+	drainingStates := u.updateDraining(upstreamStates, now)
+
 	balancerState := &BalancerState{
 		States: []*pb.BalancerState{
 			{
 				Name:      u.name,
 				LbService: u.config.GetLbService(),
-				Upstreams: upstreamStates,
+				Upstreams: append(
+					append([]*pb.UpstreamState{}, upstreamStates...),
+					drainingStates...),
+				ConnectionCleanup: u.config.GetConnectionCleanup(),
 			},
 		},
 		AliveRatio:   aliveRatio,
 		InitialState: u.initialState,
+		Failsafe:     failsafe,
+		FailClosed:   failClosed,
 	}
 
 	// generate fwmark states.
@@ -604,6 +902,12 @@ func (u *Balancer) updateLoop() {
 		case <-u.updatesConf:
 			// regenerate config because of Balancer change.
 			u.updateState(u.healthMng.GetState())
+		case <-u.passiveHealthUpdates:
+			// regenerate config because of passive health signals change.
+			u.updateState(u.healthMng.GetState())
+		case <-u.hostOverrideUpdates:
+			// regenerate config because of host overrides change.
+			u.updateState(u.healthMng.GetState())
 		}
 	}
 }
@@ -14,4 +14,10 @@ type BalancerState struct {
 	// the flag is false when Alive ratio of Balancer was positive, otherwise
 	// false.
 	InitialState bool
+	// the flag is true when all upstreams fail health checks and weights are
+	// assigned by failsafe policy.
+	Failsafe bool
+	// the flag is true when all upstreams fail health checks and failsafe
+	// policy doesn't allow to serve traffic, route should be withdrawn.
+	FailClosed bool
 }
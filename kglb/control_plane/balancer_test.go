@@ -3,17 +3,22 @@ package control_plane
 import (
 	"context"
 	"fmt"
+	"math"
+	"net"
 	"sync/atomic"
 	"time"
 
 	. "gopkg.in/check.v1"
 
+	"dropbox/kglb/common"
 	"dropbox/kglb/utils/discovery"
 	"dropbox/kglb/utils/dns_resolver"
 	"dropbox/kglb/utils/fwmark"
 	"dropbox/kglb/utils/health_manager"
+	"dropbox/kglb/utils/passive_health"
 	pb "dropbox/proto/kglb"
 	hc_pb "dropbox/proto/kglb/healthchecker"
+	"dropbox/vortex2/v2stats"
 	. "godropbox/gocheck2"
 )
 
@@ -27,6 +32,38 @@ var dummyChecker = &hc_pb.HealthCheckerAttributes{
 	},
 }
 
+// Returns balancer with minimal set of fields required by updateState() and
+// dns resolver of host1 and host2 hosts.
+func newStateTestBalancer(config *pb.BalancerConfig) *Balancer {
+	tags := v2stats.KV{"setup": config.GetSetupName(), "service": config.GetName()}
+	balancer := &Balancer{
+		name:   config.GetName(),
+		config: config,
+		params: &BalancerParams{
+			BalancerConfig: config,
+			DnsResolver: dns_resolver.NewDnsResolverMock(map[string]*pb.IP{
+				"host1": {Address: &pb.IP_Ipv4{Ipv4: "10.0.0.1"}},
+				"host2": {Address: &pb.IP_Ipv4{Ipv4: "10.0.0.2"}},
+				"host3": {Address: &pb.IP_Ipv4{Ipv4: "10.0.0.3"}},
+			}),
+			UpdatesChan: make(chan *BalancerState, 10),
+		},
+		initialState:              true,
+		weightUp:                  1000,
+		statBalancerState:         v2stats.NewGaugeGroup(balancerStateGauge),
+		statUpstreamsCount:        v2stats.NewGaugeGroup(upstreamsCountGauge),
+		statPassiveHealthOutliers: passiveHealthOutliersGauge.Must(tags),
+		healthySince:              make(map[string]time.Time),
+		statSlowStartUpstreams:    slowStartUpstreamsGauge.Must(tags),
+		lastHealthy:               make(map[string]time.Time),
+		statHostOverrides:         v2stats.NewGaugeGroup(hostOverridesGauge),
+		draining:                  make(map[string]*drainingUpstream),
+		statDrainingUpstreams:     drainingUpstreamsGauge.Must(tags),
+	}
+	balancer.state.Store(&BalancerState{InitialState: true})
+	return balancer
+}
+
 func (s *BalancerSuite) TestBasicFlow(c *C) {
 	dnsCache := map[string]*pb.IP{
 		"test-host-1": &pb.IP{
@@ -122,6 +159,7 @@ func (s *BalancerSuite) TestBasicFlow(c *C) {
 					// forward method.
 					ForwardMethod: balancerConfig.GetUpstreamRouting().GetForwardMethod(),
 					Weight:        0,
+					Unhealthy:     true,
 				},
 			},
 		})
@@ -135,6 +173,7 @@ func (s *BalancerSuite) TestBasicFlow(c *C) {
 			// forward method.
 			ForwardMethod: balancerConfig.GetUpstreamRouting().GetForwardMethod(),
 			Weight:        0,
+			Unhealthy:     true,
 		})
 	case <-time.After(5 * time.Second):
 		c.Log("fails to wait second update from balancer.")
@@ -264,6 +303,7 @@ func (s *BalancerSuite) TestBasicFlow(c *C) {
 					// forward method.
 					ForwardMethod: balancerConfig.GetUpstreamRouting().GetForwardMethod(),
 					Weight:        0,
+					Unhealthy:     true,
 				},
 			},
 		})
@@ -903,3 +943,176 @@ func (s *BalancerSuite) TestUpdatingCustomWeightAndUpdate(c *C) {
 		c.Fail()
 	}
 }
+
+func (s *BalancerSuite) TestScaleWeight(c *C) {
+	c.Assert(scaleWeight(1000, 100), Equals, uint32(1000))
+	c.Assert(scaleWeight(1000, 75), Equals, uint32(750))
+	c.Assert(scaleWeight(1000, 256), Equals, uint32(2560))
+	// drained upstream.
+	c.Assert(scaleWeight(1000, 0), Equals, uint32(0))
+	// small weight is rounded up to keep upstream alive.
+	c.Assert(scaleWeight(10, 1), Equals, uint32(1))
+	c.Assert(scaleWeight(math.MaxUint32, 200), Equals, uint32(math.MaxUint32))
+}
+
+func (s *BalancerSuite) TestConnectionThresholds(c *C) {
+	routing := &pb.UpstreamRouting{
+		ConnectionThresholds: &pb.ConnectionThresholds{Upper: 1000, Lower: 500},
+		HostConnectionThresholds: map[string]*pb.ConnectionThresholds{
+			"host2": {Upper: 100},
+		},
+	}
+	c.Assert(
+		connectionThresholds(routing, "host1"),
+		DeepEqualsPretty,
+		&pb.ConnectionThresholds{Upper: 1000, Lower: 500})
+	c.Assert(
+		connectionThresholds(routing, "host2"),
+		DeepEqualsPretty,
+		&pb.ConnectionThresholds{Upper: 100})
+	// no thresholds.
+	c.Assert(connectionThresholds(&pb.UpstreamRouting{}, "host1").GetUpper(), Equals, uint32(0))
+	c.Assert(connectionThresholds(nil, "host1").GetLower(), Equals, uint32(0))
+}
+
+func (s *BalancerSuite) TestTunnelEncapsulation(c *C) {
+	gue := &pb.TunnelEncapsulation{
+		Type: pb.TunnelEncapsulation_GUE,
+		Port: 6080,
+	}
+	c.Assert(
+		tunnelEncapsulation(&pb.UpstreamRouting{Tunnel: gue}),
+		DeepEqualsPretty,
+		gue)
+	// IPIP is represented by nil.
+	c.Assert(tunnelEncapsulation(&pb.UpstreamRouting{
+		Tunnel: &pb.TunnelEncapsulation{},
+	}), IsNil)
+	c.Assert(tunnelEncapsulation(&pb.UpstreamRouting{}), IsNil)
+	// encapsulation is ignored by other forwarding methods.
+	c.Assert(tunnelEncapsulation(&pb.UpstreamRouting{
+		ForwardMethod: pb.ForwardMethods_MASQ,
+		Tunnel:        gue,
+	}), IsNil)
+}
+
+type fakePassiveHealth struct {
+	outliers map[string]struct{}
+}
+
+func (f *fakePassiveHealth) IsOutlier(service string, address net.IP, port uint32) bool {
+	_, ok := f.outliers[passive_health.Key(service, address, port)]
+	return ok
+}
+
+func (f *fakePassiveHealth) Subscribe() <-chan struct{} {
+	return make(chan struct{})
+}
+
+func (f *fakePassiveHealth) Unsubscribe(<-chan struct{}) {}
+
+func (s *BalancerSuite) TestApplyPassiveHealth(c *C) {
+	provider := &fakePassiveHealth{
+		outliers: map[string]struct{}{
+			"172.0.0.1:80-tcp/10.0.0.1:80": {},
+			"172.0.0.1:80-tcp/10.0.0.2:80": {},
+			"172.0.0.1:80-tcp/10.0.0.3:80": {},
+			// outlier of other service.
+			"172.0.0.2:80-tcp/10.0.0.4:80": {},
+		},
+	}
+	balancer := &Balancer{
+		name: "test-balancer",
+		config: &pb.BalancerConfig{
+			Name:      "test-balancer",
+			SetupName: "test-setup",
+			LbService: &pb.LoadBalancerService{
+				Service: &pb.LoadBalancerService_IpvsService{
+					IpvsService: &pb.IpvsService{
+						Attributes: &pb.IpvsService_TcpAttributes{
+							TcpAttributes: &pb.IpvsTcpAttributes{
+								Address: common.NetIpToKglbAddr(net.ParseIP("172.0.0.1")),
+								Port:    80,
+							},
+						},
+					},
+				},
+			},
+			PassiveHealth: &pb.PassiveHealth{
+				Enabled:              true,
+				OutlierWeightPercent: 10,
+				RestoreDelayMs:       1000,
+			},
+		},
+		params:      &BalancerParams{PassiveHealth: provider},
+		updatesConf: make(chan struct{}, 1),
+		outliers:    make(map[string]time.Time),
+		statPassiveHealthOutliers: passiveHealthOutliersGauge.Must(v2stats.KV{
+			"setup":   "test-setup",
+			"service": "test-balancer",
+		}),
+	}
+	defer func() {
+		if balancer.outlierTimer != nil {
+			balancer.outlierTimer.Stop()
+		}
+	}()
+
+	makeUpstreams := func() []*pb.UpstreamState {
+		var upstreams []*pb.UpstreamState
+		for i := 1; i <= 4; i++ {
+			upstreams = append(upstreams, &pb.UpstreamState{
+				Hostname: fmt.Sprintf("host%d", i),
+				Address:  common.NetIpToKglbAddr(net.ParseIP(fmt.Sprintf("10.0.0.%d", i))),
+				Port:     80,
+				Weight:   1000,
+			})
+		}
+		return upstreams
+	}
+	now := time.Now()
+
+	// unhealthy upstream is not counted, max ratio allows 2 outliers of 4.
+	upstreams := makeUpstreams()
+	upstreams[0].Weight = 0
+	balancer.applyPassiveHealth(upstreams, now)
+	c.Assert(upstreams[0].Weight, Equals, uint32(0))
+	c.Assert(upstreams[1].Weight, Equals, uint32(100))
+	c.Assert(upstreams[2].Weight, Equals, uint32(100))
+	c.Assert(upstreams[3].Weight, Equals, uint32(1000))
+	c.Assert(balancer.outlierTimer, NotNil)
+
+	// outliers are not flagged anymore, but they are restored only after
+	// the delay.
+	provider.outliers = map[string]struct{}{}
+	upstreams = makeUpstreams()
+	balancer.applyPassiveHealth(upstreams, now.Add(500*time.Millisecond))
+	c.Assert(upstreams[0].Weight, Equals, uint32(1000))
+	c.Assert(upstreams[1].Weight, Equals, uint32(100))
+	c.Assert(upstreams[2].Weight, Equals, uint32(100))
+	c.Assert(upstreams[3].Weight, Equals, uint32(1000))
+
+	upstreams = makeUpstreams()
+	balancer.applyPassiveHealth(upstreams, now.Add(time.Second))
+	for _, upstream := range upstreams {
+		c.Assert(upstream.Weight, Equals, uint32(1000))
+	}
+	c.Assert(balancer.outliers, HasLen, 0)
+	c.Assert(balancer.outlierTimer, IsNil)
+
+	// default weight percent isn't zero.
+	provider.outliers = map[string]struct{}{"172.0.0.1:80-tcp/10.0.0.1:80": {}}
+	balancer.config.PassiveHealth.OutlierWeightPercent = 0
+	upstreams = makeUpstreams()
+	balancer.applyPassiveHealth(upstreams, now)
+	c.Assert(upstreams[0].Weight, Equals, uint32(100))
+
+	// disabled.
+	balancer.config.PassiveHealth.Enabled = false
+	upstreams = makeUpstreams()
+	balancer.applyPassiveHealth(upstreams, now)
+	for _, upstream := range upstreams {
+		c.Assert(upstream.Weight, Equals, uint32(1000))
+	}
+	c.Assert(balancer.outliers, HasLen, 0)
+}
@@ -1,6 +1,7 @@
 package control_plane
 
 import (
+	"net"
 	"time"
 
 	kglb_pb "dropbox/proto/kglb"
@@ -17,3 +18,14 @@ const (
 type DataPlaneClient interface {
 	Set(state *kglb_pb.DataPlaneState) error
 }
+
+// Source of passive health signals about reals produced by data plane.
+type PassiveHealthProvider interface {
+	// Returns true when the real of the service is flagged as outlier, service
+	// is identity returned by common.GetKeyFromLbService().
+	IsOutlier(service string, address net.IP, port uint32) bool
+	// Returns channel notifying about changes of outliers.
+	Subscribe() <-chan struct{}
+	// Removes subscription created by Subscribe call.
+	Unsubscribe(ch <-chan struct{})
+}
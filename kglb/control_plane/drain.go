@@ -0,0 +1,104 @@
+package control_plane
+
+import (
+	"sort"
+	"time"
+
+	"github.com/gogo/protobuf/proto"
+
+	"dropbox/dlog"
+	pb "dropbox/proto/kglb"
+)
+
+// Upstream removed from discovery which is kept with zero weight until drain
+// timeout elapses.
+type drainingUpstream struct {
+	state    *pb.UpstreamState
+	deadline time.Time
+}
+
+// Tracks upstreams removed since previous state and returns ones which are
+// still draining. Data plane deletes them earlier when they don't have active
+// connections. Should be called under u.mutex.
+func (u *Balancer) updateDraining(
+	upstreams []*pb.UpstreamState,
+	now time.Time) []*pb.UpstreamState {
+
+	timeout := time.Duration(u.config.GetDrainTimeoutMs()) * time.Millisecond
+
+	present := make(map[string]struct{}, len(upstreams))
+	for _, upstream := range upstreams {
+		present[upstreamKey(upstream)] = struct{}{}
+	}
+
+	if timeout > 0 {
+		var prevUpstreams []*pb.UpstreamState
+		if prevStates := u.GetState().States; len(prevStates) > 0 {
+			prevUpstreams = prevStates[0].GetUpstreams()
+		}
+		for _, upstream := range prevUpstreams {
+			key := upstreamKey(upstream)
+			if upstream.GetDraining() {
+				continue
+			}
+			if _, ok := present[key]; ok {
+				continue
+			}
+			state := proto.Clone(upstream).(*pb.UpstreamState)
+			state.Weight = DefaultWeightDown
+			state.Draining = true
+			u.draining[key] = &drainingUpstream{
+				state:    state,
+				deadline: now.Add(timeout),
+			}
+			dlog.Infof(
+				"%s upstream has been removed from %s balancer, draining it for %v",
+				upstream.GetHostname(),
+				u.name,
+				timeout)
+		}
+	}
+
+	var nextDeadline time.Time
+	result := make([]*pb.UpstreamState, 0, len(u.draining))
+	for key, upstream := range u.draining {
+		if _, ok := present[key]; ok {
+			// upstream is back in discovery.
+			delete(u.draining, key)
+			continue
+		}
+		if timeout == 0 || !now.Before(upstream.deadline) {
+			dlog.Infof(
+				"drain timeout of %s upstream of %s balancer has elapsed",
+				upstream.state.GetHostname(),
+				u.name)
+			delete(u.draining, key)
+			continue
+		}
+		if nextDeadline.IsZero() || upstream.deadline.Before(nextDeadline) {
+			nextDeadline = upstream.deadline
+		}
+		result = append(result, upstream.state)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return upstreamKey(result[i]) < upstreamKey(result[j])
+	})
+
+	u.statDrainingUpstreams.Set(float64(len(result)))
+
+	// regenerating state when the nearest drain timeout elapses.
+	if u.drainTimer != nil {
+		u.drainTimer.Stop()
+		u.drainTimer = nil
+	}
+	if !nextDeadline.IsZero() {
+		u.drainTimer = time.AfterFunc(nextDeadline.Sub(now), func() {
+			select {
+			case u.updatesConf <- struct{}{}:
+			default:
+			}
+		})
+	}
+
+	return result
+}
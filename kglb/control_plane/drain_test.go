@@ -0,0 +1,76 @@
+package control_plane
+
+import (
+	"time"
+
+	. "gopkg.in/check.v1"
+
+	pb "dropbox/proto/kglb"
+	. "godropbox/gocheck2"
+)
+
+type DrainSuite struct{}
+
+var _ = Suite(&DrainSuite{})
+
+func stopDrainTimer(balancer *Balancer) {
+	balancer.mutex.Lock()
+	defer balancer.mutex.Unlock()
+	if balancer.drainTimer != nil {
+		balancer.drainTimer.Stop()
+	}
+}
+
+func (s *DrainSuite) TestDraining(c *C) {
+	balancer := newStateTestBalancer(&pb.BalancerConfig{
+		Name:           c.TestName(),
+		SetupName:      "test-setup",
+		DrainTimeoutMs: 60000,
+	})
+	defer stopDrainTimer(balancer)
+
+	weights, _ := generateWeights(c, balancer, true, true, true)
+	c.Assert(weights, DeepEquals, []uint32{1000, 1000, 1000})
+
+	// removed upstream is kept with zero weight.
+	weights, state := generateWeights(c, balancer, true, true)
+	c.Assert(weights, DeepEquals, []uint32{1000, 1000, 0})
+	upstreams := state.States[0].Upstreams
+	c.Assert(upstreams[2].Hostname, Equals, "host3")
+	c.Assert(upstreams[2].Draining, IsTrue)
+	c.Assert(upstreams[0].Draining, IsFalse)
+	c.Assert(state.AliveRatio, Equals, float32(1))
+	c.Assert(balancer.drainTimer, NotNil)
+
+	// draining upstream stays until timeout.
+	weights, _ = generateWeights(c, balancer, true, true)
+	c.Assert(weights, DeepEquals, []uint32{1000, 1000, 0})
+	c.Assert(balancer.draining, HasLen, 1)
+
+	// upstream is back in discovery.
+	weights, state = generateWeights(c, balancer, true, true, false)
+	c.Assert(weights, DeepEquals, []uint32{1000, 1000, 0})
+	c.Assert(state.States[0].Upstreams[2].Draining, IsFalse)
+	c.Assert(balancer.draining, HasLen, 0)
+
+	// upstream is removed after timeout.
+	generateWeights(c, balancer, true, true)
+	c.Assert(balancer.draining, HasLen, 1)
+	balancer.draining["host3:80"].deadline = time.Now().Add(-time.Second)
+	weights, _ = generateWeights(c, balancer, true, true)
+	c.Assert(weights, DeepEquals, []uint32{1000, 1000})
+	c.Assert(balancer.draining, HasLen, 0)
+	c.Assert(balancer.drainTimer, IsNil)
+}
+
+func (s *DrainSuite) TestDrainingDisabled(c *C) {
+	balancer := newStateTestBalancer(&pb.BalancerConfig{
+		Name:      c.TestName(),
+		SetupName: "test-setup",
+	})
+
+	generateWeights(c, balancer, true, true, true)
+	weights, _ := generateWeights(c, balancer, true, true)
+	c.Assert(weights, DeepEquals, []uint32{1000, 1000})
+	c.Assert(balancer.draining, HasLen, 0)
+}
@@ -0,0 +1,97 @@
+package control_plane
+
+import (
+	"net"
+	"sort"
+	"strconv"
+	"time"
+
+	pb "dropbox/proto/kglb"
+)
+
+// default number of upstreams kept in KEEP_LAST_HEALTHY failsafe mode.
+const DefaultFailsafeKeepLastCount = uint32(1)
+
+// Returns key of the upstream matching discovery.HostPort key.
+func upstreamKey(upstream *pb.UpstreamState) string {
+	return net.JoinHostPort(
+		upstream.GetHostname(),
+		strconv.Itoa(int(upstream.GetPort())))
+}
+
+// Remembers when upstreams were healthy last time and forgets upstreams
+// removed from discovery. Should be called under u.mutex.
+func (u *Balancer) updateLastHealthy(
+	upstreams []*pb.UpstreamState,
+	healthy map[string]struct{},
+	now time.Time) {
+
+	present := make(map[string]struct{}, len(upstreams))
+	for _, upstream := range upstreams {
+		key := upstreamKey(upstream)
+		present[key] = struct{}{}
+		if _, ok := healthy[key]; ok {
+			u.lastHealthy[key] = now
+		}
+	}
+
+	for key := range u.lastHealthy {
+		if _, ok := present[key]; !ok {
+			delete(u.lastHealthy, key)
+		}
+	}
+}
+
+// Applies failsafe policy when all upstreams fail health checks. Returns false
+// when balancer fails closed and upstreams weights are untouched. Should be
+// called under u.mutex.
+func (u *Balancer) applyFailsafe(upstreams []*pb.UpstreamState) bool {
+	conf := u.config.GetFailsafe()
+
+	if conf.GetMode() == pb.Failsafe_FAIL_CLOSED {
+		return false
+	}
+	if uint32(len(upstreams)) < conf.GetMinUpstreams() {
+		return false
+	}
+
+	if conf.GetMode() == pb.Failsafe_KEEP_LAST_HEALTHY {
+		keepCount := conf.GetKeepLastCount()
+		if keepCount == 0 {
+			keepCount = DefaultFailsafeKeepLastCount
+		}
+
+		// upstreams which were healthy, most recent first. Upstreams which
+		// were healthy at the same time are ordered by key, so the same
+		// upstreams are kept regardless of discovery order.
+		var known []*pb.UpstreamState
+		for _, upstream := range upstreams {
+			if _, ok := u.lastHealthy[upstreamKey(upstream)]; ok {
+				known = append(known, upstream)
+			}
+		}
+		sort.SliceStable(known, func(i, j int) bool {
+			keyI := upstreamKey(known[i])
+			keyJ := upstreamKey(known[j])
+			if !u.lastHealthy[keyI].Equal(u.lastHealthy[keyJ]) {
+				return u.lastHealthy[keyI].After(u.lastHealthy[keyJ])
+			}
+			return keyI < keyJ
+		})
+
+		if len(known) > 0 {
+			if uint32(len(known)) > keepCount {
+				known = known[:keepCount]
+			}
+			for _, upstream := range known {
+				upstream.Weight = u.weightUp
+			}
+			return true
+		}
+	}
+
+	for _, upstream := range upstreams {
+		upstream.Weight = u.weightUp
+	}
+	return true
+}
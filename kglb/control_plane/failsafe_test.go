@@ -0,0 +1,152 @@
+package control_plane
+
+import (
+	"time"
+
+	. "gopkg.in/check.v1"
+
+	"dropbox/kglb/utils/health_manager"
+	pb "dropbox/proto/kglb"
+	. "godropbox/gocheck2"
+)
+
+type FailsafeSuite struct{}
+
+var _ = Suite(&FailsafeSuite{})
+
+// Returns weights of upstreams generated by balancer for provided states of
+// host1, host2 and host3.
+func generateWeights(
+	c *C,
+	balancer *Balancer,
+	healthy ...bool) ([]uint32, *BalancerState) {
+
+	var state health_manager.HealthManagerState
+	for i, isHealthy := range healthy {
+		state = append(state, health_manager.NewHealthManagerEntry(
+			isHealthy,
+			[]string{"host1", "host2", "host3"}[i],
+			80))
+	}
+	balancer.updateState(state)
+
+	balancerState := balancer.GetState()
+	c.Assert(balancerState.States, HasLen, 1)
+	var weights []uint32
+	for _, upstream := range balancerState.States[0].Upstreams {
+		weights = append(weights, upstream.Weight)
+	}
+	return weights, balancerState
+}
+
+func (s *FailsafeSuite) TestAllUp(c *C) {
+	balancer := newStateTestBalancer(&pb.BalancerConfig{
+		Name:      c.TestName(),
+		SetupName: "test-setup",
+	})
+
+	// failsafe is not applied during initialization.
+	weights, state := generateWeights(c, balancer, false, false, false)
+	c.Assert(weights, DeepEquals, []uint32{0, 0, 0})
+	c.Assert(state.Failsafe, IsFalse)
+	c.Assert(state.FailClosed, IsFalse)
+
+	weights, state = generateWeights(c, balancer, true, false, false)
+	c.Assert(weights, DeepEquals, []uint32{1000, 0, 0})
+	c.Assert(state.Failsafe, IsFalse)
+
+	weights, state = generateWeights(c, balancer, false, false, false)
+	c.Assert(weights, DeepEquals, []uint32{1000, 1000, 1000})
+	c.Assert(state.Failsafe, IsTrue)
+	c.Assert(state.FailClosed, IsFalse)
+	c.Assert(state.AliveRatio, Equals, float32(1))
+}
+
+func (s *FailsafeSuite) TestFailClosed(c *C) {
+	balancer := newStateTestBalancer(&pb.BalancerConfig{
+		Name:      c.TestName(),
+		SetupName: "test-setup",
+		Failsafe: &pb.Failsafe{
+			Mode: pb.Failsafe_FAIL_CLOSED,
+		},
+	})
+
+	generateWeights(c, balancer, true, true, true)
+	weights, state := generateWeights(c, balancer, false, false, false)
+	c.Assert(weights, DeepEquals, []uint32{0, 0, 0})
+	c.Assert(state.Failsafe, IsFalse)
+	c.Assert(state.FailClosed, IsTrue)
+}
+
+func (s *FailsafeSuite) TestMinUpstreams(c *C) {
+	balancer := newStateTestBalancer(&pb.BalancerConfig{
+		Name:      c.TestName(),
+		SetupName: "test-setup",
+		Failsafe: &pb.Failsafe{
+			MinUpstreams: 3,
+		},
+	})
+
+	generateWeights(c, balancer, true, true, true)
+	weights, state := generateWeights(c, balancer, false, false, false)
+	c.Assert(weights, DeepEquals, []uint32{1000, 1000, 1000})
+	c.Assert(state.Failsafe, IsTrue)
+
+	// not enough upstreams for failsafe.
+	weights, state = generateWeights(c, balancer, false, false)
+	c.Assert(weights, DeepEquals, []uint32{0, 0})
+	c.Assert(state.FailClosed, IsTrue)
+}
+
+func (s *FailsafeSuite) TestKeepLastHealthy(c *C) {
+	balancer := newStateTestBalancer(&pb.BalancerConfig{
+		Name:      c.TestName(),
+		SetupName: "test-setup",
+		Failsafe: &pb.Failsafe{
+			Mode:          pb.Failsafe_KEEP_LAST_HEALTHY,
+			KeepLastCount: 2,
+		},
+	})
+
+	generateWeights(c, balancer, true, true, false)
+	now := time.Now()
+	balancer.lastHealthy["host1:80"] = now.Add(-time.Minute)
+	balancer.lastHealthy["host2:80"] = now.Add(-time.Second)
+	balancer.lastHealthy["host3:80"] = now.Add(-time.Hour)
+
+	weights, state := generateWeights(c, balancer, false, false, false)
+	c.Assert(weights, DeepEquals, []uint32{1000, 1000, 0})
+	c.Assert(state.Failsafe, IsTrue)
+	c.Assert(state.FailClosed, IsFalse)
+
+	balancer.config.Failsafe.KeepLastCount = 1
+	weights, _ = generateWeights(c, balancer, false, false, false)
+	c.Assert(weights, DeepEquals, []uint32{0, 1000, 0})
+
+	// upstreams healthy at the same time are ordered by key.
+	balancer.lastHealthy["host1:80"] = now
+	balancer.lastHealthy["host2:80"] = now
+	balancer.lastHealthy["host3:80"] = now
+	weights, _ = generateWeights(c, balancer, false, false, false)
+	c.Assert(weights, DeepEquals, []uint32{1000, 0, 0})
+
+	// all upstreams are kept when none of them was healthy.
+	balancer.lastHealthy = make(map[string]time.Time)
+	weights, _ = generateWeights(c, balancer, false, false, false)
+	c.Assert(weights, DeepEquals, []uint32{1000, 1000, 1000})
+}
+
+func (s *FailsafeSuite) TestCanAnnounceRoute(c *C) {
+	c.Assert(canAnnounceRoute(&BalancerState{AliveRatio: 0.9}, 0.5), IsTrue)
+	c.Assert(canAnnounceRoute(&BalancerState{AliveRatio: 0.4}, 0.5), IsFalse)
+	c.Assert(canAnnounceRoute(&BalancerState{
+		AliveRatio:   0.9,
+		InitialState: true,
+	}, 0.5), IsFalse)
+	// failsafe mode keeps route even with low alive ratio.
+	c.Assert(canAnnounceRoute(&BalancerState{
+		AliveRatio: 0.4,
+		Failsafe:   true,
+	}, 0.5), IsTrue)
+	c.Assert(canAnnounceRoute(&BalancerState{FailClosed: true}, 0), IsFalse)
+}
@@ -0,0 +1,73 @@
+package control_plane
+
+import (
+	"time"
+
+	"dropbox/dlog"
+	"dropbox/exclog"
+	"dropbox/kglb/utils/host_override"
+	pb "dropbox/proto/kglb"
+	"dropbox/vortex2/v2stats"
+	"godropbox/errors"
+)
+
+// Returns action of active host override of the hostname in the balancer.
+func (u *Balancer) hostOverride(
+	hostname string,
+	now time.Time) (host_override.Action, bool) {
+
+	if u.params.HostOverrides == nil {
+		return "", false
+	}
+	override, ok := u.params.HostOverrides.Get(u.name, hostname, now)
+	if !ok {
+		return "", false
+	}
+	return override.Action, true
+}
+
+// Sets zero weight to upstreams drained or forced down by operator and
+// reports number of overridden upstreams. Should be called under u.mutex.
+func (u *Balancer) applyHostOverrides(
+	upstreams []*pb.UpstreamState,
+	overrides map[string]host_override.Action) {
+
+	counts := map[host_override.Action]int{
+		host_override.Drain:     0,
+		host_override.ForceDown: 0,
+		host_override.ForceUp:   0,
+	}
+	for _, upstream := range upstreams {
+		action, ok := overrides[upstreamKey(upstream)]
+		if !ok {
+			continue
+		}
+		counts[action]++
+		if action == host_override.ForceUp {
+			continue
+		}
+		if upstream.Weight != DefaultWeightDown {
+			dlog.Infof(
+				"host override: %s upstream of %s balancer is set to zero weight "+
+					"because of %s override",
+				upstream.GetHostname(),
+				u.name,
+				action)
+		}
+		upstream.Weight = DefaultWeightDown
+	}
+
+	for action, count := range counts {
+		tags := v2stats.KV{
+			"setup":   u.params.BalancerConfig.SetupName,
+			"service": u.params.BalancerConfig.Name,
+			"action":  string(action),
+		}
+		if err := u.statHostOverrides.PrepareToSet(float64(count), tags); err != nil {
+			exclog.Report(errors.Wrapf(err,
+				"unable to set hostOverrides gauge (%v)", tags), exclog.Critical, "")
+			return
+		}
+	}
+	u.statHostOverrides.SetAndReset()
+}
@@ -0,0 +1,90 @@
+package control_plane
+
+import (
+	. "gopkg.in/check.v1"
+
+	"dropbox/kglb/utils/host_override"
+	pb "dropbox/proto/kglb"
+	. "godropbox/gocheck2"
+)
+
+type HostOverrideSuite struct{}
+
+var _ = Suite(&HostOverrideSuite{})
+
+func (s *HostOverrideSuite) TestApplyHostOverrides(c *C) {
+	store, err := host_override.NewStore("")
+	c.Assert(err, IsNil)
+	balancer := newStateTestBalancer(&pb.BalancerConfig{
+		Name:      c.TestName(),
+		SetupName: "test-setup",
+	})
+	balancer.params.HostOverrides = store
+
+	weights, _ := generateWeights(c, balancer, true, true, false)
+	c.Assert(weights, DeepEquals, []uint32{1000, 1000, 0})
+
+	// balancer scoped drain and global force up.
+	c.Assert(store.Set(host_override.HostOverride{
+		Hostname: "host1",
+		Balancer: c.TestName(),
+		Action:   host_override.Drain,
+	}), IsNil)
+	c.Assert(store.Set(host_override.HostOverride{
+		Hostname: "host3",
+		Action:   host_override.ForceUp,
+	}), IsNil)
+	// override of another balancer is ignored.
+	c.Assert(store.Set(host_override.HostOverride{
+		Hostname: "host2",
+		Balancer: "another",
+		Action:   host_override.ForceDown,
+	}), IsNil)
+	weights, state := generateWeights(c, balancer, true, true, false)
+	c.Assert(weights, DeepEquals, []uint32{0, 1000, 1000})
+	c.Assert(state.AliveRatio, Equals, float32(2)/3)
+
+	// forced down upstreams stay down in failsafe mode.
+	c.Assert(store.Set(host_override.HostOverride{
+		Hostname: "host2",
+		Action:   host_override.ForceDown,
+	}), IsNil)
+	_, err = store.Delete("host3", "")
+	c.Assert(err, IsNil)
+	weights, state = generateWeights(c, balancer, false, false, false)
+	c.Assert(weights, DeepEquals, []uint32{0, 0, 1000})
+	c.Assert(state.Failsafe, IsTrue)
+
+	// drained healthy upstream doesn't trigger failsafe mode.
+	weights, state = generateWeights(c, balancer, true, false, false)
+	c.Assert(weights, DeepEquals, []uint32{0, 0, 0})
+	c.Assert(state.Failsafe, IsFalse)
+	c.Assert(state.AliveRatio, Equals, float32(0))
+}
+
+func (s *HostOverrideSuite) TestServicerApi(c *C) {
+	servicer := &ControlPlaneServicer{}
+	c.Assert(servicer.SetHostOverride(host_override.HostOverride{
+		Hostname: "host1",
+		Action:   host_override.Drain,
+	}), NotNil)
+
+	store, err := host_override.NewStore("")
+	c.Assert(err, IsNil)
+	servicer.modules.HostOverrides = store
+	c.Assert(servicer.SetHostOverride(host_override.HostOverride{
+		Hostname: "host1",
+		Action:   host_override.Drain,
+	}), IsNil)
+	overrides, err := servicer.ListHostOverrides()
+	c.Assert(err, IsNil)
+	c.Assert(overrides, HasLen, 1)
+	c.Assert(overrides[0].Hostname, Equals, "host1")
+
+	deleted, err := servicer.DeleteHostOverride("host1", "")
+	c.Assert(err, IsNil)
+	c.Assert(deleted, IsTrue)
+	overrides, err = servicer.ListHostOverrides()
+	c.Assert(err, IsNil)
+	c.Assert(overrides, HasLen, 0)
+}
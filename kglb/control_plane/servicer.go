@@ -5,7 +5,9 @@ import (
 	go_context "context"
 	"fmt"
 	"hash/fnv"
+	"math"
 	"net"
+	"sort"
 	"sync"
 	"time"
 
@@ -17,11 +19,23 @@ import (
 	common_config_loader "dropbox/kglb/utils/config_loader"
 	"dropbox/kglb/utils/dns_resolver"
 	"dropbox/kglb/utils/fwmark"
+	"dropbox/kglb/utils/health_manager"
+	"dropbox/kglb/utils/host_override"
 	pb "dropbox/proto/kglb"
 	"dropbox/vortex2/v2stats"
 	"godropbox/errors"
 )
 
+// range of vrrp priorities of the node, 0 and 255 are reserved.
+const (
+	minVrrpPriority = 1
+	maxVrrpPriority = 254
+	// number of steps of health ratio between min and max priorities, small
+	// fluctuations of health within a step don't change priority, so they
+	// don't flip master between nodes.
+	vrrpPrioritySteps = 4
+)
+
 // Custom handler called by Control Plane after initialization which includes
 // passing through following steps:
 // 1. completion to parse and apply configuration.
@@ -32,7 +46,7 @@ import (
 type AfterInitHandlerFunc func()
 
 type ControlPlaneServicer struct {
-	// mutext to protect state.
+	// mutext to protect state and modifications of balancers map.
 	mu sync.Mutex
 
 	ctx        context.Context
@@ -66,6 +80,15 @@ type ServicerModules struct {
 	// fwmark manager
 	FwmarkManager *fwmark.Manager
 
+	// (Optional) passive health signals from data plane.
+	PassiveHealth PassiveHealthProvider
+
+	// (Optional) scheduler to share health checks results between balancers.
+	CheckScheduler *health_manager.CheckScheduler
+
+	// (Optional) manual overrides of upstreams health status.
+	HostOverrides *host_override.Store
+
 	// handler called right after initialization.
 	AfterInitHandler AfterInitHandlerFunc
 }
@@ -119,6 +142,48 @@ func (s *ControlPlaneServicer) GetConfiguration(
 	return s.state, nil
 }
 
+// Returns diagnostics of health managers of all balancers sorted by balancer
+// name.
+func (s *ControlPlaneServicer) GetDiagnostics() []health_manager.HealthManagerDiagnostics {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	result := make([]health_manager.HealthManagerDiagnostics, 0, len(s.balancers))
+	for _, balancer := range s.balancers {
+		result = append(result, balancer.GetDiagnostics())
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Id < result[j].Id
+	})
+
+	return result
+}
+
+// Sets manual override of health status of the host.
+func (s *ControlPlaneServicer) SetHostOverride(override host_override.HostOverride) error {
+	if s.modules.HostOverrides == nil {
+		return errors.New("host overrides are not configured")
+	}
+	return s.modules.HostOverrides.Set(override)
+}
+
+// Removes manual override of the host in the balancer (empty balancer removes
+// global override). Returns false when the override doesn't exist.
+func (s *ControlPlaneServicer) DeleteHostOverride(hostname, balancer string) (bool, error) {
+	if s.modules.HostOverrides == nil {
+		return false, errors.New("host overrides are not configured")
+	}
+	return s.modules.HostOverrides.Delete(hostname, balancer)
+}
+
+// Returns active manual overrides of health status of hosts.
+func (s *ControlPlaneServicer) ListHostOverrides() ([]host_override.HostOverride, error) {
+	if s.modules.HostOverrides == nil {
+		return nil, errors.New("host overrides are not configured")
+	}
+	return s.modules.HostOverrides.List(), nil
+}
+
 func (s *ControlPlaneServicer) updateConfig(config *pb.ControlPlaneConfig) error {
 	// 1. Create required balancer.
 	for _, balancerConfig := range config.Balancers {
@@ -152,6 +217,9 @@ func (s *ControlPlaneServicer) updateConfig(config *pb.ControlPlaneConfig) error
 				DnsResolver:     s.modules.DnsResolver,      // dns module
 				UpdatesChan:     s.balancersUpdatesChan,     // updates channel
 				FwmarkManager:   s.modules.FwmarkManager,
+				PassiveHealth:   s.modules.PassiveHealth,
+				CheckScheduler:  s.modules.CheckScheduler,
+				HostOverrides:   s.modules.HostOverrides,
 			}
 			balancer, err = NewBalancer(s.ctx, balancerParams)
 			if err != nil {
@@ -160,7 +228,9 @@ func (s *ControlPlaneServicer) updateConfig(config *pb.ControlPlaneConfig) error
 					exclog.Critical, "")
 				return err
 			}
+			s.mu.Lock()
 			s.balancers[key] = balancer
+			s.mu.Unlock()
 		}
 	}
 
@@ -183,7 +253,9 @@ func (s *ControlPlaneServicer) updateConfig(config *pb.ControlPlaneConfig) error
 		if !found {
 			dlog.Infof("Removing balancer: %s, %s", balancer.Name(), balancerId)
 			balancer.Close()
+			s.mu.Lock()
 			delete(s.balancers, balancerId)
+			s.mu.Unlock()
 		}
 	}
 
@@ -290,6 +362,44 @@ func (s *ControlPlaneServicer) generateRoutes(
 	return result, nil
 }
 
+// generate list of l2 routes allowed to be announced, address is not announced
+// while any balancer of the address is not ready.
+func (s *ControlPlaneServicer) generateL2Routes(
+	allowedRoutes,
+	prohibitedRoutes []*pb.L2RouteAttributes) []*pb.DynamicRoute {
+
+	prohibitedAddrs := make(map[string]bool)
+	for _, route := range prohibitedRoutes {
+		prohibitedAddrs[common.KglbAddrToNetIp(route.GetAddress()).String()] = true
+	}
+
+	var result []*pb.DynamicRoute
+	// map of routes to skip dups.
+	resultMap := make(map[string]bool)
+	for _, route := range allowedRoutes {
+		addr := common.KglbAddrToNetIp(route.GetAddress()).String()
+		if prohibitedAddrs[addr] {
+			dlog.Info("address is not allowed to be announced yet: ", addr)
+			continue
+		}
+
+		key := common.L2RouteAttributesComparable.Key(route)
+		if _, ok := resultMap[key]; ok {
+			continue
+		}
+		result = append(
+			result,
+			&pb.DynamicRoute{
+				Attributes: &pb.DynamicRoute_L2Attributes{
+					L2Attributes: route,
+				},
+			})
+		resultMap[key] = true
+	}
+
+	return result
+}
+
 func perUpstreamHash(s string, u uint32) uint64 {
 	fnvHash := fnv.New64()
 	fnvHash.Write([]byte(fmt.Sprintf("%s_%d", s, u)))
@@ -317,6 +427,79 @@ func (s *ControlPlaneServicer) setStatesHashes(hashes map[string]uint64) {
 	}
 }
 
+// Returns true when route of the balancer can be announced.
+func canAnnounceRoute(state *BalancerState, confRatio float32) bool {
+	// do not announce route in initial state since alive ratio may be
+	// still zero during this period.
+	if state.InitialState || state.FailClosed || state.AliveRatio == 0 {
+		return false
+	}
+	// balancer in failsafe mode keeps the route regardless of alive ratio.
+	return state.Failsafe || state.AliveRatio >= confRatio
+}
+
+// Returns health ratio of the balancer, it's zero until balancer is able
+// to serve traffic.
+func balancerHealthRatio(state *BalancerState) float32 {
+	if state.InitialState || state.FailClosed {
+		return 0
+	}
+	return state.AliveRatio
+}
+
+// Returns vrrp instances of groups with priority derived from the lowest
+// health ratio of balancers of vips of the group, so node with healthier
+// balancers becomes master. Ratio is rounded down to vrrpPrioritySteps.
+func generateVrrpInstances(
+	groups []*pb.VrrpGroupConfig,
+	vipRatios map[string]float32) []*pb.VrrpInstance {
+
+	var result []*pb.VrrpInstance
+	for _, group := range groups {
+		var ratio float32
+		found := false
+		for _, addr := range group.GetAddresses() {
+			vipRatio, ok := vipRatios[common.KglbAddrToNetIp(addr).String()]
+			if !ok {
+				continue
+			}
+			if !found || vipRatio < ratio {
+				ratio = vipRatio
+			}
+			found = true
+		}
+
+		step := math.Floor(float64(ratio)*vrrpPrioritySteps) / vrrpPrioritySteps
+		result = append(result, &pb.VrrpInstance{
+			Vrid:      group.GetVrid(),
+			Interface: group.GetInterface(),
+			Addresses: group.GetAddresses(),
+			Priority: minVrrpPriority + uint32(math.Round(
+				step*(maxVrrpPriority-minVrrpPriority))),
+			AdvertIntervalMs: group.GetAdvertIntervalMs(),
+			Nopreempt:        group.GetNopreempt(),
+		})
+	}
+	return result
+}
+
+// Returns ipvs sync daemon of master or backup role.
+func generateSyncDaemon(config *pb.IpvsSyncConfig, master bool) *pb.IpvsSyncDaemon {
+	role := pb.IpvsSyncDaemon_BACKUP
+	if master {
+		role = pb.IpvsSyncDaemon_MASTER
+	}
+	return &pb.IpvsSyncDaemon{
+		Role:       role,
+		Interface:  config.GetInterface(),
+		SyncId:     config.GetSyncId(),
+		McastGroup: config.GetMcastGroup(),
+		McastPort:  config.GetMcastPort(),
+		McastTtl:   config.GetMcastTtl(),
+		SyncMaxlen: config.GetSyncMaxlen(),
+	}
+}
+
 func (s *ControlPlaneServicer) GenerateDataPlaneState() (
 	*pb.DataPlaneState, error) {
 
@@ -325,10 +508,15 @@ func (s *ControlPlaneServicer) GenerateDataPlaneState() (
 	var prohibitedRoutes []*pb.BgpRouteAttributes
 	// list of allowed routes.
 	var allowedRoutes []*pb.BgpRouteAttributes
+	// same lists of routes announced through l2 module.
+	var prohibitedL2Routes, allowedL2Routes []*pb.L2RouteAttributes
 
 	// number of fully uninitialized balancers.
 	statelessBalancerCnt := 0
 
+	// the lowest health ratio of balancers per vip, it defines vrrp priority.
+	vipRatios := make(map[string]float32)
+
 	result := &pb.DataPlaneState{}
 
 	existingFwmarks := make(map[uint32]bool)
@@ -356,6 +544,13 @@ func (s *ControlPlaneServicer) GenerateDataPlaneState() (
 		}
 		confRatio := balancerConfig.GetDynamicRouting().GetAnnounceLimitRatio()
 
+		if vip, _, err := common.GetVipFromLbService(balancerConfig.GetLbService()); err == nil {
+			ratio := balancerHealthRatio(balancerState)
+			if prev, ok := vipRatios[vip]; !ok || ratio < prev {
+				vipRatios[vip] = ratio
+			}
+		}
+
 		// Counting balancers without states, it generates states only after
 		// receiving update from health manager which does it after discovery and
 		// health checking.
@@ -369,12 +564,21 @@ func (s *ControlPlaneServicer) GenerateDataPlaneState() (
 			"service": balancerConfig.Name,
 		}
 
-		// do not announce route in initial state since alive ratio may be
-		// still zero during this period.
-		if balancerConfig.GetDynamicRouting().GetBgpAttributes() != nil &&
-			!balancerState.InitialState &&
-			balancerState.AliveRatio > 0 &&
-			balancerState.AliveRatio >= confRatio {
+		if l2Route := balancerConfig.GetDynamicRouting().GetL2Attributes(); l2Route != nil {
+			if canAnnounceRoute(balancerState, confRatio) {
+				allowedL2Routes = append(allowedL2Routes, l2Route)
+				tags["state"] = "on"
+			} else {
+				dlog.Infof(
+					"skipping announcing l2 route for balancer: %s, %+v, %+v",
+					balancerConfig.GetName(),
+					l2Route,
+					balancerState)
+				prohibitedL2Routes = append(prohibitedL2Routes, l2Route)
+				tags["state"] = "off"
+			}
+		} else if balancerConfig.GetDynamicRouting().GetBgpAttributes() != nil &&
+			canAnnounceRoute(balancerState, confRatio) {
 
 			allowedRoutes = append(
 				allowedRoutes,
@@ -408,6 +612,9 @@ func (s *ControlPlaneServicer) GenerateDataPlaneState() (
 	if err != nil {
 		return nil, err
 	}
+	result.DynamicRoutes = append(
+		result.DynamicRoutes,
+		s.generateL2Routes(allowedL2Routes, prohibitedL2Routes)...)
 
 	// generate link addresses.
 	linkAddresses, err := s.generateLinkAddrs()
@@ -416,6 +623,18 @@ func (s *ControlPlaneServicer) GenerateDataPlaneState() (
 	}
 	result.LinkAddresses = linkAddresses
 
+	// kernel settings are passed as is.
+	result.KernelSettings = s.config.GetKernelSettings()
+
+	// balancer announcing routes is master of connection synchronization.
+	if syncConfig := s.config.GetIpvsSync(); syncConfig != nil {
+		result.SyncDaemons = []*pb.IpvsSyncDaemon{
+			generateSyncDaemon(syncConfig, len(result.DynamicRoutes) > 0),
+		}
+	}
+
+	result.VrrpInstances = generateVrrpInstances(s.config.GetVrrpGroups(), vipRatios)
+
 	// validating generate state.
 	if err = common.ValidateDataPlaneState(result); err != nil {
 		return nil, err
@@ -2576,3 +2576,129 @@ func (s *ServicerSuite) TestAfterInitCallback(c *C) {
 	err = servicer.applyDataPlaneState(&pb.DataPlaneState{})
 	c.Assert(err, IsNil)
 }
+
+func (s *ServicerSuite) TestGenerateSyncDaemon(c *C) {
+	config := &pb.IpvsSyncConfig{
+		Interface:  "eth0",
+		SyncId:     10,
+		McastGroup: &pb.IP{Address: &pb.IP_Ipv4{Ipv4: "224.0.0.82"}},
+		McastPort:  8849,
+	}
+	c.Assert(generateSyncDaemon(config, true), DeepEquals, &pb.IpvsSyncDaemon{
+		Role:       pb.IpvsSyncDaemon_MASTER,
+		Interface:  "eth0",
+		SyncId:     10,
+		McastGroup: &pb.IP{Address: &pb.IP_Ipv4{Ipv4: "224.0.0.82"}},
+		McastPort:  8849,
+	})
+	c.Assert(
+		generateSyncDaemon(config, false).GetRole(),
+		Equals,
+		pb.IpvsSyncDaemon_BACKUP)
+}
+
+func (s *ServicerSuite) TestL2RouteGenerator(c *C) {
+	ctx, cancelFunc := context.WithCancel(context.Background())
+	defer cancelFunc()
+
+	servicer, err := newControlPlaneServicer(
+		ctx,
+		s.modules,
+		time.Millisecond)
+	c.Assert(err, NoErr)
+
+	route1 := &pb.L2RouteAttributes{
+		Address:   &pb.IP{Address: &pb.IP_Ipv4{Ipv4: "172.0.0.1"}},
+		Interface: "eth0",
+	}
+	route2 := &pb.L2RouteAttributes{
+		Address:   &pb.IP{Address: &pb.IP_Ipv6{Ipv6: "fc00::1"}},
+		Interface: "eth0",
+	}
+
+	// dups are skipped.
+	result := servicer.generateL2Routes(
+		[]*pb.L2RouteAttributes{route1, route2, route1}, nil)
+	c.Assert(result, DeepEqualsPretty, []*pb.DynamicRoute{
+		{Attributes: &pb.DynamicRoute_L2Attributes{L2Attributes: route1}},
+		{Attributes: &pb.DynamicRoute_L2Attributes{L2Attributes: route2}},
+	})
+
+	// address isn't announced while one of its balancers isn't ready.
+	result = servicer.generateL2Routes(
+		[]*pb.L2RouteAttributes{route1, route2},
+		[]*pb.L2RouteAttributes{route1})
+	c.Assert(result, DeepEqualsPretty, []*pb.DynamicRoute{
+		{Attributes: &pb.DynamicRoute_L2Attributes{L2Attributes: route2}},
+	})
+}
+
+func (s *ServicerSuite) TestVrrpInstanceGenerator(c *C) {
+	group1 := &pb.VrrpGroupConfig{
+		Vrid:      10,
+		Interface: "eth0",
+		Addresses: []*pb.IP{
+			{Address: &pb.IP_Ipv4{Ipv4: "172.0.0.1"}},
+			{Address: &pb.IP_Ipv4{Ipv4: "172.0.0.2"}},
+		},
+		AdvertIntervalMs: 100,
+	}
+	group2 := &pb.VrrpGroupConfig{
+		Vrid:      11,
+		Interface: "eth0",
+		Addresses: []*pb.IP{
+			{Address: &pb.IP_Ipv6{Ipv6: "fc00::1"}},
+		},
+		Nopreempt: true,
+	}
+
+	// priority is defined by the lowest ratio of vips of the group, group
+	// without balancers has the lowest priority.
+	result := generateVrrpInstances(
+		[]*pb.VrrpGroupConfig{group1, group2},
+		map[string]float32{
+			"172.0.0.1": 1,
+			"172.0.0.2": 0.5,
+		})
+	c.Assert(result, DeepEqualsPretty, []*pb.VrrpInstance{
+		{
+			Vrid:             10,
+			Interface:        "eth0",
+			Addresses:        group1.GetAddresses(),
+			Priority:         128,
+			AdvertIntervalMs: 100,
+		},
+		{
+			Vrid:      11,
+			Interface: "eth0",
+			Addresses: group2.GetAddresses(),
+			Priority:  1,
+			Nopreempt: true,
+		},
+	})
+
+	result = generateVrrpInstances(
+		[]*pb.VrrpGroupConfig{group1},
+		map[string]float32{
+			"172.0.0.1": 1,
+			"172.0.0.2": 1,
+		})
+	c.Assert(result[0].GetPriority(), Equals, uint32(254))
+
+	// priority is changed by steps of health ratio.
+	for ratio, priority := range map[float32]uint32{
+		0.99: 191,
+		0.76: 191,
+		0.74: 128,
+		0.49: 64,
+		0.2:  1,
+	} {
+		result = generateVrrpInstances(
+			[]*pb.VrrpGroupConfig{group1},
+			map[string]float32{
+				"172.0.0.1": 1,
+				"172.0.0.2": ratio,
+			})
+		c.Assert(result[0].GetPriority(), Equals, priority)
+	}
+}
@@ -0,0 +1,119 @@
+package control_plane
+
+import (
+	"math"
+	"time"
+
+	"dropbox/dlog"
+	pb "dropbox/proto/kglb"
+)
+
+const (
+	// default initial weight of slow starting upstream in percents.
+	DefaultSlowStartMinWeightPercent = uint32(10)
+	// default interval of updating weights of slow starting upstreams.
+	DefaultSlowStartUpdateInterval = 5 * time.Second
+)
+
+// Returns weight of upstream which became healthy elapsed time ago and true
+// when the upstream is still ramping.
+func slowStartWeight(
+	weight uint32,
+	elapsed time.Duration,
+	conf *pb.SlowStart) (uint32, bool) {
+
+	window := time.Duration(conf.GetWindowMs()) * time.Millisecond
+	if window == 0 || elapsed >= window || weight == 0 {
+		return weight, false
+	}
+	if elapsed < 0 {
+		elapsed = 0
+	}
+
+	minWeightPercent := conf.GetMinWeightPercent()
+	if minWeightPercent == 0 {
+		minWeightPercent = DefaultSlowStartMinWeightPercent
+	}
+	minFactor := float64(minWeightPercent) / 100
+	progress := float64(elapsed) / float64(window)
+
+	var factor float64
+	switch conf.GetMode() {
+	case pb.SlowStart_EXPONENTIAL:
+		// doubles weight with the same pace from min to full weight.
+		factor = math.Pow(minFactor, 1-progress)
+	default:
+		factor = minFactor + (1-minFactor)*progress
+	}
+
+	scaled := uint32(math.Ceil(float64(weight) * factor))
+	if scaled == 0 {
+		scaled = 1
+	}
+	if scaled > weight {
+		scaled = weight
+	}
+	return scaled, true
+}
+
+// Applies slow start to the weight of healthy upstream identified by the key
+// and returns true when the upstream is ramping. Should be called under
+// u.mutex.
+func (u *Balancer) applySlowStart(
+	key string,
+	weight uint32,
+	now time.Time) (uint32, bool) {
+
+	since, ok := u.healthySince[key]
+	if !ok {
+		// upstreams which are healthy during initialization receive full
+		// weight right away.
+		if !u.initialState {
+			since = now
+		}
+		u.healthySince[key] = since
+	}
+
+	if since.IsZero() {
+		return weight, false
+	}
+	return slowStartWeight(weight, now.Sub(since), u.config.GetSlowStart())
+}
+
+// Forgets upstreams which are not healthy anymore and schedules state
+// regeneration while there are ramping upstreams. Should be called under
+// u.mutex.
+func (u *Balancer) updateSlowStart(healthy map[string]struct{}, ramping int) {
+	for key := range u.healthySince {
+		if _, ok := healthy[key]; !ok {
+			delete(u.healthySince, key)
+		}
+	}
+
+	u.statSlowStartUpstreams.Set(float64(ramping))
+
+	if ramping == 0 || u.slowStartTimer != nil {
+		return
+	}
+
+	interval := DefaultSlowStartUpdateInterval
+	if intervalMs := u.config.GetSlowStart().GetUpdateIntervalMs(); intervalMs > 0 {
+		interval = time.Duration(intervalMs) * time.Millisecond
+	}
+	dlog.Infof(
+		"%d upstreams of %s balancer are slow starting, next update in %v",
+		ramping,
+		u.name,
+		interval)
+
+	u.slowStartTimer = time.AfterFunc(interval, func() {
+		u.mutex.Lock()
+		u.slowStartTimer = nil
+		u.mutex.Unlock()
+
+		select {
+		case u.updatesConf <- struct{}{}:
+		default:
+		}
+	})
+}
@@ -0,0 +1,112 @@
+package control_plane
+
+import (
+	"time"
+
+	. "gopkg.in/check.v1"
+
+	"dropbox/kglb/utils/health_manager"
+	pb "dropbox/proto/kglb"
+	. "godropbox/gocheck2"
+)
+
+type SlowStartSuite struct{}
+
+var _ = Suite(&SlowStartSuite{})
+
+func stopSlowStartTimer(balancer *Balancer) {
+	balancer.mutex.Lock()
+	defer balancer.mutex.Unlock()
+	if balancer.slowStartTimer != nil {
+		balancer.slowStartTimer.Stop()
+	}
+}
+
+func (s *SlowStartSuite) TestSlowStartWeight(c *C) {
+	linear := &pb.SlowStart{
+		WindowMs:         10000,
+		MinWeightPercent: 10,
+	}
+	weight, ramping := slowStartWeight(1000, 0, linear)
+	c.Assert(weight, Equals, uint32(100))
+	c.Assert(ramping, IsTrue)
+	weight, ramping = slowStartWeight(1000, 5*time.Second, linear)
+	c.Assert(weight, Equals, uint32(550))
+	c.Assert(ramping, IsTrue)
+	weight, ramping = slowStartWeight(1000, 10*time.Second, linear)
+	c.Assert(weight, Equals, uint32(1000))
+	c.Assert(ramping, IsFalse)
+
+	exponential := &pb.SlowStart{
+		WindowMs:         10000,
+		Mode:             pb.SlowStart_EXPONENTIAL,
+		MinWeightPercent: 1,
+	}
+	weight, ramping = slowStartWeight(1000, 0, exponential)
+	c.Assert(weight, Equals, uint32(10))
+	c.Assert(ramping, IsTrue)
+	weight, _ = slowStartWeight(1000, 5*time.Second, exponential)
+	c.Assert(weight, Equals, uint32(100))
+
+	// default min weight, small weight never becomes zero.
+	weight, _ = slowStartWeight(1000, 0, &pb.SlowStart{WindowMs: 10000})
+	c.Assert(weight, Equals, uint32(100))
+	weight, _ = slowStartWeight(1, 0, exponential)
+	c.Assert(weight, Equals, uint32(1))
+
+	// disabled slow start and drained upstream.
+	weight, ramping = slowStartWeight(1000, 0, nil)
+	c.Assert(weight, Equals, uint32(1000))
+	c.Assert(ramping, IsFalse)
+	weight, ramping = slowStartWeight(0, 0, linear)
+	c.Assert(weight, Equals, uint32(0))
+	c.Assert(ramping, IsFalse)
+}
+
+func (s *SlowStartSuite) TestUpdateState(c *C) {
+	config := &pb.BalancerConfig{
+		Name:      c.TestName(),
+		SetupName: "test-setup",
+		SlowStart: &pb.SlowStart{
+			WindowMs:         60000,
+			MinWeightPercent: 10,
+			UpdateIntervalMs: 60000,
+		},
+	}
+	balancer := newStateTestBalancer(config)
+	defer stopSlowStartTimer(balancer)
+
+	host1 := health_manager.NewHealthManagerEntry(true, "host1", 80)
+	host2 := health_manager.NewHealthManagerEntry(false, "host2", 80)
+
+	// upstreams healthy during initialization get full weight.
+	balancer.updateState(health_manager.HealthManagerState{host1, host2})
+	upstreams := balancer.GetState().States[0].Upstreams
+	c.Assert(upstreams[0].Weight, Equals, uint32(1000))
+	c.Assert(upstreams[1].Weight, Equals, uint32(0))
+	c.Assert(balancer.slowStartTimer, IsNil)
+
+	// newly healthy upstream is slow starting.
+	host2.Status.UpdateHealthCheckStatus(true, 1, 1)
+	balancer.updateState(health_manager.HealthManagerState{host1, host2})
+	upstreams = balancer.GetState().States[0].Upstreams
+	c.Assert(upstreams[0].Weight, Equals, uint32(1000))
+	c.Assert(upstreams[1].Weight, Equals, uint32(100))
+	c.Assert(balancer.slowStartTimer, NotNil)
+
+	// in the middle of the window.
+	balancer.healthySince["host2:80"] = time.Now().Add(-30 * time.Second)
+	balancer.updateState(health_manager.HealthManagerState{host1, host2})
+	upstreams = balancer.GetState().States[0].Upstreams
+	c.Assert(upstreams[1].Weight >= 550 && upstreams[1].Weight < 560, IsTrue)
+
+	// failed upstream starts from the beginning after recovery.
+	host2.Status.UpdateHealthCheckStatus(false, 1, 1)
+	balancer.updateState(health_manager.HealthManagerState{host1, host2})
+	_, ok := balancer.healthySince["host2:80"]
+	c.Assert(ok, IsFalse)
+	host2.Status.UpdateHealthCheckStatus(true, 1, 1)
+	balancer.updateState(health_manager.HealthManagerState{host1, host2})
+	upstreams = balancer.GetState().States[0].Upstreams
+	c.Assert(upstreams[1].Weight, Equals, uint32(100))
+}
@@ -6,7 +6,7 @@ import (
 
 // Current balancer state (on given setup/service)
 // Tags:
-// - state: current state of balancer [initial, available, no_upstreams, failsafe, shutdown]
+// - state: current state of balancer [initial, available, no_upstreams, failsafe, fail_closed, shutdown]
 // - setup: setup name
 // - service: service name
 var balancerStateGauge = v2stats.MustDefineGauge("kglb/control_plane/balancer_state", "setup", "service", "state")
@@ -33,3 +33,28 @@ var availabilityGauge = v2stats.MustDefineGauge("kglb/control_plane/availability
 // KGLB state hash gauge (gauge value being used to compare between different kglbs in same cluster)
 // same value means they are consistent (see the same amount of up/down hosts)
 var stateHashGauge = v2stats.MustDefineGauge("kglb/control_plane/state_hash", "entity", "setup")
+
+// Number of upstreams down-weighted because of passive health signals.
+// Tags:
+// - setup: setup name
+// - service: service name
+var passiveHealthOutliersGauge = v2stats.MustDefineGauge("kglb/control_plane/passive_health_outliers", "setup", "service")
+
+// Number of upstreams which weight is ramping because of slow start.
+// Tags:
+// - setup: setup name
+// - service: service name
+var slowStartUpstreamsGauge = v2stats.MustDefineGauge("kglb/control_plane/slow_start_upstreams", "setup", "service")
+
+// Number of upstreams with active manual host overrides.
+// Tags:
+// - setup: setup name
+// - service: service name
+// - action: override action [drain, force_down, force_up]
+var hostOverridesGauge = v2stats.MustDefineGauge("kglb/control_plane/host_overrides", "setup", "service", "action")
+
+// Number of upstreams removed from discovery which are draining.
+// Tags:
+// - setup: setup name
+// - service: service name
+var drainingUpstreamsGauge = v2stats.MustDefineGauge("kglb/control_plane/draining_upstreams", "setup", "service")
@@ -0,0 +1,414 @@
+package data_plane
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"time"
+
+	"dropbox/kglb/common"
+	kglb_pb "dropbox/proto/kglb"
+	"godropbox/errors"
+)
+
+// BGP-4 (RFC 4271) constants.
+const (
+	bgpVersion       = 4
+	bgpPort          = 179
+	bgpMarkerLen     = 16
+	bgpHeaderLen     = 19
+	bgpMaxMessageLen = 4096
+
+	bgpMsgOpen         = 1
+	bgpMsgUpdate       = 2
+	bgpMsgNotification = 3
+	bgpMsgKeepalive    = 4
+)
+
+// Path attributes.
+const (
+	bgpAttrFlagOptional    = 0x80
+	bgpAttrFlagTransitive  = 0x40
+	bgpAttrFlagExtendedLen = 0x10
+
+	bgpAttrOrigin              = 1
+	bgpAttrAsPath              = 2
+	bgpAttrNextHop             = 3
+	bgpAttrMed                 = 4
+	bgpAttrLocalPref           = 5
+	bgpAttrCommunities         = 8
+	bgpAttrMpReachNlri         = 14
+	bgpAttrMpUnreachNlri       = 15
+	bgpAttrExtendedCommunities = 16
+	bgpAttrLargeCommunities    = 32
+
+	bgpOriginIgp        = 0
+	bgpAsSequence       = 2
+	bgpDefaultLocalPref = 100
+)
+
+// Address families (RFC 4760) and capabilities (RFC 5492, RFC 6793).
+const (
+	bgpAfiIpv4     = 1
+	bgpAfiIpv6     = 2
+	bgpSafiUnicast = 1
+
+	bgpOptParamCapabilities = 2
+	bgpCapMultiprotocol     = 1
+	bgpCapFourOctetAs       = 65
+	// 2-octet placeholder of 4-octet ASN.
+	bgpAsTrans = 23456
+)
+
+// Notification error codes and subcodes.
+const (
+	bgpErrOpenMessage      = 2
+	bgpErrHoldTimerExpired = 4
+	bgpErrFsm              = 5
+	bgpErrCease            = 6
+
+	bgpErrSubBadPeerAs             = 2
+	bgpErrSubUnacceptableHoldTime  = 6
+	bgpErrSubUnsupportedCapability = 7
+)
+
+// Serializes message with the header.
+func marshalBgpMessage(msgType uint8, body []byte) []byte {
+	msg := make([]byte, bgpHeaderLen, bgpHeaderLen+len(body))
+	for i := 0; i < bgpMarkerLen; i++ {
+		msg[i] = 0xff
+	}
+	binary.BigEndian.PutUint16(msg[16:18], uint16(bgpHeaderLen+len(body)))
+	msg[18] = msgType
+	return append(msg, body...)
+}
+
+// Reads next message and returns its type and body.
+func readBgpMessage(r io.Reader) (uint8, []byte, error) {
+	header := make([]byte, bgpHeaderLen)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return 0, nil, err
+	}
+	for i := 0; i < bgpMarkerLen; i++ {
+		if header[i] != 0xff {
+			return 0, nil, errors.New("invalid bgp message marker")
+		}
+	}
+	length := int(binary.BigEndian.Uint16(header[16:18]))
+	if length < bgpHeaderLen || length > bgpMaxMessageLen {
+		return 0, nil, errors.Newf("invalid bgp message length: %d", length)
+	}
+	body := make([]byte, length-bgpHeaderLen)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return 0, nil, err
+	}
+	return header[18], body, nil
+}
+
+// NOTIFICATION message, it's used as error closing the session.
+type bgpNotification struct {
+	code    uint8
+	subcode uint8
+}
+
+func (n *bgpNotification) Error() string {
+	return fmt.Sprintf("bgp notification: code %d, subcode %d", n.code, n.subcode)
+}
+
+func (n *bgpNotification) marshal() []byte {
+	return marshalBgpMessage(bgpMsgNotification, []byte{n.code, n.subcode})
+}
+
+func parseBgpNotification(body []byte) (*bgpNotification, error) {
+	if len(body) < 2 {
+		return nil, errors.Newf("short bgp notification: %d bytes", len(body))
+	}
+	return &bgpNotification{code: body[0], subcode: body[1]}, nil
+}
+
+// OPEN message with multiprotocol and 4-octet ASN capabilities.
+type bgpOpen struct {
+	asn      uint32
+	holdTime time.Duration
+	routerId net.IP
+	// supported unicast address families.
+	ipv4 bool
+	ipv6 bool
+	// peer supports 4-octet ASN.
+	fourOctetAs bool
+}
+
+func (o *bgpOpen) marshal() []byte {
+	body := make([]byte, 5, 64)
+	body[0] = bgpVersion
+	asn := o.asn
+	if asn > 0xffff {
+		asn = bgpAsTrans
+	}
+	binary.BigEndian.PutUint16(body[1:3], uint16(asn))
+	binary.BigEndian.PutUint16(body[3:5], uint16(o.holdTime/time.Second))
+	body = append(body, o.routerId.To4()...)
+
+	var caps []byte
+	if o.ipv4 {
+		caps = append(caps, bgpCapMultiprotocol, 4, 0, bgpAfiIpv4, 0, bgpSafiUnicast)
+	}
+	if o.ipv6 {
+		caps = append(caps, bgpCapMultiprotocol, 4, 0, bgpAfiIpv6, 0, bgpSafiUnicast)
+	}
+	caps = append(caps, bgpCapFourOctetAs, 4)
+	caps = appendUint32(caps, o.asn)
+
+	body = append(body, uint8(len(caps)+2), bgpOptParamCapabilities, uint8(len(caps)))
+	body = append(body, caps...)
+	return marshalBgpMessage(bgpMsgOpen, body)
+}
+
+func parseBgpOpen(body []byte) (*bgpOpen, error) {
+	if len(body) < 10 {
+		return nil, errors.Newf("short bgp open: %d bytes", len(body))
+	}
+	if body[0] != bgpVersion {
+		return nil, errors.Newf("unsupported bgp version: %d", body[0])
+	}
+	open := &bgpOpen{
+		asn:      uint32(binary.BigEndian.Uint16(body[1:3])),
+		holdTime: time.Duration(binary.BigEndian.Uint16(body[3:5])) * time.Second,
+		routerId: net.IP(append([]byte(nil), body[5:9]...)),
+	}
+
+	params := body[10:]
+	if len(params) != int(body[9]) {
+		return nil, errors.Newf("invalid length of bgp open parameters: %d", body[9])
+	}
+	multiprotocol := false
+	for len(params) > 0 {
+		if len(params) < 2 || len(params) < 2+int(params[1]) {
+			return nil, errors.New("malformed bgp open parameter")
+		}
+		paramType, value := params[0], params[2:2+int(params[1])]
+		params = params[2+int(params[1]):]
+		if paramType != bgpOptParamCapabilities {
+			continue
+		}
+		for len(value) > 0 {
+			if len(value) < 2 || len(value) < 2+int(value[1]) {
+				return nil, errors.New("malformed bgp capability")
+			}
+			code, capValue := value[0], value[2:2+int(value[1])]
+			value = value[2+int(value[1]):]
+			switch code {
+			case bgpCapMultiprotocol:
+				if len(capValue) != 4 {
+					return nil, errors.New("malformed bgp multiprotocol capability")
+				}
+				multiprotocol = true
+				afi := binary.BigEndian.Uint16(capValue[0:2])
+				if capValue[3] != bgpSafiUnicast {
+					continue
+				}
+				open.ipv4 = open.ipv4 || afi == bgpAfiIpv4
+				open.ipv6 = open.ipv6 || afi == bgpAfiIpv6
+			case bgpCapFourOctetAs:
+				if len(capValue) != 4 {
+					return nil, errors.New("malformed bgp 4-octet asn capability")
+				}
+				open.fourOctetAs = true
+				open.asn = binary.BigEndian.Uint32(capValue)
+			}
+		}
+	}
+	// ipv4 unicast is implied without multiprotocol capability (RFC 4760).
+	if !multiprotocol {
+		open.ipv4 = true
+	}
+	return open, nil
+}
+
+// Unicast path of UPDATE message.
+type bgpPath struct {
+	prefix  *net.IPNet
+	nextHop net.IP
+	asPath  []uint32
+	// nil when it's not sent.
+	med *uint32
+	// nil when it's not sent.
+	localPref           *uint32
+	communities         []uint32
+	largeCommunities    [][3]uint32
+	extendedCommunities []uint64
+}
+
+// Serializes UPDATE announcing single path, ipv6 paths are sent in
+// MP_REACH_NLRI attribute.
+func (p *bgpPath) marshal() []byte {
+	ipv6 := p.prefix.IP.To4() == nil
+
+	attrs := appendBgpAttr(nil, bgpAttrFlagTransitive, bgpAttrOrigin, []byte{bgpOriginIgp})
+	var asPath []byte
+	if len(p.asPath) > 0 {
+		asPath = append(asPath, bgpAsSequence, uint8(len(p.asPath)))
+		for _, asn := range p.asPath {
+			asPath = appendUint32(asPath, asn)
+		}
+	}
+	attrs = appendBgpAttr(attrs, bgpAttrFlagTransitive, bgpAttrAsPath, asPath)
+	if !ipv6 {
+		attrs = appendBgpAttr(attrs, bgpAttrFlagTransitive, bgpAttrNextHop, p.nextHop.To4())
+	}
+	if p.med != nil {
+		attrs = appendBgpAttr(attrs, bgpAttrFlagOptional, bgpAttrMed, appendUint32(nil, *p.med))
+	}
+	if p.localPref != nil {
+		attrs = appendBgpAttr(
+			attrs,
+			bgpAttrFlagTransitive,
+			bgpAttrLocalPref,
+			appendUint32(nil, *p.localPref))
+	}
+	if len(p.communities) > 0 {
+		var communities []byte
+		for _, community := range p.communities {
+			communities = appendUint32(communities, community)
+		}
+		attrs = appendBgpAttr(
+			attrs,
+			bgpAttrFlagOptional|bgpAttrFlagTransitive,
+			bgpAttrCommunities,
+			communities)
+	}
+
+	var nlri []byte
+	if ipv6 {
+		mpReach := []byte{0, bgpAfiIpv6, bgpSafiUnicast, net.IPv6len}
+		mpReach = append(mpReach, p.nextHop.To16()...)
+		// reserved.
+		mpReach = append(mpReach, 0)
+		mpReach = appendBgpPrefix(mpReach, p.prefix)
+		attrs = appendBgpAttr(attrs, bgpAttrFlagOptional, bgpAttrMpReachNlri, mpReach)
+	} else {
+		nlri = appendBgpPrefix(nil, p.prefix)
+	}
+	if len(p.extendedCommunities) > 0 {
+		var communities []byte
+		for _, community := range p.extendedCommunities {
+			communities = appendUint32(communities, uint32(community>>32))
+			communities = appendUint32(communities, uint32(community))
+		}
+		attrs = appendBgpAttr(
+			attrs,
+			bgpAttrFlagOptional|bgpAttrFlagTransitive,
+			bgpAttrExtendedCommunities,
+			communities)
+	}
+	if len(p.largeCommunities) > 0 {
+		var communities []byte
+		for _, community := range p.largeCommunities {
+			for _, part := range community {
+				communities = appendUint32(communities, part)
+			}
+		}
+		attrs = appendBgpAttr(
+			attrs,
+			bgpAttrFlagOptional|bgpAttrFlagTransitive,
+			bgpAttrLargeCommunities,
+			communities)
+	}
+
+	body := []byte{0, 0}
+	body = appendUint16(body, uint16(len(attrs)))
+	body = append(body, attrs...)
+	body = append(body, nlri...)
+	return marshalBgpMessage(bgpMsgUpdate, body)
+}
+
+// Serializes UPDATE withdrawing single prefix, ipv6 prefixes are sent in
+// MP_UNREACH_NLRI attribute.
+func marshalBgpWithdrawal(prefix *net.IPNet) []byte {
+	var body []byte
+	if prefix.IP.To4() == nil {
+		mpUnreach := appendBgpPrefix([]byte{0, bgpAfiIpv6, bgpSafiUnicast}, prefix)
+		attrs := appendBgpAttr(nil, bgpAttrFlagOptional, bgpAttrMpUnreachNlri, mpUnreach)
+		body = []byte{0, 0}
+		body = appendUint16(body, uint16(len(attrs)))
+		body = append(body, attrs...)
+	} else {
+		withdrawn := appendBgpPrefix(nil, prefix)
+		body = appendUint16(nil, uint16(len(withdrawn)))
+		body = append(body, withdrawn...)
+		body = append(body, 0, 0)
+	}
+	return marshalBgpMessage(bgpMsgUpdate, body)
+}
+
+func appendBgpAttr(b []byte, flags uint8, attrType uint8, value []byte) []byte {
+	if len(value) > 0xff {
+		b = append(b, flags|bgpAttrFlagExtendedLen, attrType)
+		b = appendUint16(b, uint16(len(value)))
+	} else {
+		b = append(b, flags, attrType, uint8(len(value)))
+	}
+	return append(b, value...)
+}
+
+// Appends prefix in NLRI encoding: length in bits and significant octets.
+func appendBgpPrefix(b []byte, prefix *net.IPNet) []byte {
+	ones, _ := prefix.Mask.Size()
+	b = append(b, uint8(ones))
+	return append(b, prefix.IP[:(ones+7)/8]...)
+}
+
+// Returns prefix of the route.
+func bgpRoutePrefix(route *kglb_pb.BgpRouteAttributes) (*net.IPNet, error) {
+	ip := common.KglbAddrToNetIp(route.GetPrefix())
+	if ip == nil {
+		return nil, errors.Newf("invalid bgp prefix: %v", route.GetPrefix())
+	}
+	bits := net.IPv6len * 8
+	if ip4 := ip.To4(); ip4 != nil {
+		ip, bits = ip4, net.IPv4len*8
+	} else {
+		ip = ip.To16()
+	}
+	if route.GetPrefixlen() == 0 || int(route.GetPrefixlen()) > bits {
+		return nil, errors.Newf("invalid bgp prefix length: %d", route.GetPrefixlen())
+	}
+	mask := net.CIDRMask(int(route.GetPrefixlen()), bits)
+	return &net.IPNet{IP: ip.Mask(mask), Mask: mask}, nil
+}
+
+// Returns standard, large and extended communities of the route.
+func bgpRouteCommunities(
+	route *kglb_pb.BgpRouteAttributes) ([]uint32, [][3]uint32, []uint64, error) {
+
+	communities, err := common.ParseBgpCommunities(route.GetCommunity())
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	var largeCommunities [][3]uint32
+	for _, community := range route.GetLargeCommunities() {
+		large, err := common.ParseBgpLargeCommunity(community)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		largeCommunities = append(largeCommunities, large)
+	}
+	var extendedCommunities []uint64
+	for _, community := range route.GetExtendedCommunities() {
+		extended, err := common.ParseBgpExtendedCommunity(community)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		extendedCommunities = append(extendedCommunities, extended)
+	}
+	return communities, largeCommunities, extendedCommunities, nil
+}
+
+func appendUint16(b []byte, v uint16) []byte {
+	return append(b, uint8(v>>8), uint8(v))
+}
+
+func appendUint32(b []byte, v uint32) []byte {
+	return append(b, uint8(v>>24), uint8(v>>16), uint8(v>>8), uint8(v))
+}
@@ -0,0 +1,153 @@
+package data_plane
+
+import (
+	"bytes"
+	"encoding/hex"
+	"net"
+	"time"
+
+	"github.com/gogo/protobuf/proto"
+	. "gopkg.in/check.v1"
+
+	kglb_pb "dropbox/proto/kglb"
+	. "godropbox/gocheck2"
+)
+
+type BgpMessageSuite struct {
+}
+
+var _ = Suite(&BgpMessageSuite{})
+
+func mustParseCIDR(c *C, cidr string) *net.IPNet {
+	ip, prefix, err := net.ParseCIDR(cidr)
+	c.Assert(err, NoErr)
+	if ip4 := ip.To4(); ip4 != nil {
+		prefix.IP = prefix.IP.To4()
+	}
+	return prefix
+}
+
+func (m *BgpMessageSuite) TestOpen(c *C) {
+	open := &bgpOpen{
+		asn:      4200000000,
+		holdTime: 90 * time.Second,
+		routerId: net.ParseIP("10.0.0.1"),
+		ipv4:     true,
+		ipv6:     true,
+	}
+	msg := open.marshal()
+	c.Assert(
+		hex.EncodeToString(msg[bgpMarkerLen:]),
+		Equals,
+		"003101"+
+			"045ba0005a0a000001"+
+			"140212"+"010400010001"+"010400020001"+"4104fa56ea00")
+
+	msgType, body, err := readBgpMessage(bytes.NewReader(msg))
+	c.Assert(err, NoErr)
+	c.Assert(msgType, Equals, uint8(bgpMsgOpen))
+	parsed, err := parseBgpOpen(body)
+	c.Assert(err, NoErr)
+	c.Assert(parsed, DeepEqualsPretty, &bgpOpen{
+		asn:         4200000000,
+		holdTime:    90 * time.Second,
+		routerId:    net.ParseIP("10.0.0.1").To4(),
+		ipv4:        true,
+		ipv6:        true,
+		fourOctetAs: true,
+	})
+
+	// ipv4 unicast is implied without capabilities.
+	parsed, err = parseBgpOpen([]byte{4, 0xfd, 0xe9, 0, 3, 10, 0, 0, 2, 0})
+	c.Assert(err, NoErr)
+	c.Assert(parsed.asn, Equals, uint32(65001))
+	c.Assert(parsed.ipv4, IsTrue)
+	c.Assert(parsed.ipv6, IsFalse)
+	c.Assert(parsed.fourOctetAs, IsFalse)
+
+	_, err = parseBgpOpen([]byte{3, 0xfd, 0xe9, 0, 3, 10, 0, 0, 2, 0})
+	c.Assert(err, ErrorMatches, "(?s).*unsupported bgp version.*")
+	_, err = parseBgpOpen([]byte{4, 0xfd, 0xe9, 0, 3, 10, 0, 0, 2, 2, 2, 4})
+	c.Assert(err, NotNil)
+
+	// invalid marker.
+	msg[0] = 0
+	_, _, err = readBgpMessage(bytes.NewReader(msg))
+	c.Assert(err, NotNil)
+}
+
+func (m *BgpMessageSuite) TestUpdate(c *C) {
+	path := &bgpPath{
+		prefix:      mustParseCIDR(c, "172.0.0.0/24"),
+		nextHop:     net.ParseIP("10.0.0.1"),
+		asPath:      []uint32{65001},
+		communities: []uint32{65001<<16 | 100},
+	}
+	msg := path.marshal()
+	c.Assert(
+		hex.EncodeToString(msg[bgpMarkerLen:]),
+		Equals,
+		"003602"+"0000001b"+
+			"40010100"+
+			"40020602010000fde9"+
+			"4003040a000001"+
+			"c00804fde90064"+
+			"18ac0000")
+
+	// ipv6 path is sent in mp_reach_nlri.
+	path = &bgpPath{
+		prefix:              mustParseCIDR(c, "fc00::1/128"),
+		nextHop:             net.ParseIP("fc00::2"),
+		med:                 proto.Uint32(10),
+		localPref:           proto.Uint32(200),
+		largeCommunities:    [][3]uint32{{4200000000, 1, 2}},
+		extendedCommunities: []uint64{0x0002fde9000186a0},
+	}
+	msg = path.marshal()
+	c.Assert(
+		hex.EncodeToString(msg[bgpMarkerLen:]),
+		Equals,
+		"006f02"+"00000058"+
+			"40010100"+
+			"400200"+
+			"800404"+"0000000a"+
+			"400504"+"000000c8"+
+			"800e26"+"00020110"+"fc000000000000000000000000000002"+"00"+
+			"80fc000000000000000000000000000001"+
+			"c01008"+"0002fde9000186a0"+
+			"c0200c"+"fa56ea00"+"00000001"+"00000002")
+
+	msg = marshalBgpWithdrawal(mustParseCIDR(c, "172.0.0.0/24"))
+	c.Assert(
+		hex.EncodeToString(msg[bgpMarkerLen:]),
+		Equals,
+		"001b02"+"0004"+"18ac0000"+"0000")
+	msg = marshalBgpWithdrawal(mustParseCIDR(c, "fc00::/64"))
+	c.Assert(
+		hex.EncodeToString(msg[bgpMarkerLen:]),
+		Equals,
+		"002602"+"0000000f"+"800f0c"+"000201"+"40fc00000000000000")
+}
+
+func (m *BgpMessageSuite) TestRoutePrefix(c *C) {
+	prefix, err := bgpRoutePrefix(&kglb_pb.BgpRouteAttributes{
+		Prefix:    &kglb_pb.IP{Address: &kglb_pb.IP_Ipv4{Ipv4: "172.0.0.1"}},
+		Prefixlen: 24,
+	})
+	c.Assert(err, NoErr)
+	c.Assert(prefix.String(), Equals, "172.0.0.0/24")
+	c.Assert(prefix.IP, HasLen, net.IPv4len)
+
+	prefix, err = bgpRoutePrefix(&kglb_pb.BgpRouteAttributes{
+		Prefix:    &kglb_pb.IP{Address: &kglb_pb.IP_Ipv6{Ipv6: "fc00::1"}},
+		Prefixlen: 128,
+	})
+	c.Assert(err, NoErr)
+	c.Assert(prefix.String(), Equals, "fc00::1/128")
+
+	_, err = bgpRoutePrefix(&kglb_pb.BgpRouteAttributes{
+		Prefix:    &kglb_pb.IP{Address: &kglb_pb.IP_Ipv4{Ipv4: "172.0.0.1"}},
+		Prefixlen: 33,
+	})
+	c.Assert(err, NotNil)
+}
@@ -15,3 +15,9 @@ type BgpModule interface {
 	//	get BGP session state
 	IsSessionEstablished() (bool, error)
 }
+
+// (Optional) extension of Bgp module running sessions to several peers.
+type BgpPeerStatesModule interface {
+	// get established state of sessions by peer address.
+	PeerStates() map[string]bool
+}
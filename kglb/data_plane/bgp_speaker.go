@@ -0,0 +1,643 @@
+package data_plane
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gogo/protobuf/proto"
+
+	"dropbox/dlog"
+	"dropbox/exclog"
+	"dropbox/kglb/common"
+	kglb_pb "dropbox/proto/kglb"
+	"godropbox/errors"
+)
+
+const (
+	defaultBgpHoldTime     = 90 * time.Second
+	defaultBgpConnectRetry = 5 * time.Second
+	bgpConnectTimeout      = 5 * time.Second
+	bgpWriteTimeout        = 10 * time.Second
+	// hold time used until OPEN messages are exchanged.
+	bgpOpenHoldTime = 4 * time.Minute
+)
+
+// BGP peer of the speaker.
+type BgpPeerConfig struct {
+	Address net.IP
+	// (Optional) default is 179.
+	Port int
+	// Routes are advertised to peers with PeerAsn of the route.
+	Asn uint32
+}
+
+type BgpSpeakerParams struct {
+	// BGP identifier, it's also ipv4 next hop of sessions over ipv6.
+	RouterId net.IP
+	Peers    []BgpPeerConfig
+	// (Optional) proposed hold time, default is 90s.
+	HoldTime time.Duration
+	// (Optional) delay between connection attempts, default is 5s.
+	ConnectRetry time.Duration
+}
+
+// BgpModule implementation running BGP-4 sessions to configured peers and
+// advertising ipv4 and ipv6 unicast routes to them. Speaker only connects to
+// peers (it doesn't accept connections) and ignores routes received from
+// them. It's implemented in place instead of embedding GoBGP, since the
+// library and its dependencies aren't available to the build, and kglb only
+// needs to originate routes, which keeps the state machine small.
+type BgpSpeaker struct {
+	params BgpSpeakerParams
+
+	mu  sync.Mutex
+	asn uint32
+	// advertised routes by peer asn and prefix.
+	paths    map[string]*kglb_pb.BgpRouteAttributes
+	sessions []*bgpSession
+	closed   bool
+}
+
+var _ BgpModule = &BgpSpeaker{}
+var _ BgpPeerStatesModule = &BgpSpeaker{}
+
+func NewBgpSpeaker(params BgpSpeakerParams) (*BgpSpeaker, error) {
+	if params.RouterId.To4() == nil {
+		return nil, errors.Newf("bgp router id should be ipv4 address: %s", params.RouterId)
+	}
+	if params.HoldTime == 0 {
+		params.HoldTime = defaultBgpHoldTime
+	}
+	if params.HoldTime < 3*time.Second {
+		return nil, errors.Newf("bgp hold time should be at least 3s: %v", params.HoldTime)
+	}
+	if params.ConnectRetry == 0 {
+		params.ConnectRetry = defaultBgpConnectRetry
+	}
+	params.Peers = append([]BgpPeerConfig(nil), params.Peers...)
+	for i, peer := range params.Peers {
+		if peer.Address == nil || peer.Asn == 0 {
+			return nil, errors.Newf("bgp peer requires address and asn: %+v", peer)
+		}
+		if peer.Port == 0 {
+			params.Peers[i].Port = bgpPort
+		}
+	}
+
+	return &BgpSpeaker{
+		params: params,
+		paths:  make(map[string]*kglb_pb.BgpRouteAttributes),
+	}, nil
+}
+
+// Starts sessions with the ASN, sessions are restarted when ASN is changed.
+func (s *BgpSpeaker) Init(asn uint32) error {
+	if asn == 0 {
+		return errors.New("bgp asn cannot be 0")
+	}
+
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return errors.New("bgp speaker is closed")
+	}
+	if s.asn == asn {
+		s.mu.Unlock()
+		return nil
+	}
+	if s.asn != 0 {
+		dlog.Infof("Restarting bgp sessions, asn is changed: %d -> %d", s.asn, asn)
+	}
+	s.asn = asn
+	stopped := s.sessions
+	s.sessions = nil
+	for _, peer := range s.params.Peers {
+		s.sessions = append(s.sessions, newBgpSession(s, peer, asn))
+	}
+	started := s.sessions
+	s.mu.Unlock()
+
+	// sessions acquire speaker lock on establishment, so they are stopped
+	// without it.
+	for _, session := range stopped {
+		session.Stop()
+	}
+	for _, session := range started {
+		go session.run()
+	}
+	return nil
+}
+
+func (s *BgpSpeaker) Advertise(route *kglb_pb.BgpRouteAttributes) error {
+	prefix, err := bgpRoutePrefix(route)
+	if err != nil {
+		return err
+	}
+	if _, _, _, err = bgpRouteCommunities(route); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.paths[bgpPathKey(route.GetPeerAsn(), prefix)] = route
+	for _, session := range s.sessions {
+		session.advertise(route)
+	}
+	return nil
+}
+
+func (s *BgpSpeaker) Withdraw(route *kglb_pb.BgpRouteAttributes) error {
+	prefix, err := bgpRoutePrefix(route)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := bgpPathKey(route.GetPeerAsn(), prefix)
+	current, ok := s.paths[key]
+	// prefix may be already re-advertised with new attributes.
+	if !ok || !common.BgpRoutingAttributesComparable.Equal(current, route) {
+		return nil
+	}
+	delete(s.paths, key)
+	for _, session := range s.sessions {
+		session.withdraw(route, prefix)
+	}
+	return nil
+}
+
+func (s *BgpSpeaker) ListPaths() ([]*kglb_pb.BgpRouteAttributes, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	keys := make([]string, 0, len(s.paths))
+	for key := range s.paths {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	result := make([]*kglb_pb.BgpRouteAttributes, len(keys))
+	for i, key := range keys {
+		result[i] = s.paths[key]
+	}
+	return result, nil
+}
+
+// Returns true when sessions to all peers are established.
+func (s *BgpSpeaker) IsSessionEstablished() (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.sessions) == 0 {
+		return false, nil
+	}
+	for _, session := range s.sessions {
+		if !session.IsEstablished() {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// Returns established state of sessions by peer address.
+func (s *BgpSpeaker) PeerStates() map[string]bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	result := make(map[string]bool, len(s.sessions))
+	for _, session := range s.sessions {
+		result[session.peer.Address.String()] = session.IsEstablished()
+	}
+	return result
+}
+
+// Closes sessions with cease notification, paths are not withdrawn
+// explicitly.
+func (s *BgpSpeaker) Close() {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return
+	}
+	s.closed = true
+	stopped := s.sessions
+	s.sessions = nil
+	s.mu.Unlock()
+
+	for _, session := range stopped {
+		session.Stop()
+	}
+}
+
+// Returns paths for the peer.
+func (s *BgpSpeaker) peerPathsLocked(peerAsn uint32) []*kglb_pb.BgpRouteAttributes {
+	var result []*kglb_pb.BgpRouteAttributes
+	for _, route := range s.paths {
+		if route.GetPeerAsn() == peerAsn {
+			result = append(result, route)
+		}
+	}
+	return result
+}
+
+func bgpPathKey(peerAsn uint32, prefix *net.IPNet) string {
+	return fmt.Sprintf("%d:%s", peerAsn, prefix)
+}
+
+// BGP session to single peer, it reconnects until it's stopped.
+type bgpSession struct {
+	speaker  *BgpSpeaker
+	peer     BgpPeerConfig
+	localAsn uint32
+
+	// protects fields below, it's never held during network io.
+	mu          sync.Mutex
+	conn        net.Conn
+	established bool
+	stopped     bool
+	// messages waiting for writeLoop of the connection.
+	pending [][]byte
+	wake    chan struct{}
+	// local address of established connection.
+	localAddr net.IP
+	// negotiated address families.
+	ipv4 bool
+	ipv6 bool
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+func newBgpSession(speaker *BgpSpeaker, peer BgpPeerConfig, localAsn uint32) *bgpSession {
+	return &bgpSession{
+		speaker:  speaker,
+		peer:     peer,
+		localAsn: localAsn,
+		wake:     make(chan struct{}, 1),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+}
+
+func (b *bgpSession) IsEstablished() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.established
+}
+
+// Stops the session with cease notification.
+func (b *bgpSession) Stop() {
+	b.mu.Lock()
+	b.stopped = true
+	conn := b.conn
+	established := b.established
+	b.mu.Unlock()
+
+	if conn != nil {
+		if established {
+			writeBgpMessage(conn, (&bgpNotification{code: bgpErrCease}).marshal())
+		}
+		conn.Close()
+	}
+
+	close(b.stop)
+	<-b.done
+}
+
+func (b *bgpSession) run() {
+	defer close(b.done)
+
+	for {
+		conn, err := net.DialTimeout(
+			"tcp",
+			net.JoinHostPort(b.peer.Address.String(), strconv.Itoa(b.peer.Port)),
+			bgpConnectTimeout)
+		if err != nil {
+			dlog.Infof("fails to connect bgp peer %s: %v", b.peer.Address, err)
+		} else {
+			if err = b.serve(conn); err != nil {
+				dlog.Infof("bgp session with %s is closed: %v", b.peer.Address, err)
+			}
+		}
+
+		select {
+		case <-b.stop:
+			return
+		case <-time.After(b.speaker.params.ConnectRetry):
+		}
+	}
+}
+
+// Runs the session over the connection until it's closed.
+func (b *bgpSession) serve(conn net.Conn) (err error) {
+	defer conn.Close()
+
+	b.mu.Lock()
+	if b.stopped {
+		b.mu.Unlock()
+		return nil
+	}
+	b.conn = conn
+	b.mu.Unlock()
+
+	closed := make(chan struct{})
+	go b.writeLoop(conn, closed)
+
+	defer func() {
+		close(closed)
+		b.mu.Lock()
+		stopped := b.stopped
+		if b.established {
+			dlog.Infof("bgp session with %s is down", b.peer.Address)
+		}
+		b.conn = nil
+		b.established = false
+		b.pending = nil
+		b.mu.Unlock()
+
+		if notification, ok := err.(*bgpNotification); ok && !stopped {
+			writeBgpMessage(conn, notification.marshal())
+		}
+	}()
+
+	holdTime, err := b.openSession(conn)
+	if err != nil {
+		return err
+	}
+
+	// sending keepalives until the connection is closed, zero hold time
+	// disables both keepalives and hold timer.
+	if holdTime > 0 {
+		go b.keepaliveLoop(holdTime/3, closed)
+	}
+
+	for {
+		var deadline time.Time
+		if holdTime > 0 {
+			deadline = time.Now().Add(holdTime)
+		}
+		if err := conn.SetReadDeadline(deadline); err != nil {
+			return err
+		}
+		msgType, body, err := readBgpMessage(conn)
+		if err != nil {
+			if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+				return &bgpNotification{code: bgpErrHoldTimerExpired}
+			}
+			return err
+		}
+
+		switch msgType {
+		// routes of the peer are not used.
+		case bgpMsgKeepalive, bgpMsgUpdate:
+		case bgpMsgNotification:
+			notification, err := parseBgpNotification(body)
+			if err != nil {
+				return err
+			}
+			return errors.Wrap(notification, "received from peer: ")
+		default:
+			return &bgpNotification{code: bgpErrFsm}
+		}
+	}
+}
+
+// Exchanges OPEN and KEEPALIVE messages, advertises paths to established
+// session and returns negotiated hold time.
+func (b *bgpSession) openSession(conn net.Conn) (time.Duration, error) {
+	if err := conn.SetReadDeadline(time.Now().Add(bgpOpenHoldTime)); err != nil {
+		return 0, err
+	}
+
+	localOpen := &bgpOpen{
+		asn:      b.localAsn,
+		holdTime: b.speaker.params.HoldTime,
+		routerId: b.speaker.params.RouterId,
+		ipv4:     true,
+		ipv6:     true,
+	}
+	b.mu.Lock()
+	b.sendLocked(localOpen.marshal())
+	b.mu.Unlock()
+
+	msgType, body, err := readBgpMessage(conn)
+	if err != nil {
+		return 0, err
+	}
+	if msgType != bgpMsgOpen {
+		return 0, &bgpNotification{code: bgpErrFsm}
+	}
+	peerOpen, err := parseBgpOpen(body)
+	if err != nil {
+		dlog.Infof("invalid bgp open from %s: %v", b.peer.Address, err)
+		return 0, &bgpNotification{code: bgpErrOpenMessage}
+	}
+	if !peerOpen.fourOctetAs {
+		return 0, &bgpNotification{
+			code:    bgpErrOpenMessage,
+			subcode: bgpErrSubUnsupportedCapability,
+		}
+	}
+	if peerOpen.asn != b.peer.Asn {
+		dlog.Infof("unexpected asn of bgp peer %s: %d", b.peer.Address, peerOpen.asn)
+		return 0, &bgpNotification{code: bgpErrOpenMessage, subcode: bgpErrSubBadPeerAs}
+	}
+	if peerOpen.holdTime > 0 && peerOpen.holdTime < 3*time.Second {
+		return 0, &bgpNotification{
+			code:    bgpErrOpenMessage,
+			subcode: bgpErrSubUnacceptableHoldTime,
+		}
+	}
+	holdTime := negotiateBgpHoldTime(localOpen.holdTime, peerOpen.holdTime)
+
+	b.mu.Lock()
+	b.sendLocked(marshalBgpMessage(bgpMsgKeepalive, nil))
+	b.mu.Unlock()
+
+	if msgType, body, err = readBgpMessage(conn); err != nil {
+		return 0, err
+	}
+	switch msgType {
+	case bgpMsgKeepalive:
+	case bgpMsgNotification:
+		notification, err := parseBgpNotification(body)
+		if err != nil {
+			return 0, err
+		}
+		return 0, errors.Wrap(notification, "received from peer: ")
+	default:
+		return 0, &bgpNotification{code: bgpErrFsm}
+	}
+
+	// speaker lock keeps advertised paths consistent with the ones sent
+	// to the session.
+	b.speaker.mu.Lock()
+	defer b.speaker.mu.Unlock()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.stopped {
+		return 0, errors.New("bgp session is stopped")
+	}
+	b.established = true
+	b.localAddr = conn.LocalAddr().(*net.TCPAddr).IP
+	b.ipv4 = peerOpen.ipv4
+	b.ipv6 = peerOpen.ipv6
+	dlog.Infof(
+		"bgp session with %s is established, hold time: %v",
+		b.peer.Address,
+		holdTime)
+	for _, route := range b.speaker.peerPathsLocked(b.peer.Asn) {
+		b.advertiseLocked(route)
+	}
+	return holdTime, nil
+}
+
+// Returns smaller of proposed hold times, zero hold time of the peer disables
+// keepalives (RFC 4271 4.2).
+func negotiateBgpHoldTime(local, peer time.Duration) time.Duration {
+	if peer < local {
+		return peer
+	}
+	return local
+}
+
+func (b *bgpSession) keepaliveLoop(interval time.Duration, closed chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-closed:
+			return
+		case <-ticker.C:
+			b.mu.Lock()
+			b.sendLocked(marshalBgpMessage(bgpMsgKeepalive, nil))
+			b.mu.Unlock()
+		}
+	}
+}
+
+// Queues the message for writeLoop of the connection, so messages keep
+// order without holding locks during writes.
+func (b *bgpSession) sendLocked(msg []byte) {
+	if b.conn == nil {
+		return
+	}
+	b.pending = append(b.pending, msg)
+	select {
+	case b.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Writes queued messages to the connection, connection is closed on failure
+// to restart the session.
+func (b *bgpSession) writeLoop(conn net.Conn, closed chan struct{}) {
+	for {
+		select {
+		case <-closed:
+			return
+		case <-b.wake:
+		}
+
+		b.mu.Lock()
+		var msgs [][]byte
+		if b.conn == conn {
+			msgs = b.pending
+			b.pending = nil
+		}
+		b.mu.Unlock()
+
+		for _, msg := range msgs {
+			if err := writeBgpMessage(conn, msg); err != nil {
+				exclog.Report(
+					errors.Wrapf(err, "fails to send bgp message to %s: ", b.peer.Address),
+					exclog.Noncritical, "")
+				conn.Close()
+				return
+			}
+		}
+	}
+}
+
+func writeBgpMessage(conn net.Conn, msg []byte) error {
+	if err := conn.SetWriteDeadline(time.Now().Add(bgpWriteTimeout)); err != nil {
+		return err
+	}
+	_, err := conn.Write(msg)
+	return err
+}
+
+func (b *bgpSession) advertise(route *kglb_pb.BgpRouteAttributes) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.advertiseLocked(route)
+}
+
+func (b *bgpSession) advertiseLocked(route *kglb_pb.BgpRouteAttributes) {
+	if !b.established || route.GetPeerAsn() != b.peer.Asn {
+		return
+	}
+	// validated by Advertise().
+	prefix, _ := bgpRoutePrefix(route)
+	communities, largeCommunities, extendedCommunities, _ := bgpRouteCommunities(route)
+
+	ipv6 := prefix.IP.To4() == nil
+	if (ipv6 && !b.ipv6) || (!ipv6 && !b.ipv4) {
+		return
+	}
+	path := &bgpPath{
+		prefix:              prefix,
+		nextHop:             b.nextHopLocked(ipv6),
+		communities:         communities,
+		largeCommunities:    largeCommunities,
+		extendedCommunities: extendedCommunities,
+	}
+	if route.GetMed() != nil {
+		path.med = proto.Uint32(route.GetMed().GetValue())
+	}
+	if route.GetNextHop() != nil {
+		path.nextHop = common.KglbAddrToNetIp(route.GetNextHop())
+	}
+	if b.peer.Asn == b.localAsn {
+		path.localPref = proto.Uint32(bgpDefaultLocalPref)
+		if route.GetLocalPref() != nil {
+			path.localPref = proto.Uint32(route.GetLocalPref().GetValue())
+		}
+	} else {
+		for i := uint32(0); i <= route.GetAsPathPrepend(); i++ {
+			path.asPath = append(path.asPath, b.localAsn)
+		}
+	}
+	b.sendLocked(path.marshal())
+}
+
+func (b *bgpSession) withdraw(route *kglb_pb.BgpRouteAttributes, prefix *net.IPNet) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if !b.established || route.GetPeerAsn() != b.peer.Asn {
+		return
+	}
+	b.sendLocked(marshalBgpWithdrawal(prefix))
+}
+
+// Returns next hop of the family: local address of the session, ipv4
+// mapped one for ipv6 paths over ipv4 session and router id for ipv4 paths
+// over ipv6 session.
+func (b *bgpSession) nextHopLocked(ipv6 bool) net.IP {
+	if ipv6 {
+		return b.localAddr.To16()
+	}
+	if local := b.localAddr.To4(); local != nil {
+		return local
+	}
+	return b.speaker.params.RouterId.To4()
+}
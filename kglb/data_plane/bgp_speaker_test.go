@@ -0,0 +1,503 @@
+package data_plane
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	"github.com/gogo/protobuf/proto"
+	. "gopkg.in/check.v1"
+
+	kglb_pb "dropbox/proto/kglb"
+	. "godropbox/gocheck2"
+)
+
+type BgpSpeakerSuite struct {
+}
+
+var _ = Suite(&BgpSpeakerSuite{})
+
+// Waits until the condition is true.
+func waitBgpCondition(c *C, condition func() bool) {
+	deadline := time.Now().Add(5 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			c.Fatal("condition isn't met in time")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// BGP peer on localhost accepting sessions of the speaker, it records raw
+// UPDATE messages sent by the speaker.
+type fakeBgpPeer struct {
+	listener net.Listener
+	asn      uint32
+	holdTime time.Duration
+	// asn of the speaker, session is rejected with other one.
+	speakerAsn uint32
+
+	mu          sync.Mutex
+	conn        net.Conn
+	established bool
+	keepalives  int
+	updates     [][]byte
+}
+
+func newFakeBgpPeer(c *C, asn, speakerAsn uint32, holdTime time.Duration) *fakeBgpPeer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	c.Assert(err, NoErr)
+	p := &fakeBgpPeer{
+		listener:   listener,
+		asn:        asn,
+		holdTime:   holdTime,
+		speakerAsn: speakerAsn,
+	}
+	go p.acceptLoop()
+	return p
+}
+
+func (p *fakeBgpPeer) port() int {
+	return p.listener.Addr().(*net.TCPAddr).Port
+}
+
+func (p *fakeBgpPeer) acceptLoop() {
+	for {
+		conn, err := p.listener.Accept()
+		if err != nil {
+			return
+		}
+		p.serve(conn)
+	}
+}
+
+func (p *fakeBgpPeer) serve(conn net.Conn) {
+	defer conn.Close()
+
+	msgType, body, err := readBgpMessage(conn)
+	if err != nil || msgType != bgpMsgOpen {
+		return
+	}
+	open, err := parseBgpOpen(body)
+	if err != nil {
+		return
+	}
+	if open.asn != p.speakerAsn {
+		conn.Write((&bgpNotification{
+			code:    bgpErrOpenMessage,
+			subcode: bgpErrSubBadPeerAs,
+		}).marshal())
+		return
+	}
+	localOpen := &bgpOpen{
+		asn:      p.asn,
+		holdTime: p.holdTime,
+		routerId: net.ParseIP("10.0.0.2"),
+		ipv4:     true,
+		ipv6:     true,
+	}
+	conn.Write(localOpen.marshal())
+	conn.Write(marshalBgpMessage(bgpMsgKeepalive, nil))
+
+	p.mu.Lock()
+	p.conn = conn
+	p.mu.Unlock()
+	closed := make(chan struct{})
+	defer func() {
+		close(closed)
+		p.mu.Lock()
+		p.conn = nil
+		p.established = false
+		p.mu.Unlock()
+	}()
+	if p.holdTime > 0 {
+		go func() {
+			ticker := time.NewTicker(p.holdTime / 3)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-closed:
+					return
+				case <-ticker.C:
+					conn.Write(marshalBgpMessage(bgpMsgKeepalive, nil))
+				}
+			}
+		}()
+	}
+
+	for {
+		msgType, body, err := readBgpMessage(conn)
+		if err != nil {
+			return
+		}
+		p.mu.Lock()
+		switch msgType {
+		case bgpMsgKeepalive:
+			p.established = true
+			p.keepalives++
+		case bgpMsgUpdate:
+			p.updates = append(p.updates, marshalBgpMessage(msgType, body))
+		}
+		p.mu.Unlock()
+		if msgType == bgpMsgNotification {
+			return
+		}
+	}
+}
+
+func (p *fakeBgpPeer) isEstablished() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.established
+}
+
+func (p *fakeBgpPeer) keepaliveCnt() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.keepalives
+}
+
+func (p *fakeBgpPeer) receivedUpdates() [][]byte {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([][]byte(nil), p.updates...)
+}
+
+func (p *fakeBgpPeer) Close() {
+	p.listener.Close()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.conn != nil {
+		p.conn.Close()
+	}
+}
+
+// Returns speaker connecting to the peer.
+func newTestBgpSpeaker(c *C, peer *fakeBgpPeer, peerAsn uint32) *BgpSpeaker {
+	speaker, err := NewBgpSpeaker(BgpSpeakerParams{
+		RouterId: net.ParseIP("10.0.0.1"),
+		Peers: []BgpPeerConfig{
+			{
+				Address: net.ParseIP("127.0.0.1"),
+				Port:    peer.port(),
+				Asn:     peerAsn,
+			},
+		},
+		HoldTime:     3 * time.Second,
+		ConnectRetry: 10 * time.Millisecond,
+	})
+	c.Assert(err, NoErr)
+	return speaker
+}
+
+// Waits for the number of updates received by the peer and returns them.
+func waitBgpUpdates(c *C, peer *fakeBgpPeer, cnt int) [][]byte {
+	waitBgpCondition(c, func() bool {
+		return len(peer.receivedUpdates()) >= cnt
+	})
+	updates := peer.receivedUpdates()
+	c.Assert(updates, HasLen, cnt)
+	return updates
+}
+
+func (m *BgpSpeakerSuite) TestAdvertise(c *C) {
+	peer := newFakeBgpPeer(c, 65002, 65001, 3*time.Second)
+	defer peer.Close()
+	speaker := newTestBgpSpeaker(c, peer, 65002)
+	defer speaker.Close()
+
+	established, err := speaker.IsSessionEstablished()
+	c.Assert(err, NoErr)
+	c.Assert(established, IsFalse)
+
+	route4 := &kglb_pb.BgpRouteAttributes{
+		LocalAsn:  65001,
+		PeerAsn:   65002,
+		Community: "65001:100",
+		Prefix:    &kglb_pb.IP{Address: &kglb_pb.IP_Ipv4{Ipv4: "172.0.0.1"}},
+		Prefixlen: 32,
+	}
+	route6 := &kglb_pb.BgpRouteAttributes{
+		LocalAsn:  65001,
+		PeerAsn:   65002,
+		Community: "65001:200 no-export",
+		Prefix:    &kglb_pb.IP{Address: &kglb_pb.IP_Ipv6{Ipv6: "fc00::1"}},
+		Prefixlen: 128,
+	}
+	// route for other peer asn.
+	routeOther := &kglb_pb.BgpRouteAttributes{
+		LocalAsn:  65001,
+		PeerAsn:   65003,
+		Community: "65001:300",
+		Prefix:    &kglb_pb.IP{Address: &kglb_pb.IP_Ipv4{Ipv4: "172.0.0.2"}},
+		Prefixlen: 32,
+	}
+
+	// paths advertised before establishment are sent once session is up.
+	c.Assert(speaker.Advertise(route4), NoErr)
+	c.Assert(speaker.Init(65001), NoErr)
+	waitBgpCondition(c, func() bool {
+		established, _ := speaker.IsSessionEstablished()
+		return established && peer.isEstablished()
+	})
+	c.Assert(speaker.PeerStates(), DeepEquals, map[string]bool{"127.0.0.1": true})
+
+	path4 := &bgpPath{
+		prefix:      mustParseCIDR(c, "172.0.0.1/32"),
+		nextHop:     net.ParseIP("127.0.0.1"),
+		asPath:      []uint32{65001},
+		communities: []uint32{65001<<16 | 100},
+	}
+	updates := waitBgpUpdates(c, peer, 1)
+	c.Assert(updates[0], DeepEquals, path4.marshal())
+
+	c.Assert(speaker.Advertise(route6), NoErr)
+	c.Assert(speaker.Advertise(routeOther), NoErr)
+	// ipv4 mapped next hop of session over ipv4.
+	path6 := &bgpPath{
+		prefix:      mustParseCIDR(c, "fc00::1/128"),
+		nextHop:     net.ParseIP("::ffff:127.0.0.1"),
+		asPath:      []uint32{65001},
+		communities: []uint32{65001<<16 | 200, 0xffffff01},
+	}
+	updates = waitBgpUpdates(c, peer, 2)
+	c.Assert(updates[1], DeepEquals, path6.marshal())
+
+	paths, err := speaker.ListPaths()
+	c.Assert(err, NoErr)
+	c.Assert(paths, HasLen, 3)
+
+	// stale withdrawal of re-advertised prefix is ignored.
+	updated := *route4
+	updated.Community = "65001:101"
+	c.Assert(speaker.Advertise(&updated), NoErr)
+	c.Assert(speaker.Withdraw(route4), NoErr)
+	c.Assert(speaker.Withdraw(&updated), NoErr)
+	c.Assert(speaker.Withdraw(route6), NoErr)
+	updatedPath4 := *path4
+	updatedPath4.communities = []uint32{65001<<16 | 101}
+	updates = waitBgpUpdates(c, peer, 5)
+	c.Assert(updates[2], DeepEquals, updatedPath4.marshal())
+	c.Assert(updates[3], DeepEquals, marshalBgpWithdrawal(path4.prefix))
+	c.Assert(updates[4], DeepEquals, marshalBgpWithdrawal(path6.prefix))
+	paths, err = speaker.ListPaths()
+	c.Assert(err, NoErr)
+	c.Assert(paths, DeepEquals, []*kglb_pb.BgpRouteAttributes{routeOther})
+
+	// traffic engineering attributes.
+	routeTe := &kglb_pb.BgpRouteAttributes{
+		LocalAsn:            65001,
+		PeerAsn:             65002,
+		Community:           "65001:100",
+		Prefix:              &kglb_pb.IP{Address: &kglb_pb.IP_Ipv4{Ipv4: "172.0.0.3"}},
+		Prefixlen:           32,
+		Med:                 &kglb_pb.UInt32Value{Value: 10},
+		LocalPref:           &kglb_pb.UInt32Value{Value: 200},
+		AsPathPrepend:       2,
+		LargeCommunities:    []string{"65001:1:2"},
+		ExtendedCommunities: []string{"rt:65001:100"},
+		NextHop:             &kglb_pb.IP{Address: &kglb_pb.IP_Ipv4{Ipv4: "10.0.0.10"}},
+	}
+	c.Assert(speaker.Advertise(routeTe), NoErr)
+	// local pref isn't sent to ebgp peer.
+	pathTe := &bgpPath{
+		prefix:              mustParseCIDR(c, "172.0.0.3/32"),
+		nextHop:             net.ParseIP("10.0.0.10"),
+		asPath:              []uint32{65001, 65001, 65001},
+		med:                 proto.Uint32(10),
+		communities:         []uint32{65001<<16 | 100},
+		largeCommunities:    [][3]uint32{{65001, 1, 2}},
+		extendedCommunities: []uint64{0x0002fde900000064},
+	}
+	updates = waitBgpUpdates(c, peer, 6)
+	c.Assert(updates[5], DeepEquals, pathTe.marshal())
+
+	// invalid communities are rejected.
+	route4.Community = "my_community"
+	c.Assert(speaker.Advertise(route4), NotNil)
+	routeTe.LargeCommunities = []string{"65001:1"}
+	c.Assert(speaker.Advertise(routeTe), NotNil)
+
+	// session goes down once speaker is closed.
+	speaker.Close()
+	waitBgpCondition(c, func() bool {
+		return !peer.isEstablished()
+	})
+	c.Assert(speaker.Init(65001), NotNil)
+}
+
+func (m *BgpSpeakerSuite) TestIbgp(c *C) {
+	peer := newFakeBgpPeer(c, 65001, 65001, 3*time.Second)
+	defer peer.Close()
+	speaker := newTestBgpSpeaker(c, peer, 65001)
+	defer speaker.Close()
+
+	c.Assert(speaker.Init(65001), NoErr)
+	c.Assert(speaker.Advertise(&kglb_pb.BgpRouteAttributes{
+		LocalAsn:  65001,
+		PeerAsn:   65001,
+		Community: "65001:100",
+		Prefix:    &kglb_pb.IP{Address: &kglb_pb.IP_Ipv4{Ipv4: "172.0.0.1"}},
+		Prefixlen: 32,
+	}), NoErr)
+	waitBgpUpdates(c, peer, 1)
+	// as path isn't prepended for ibgp peer.
+	c.Assert(speaker.Advertise(&kglb_pb.BgpRouteAttributes{
+		LocalAsn:      65001,
+		PeerAsn:       65001,
+		Community:     "65001:100",
+		Prefix:        &kglb_pb.IP{Address: &kglb_pb.IP_Ipv4{Ipv4: "172.0.0.2"}},
+		Prefixlen:     32,
+		LocalPref:     &kglb_pb.UInt32Value{Value: 200},
+		AsPathPrepend: 2,
+	}), NoErr)
+
+	// zero med and local pref are sent when set.
+	c.Assert(speaker.Advertise(&kglb_pb.BgpRouteAttributes{
+		LocalAsn:  65001,
+		PeerAsn:   65001,
+		Community: "65001:100",
+		Prefix:    &kglb_pb.IP{Address: &kglb_pb.IP_Ipv4{Ipv4: "172.0.0.3"}},
+		Prefixlen: 32,
+		Med:       &kglb_pb.UInt32Value{Value: 0},
+		LocalPref: &kglb_pb.UInt32Value{Value: 0},
+	}), NoErr)
+
+	updates := waitBgpUpdates(c, peer, 3)
+	c.Assert(updates[0], DeepEquals, (&bgpPath{
+		prefix:      mustParseCIDR(c, "172.0.0.1/32"),
+		nextHop:     net.ParseIP("127.0.0.1"),
+		localPref:   proto.Uint32(bgpDefaultLocalPref),
+		communities: []uint32{65001<<16 | 100},
+	}).marshal())
+	c.Assert(updates[1], DeepEquals, (&bgpPath{
+		prefix:      mustParseCIDR(c, "172.0.0.2/32"),
+		nextHop:     net.ParseIP("127.0.0.1"),
+		localPref:   proto.Uint32(200),
+		communities: []uint32{65001<<16 | 100},
+	}).marshal())
+	c.Assert(updates[2], DeepEquals, (&bgpPath{
+		prefix:      mustParseCIDR(c, "172.0.0.3/32"),
+		nextHop:     net.ParseIP("127.0.0.1"),
+		med:         proto.Uint32(0),
+		localPref:   proto.Uint32(0),
+		communities: []uint32{65001<<16 | 100},
+	}).marshal())
+}
+
+func (m *BgpSpeakerSuite) TestBadPeerAsn(c *C) {
+	// peer expects 65001 asn of the speaker.
+	peer := newFakeBgpPeer(c, 65002, 65001, 3*time.Second)
+	defer peer.Close()
+
+	// peer has other asn than configured one.
+	speaker := newTestBgpSpeaker(c, peer, 65003)
+	c.Assert(speaker.Init(65001), NoErr)
+	time.Sleep(200 * time.Millisecond)
+	established, err := speaker.IsSessionEstablished()
+	c.Assert(err, NoErr)
+	c.Assert(established, IsFalse)
+	c.Assert(speaker.PeerStates(), DeepEquals, map[string]bool{"127.0.0.1": false})
+	speaker.Close()
+
+	// speaker is rejected by the peer.
+	speaker = newTestBgpSpeaker(c, peer, 65002)
+	defer speaker.Close()
+	c.Assert(speaker.Init(65004), NoErr)
+	time.Sleep(200 * time.Millisecond)
+	established, err = speaker.IsSessionEstablished()
+	c.Assert(err, NoErr)
+	c.Assert(established, IsFalse)
+
+	// sessions are restarted with new asn.
+	c.Assert(speaker.Init(65001), NoErr)
+	waitBgpCondition(c, func() bool {
+		established, _ := speaker.IsSessionEstablished()
+		return established
+	})
+}
+
+func (m *BgpSpeakerSuite) TestHoldTime(c *C) {
+	c.Assert(negotiateBgpHoldTime(90*time.Second, 30*time.Second), Equals, 30*time.Second)
+	c.Assert(negotiateBgpHoldTime(30*time.Second, 90*time.Second), Equals, 30*time.Second)
+	c.Assert(negotiateBgpHoldTime(90*time.Second, 0), Equals, time.Duration(0))
+
+	// zero hold time of the peer disables keepalives.
+	peer := newFakeBgpPeer(c, 65002, 65001, 0)
+	defer peer.Close()
+	speaker := newTestBgpSpeaker(c, peer, 65002)
+	defer speaker.Close()
+
+	c.Assert(speaker.Init(65001), NoErr)
+	waitBgpCondition(c, func() bool {
+		established, _ := speaker.IsSessionEstablished()
+		return established && peer.isEstablished()
+	})
+	// keepalive of the speaker would be sent in 1s with 3s hold time.
+	time.Sleep(1500 * time.Millisecond)
+	c.Assert(peer.keepaliveCnt(), Equals, 1)
+	established, err := speaker.IsSessionEstablished()
+	c.Assert(err, NoErr)
+	c.Assert(established, IsTrue)
+}
+
+func (m *BgpSpeakerSuite) TestStuckPeer(c *C) {
+	speaker, err := NewBgpSpeaker(BgpSpeakerParams{
+		RouterId: net.ParseIP("10.0.0.1"),
+		Peers: []BgpPeerConfig{
+			{Address: net.ParseIP("127.0.0.1"), Asn: 65002},
+		},
+	})
+	c.Assert(err, NoErr)
+
+	// writes to the pipe block until the peer reads them.
+	local, remote := net.Pipe()
+	defer local.Close()
+	defer remote.Close()
+	session := newBgpSession(speaker, speaker.params.Peers[0], 65001)
+	session.conn = local
+	session.established = true
+	session.localAddr = net.ParseIP("127.0.0.1")
+	session.ipv4 = true
+	closed := make(chan struct{})
+	defer close(closed)
+	go session.writeLoop(local, closed)
+	speaker.asn = 65001
+	speaker.sessions = []*bgpSession{session}
+
+	route := &kglb_pb.BgpRouteAttributes{
+		LocalAsn:  65001,
+		PeerAsn:   65002,
+		Community: "65001:100",
+		Prefix:    &kglb_pb.IP{Address: &kglb_pb.IP_Ipv4{Ipv4: "172.0.0.1"}},
+		Prefixlen: 32,
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 2; i++ {
+			speaker.Advertise(route)
+			speaker.Withdraw(route)
+		}
+		speaker.IsSessionEstablished()
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		c.Fatal("speaker is blocked by the peer")
+	}
+
+	// messages are sent in order once the peer reads them.
+	path := (&bgpPath{
+		prefix:      mustParseCIDR(c, "172.0.0.1/32"),
+		nextHop:     net.ParseIP("127.0.0.1"),
+		asPath:      []uint32{65001},
+		communities: []uint32{65001<<16 | 100},
+	}).marshal()
+	withdrawal := marshalBgpWithdrawal(mustParseCIDR(c, "172.0.0.1/32"))
+	for _, expected := range [][]byte{path, withdrawal, path, withdrawal} {
+		msgType, body, err := readBgpMessage(remote)
+		c.Assert(err, NoErr)
+		c.Assert(marshalBgpMessage(msgType, body), DeepEquals, expected)
+	}
+}
@@ -0,0 +1,14 @@
+package data_plane
+
+import kglb_pb "dropbox/proto/kglb"
+
+// Removal of connection entries of ipvs destinations. Kernel doesn't provide
+// removal of IPVS connection entries, entries of deleted reals are expired on
+// next packet when expire_nodest_conn sysctl is enabled (see KernelSettings),
+// so unhealthy reals are deleted from balancers with ConnectionCleanup.FlushIpvs
+// (see excludeFlushedUpstreams).
+type ConnCleanupModule interface {
+	// Remove netfilter conntrack entries of the service destined to the
+	// destinations, returns number of removed entries.
+	FlushConntrack(service *kglb_pb.IpvsService, dsts []*kglb_pb.UpstreamState) (uint, error)
+}
@@ -0,0 +1,252 @@
+package data_plane
+
+import (
+	"bufio"
+	"encoding/binary"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+
+	"dropbox/kglb/common"
+	kglb_pb "dropbox/proto/kglb"
+	"godropbox/errors"
+)
+
+// IPVS connection table of the kernel.
+const procIpvsConn = "/proc/net/ip_vs_conn"
+
+// Removes conntrack entries matching the filter, returns number of removed
+// entries.
+type conntrackDeleteFunc func(
+	table netlink.ConntrackTableType,
+	family netlink.InetFamily,
+	filter netlink.CustomConntrackFilter) (uint, error)
+
+// Calls visit for each IPVS connection entry.
+type ipvsConnsFunc func(visit func(conn *ipvsConn)) error
+
+// ConnCleanupModule implementation based on netfilter conntrack netlink api.
+type NetfilterConnCleanup struct {
+	conntrackDelete conntrackDeleteFunc
+	ipvsConns       ipvsConnsFunc
+}
+
+var _ ConnCleanupModule = &NetfilterConnCleanup{}
+
+func NewNetfilterConnCleanup() *NetfilterConnCleanup {
+	return &NetfilterConnCleanup{
+		conntrackDelete: netlink.ConntrackDeleteFilter,
+		ipvsConns: func(visit func(conn *ipvsConn)) error {
+			return readIpvsConns(procIpvsConn, visit)
+		},
+	}
+}
+
+// Flushes conntrack entries of all destinations at once since every flush
+// walks whole conntrack table.
+func (n *NetfilterConnCleanup) FlushConntrack(
+	service *kglb_pb.IpvsService,
+	dsts []*kglb_pb.UpstreamState) (uint, error) {
+
+	filter, err := newConntrackFilter(service)
+	if err != nil {
+		return 0, err
+	}
+
+	// reals of other forward methods are unknown to conntrack, their
+	// entries are matched by clients of IPVS connections.
+	unmasqueraded := make(map[string]bool)
+	for _, dst := range dsts {
+		key := ipPortKey(
+			common.KglbAddrToNetIp(dst.GetAddress()),
+			uint16(dst.GetPort()))
+		if dst.GetForwardMethod() == kglb_pb.ForwardMethods_MASQ {
+			filter.reals[key] = true
+		} else {
+			unmasqueraded[key] = true
+		}
+	}
+	if len(unmasqueraded) > 0 {
+		err := n.ipvsConns(func(conn *ipvsConn) {
+			if conn.protocol == filter.protocol &&
+				conn.vip.Equal(filter.vip) &&
+				conn.vipPort == filter.port &&
+				unmasqueraded[ipPortKey(conn.real, conn.realPort)] {
+
+				filter.clients[ipPortKey(conn.client, conn.clientPort)] = true
+			}
+		})
+		if err != nil {
+			return 0, errors.Wrap(err, "fails to read IPVS connections: ")
+		}
+	}
+	if len(filter.reals) == 0 && len(filter.clients) == 0 {
+		return 0, nil
+	}
+
+	family := netlink.InetFamily(unix.AF_INET)
+	if filter.vip.To4() == nil {
+		family = netlink.InetFamily(unix.AF_INET6)
+	}
+
+	cnt, err := n.conntrackDelete(netlink.ConntrackTable, family, filter)
+	if err != nil {
+		return cnt, errors.Wrapf(
+			err,
+			"fails to flush conntrack entries of %s:%d: ",
+			filter.vip,
+			filter.port)
+	}
+	return cnt, nil
+}
+
+// Matches conntrack entries of vip:port which are either replied by
+// masqueraded reals or initiated by clients.
+type conntrackFilter struct {
+	protocol uint8
+	vip      net.IP
+	port     uint16
+
+	// address:port of masqueraded reals.
+	reals map[string]bool
+	// address:port of clients of IPVS connections.
+	clients map[string]bool
+}
+
+var _ netlink.CustomConntrackFilter = &conntrackFilter{}
+
+func newConntrackFilter(service *kglb_pb.IpvsService) (*conntrackFilter, error) {
+	filter := &conntrackFilter{
+		reals:   make(map[string]bool),
+		clients: make(map[string]bool),
+	}
+	switch attr := service.GetAttributes().(type) {
+	case *kglb_pb.IpvsService_TcpAttributes:
+		filter.protocol = unix.IPPROTO_TCP
+		filter.vip = common.KglbAddrToNetIp(attr.TcpAttributes.GetAddress())
+		filter.port = uint16(attr.TcpAttributes.GetPort())
+	case *kglb_pb.IpvsService_UdpAttributes:
+		filter.protocol = unix.IPPROTO_UDP
+		filter.vip = common.KglbAddrToNetIp(attr.UdpAttributes.GetAddress())
+		filter.port = uint16(attr.UdpAttributes.GetPort())
+	default:
+		return nil, errors.Newf(
+			"conntrack entries cannot be matched for ipvs service: %+v",
+			service)
+	}
+	return filter, nil
+}
+
+func (f *conntrackFilter) MatchConntrackFlow(flow *netlink.ConntrackFlow) bool {
+	if flow.Forward.Protocol != f.protocol ||
+		!flow.Forward.DstIP.Equal(f.vip) ||
+		flow.Forward.DstPort != f.port {
+
+		return false
+	}
+	// reply direction of masqueraded connection comes from the real.
+	if f.reals[ipPortKey(flow.Reverse.SrcIP, flow.Reverse.SrcPort)] {
+		return true
+	}
+	return f.clients[ipPortKey(flow.Forward.SrcIP, flow.Forward.SrcPort)]
+}
+
+func ipPortKey(ip net.IP, port uint16) string {
+	return net.JoinHostPort(ip.String(), strconv.Itoa(int(port)))
+}
+
+// Entry of IPVS connection table.
+type ipvsConn struct {
+	// zero for protocols other than tcp and udp.
+	protocol   uint8
+	client     net.IP
+	clientPort uint16
+	vip        net.IP
+	vipPort    uint16
+	real       net.IP
+	realPort   uint16
+}
+
+// Reads IPVS connection table in /proc/net/ip_vs_conn format:
+//
+//	Pro FromIP   FPrt ToIP     TPrt DestIP   DPrt State       Expires
+//	TCP C0A80101 C350 AC000001 01BB 0A000001 01BB ESTABLISHED     899
+//
+// ipv6 addresses are printed in colon notation (destination is enclosed in
+// brackets when its family differs from the service one).
+func readIpvsConns(path string, visit func(conn *ipvsConn)) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	// skipping header.
+	scanner.Scan()
+	for scanner.Scan() {
+		conn, err := parseIpvsConn(scanner.Text())
+		if err != nil {
+			return err
+		}
+		visit(conn)
+	}
+	return scanner.Err()
+}
+
+func parseIpvsConn(line string) (*ipvsConn, error) {
+	fields := strings.Fields(line)
+	if len(fields) < 7 {
+		return nil, errors.Newf("invalid ipvs connection entry: %s", line)
+	}
+
+	conn := &ipvsConn{}
+	switch fields[0] {
+	case "TCP":
+		conn.protocol = unix.IPPROTO_TCP
+	case "UDP":
+		conn.protocol = unix.IPPROTO_UDP
+	}
+
+	var err error
+	for i, addr := range []struct {
+		ip   *net.IP
+		port *uint16
+	}{
+		{&conn.client, &conn.clientPort},
+		{&conn.vip, &conn.vipPort},
+		{&conn.real, &conn.realPort},
+	} {
+		if *addr.ip, err = parseIpvsConnAddr(fields[2*i+1]); err != nil {
+			return nil, errors.Wrapf(err, "invalid ipvs connection entry: %s: ", line)
+		}
+		port, err := strconv.ParseUint(fields[2*i+2], 16, 16)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid ipvs connection entry: %s: ", line)
+		}
+		*addr.port = uint16(port)
+	}
+	return conn, nil
+}
+
+func parseIpvsConnAddr(field string) (net.IP, error) {
+	if strings.Contains(field, ":") {
+		ip := net.ParseIP(strings.Trim(field, "[]"))
+		if ip == nil {
+			return nil, errors.Newf("invalid address: %s", field)
+		}
+		return ip, nil
+	}
+
+	value, err := strconv.ParseUint(field, 16, 32)
+	if err != nil {
+		return nil, err
+	}
+	ip := make(net.IP, net.IPv4len)
+	binary.BigEndian.PutUint32(ip, uint32(value))
+	return ip, nil
+}
@@ -0,0 +1,177 @@
+package data_plane
+
+import (
+	"io/ioutil"
+	"net"
+	"path/filepath"
+
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+	. "gopkg.in/check.v1"
+
+	kglb_pb "dropbox/proto/kglb"
+)
+
+type NetfilterConnCleanupSuite struct {
+}
+
+var _ = Suite(&NetfilterConnCleanupSuite{})
+
+func testConntrackFlow(vip, real string, port, realPort uint16) *netlink.ConntrackFlow {
+	flow := &netlink.ConntrackFlow{FamilyType: unix.AF_INET}
+	flow.Forward.Protocol = unix.IPPROTO_TCP
+	flow.Forward.SrcIP = net.ParseIP("192.168.1.1")
+	flow.Forward.SrcPort = 50000
+	flow.Forward.DstIP = net.ParseIP(vip)
+	flow.Forward.DstPort = port
+	flow.Reverse.Protocol = unix.IPPROTO_TCP
+	flow.Reverse.SrcIP = net.ParseIP(real)
+	flow.Reverse.SrcPort = realPort
+	flow.Reverse.DstIP = net.ParseIP("192.168.1.1")
+	flow.Reverse.DstPort = 50000
+	return flow
+}
+
+func (m *NetfilterConnCleanupSuite) TestFlushConntrack(c *C) {
+	var filter netlink.CustomConntrackFilter
+	deleteCnt := 0
+	conns := []*ipvsConn{
+		// connection of the tunneled real.
+		{
+			protocol:   unix.IPPROTO_TCP,
+			client:     net.ParseIP("192.168.1.2"),
+			clientPort: 50000,
+			vip:        net.ParseIP("172.0.0.1"),
+			vipPort:    443,
+			real:       net.ParseIP("10.0.0.3"),
+			realPort:   443,
+		},
+		// connection of other real.
+		{
+			protocol:   unix.IPPROTO_TCP,
+			client:     net.ParseIP("192.168.1.3"),
+			clientPort: 50000,
+			vip:        net.ParseIP("172.0.0.1"),
+			vipPort:    443,
+			real:       net.ParseIP("10.0.0.4"),
+			realPort:   443,
+		},
+	}
+	cleanup := &NetfilterConnCleanup{
+		conntrackDelete: func(
+			table netlink.ConntrackTableType,
+			family netlink.InetFamily,
+			f netlink.CustomConntrackFilter) (uint, error) {
+
+			c.Assert(table, Equals, netlink.ConntrackTableType(netlink.ConntrackTable))
+			c.Assert(family, Equals, netlink.InetFamily(unix.AF_INET))
+			filter = f
+			deleteCnt++
+			return 3, nil
+		},
+		ipvsConns: func(visit func(conn *ipvsConn)) error {
+			for _, conn := range conns {
+				visit(conn)
+			}
+			return nil
+		},
+	}
+	service := &kglb_pb.IpvsService{
+		Attributes: &kglb_pb.IpvsService_TcpAttributes{
+			TcpAttributes: &kglb_pb.IpvsTcpAttributes{
+				Address: &kglb_pb.IP{Address: &kglb_pb.IP_Ipv4{Ipv4: "172.0.0.1"}},
+				Port:    443,
+			}},
+	}
+	dsts := []*kglb_pb.UpstreamState{
+		{
+			Address:       &kglb_pb.IP{Address: &kglb_pb.IP_Ipv4{Ipv4: "10.0.0.1"}},
+			Port:          8443,
+			Hostname:      "hostname1",
+			ForwardMethod: kglb_pb.ForwardMethods_MASQ,
+		},
+		{
+			Address:       &kglb_pb.IP{Address: &kglb_pb.IP_Ipv4{Ipv4: "10.0.0.3"}},
+			Port:          443,
+			Hostname:      "hostname3",
+			ForwardMethod: kglb_pb.ForwardMethods_TUNNEL,
+		},
+	}
+
+	// entries of all destinations are flushed at once.
+	cnt, err := cleanup.FlushConntrack(service, dsts)
+	c.Assert(err, IsNil)
+	c.Assert(cnt, Equals, uint(3))
+	c.Assert(deleteCnt, Equals, 1)
+
+	// masqueraded connections are matched by real.
+	c.Assert(filter.MatchConntrackFlow(
+		testConntrackFlow("172.0.0.1", "10.0.0.1", 443, 8443)), Equals, true)
+	c.Assert(filter.MatchConntrackFlow(
+		testConntrackFlow("172.0.0.1", "10.0.0.2", 443, 8443)), Equals, false)
+	c.Assert(filter.MatchConntrackFlow(
+		testConntrackFlow("172.0.0.1", "10.0.0.1", 443, 443)), Equals, false)
+	c.Assert(filter.MatchConntrackFlow(
+		testConntrackFlow("172.0.0.2", "10.0.0.1", 443, 8443)), Equals, false)
+
+	// tunneled connections are matched by clients of ipvs connections.
+	flow := testConntrackFlow("172.0.0.1", "172.0.0.1", 443, 443)
+	flow.Forward.SrcIP = net.ParseIP("192.168.1.2")
+	c.Assert(filter.MatchConntrackFlow(flow), Equals, true)
+	flow.Forward.SrcIP = net.ParseIP("192.168.1.3")
+	c.Assert(filter.MatchConntrackFlow(flow), Equals, false)
+	flow.Forward.SrcIP = net.ParseIP("192.168.1.2")
+	flow.Forward.DstPort = 80
+	c.Assert(filter.MatchConntrackFlow(flow), Equals, false)
+
+	// nothing is flushed without ipvs connections of tunneled real.
+	_, err = cleanup.FlushConntrack(service, dsts[1:])
+	c.Assert(err, IsNil)
+	conns = nil
+	_, err = cleanup.FlushConntrack(service, dsts[1:])
+	c.Assert(err, IsNil)
+	c.Assert(deleteCnt, Equals, 2)
+
+	// fwmark services aren't supported.
+	_, err = cleanup.FlushConntrack(&kglb_pb.IpvsService{
+		Attributes: &kglb_pb.IpvsService_FwmarkAttributes{
+			FwmarkAttributes: &kglb_pb.IpvsFwmarkAttributes{Fwmark: 10},
+		},
+	}, dsts)
+	c.Assert(err, NotNil)
+}
+
+func (m *NetfilterConnCleanupSuite) TestReadIpvsConns(c *C) {
+	path := filepath.Join(c.MkDir(), "ip_vs_conn")
+	content := "Pro FromIP   FPrt ToIP     TPrt DestIP   DPrt State       Expires PEName PEData\n" +
+		"TCP C0A80102 C350 AC000001 01BB 0A000003 01BB ESTABLISHED     899\n" +
+		"UDP fc00:0000:0000:0000:0000:0000:0000:0002 0035 fc00:0000:0000:0000:0000:0000:0000:0001 0035 fc00:0000:0000:0000:0000:0000:0000:0003 0035 UDP             299\n" +
+		"IP  C0A80102 0000 AC000001 0000 [fc00::3] 0000 NONE             60\n"
+	c.Assert(ioutil.WriteFile(path, []byte(content), 0644), IsNil)
+
+	var conns []*ipvsConn
+	err := readIpvsConns(path, func(conn *ipvsConn) {
+		conns = append(conns, conn)
+	})
+	c.Assert(err, IsNil)
+	c.Assert(conns, HasLen, 3)
+	c.Assert(conns[0], DeepEquals, &ipvsConn{
+		protocol:   unix.IPPROTO_TCP,
+		client:     net.ParseIP("192.168.1.2").To4(),
+		clientPort: 50000,
+		vip:        net.ParseIP("172.0.0.1").To4(),
+		vipPort:    443,
+		real:       net.ParseIP("10.0.0.3").To4(),
+		realPort:   443,
+	})
+	c.Assert(conns[1].protocol, Equals, uint8(unix.IPPROTO_UDP))
+	c.Assert(conns[1].client.String(), Equals, "fc00::2")
+	c.Assert(conns[1].real.String(), Equals, "fc00::3")
+	c.Assert(conns[1].realPort, Equals, uint16(53))
+	c.Assert(conns[2].protocol, Equals, uint8(0))
+	c.Assert(conns[2].real.String(), Equals, "fc00::3")
+
+	c.Assert(ioutil.WriteFile(path, []byte("Pro\nTCP C0A80102 C350\n"), 0644), IsNil)
+	err = readIpvsConns(path, func(conn *ipvsConn) {})
+	c.Assert(err, NotNil)
+}
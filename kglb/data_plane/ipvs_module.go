@@ -8,6 +8,9 @@ type IpvsModule interface {
 	AddService(service *kglb_pb.IpvsService) error
 	// Delete ipvs service.
 	DeleteService(service *kglb_pb.IpvsService) error
+	// Update scheduler, flags and persistence settings of existent ipvs
+	// service with the same VIP:port, proto or fwmark.
+	UpdateService(service *kglb_pb.IpvsService) error
 	// Get list of existent ipvs Services.
 	ListServices() ([]*kglb_pb.IpvsService, []*kglb_pb.Stats, error)
 	// Get list of destinations of specific ipvs service
@@ -72,6 +72,22 @@ func (m *IpvsMqliang) DeleteService(service *kglb_pb.IpvsService) error {
 	return err
 }
 
+// Update attributes of existent ipvs service.
+func (m *IpvsMqliang) UpdateService(service *kglb_pb.IpvsService) error {
+	libipvsService, err := toLibipvsService(service)
+	if err != nil {
+		return err
+	}
+	dlog.Infof("Updating service: %+v", libipvsService)
+	err = m.libIpvs.UpdateService(libipvsService)
+	if err != nil {
+		exclog.Report(
+			errors.Wrap(err, "failed to update IPVS service"),
+			exclog.Critical, "")
+	}
+	return err
+}
+
 // Get list of existent ipvs Services.
 func (m *IpvsMqliang) ListServices() ([]*kglb_pb.IpvsService, []*kglb_pb.Stats, error) {
 	services, err := m.libIpvs.ListServices()
@@ -6,6 +6,7 @@ import (
 	"syscall"
 
 	"github.com/mqliang/libipvs"
+	"github.com/vishvananda/netlink/nl"
 
 	"dropbox/kglb/common"
 	kglb_pb "dropbox/proto/kglb"
@@ -100,6 +101,14 @@ func tokglbVirtualService(service *libipvs.Service) (*kglb_pb.IpvsService, error
 		return nil, err
 	}
 
+	kglbIpvsService.PersistenceTimeoutS = service.Timeout
+	kglbIpvsService.PersistenceNetmask, err = tokglbNetmask(
+		service.AddressFamily,
+		service.Netmask)
+	if err != nil {
+		return nil, err
+	}
+
 	if service.FWMark != 0 {
 		kglbIpvsService.Attributes = &kglb_pb.IpvsService_FwmarkAttributes{
 			FwmarkAttributes: &kglb_pb.IpvsFwmarkAttributes{
@@ -139,10 +148,19 @@ func tokglbRealServer(destination *libipvs.Destination) (*kglb_pb.UpstreamState,
 		Port:          uint32(destination.Port),
 		Weight:        destination.Weight,
 		ForwardMethod: fwd,
+		UThreshold:    destination.UThresh,
+		LThreshold:    destination.LThresh,
 	}, nil
 }
 
 func toLibipvsDestination(realServer *kglb_pb.UpstreamState) (*libipvs.Destination, error) {
+	// libipvs doesn't encode tunnel attributes.
+	if realServer.GetTunnel().GetType() != kglb_pb.TunnelEncapsulation_IPIP {
+		return nil, errors.Newf(
+			"tunnel encapsulation is not supported by libipvs: %+v",
+			realServer.GetTunnel())
+	}
+
 	ip := common.KglbAddrToNetIp(realServer.Address)
 	fwd, err := tolibForwardMethod(realServer.ForwardMethod)
 	if err != nil {
@@ -155,6 +173,8 @@ func toLibipvsDestination(realServer *kglb_pb.UpstreamState) (*libipvs.Destinati
 		Weight:        realServer.Weight,
 		AddressFamily: ipToLibipvsAddressFamily(ip),
 		FwdMethod:     fwd,
+		UThresh:       realServer.GetUThreshold(),
+		LThresh:       realServer.GetLThreshold(),
 	}, nil
 }
 
@@ -162,6 +182,13 @@ func toLibipvsService(service *kglb_pb.IpvsService) (*libipvs.Service, error) {
 	var err error
 	result := &libipvs.Service{}
 
+	// libipvs doesn't encode persistence engine attribute.
+	if service.GetPeName() != "" {
+		return nil, errors.Newf(
+			"persistence engine is not supported by libipvs: %s",
+			service.GetPeName())
+	}
+
 	if result.SchedName, err = tolibIPVSScheduler(service.Scheduler); err != nil {
 		return nil, err
 	}
@@ -177,30 +204,56 @@ func toLibipvsService(service *kglb_pb.IpvsService) (*libipvs.Service, error) {
 		result.Protocol = syscall.IPPROTO_TCP
 		result.Port = uint16(attr.TcpAttributes.Port)
 		result.AddressFamily = ipToLibipvsAddressFamily(vip)
-		result.Netmask = netmaskByAddressFamily(result.AddressFamily)
 	case *kglb_pb.IpvsService_UdpAttributes:
 		vip := common.KglbAddrToNetIp(attr.UdpAttributes.GetAddress())
 		result.Address = vip
 		result.Protocol = syscall.IPPROTO_UDP
 		result.Port = uint16(attr.UdpAttributes.GetPort())
 		result.AddressFamily = ipToLibipvsAddressFamily(vip)
-		result.Netmask = netmaskByAddressFamily(result.AddressFamily)
 	case *kglb_pb.IpvsService_FwmarkAttributes:
 		result.AddressFamily = tolibAddressFamily(attr.FwmarkAttributes.GetAddressFamily())
 		result.FWMark = attr.FwmarkAttributes.Fwmark
-		result.Netmask = netmaskByAddressFamily(result.AddressFamily)
 	default:
 		return nil, errors.Newf("Unknown attributes type: %s", attr)
 	}
 
+	result.Timeout = service.GetPersistenceTimeoutS()
+	result.Netmask = toLibipvsNetmask(result.AddressFamily, service.GetPersistenceNetmask())
+
 	return result, nil
 }
 
-func netmaskByAddressFamily(af libipvs.AddressFamily) uint32 {
+// Converts prefix length into netmask of libipvs service. Zero prefix length
+// means full address.
+func toLibipvsNetmask(af libipvs.AddressFamily, prefixLen uint32) uint32 {
 	if af == libipvs.AddressFamily(syscall.AF_INET6) {
-		return 128
+		if prefixLen == 0 {
+			return 128
+		}
+		return prefixLen
+	}
+
+	if prefixLen == 0 {
+		prefixLen = 32
+	}
+	// ipvs expects ipv4 netmask in network byte order while libipvs encodes
+	// attributes in native byte order.
+	return nl.NativeEndian().Uint32(net.CIDRMask(int(prefixLen), 32))
+}
+
+// Converts netmask of libipvs service into prefix length.
+func tokglbNetmask(af libipvs.AddressFamily, netmask uint32) (uint32, error) {
+	if af == libipvs.AddressFamily(syscall.AF_INET6) {
+		return netmask, nil
+	}
+
+	mask := make(net.IPMask, net.IPv4len)
+	nl.NativeEndian().PutUint32(mask, netmask)
+	prefixLen, bits := mask.Size()
+	if bits == 0 {
+		return 0, errors.Newf("non-canonical ipv4 netmask: %v", mask)
 	}
-	return 0
+	return uint32(prefixLen), nil
 }
 
 func ipToLibipvsAddressFamily(ip net.IP) libipvs.AddressFamily {
@@ -219,6 +272,28 @@ func tokglbIPVSScheduler(scheduler string) (kglb_pb.IpvsService_Scheduler, error
 		return kglb_pb.IpvsService_WRR, nil
 	case "ip_vs_sch":
 		return kglb_pb.IpvsService_IP_VS_SCH, nil
+	case "lc":
+		return kglb_pb.IpvsService_LC, nil
+	case "wlc":
+		return kglb_pb.IpvsService_WLC, nil
+	case "sh":
+		return kglb_pb.IpvsService_SH, nil
+	case "mh":
+		return kglb_pb.IpvsService_MH, nil
+	case "sed":
+		return kglb_pb.IpvsService_SED, nil
+	case "nq":
+		return kglb_pb.IpvsService_NQ, nil
+	case "lblc":
+		return kglb_pb.IpvsService_LBLC, nil
+	case "lblcr":
+		return kglb_pb.IpvsService_LBLCR, nil
+	case "dh":
+		return kglb_pb.IpvsService_DH, nil
+	case "fo":
+		return kglb_pb.IpvsService_FO, nil
+	case "ovf":
+		return kglb_pb.IpvsService_OVF, nil
 	default:
 		return 0, errors.Newf("unknown libipvs ipvs scheduler: %v", scheduler)
 	}
@@ -232,6 +307,28 @@ func tolibIPVSScheduler(scheduler kglb_pb.IpvsService_Scheduler) (string, error)
 		return "wrr", nil
 	case kglb_pb.IpvsService_IP_VS_SCH:
 		return "ip_vs_sch", nil
+	case kglb_pb.IpvsService_LC:
+		return "lc", nil
+	case kglb_pb.IpvsService_WLC:
+		return "wlc", nil
+	case kglb_pb.IpvsService_SH:
+		return "sh", nil
+	case kglb_pb.IpvsService_MH:
+		return "mh", nil
+	case kglb_pb.IpvsService_SED:
+		return "sed", nil
+	case kglb_pb.IpvsService_NQ:
+		return "nq", nil
+	case kglb_pb.IpvsService_LBLC:
+		return "lblc", nil
+	case kglb_pb.IpvsService_LBLCR:
+		return "lblcr", nil
+	case kglb_pb.IpvsService_DH:
+		return "dh", nil
+	case kglb_pb.IpvsService_FO:
+		return "fo", nil
+	case kglb_pb.IpvsService_OVF:
+		return "ovf", nil
 	default:
 		return "", errors.Newf("unknown kglb ipvs scheduler: %v", scheduler)
 	}
@@ -256,6 +353,8 @@ func tokglbUpstreamForwardMethod(
 		return kglb_pb.ForwardMethods_TUNNEL, nil
 	case libipvs.IP_VS_CONN_F_MASQ:
 		return kglb_pb.ForwardMethods_MASQ, nil
+	case libipvs.IP_VS_CONN_F_DROUTE:
+		return kglb_pb.ForwardMethods_DR, nil
 	default:
 		return 0, errors.Newf("unknown forward method: %v", fwdMethod.String())
 	}
@@ -270,6 +369,8 @@ func tolibForwardMethod(
 		return libipvs.IP_VS_CONN_F_TUNNEL, nil
 	case kglb_pb.ForwardMethods_MASQ:
 		return libipvs.IP_VS_CONN_F_MASQ, nil
+	case kglb_pb.ForwardMethods_DR:
+		return libipvs.IP_VS_CONN_F_DROUTE, nil
 	default:
 		return 0, errors.Newf("unknown forward method: %v", fwdMethod.String())
 	}
@@ -1,9 +1,13 @@
 package data_plane
 
 import (
+	"syscall"
+
 	"github.com/mqliang/libipvs"
+	"github.com/vishvananda/netlink/nl"
 	. "gopkg.in/check.v1"
 
+	"dropbox/kglb/common"
 	kglb_pb "dropbox/proto/kglb"
 	. "godropbox/gocheck2"
 )
@@ -98,3 +102,153 @@ func (ts *ConvertTypesTestSuite) TestlibIpvsFlags(c *C) {
 		})
 	c.Assert(err, MultilineErrorMatches, "Unsupported flags left: 128")
 }
+
+func (ts *ConvertTypesTestSuite) TestSchedulers(c *C) {
+	// all schedulers are converted back and forth.
+	for value := range kglb_pb.IpvsService_Scheduler_name {
+		scheduler := kglb_pb.IpvsService_Scheduler(value)
+		name, err := tolibIPVSScheduler(scheduler)
+		c.Assert(err, NoErr)
+		converted, err := tokglbIPVSScheduler(name)
+		c.Assert(err, NoErr)
+		c.Assert(converted, Equals, scheduler)
+	}
+
+	name, err := tolibIPVSScheduler(kglb_pb.IpvsService_MH)
+	c.Assert(err, NoErr)
+	c.Assert(name, Equals, "mh")
+
+	_, err = tokglbIPVSScheduler("unknown")
+	c.Assert(err, NotNil)
+}
+
+func (ts *ConvertTypesTestSuite) TestServiceRoundTrip(c *C) {
+	service := &kglb_pb.IpvsService{
+		Attributes: &kglb_pb.IpvsService_TcpAttributes{
+			TcpAttributes: &kglb_pb.IpvsTcpAttributes{
+				Address: &kglb_pb.IP{Address: &kglb_pb.IP_Ipv4{Ipv4: "10.0.0.1"}},
+				Port:    443,
+			}},
+		Scheduler: kglb_pb.IpvsService_SH,
+		Flags: []kglb_pb.IpvsService_Flag{
+			kglb_pb.IpvsService_SH_FALLBACK,
+			kglb_pb.IpvsService_SH_PORT,
+		},
+	}
+
+	libService, err := toLibipvsService(service)
+	c.Assert(err, NoErr)
+	c.Assert(libService.SchedName, Equals, "sh")
+	// IPVS sets HASHED flag for all services.
+	libService.Flags.Flags |= libipvs.IP_VS_SVC_F_HASHED
+
+	converted, err := tokglbVirtualService(libService)
+	c.Assert(err, NoErr)
+	// unset netmask is reported as full address.
+	c.Assert(converted, DeepEqualsPretty, common.NormalizeIpvsService(service))
+}
+
+func (ts *ConvertTypesTestSuite) TestPersistence(c *C) {
+	service := &kglb_pb.IpvsService{
+		Attributes: &kglb_pb.IpvsService_TcpAttributes{
+			TcpAttributes: &kglb_pb.IpvsTcpAttributes{
+				Address: &kglb_pb.IP{Address: &kglb_pb.IP_Ipv4{Ipv4: "10.0.0.1"}},
+				Port:    443,
+			}},
+		Flags:               []kglb_pb.IpvsService_Flag{kglb_pb.IpvsService_PERSISTENT},
+		PersistenceTimeoutS: 600,
+		PersistenceNetmask:  24,
+	}
+
+	libService, err := toLibipvsService(service)
+	c.Assert(err, NoErr)
+	c.Assert(libService.Timeout, Equals, uint32(600))
+	// 255.255.255.0 in network byte order.
+	c.Assert(libService.Netmask, Equals, nl.NativeEndian().Uint32([]byte{255, 255, 255, 0}))
+
+	converted, err := tokglbVirtualService(libService)
+	c.Assert(err, NoErr)
+	c.Assert(converted, DeepEqualsPretty, service)
+
+	// full address.
+	service.PersistenceNetmask = 0
+	libService, err = toLibipvsService(service)
+	c.Assert(err, NoErr)
+	c.Assert(libService.Netmask, Equals, ^uint32(0))
+	converted, err = tokglbVirtualService(libService)
+	c.Assert(err, NoErr)
+	c.Assert(converted.PersistenceNetmask, Equals, uint32(32))
+	service.PersistenceNetmask = 32
+	libService, err = toLibipvsService(service)
+	c.Assert(err, NoErr)
+	c.Assert(libService.Netmask, Equals, ^uint32(0))
+
+	// zero netmask of services created by earlier versions.
+	prefixLen, err := tokglbNetmask(libipvs.AddressFamily(syscall.AF_INET), 0)
+	c.Assert(err, NoErr)
+	c.Assert(prefixLen, Equals, uint32(0))
+
+	// ipv6 netmask is prefix length.
+	service.GetTcpAttributes().Address = &kglb_pb.IP{
+		Address: &kglb_pb.IP_Ipv6{Ipv6: "fc00::1"}}
+	service.PersistenceNetmask = 0
+	libService, err = toLibipvsService(service)
+	c.Assert(err, NoErr)
+	c.Assert(libService.Netmask, Equals, uint32(128))
+	service.PersistenceNetmask = 64
+	libService, err = toLibipvsService(service)
+	c.Assert(err, NoErr)
+	c.Assert(libService.Netmask, Equals, uint32(64))
+	converted, err = tokglbVirtualService(libService)
+	c.Assert(err, NoErr)
+	c.Assert(converted, DeepEqualsPretty, service)
+
+	// non-canonical netmask.
+	_, err = tokglbNetmask(
+		libipvs.AddressFamily(syscall.AF_INET),
+		nl.NativeEndian().Uint32([]byte{255, 0, 255, 0}))
+	c.Assert(err, NotNil)
+}
+
+func (ts *ConvertTypesTestSuite) TestDestinationThresholds(c *C) {
+	upstream := &kglb_pb.UpstreamState{
+		Address:       &kglb_pb.IP{Address: &kglb_pb.IP_Ipv4{Ipv4: "10.0.0.1"}},
+		Port:          443,
+		Weight:        100,
+		ForwardMethod: kglb_pb.ForwardMethods_TUNNEL,
+		UThreshold:    1000,
+		LThreshold:    500,
+	}
+
+	destination, err := toLibipvsDestination(upstream)
+	c.Assert(err, NoErr)
+	c.Assert(destination.UThresh, Equals, uint32(1000))
+	c.Assert(destination.LThresh, Equals, uint32(500))
+
+	converted, err := tokglbRealServer(destination)
+	c.Assert(err, NoErr)
+	c.Assert(converted, DeepEqualsPretty, upstream)
+}
+
+func (ts *ConvertTypesTestSuite) TestForwardMethods(c *C) {
+	upstream := &kglb_pb.UpstreamState{
+		Address:       &kglb_pb.IP{Address: &kglb_pb.IP_Ipv4{Ipv4: "10.0.0.1"}},
+		Port:          443,
+		Weight:        100,
+		ForwardMethod: kglb_pb.ForwardMethods_DR,
+	}
+	destination, err := toLibipvsDestination(upstream)
+	c.Assert(err, NoErr)
+	c.Assert(destination.FwdMethod, Equals, libipvs.FwdMethod(libipvs.IP_VS_CONN_F_DROUTE))
+	converted, err := tokglbRealServer(destination)
+	c.Assert(err, NoErr)
+	c.Assert(converted, DeepEqualsPretty, upstream)
+
+	// tunnel encapsulation other than IPIP isn't supported by libipvs.
+	upstream.ForwardMethod = kglb_pb.ForwardMethods_TUNNEL
+	upstream.Tunnel = &kglb_pb.TunnelEncapsulation{
+		Type: kglb_pb.TunnelEncapsulation_GRE,
+	}
+	_, err = toLibipvsDestination(upstream)
+	c.Assert(err, NotNil)
+}
@@ -0,0 +1,614 @@
+package data_plane
+
+import (
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/vishvananda/netlink/nl"
+	"golang.org/x/sys/unix"
+
+	"dropbox/dlog"
+	"dropbox/exclog"
+	"dropbox/kglb/common"
+	kglb_pb "dropbox/proto/kglb"
+	"godropbox/errors"
+)
+
+var _ IpvsModule = &IpvsNetlink{}
+var _ IpvsSyncDaemonModule = &IpvsNetlink{}
+
+// max number of requests sent by single write, the limit keeps acks within
+// default receive buffer of netlink socket.
+const ipvsNetlinkBatchSize = 64
+
+// Global IPVS connection timeouts. Zero value keeps current timeout when it's
+// set.
+type IpvsTimeouts struct {
+	Tcp    time.Duration
+	TcpFin time.Duration
+	Udp    time.Duration
+}
+
+// Generic netlink request.
+type genlRequest struct {
+	family  uint16
+	cmd     uint8
+	version uint8
+	// flags in addition to NLM_F_REQUEST.
+	flags int
+	attrs []*nl.RtAttr
+}
+
+// Generic netlink header. nl.Genlmsg isn't used since it serializes reserved
+// field from memory beyond the struct.
+type genlHeader struct {
+	cmd     uint8
+	version uint8
+}
+
+func (h *genlHeader) Len() int {
+	return nl.SizeofGenlmsg
+}
+
+func (h *genlHeader) Serialize() []byte {
+	return []byte{h.cmd, h.version, 0, 0}
+}
+
+// Serializes the request with provided sequence number.
+func (r *genlRequest) serialize(seq uint32) []byte {
+	req := nl.NewNetlinkRequest(int(r.family), r.flags)
+	req.Seq = seq
+	req.AddData(&genlHeader{cmd: r.cmd, version: r.version})
+	for _, attr := range r.attrs {
+		req.AddData(attr)
+	}
+	return req.Serialize()
+}
+
+// Response of generic netlink request.
+type genlResponse struct {
+	// payloads of received messages excluding generic netlink header.
+	payloads [][]byte
+	// errno returned by kernel.
+	err error
+}
+
+// IpvsModule implementation speaking IPVS generic netlink family directly.
+// Operations with multiple destinations are sent in batches.
+type IpvsNetlink struct {
+	// serializes requests since responses are matched by sequence numbers.
+	mu sync.Mutex
+
+	transport netlinkTransport
+	// id of IPVS generic netlink family.
+	familyId uint16
+	// sequence number of the latest request.
+	seq uint32
+
+	// DNS related module.
+	resolver ResolverModule
+}
+
+func NewIpvsNetlink(resolver ResolverModule) (*IpvsNetlink, error) {
+	socket, err := newNetlinkSocket(unix.NETLINK_GENERIC, defaultNetlinkTimeout)
+	if err != nil {
+		exclog.Report(
+			errors.Wrap(err, "fails to init IPVS netlink module"),
+			exclog.Critical, "")
+		return nil, err
+	}
+
+	m, err := newIpvsNetlink(resolver, socket)
+	if err != nil {
+		socket.Close()
+		exclog.Report(
+			errors.Wrap(err, "fails to init IPVS netlink module"),
+			exclog.Critical, "")
+		return nil, err
+	}
+	return m, nil
+}
+
+// Returns new instance of IpvsNetlink using provided transport, id of IPVS
+// generic netlink family is resolved during initialization.
+func newIpvsNetlink(
+	resolver ResolverModule,
+	transport netlinkTransport) (*IpvsNetlink, error) {
+
+	m := &IpvsNetlink{
+		transport: transport,
+		resolver:  resolver,
+	}
+
+	responses, err := m.execute([]*genlRequest{{
+		family:  nl.GENL_ID_CTRL,
+		cmd:     nl.GENL_CTRL_CMD_GETFAMILY,
+		version: nl.GENL_CTRL_VERSION,
+		flags:   unix.NLM_F_ACK,
+		attrs: []*nl.RtAttr{
+			nl.NewRtAttr(nl.GENL_CTRL_ATTR_FAMILY_NAME, nl.ZeroTerminated(ipvsGenlName)),
+		},
+	}})
+	if err != nil {
+		return nil, err
+	}
+	if responses[0].err == syscall.ENOENT {
+		return nil, errors.New(
+			"IPVS generic netlink family is not found, ip_vs module is not loaded")
+	} else if responses[0].err != nil {
+		return nil, errors.Wrap(responses[0].err, "fails to resolve IPVS family: ")
+	}
+
+	for _, payload := range responses[0].payloads {
+		attrs, err := parseNetlinkAttrs(payload)
+		if err != nil {
+			return nil, err
+		}
+		if m.familyId, err = netlinkAttrUint16(attrs, nl.GENL_CTRL_ATTR_FAMILY_ID); err != nil {
+			return nil, err
+		}
+		return m, nil
+	}
+
+	return nil, errors.New("IPVS family id is not received")
+}
+
+// Closes netlink transport.
+func (m *IpvsNetlink) Close() error {
+	return m.transport.Close()
+}
+
+// Add service.
+func (m *IpvsNetlink) AddService(service *kglb_pb.IpvsService) error {
+	dlog.Infof("Adding service: %+v", service)
+	err := m.serviceCmd(ipvsCmdNewService, service, true)
+	if err != nil {
+		exclog.Report(
+			errors.Wrap(err, "failed to create IPVS service"),
+			exclog.Critical, "")
+	}
+	return err
+}
+
+// Delete ipvs service.
+func (m *IpvsNetlink) DeleteService(service *kglb_pb.IpvsService) error {
+	dlog.Infof("Deleting service: %+v", service)
+	err := m.serviceCmd(ipvsCmdDelService, service, false)
+	if err != nil {
+		exclog.Report(
+			errors.Wrap(err, "failed to delete IPVS service"),
+			exclog.Critical, "")
+	}
+	return err
+}
+
+// Update attributes of existent ipvs service.
+func (m *IpvsNetlink) UpdateService(service *kglb_pb.IpvsService) error {
+	dlog.Infof("Updating service: %+v", service)
+	err := m.serviceCmd(ipvsCmdSetService, service, true)
+	if err != nil {
+		exclog.Report(
+			errors.Wrap(err, "failed to update IPVS service"),
+			exclog.Critical, "")
+	}
+	return err
+}
+
+// Get list of existent ipvs Services.
+func (m *IpvsNetlink) ListServices() ([]*kglb_pb.IpvsService, []*kglb_pb.Stats, error) {
+	payloads, err := m.dump(m.ipvsRequest(ipvsCmdGetService, unix.NLM_F_DUMP))
+	if err != nil {
+		exclog.Report(
+			errors.Wrap(err, "failed to list IPVS Services"),
+			exclog.Critical, "")
+		return nil, nil, err
+	}
+
+	var result []*kglb_pb.IpvsService
+	var servicesStats []*kglb_pb.Stats
+	for _, payload := range payloads {
+		attrs, err := parseNetlinkAttrs(payload)
+		if err != nil {
+			return nil, nil, err
+		}
+		service, stats, err := tokglbIpvsService(attrs[ipvsCmdAttrService])
+		if err != nil {
+			return nil, nil, err
+		}
+		result = append(result, service)
+		servicesStats = append(servicesStats, stats)
+	}
+	return result, servicesStats, nil
+}
+
+// Get list of real servers of specific ipvs service
+func (m *IpvsNetlink) GetRealServers(
+	service *kglb_pb.IpvsService) ([]*kglb_pb.UpstreamState, []*kglb_pb.Stats, error) {
+
+	_, af, err := ipvsServiceIdAttrs(service)
+	if err != nil {
+		return nil, nil, err
+	}
+	serviceAttr, err := ipvsServiceAttr(service, false)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	req := m.ipvsRequest(ipvsCmdGetDest, unix.NLM_F_DUMP)
+	req.attrs = append(req.attrs, serviceAttr)
+	payloads, err := m.dump(req)
+	if err != nil {
+		exclog.Report(
+			errors.Wrap(err, "failed to list IPVS real servers"),
+			exclog.Critical, "")
+		return nil, nil, err
+	}
+
+	var realServers []*kglb_pb.UpstreamState
+	var serversStats []*kglb_pb.Stats
+	for _, payload := range payloads {
+		attrs, err := parseNetlinkAttrs(payload)
+		if err != nil {
+			return nil, nil, err
+		}
+		realServer, stats, err := tokglbIpvsDest(attrs[ipvsCmdAttrDest], af)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		rip := common.KglbAddrToNetIp(realServer.GetAddress())
+		hostName, err := m.resolver.ReverseLookup(rip)
+		if err != nil {
+			exclog.Report(
+				errors.Wrapf(
+					err,
+					"reverse lookup failed for %s address: ",
+					rip),
+				exclog.Critical, "")
+		} else {
+			realServer.Hostname = hostName
+		}
+
+		realServers = append(realServers, realServer)
+		serversStats = append(serversStats, stats)
+	}
+
+	return realServers, serversStats, nil
+}
+
+// Add real servers to the specific ipvs service.
+func (m *IpvsNetlink) AddRealServers(
+	service *kglb_pb.IpvsService,
+	dsts []*kglb_pb.UpstreamState) error {
+
+	err := m.destCmd(ipvsCmdNewDest, service, dsts, true)
+	if err != nil {
+		exclog.Report(
+			errors.Wrapf(err, "failed to add IPVS real servers, service: %+v: ", service),
+			exclog.Critical, "")
+	}
+	return err
+}
+
+// Delete real servers from specific ipvs service.
+func (m *IpvsNetlink) DeleteRealServers(
+	service *kglb_pb.IpvsService,
+	dsts []*kglb_pb.UpstreamState) error {
+
+	err := m.destCmd(ipvsCmdDelDest, service, dsts, false)
+	if err != nil {
+		exclog.Report(
+			errors.Wrapf(err, "failed to delete IPVS real servers, service: %+v: ", service),
+			exclog.Critical, "")
+	}
+	return err
+}
+
+// Update real servers for specific ipvs service.
+func (m *IpvsNetlink) UpdateRealServers(
+	service *kglb_pb.IpvsService,
+	dsts []*kglb_pb.UpstreamState) error {
+
+	err := m.destCmd(ipvsCmdSetDest, service, dsts, true)
+	if err != nil {
+		exclog.Report(
+			errors.Wrapf(err, "failed to update IPVS real servers, service: %+v: ", service),
+			exclog.Critical, "")
+	}
+	return err
+}
+
+// Returns global IPVS connection timeouts.
+func (m *IpvsNetlink) Timeouts() (IpvsTimeouts, error) {
+	req := m.ipvsRequest(ipvsCmdGetConfig, unix.NLM_F_ACK)
+	responses, err := m.execute([]*genlRequest{req})
+	if err != nil {
+		return IpvsTimeouts{}, err
+	}
+	if err := ipvsError(req.cmd, responses[0].err); err != nil {
+		return IpvsTimeouts{}, err
+	}
+	if len(responses[0].payloads) == 0 {
+		return IpvsTimeouts{}, errors.New("IPVS timeouts are not received")
+	}
+
+	attrs, err := parseNetlinkAttrs(responses[0].payloads[0])
+	if err != nil {
+		return IpvsTimeouts{}, err
+	}
+	return IpvsTimeouts{
+		Tcp:    time.Duration(netlinkAttrUint(attrs, ipvsCmdAttrTimeoutTcp)) * time.Second,
+		TcpFin: time.Duration(netlinkAttrUint(attrs, ipvsCmdAttrTimeoutTcpFin)) * time.Second,
+		Udp:    time.Duration(netlinkAttrUint(attrs, ipvsCmdAttrTimeoutUdp)) * time.Second,
+	}, nil
+}
+
+// Sets global IPVS connection timeouts (rounded down to seconds).
+func (m *IpvsNetlink) SetTimeouts(timeouts IpvsTimeouts) error {
+	dlog.Infof("Setting IPVS timeouts: %+v", timeouts)
+	req := m.ipvsRequest(ipvsCmdSetConfig, unix.NLM_F_ACK)
+	req.attrs = append(req.attrs,
+		nl.NewRtAttr(ipvsCmdAttrTimeoutTcp, nl.Uint32Attr(uint32(timeouts.Tcp/time.Second))),
+		nl.NewRtAttr(ipvsCmdAttrTimeoutTcpFin, nl.Uint32Attr(uint32(timeouts.TcpFin/time.Second))),
+		nl.NewRtAttr(ipvsCmdAttrTimeoutUdp, nl.Uint32Attr(uint32(timeouts.Udp/time.Second))))
+	return m.do([]*genlRequest{req})
+}
+
+// Starts IPVS sync daemon.
+func (m *IpvsNetlink) StartSyncDaemon(daemon *kglb_pb.IpvsSyncDaemon) error {
+	dlog.Infof("Starting IPVS sync daemon: %+v", daemon)
+	daemonAttr, err := ipvsDaemonAttr(daemon, true)
+	if err != nil {
+		return err
+	}
+	req := m.ipvsRequest(ipvsCmdNewDaemon, unix.NLM_F_ACK)
+	req.attrs = append(req.attrs, daemonAttr)
+	if err := m.do([]*genlRequest{req}); err != nil {
+		exclog.Report(
+			errors.Wrap(err, "failed to start IPVS sync daemon"),
+			exclog.Critical, "")
+		return err
+	}
+	return nil
+}
+
+// Stops IPVS sync daemon of the role.
+func (m *IpvsNetlink) StopSyncDaemon(role kglb_pb.IpvsSyncDaemon_Role) error {
+	dlog.Infof("Stopping IPVS sync daemon: %s", role)
+	daemonAttr, err := ipvsDaemonAttr(&kglb_pb.IpvsSyncDaemon{Role: role}, false)
+	if err != nil {
+		return err
+	}
+	req := m.ipvsRequest(ipvsCmdDelDaemon, unix.NLM_F_ACK)
+	req.attrs = append(req.attrs, daemonAttr)
+	if err := m.do([]*genlRequest{req}); err != nil {
+		exclog.Report(
+			errors.Wrap(err, "failed to stop IPVS sync daemon"),
+			exclog.Critical, "")
+		return err
+	}
+	return nil
+}
+
+// Returns running IPVS sync daemons.
+func (m *IpvsNetlink) ListSyncDaemons() ([]*kglb_pb.IpvsSyncDaemon, error) {
+	payloads, err := m.dump(m.ipvsRequest(ipvsCmdGetDaemon, unix.NLM_F_DUMP))
+	if err != nil {
+		exclog.Report(
+			errors.Wrap(err, "failed to list IPVS sync daemons"),
+			exclog.Critical, "")
+		return nil, err
+	}
+
+	var result []*kglb_pb.IpvsSyncDaemon
+	for _, payload := range payloads {
+		attrs, err := parseNetlinkAttrs(payload)
+		if err != nil {
+			return nil, err
+		}
+		daemon, err := tokglbIpvsSyncDaemon(attrs[ipvsCmdAttrDaemon])
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, daemon)
+	}
+	return result, nil
+}
+
+// Returns new request of IPVS generic netlink family.
+func (m *IpvsNetlink) ipvsRequest(cmd uint8, flags int) *genlRequest {
+	return &genlRequest{
+		family:  m.familyId,
+		cmd:     cmd,
+		version: ipvsGenlVersion,
+		flags:   flags,
+	}
+}
+
+// Performs service command.
+func (m *IpvsNetlink) serviceCmd(
+	cmd uint8,
+	service *kglb_pb.IpvsService,
+	full bool) error {
+
+	serviceAttr, err := ipvsServiceAttr(service, full)
+	if err != nil {
+		return err
+	}
+	req := m.ipvsRequest(cmd, unix.NLM_F_ACK)
+	req.attrs = append(req.attrs, serviceAttr)
+	return m.do([]*genlRequest{req})
+}
+
+// Performs destination command for every destination in batches.
+func (m *IpvsNetlink) destCmd(
+	cmd uint8,
+	service *kglb_pb.IpvsService,
+	dsts []*kglb_pb.UpstreamState,
+	full bool) error {
+
+	if len(dsts) == 0 {
+		return nil
+	}
+
+	serviceAttr, err := ipvsServiceAttr(service, false)
+	if err != nil {
+		return err
+	}
+
+	reqs := make([]*genlRequest, 0, len(dsts))
+	for _, dst := range dsts {
+		dstAttr, err := ipvsDestAttr(dst, full)
+		if err != nil {
+			return err
+		}
+		req := m.ipvsRequest(cmd, unix.NLM_F_ACK)
+		req.attrs = append(req.attrs, serviceAttr, dstAttr)
+		reqs = append(reqs, req)
+	}
+
+	responses, err := m.execute(reqs)
+	if err != nil {
+		return err
+	}
+	// kernel processes all requests of the batch independently, so errors of
+	// all destinations are reported.
+	var failed []string
+	var firstErr error
+	for i, response := range responses {
+		if err := ipvsError(cmd, response.err); err != nil {
+			failed = append(failed, common.KglbAddrToNetIp(dsts[i].GetAddress()).String())
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	if firstErr != nil {
+		return errors.Wrapf(
+			firstErr,
+			"%d of %d destinations failed %v: ",
+			len(failed),
+			len(dsts),
+			failed)
+	}
+	return nil
+}
+
+// Performs requests and returns first error.
+func (m *IpvsNetlink) do(reqs []*genlRequest) error {
+	responses, err := m.execute(reqs)
+	if err != nil {
+		return err
+	}
+	for i, response := range responses {
+		if err := ipvsError(reqs[i].cmd, response.err); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Performs dump request and returns payloads of all received messages.
+func (m *IpvsNetlink) dump(req *genlRequest) ([][]byte, error) {
+	responses, err := m.execute([]*genlRequest{req})
+	if err != nil {
+		return nil, err
+	}
+	if err := ipvsError(req.cmd, responses[0].err); err != nil {
+		return nil, err
+	}
+	return responses[0].payloads, nil
+}
+
+// Sends requests in batches and waits for completion of every of them.
+// Returned error means failure of the transport, errors of particular requests
+// are returned in responses.
+func (m *IpvsNetlink) execute(reqs []*genlRequest) ([]genlResponse, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	responses := make([]genlResponse, len(reqs))
+	for start := 0; start < len(reqs); start += ipvsNetlinkBatchSize {
+		end := start + ipvsNetlinkBatchSize
+		if end > len(reqs) {
+			end = len(reqs)
+		}
+		if err := m.executeBatch(reqs[start:end], responses[start:end]); err != nil {
+			return nil, err
+		}
+	}
+	return responses, nil
+}
+
+// Sends requests by single write and waits for completion of every of them.
+// Should be called under m.mu.
+func (m *IpvsNetlink) executeBatch(reqs []*genlRequest, responses []genlResponse) error {
+	// index of pending requests by sequence number.
+	pending := make(map[uint32]int, len(reqs))
+	var buf []byte
+	for i, req := range reqs {
+		m.seq++
+		pending[m.seq] = i
+		buf = append(buf, req.serialize(m.seq)...)
+	}
+
+	if err := m.transport.Send(buf); err != nil {
+		return errors.Wrap(err, "fails to send netlink messages: ")
+	}
+
+	native := nl.NativeEndian()
+	for len(pending) > 0 {
+		msgs, err := m.transport.Receive()
+		if err != nil {
+			return errors.Wrap(err, "fails to receive netlink messages: ")
+		}
+
+		for _, msg := range msgs {
+			i, ok := pending[msg.Header.Seq]
+			if !ok {
+				// late response of previously failed exchange.
+				continue
+			}
+
+			switch msg.Header.Type {
+			case unix.NLMSG_ERROR, unix.NLMSG_DONE:
+				// both messages complete the request, zero errno of error
+				// message means ack.
+				if len(msg.Data) >= 4 {
+					if errno := -int32(native.Uint32(msg.Data[:4])); errno > 0 {
+						responses[i].err = syscall.Errno(errno)
+					}
+				} else if msg.Header.Type == unix.NLMSG_ERROR {
+					return errors.Newf("malformed netlink error message: %v", msg)
+				}
+				delete(pending, msg.Header.Seq)
+			default:
+				if len(msg.Data) < nl.SizeofGenlmsg {
+					return errors.Newf("malformed generic netlink message: %v", msg)
+				}
+				responses[i].payloads = append(
+					responses[i].payloads,
+					msg.Data[nl.SizeofGenlmsg:])
+			}
+		}
+	}
+
+	return nil
+}
+
+// Converts errno returned by IPVS command into descriptive error.
+func ipvsError(cmd uint8, err error) error {
+	if err == nil {
+		return nil
+	}
+	errno, ok := err.(syscall.Errno)
+	if !ok {
+		return err
+	}
+	return errors.Wrapf(
+		errno,
+		"IPVS %s failed, %s: ",
+		ipvsCmdName(cmd),
+		ipvsErrorDescription(cmd, errno))
+}
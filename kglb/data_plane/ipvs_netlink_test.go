@@ -0,0 +1,599 @@
+package data_plane
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"syscall"
+	"time"
+
+	"github.com/vishvananda/netlink/nl"
+	. "gopkg.in/check.v1"
+
+	kglb_pb "dropbox/proto/kglb"
+	. "godropbox/gocheck2"
+)
+
+// Recorded netlink exchange: expected request and responses returned by
+// subsequent Receive calls (hex encoded).
+type netlinkExchange struct {
+	request   string
+	responses []string
+}
+
+// Transport replaying recorded netlink exchanges.
+type fakeNetlinkTransport struct {
+	c         *C
+	exchanges []netlinkExchange
+	responses []string
+	closed    bool
+}
+
+func (t *fakeNetlinkTransport) Send(buf []byte) error {
+	t.c.Assert(t.exchanges, Not(HasLen), 0)
+	t.c.Assert(hex.EncodeToString(buf), Equals, t.exchanges[0].request)
+	t.responses = t.exchanges[0].responses
+	t.exchanges = t.exchanges[1:]
+	return nil
+}
+
+func (t *fakeNetlinkTransport) Receive() ([]syscall.NetlinkMessage, error) {
+	if len(t.responses) == 0 {
+		return nil, syscall.EAGAIN
+	}
+	buf, err := hex.DecodeString(t.responses[0])
+	t.c.Assert(err, NoErr)
+	t.responses = t.responses[1:]
+	return syscall.ParseNetlinkMessage(buf)
+}
+
+func (t *fakeNetlinkTransport) Close() error {
+	t.closed = true
+	return nil
+}
+
+// Transport acknowledging every received request.
+type ackingNetlinkTransport struct {
+	// number of requests of every Send call.
+	batches []int
+	acks    []byte
+}
+
+func (t *ackingNetlinkTransport) Send(buf []byte) error {
+	msgs, err := syscall.ParseNetlinkMessage(buf)
+	if err != nil {
+		return err
+	}
+	t.batches = append(t.batches, len(msgs))
+	for _, msg := range msgs {
+		ack := make([]byte, 36)
+		binary.LittleEndian.PutUint32(ack[0:4], 36)
+		binary.LittleEndian.PutUint16(ack[4:6], syscall.NLMSG_ERROR)
+		binary.LittleEndian.PutUint32(ack[8:12], msg.Header.Seq)
+		t.acks = append(t.acks, ack...)
+	}
+	return nil
+}
+
+func (t *ackingNetlinkTransport) Receive() ([]syscall.NetlinkMessage, error) {
+	acks := t.acks
+	t.acks = nil
+	return syscall.ParseNetlinkMessage(acks)
+}
+
+func (t *ackingNetlinkTransport) Close() error {
+	return nil
+}
+
+type IpvsNetlinkSuite struct{}
+
+var _ = Suite(&IpvsNetlinkSuite{})
+
+func (s *IpvsNetlinkSuite) SetUpTest(c *C) {
+	// messages are recorded on little-endian host.
+	if nl.NativeEndian() != binary.LittleEndian {
+		c.Skip("big-endian host")
+	}
+}
+
+// Returns module with resolved family followed by provided exchanges.
+func newTestIpvsNetlink(c *C, exchanges ...netlinkExchange) (*IpvsNetlink, *fakeNetlinkTransport) {
+	transport := &fakeNetlinkTransport{
+		c: c,
+		exchanges: append([]netlinkExchange{{
+			request:   getFamilyRequest,
+			responses: []string{newFamilyResponse},
+		}}, exchanges...),
+	}
+	resolver, err := NewCacheResolver()
+	c.Assert(err, NoErr)
+	resolver.UpdateCache(&kglb_pb.DataPlaneState{
+		Balancers: []*kglb_pb.BalancerState{{
+			Name:      "test",
+			LbService: &kglb_pb.LoadBalancerService{Service: &kglb_pb.LoadBalancerService_IpvsService{IpvsService: testIpvsNetlinkService()}},
+			Upstreams: []*kglb_pb.UpstreamState{{
+				Hostname: "host2",
+				Address:  &kglb_pb.IP{Address: &kglb_pb.IP_Ipv4{Ipv4: "10.0.0.2"}},
+				Port:     443,
+			}},
+		}},
+	})
+
+	m, err := newIpvsNetlink(resolver, transport)
+	c.Assert(err, NoErr)
+	c.Assert(m.familyId, Equals, uint16(0x1d))
+	return m, transport
+}
+
+func testIpvsNetlinkService() *kglb_pb.IpvsService {
+	return &kglb_pb.IpvsService{
+		Attributes: &kglb_pb.IpvsService_TcpAttributes{
+			TcpAttributes: &kglb_pb.IpvsTcpAttributes{
+				Address: &kglb_pb.IP{Address: &kglb_pb.IP_Ipv4{Ipv4: "10.0.0.1"}},
+				Port:    443,
+			}},
+		Scheduler:           kglb_pb.IpvsService_WRR,
+		Flags:               []kglb_pb.IpvsService_Flag{kglb_pb.IpvsService_PERSISTENT},
+		PersistenceTimeoutS: 300,
+		PersistenceNetmask:  24,
+		PeName:              "sip",
+	}
+}
+
+func (s *IpvsNetlinkSuite) TestFamilyNotFound(c *C) {
+	transport := &fakeNetlinkTransport{
+		c: c,
+		exchanges: []netlinkExchange{{
+			request:   getFamilyRequest,
+			responses: []string{familyNotFoundResponse},
+		}},
+	}
+	_, err := newIpvsNetlink(nil, transport)
+	c.Assert(err, ErrorMatches, "(?s).*ip_vs module is not loaded.*")
+}
+
+func (s *IpvsNetlinkSuite) TestAddService(c *C) {
+	m, transport := newTestIpvsNetlink(c, netlinkExchange{
+		request:   newServiceRequest,
+		responses: []string{newServiceResponse},
+	})
+	c.Assert(m.AddService(testIpvsNetlinkService()), NoErr)
+	c.Assert(transport.exchanges, HasLen, 0)
+
+	// already existent service.
+	m, _ = newTestIpvsNetlink(c, netlinkExchange{
+		request:   newServiceRequest,
+		responses: []string{newServiceExistsResponse},
+	})
+	err := m.AddService(testIpvsNetlinkService())
+	c.Assert(err, ErrorMatches, "(?s).*NEW_SERVICE failed, service already exists.*")
+}
+
+func (s *IpvsNetlinkSuite) TestDeleteService(c *C) {
+	m, _ := newTestIpvsNetlink(c, netlinkExchange{
+		request:   delServiceRequest,
+		responses: []string{delServiceNotFoundResponse},
+	})
+	err := m.DeleteService(testIpvsNetlinkService())
+	c.Assert(err, ErrorMatches, "(?s).*DEL_SERVICE failed, service doesn't exist.*")
+}
+
+func (s *IpvsNetlinkSuite) TestListServices(c *C) {
+	m, _ := newTestIpvsNetlink(c, netlinkExchange{
+		request: getServiceRequest,
+		// dump is completed by separate message.
+		responses: []string{getServiceResponse, getServiceDoneResponse},
+	})
+
+	services, stats, err := m.ListServices()
+	c.Assert(err, NoErr)
+	c.Assert(services, DeepEqualsPretty, []*kglb_pb.IpvsService{
+		testIpvsNetlinkService(),
+		{
+			Attributes: &kglb_pb.IpvsService_FwmarkAttributes{
+				FwmarkAttributes: &kglb_pb.IpvsFwmarkAttributes{
+					AddressFamily: kglb_pb.AddressFamily_AF_INET6,
+					Fwmark:        10000,
+				}},
+			Scheduler:          kglb_pb.IpvsService_RR,
+			Flags:              []kglb_pb.IpvsService_Flag{},
+			PersistenceNetmask: 128,
+		},
+	})
+	c.Assert(stats, DeepEqualsPretty, []*kglb_pb.Stats{
+		{
+			ConnectionsCount: 10,
+			PacketsInCount:   20,
+			BytesInCount:     1000,
+			ConnectionsRate:  1,
+		},
+		// 32-bit stats only.
+		{ConnectionsCount: 5},
+	})
+}
+
+func (s *IpvsNetlinkSuite) TestAddRealServers(c *C) {
+	m, _ := newTestIpvsNetlink(c, netlinkExchange{
+		request:   newDestRequest,
+		responses: []string{newDestResponse},
+	})
+
+	// both destinations are sent by single write and the second one fails.
+	err := m.AddRealServers(testIpvsNetlinkService(), []*kglb_pb.UpstreamState{
+		{
+			Address:       &kglb_pb.IP{Address: &kglb_pb.IP_Ipv4{Ipv4: "10.0.0.2"}},
+			Port:          443,
+			Weight:        100,
+			ForwardMethod: kglb_pb.ForwardMethods_TUNNEL,
+			UThreshold:    1000,
+			LThreshold:    500,
+		},
+		{
+			Address:       &kglb_pb.IP{Address: &kglb_pb.IP_Ipv4{Ipv4: "10.0.0.3"}},
+			Port:          443,
+			Weight:        50,
+			ForwardMethod: kglb_pb.ForwardMethods_TUNNEL,
+		},
+	})
+	c.Assert(err, ErrorMatches, `(?s).*1 of 2 destinations failed \[10.0.0.3\].*destination already exists.*`)
+}
+
+func (s *IpvsNetlinkSuite) TestDeleteRealServers(c *C) {
+	m, _ := newTestIpvsNetlink(c, netlinkExchange{
+		request:   delDestRequest,
+		responses: []string{delDestNotFoundResponse},
+	})
+
+	err := m.DeleteRealServers(testIpvsNetlinkService(), []*kglb_pb.UpstreamState{{
+		Address:       &kglb_pb.IP{Address: &kglb_pb.IP_Ipv4{Ipv4: "10.0.0.2"}},
+		Port:          443,
+		Weight:        100,
+		ForwardMethod: kglb_pb.ForwardMethods_TUNNEL,
+	}})
+	c.Assert(err, ErrorMatches, "(?s).*DEL_DEST failed, destination doesn't exist.*")
+}
+
+func (s *IpvsNetlinkSuite) TestGetRealServers(c *C) {
+	m, _ := newTestIpvsNetlink(c, netlinkExchange{
+		request:   getDestRequest,
+		responses: []string{getDestResponse},
+	})
+
+	reals, stats, err := m.GetRealServers(testIpvsNetlinkService())
+	c.Assert(err, NoErr)
+	c.Assert(reals, DeepEqualsPretty, []*kglb_pb.UpstreamState{{
+		Address:       &kglb_pb.IP{Address: &kglb_pb.IP_Ipv4{Ipv4: "10.0.0.2"}},
+		Port:          443,
+		Hostname:      "host2",
+		Weight:        100,
+		ForwardMethod: kglb_pb.ForwardMethods_TUNNEL,
+		UThreshold:    1000,
+		LThreshold:    500,
+	}})
+	c.Assert(stats, DeepEqualsPretty, []*kglb_pb.Stats{{
+		ConnectionsCount:  7,
+		ActiveConnsCount:  3,
+		InactConnsCount:   4,
+		PersistConnsCount: 5,
+	}})
+}
+
+func (s *IpvsNetlinkSuite) TestTimeouts(c *C) {
+	m, _ := newTestIpvsNetlink(c, netlinkExchange{
+		request:   getConfigRequest,
+		responses: []string{getConfigResponse},
+	})
+	timeouts, err := m.Timeouts()
+	c.Assert(err, NoErr)
+	c.Assert(timeouts, Equals, IpvsTimeouts{
+		Tcp:    15 * time.Minute,
+		TcpFin: 2 * time.Minute,
+		Udp:    5 * time.Minute,
+	})
+
+	m, _ = newTestIpvsNetlink(c, netlinkExchange{
+		request:   setConfigRequest,
+		responses: []string{setConfigResponse},
+	})
+	c.Assert(m.SetTimeouts(IpvsTimeouts{
+		Tcp: 10 * time.Minute,
+		Udp: time.Minute,
+	}), NoErr)
+}
+
+func (s *IpvsNetlinkSuite) TestSyncDaemons(c *C) {
+	m, _ := newTestIpvsNetlink(c, netlinkExchange{
+		request:   newDaemonRequest,
+		responses: []string{newDaemonExistsResponse},
+	})
+	err := m.StartSyncDaemon(&kglb_pb.IpvsSyncDaemon{
+		Role:       kglb_pb.IpvsSyncDaemon_MASTER,
+		Interface:  "eth0",
+		SyncId:     10,
+		McastGroup: &kglb_pb.IP{Address: &kglb_pb.IP_Ipv4{Ipv4: "224.0.0.82"}},
+		McastPort:  8849,
+		McastTtl:   2,
+		SyncMaxlen: 1400,
+	})
+	c.Assert(err, ErrorMatches, "(?s).*sync daemon already runs.*")
+
+	m, _ = newTestIpvsNetlink(c, netlinkExchange{
+		request:   delDaemonRequest,
+		responses: []string{delDaemonResponse},
+	})
+	c.Assert(m.StopSyncDaemon(kglb_pb.IpvsSyncDaemon_BACKUP), NoErr)
+
+	m, _ = newTestIpvsNetlink(c, netlinkExchange{
+		request:   getDaemonRequest,
+		responses: []string{getDaemonResponse},
+	})
+	daemons, err := m.ListSyncDaemons()
+	c.Assert(err, NoErr)
+	c.Assert(daemons, DeepEqualsPretty, []*kglb_pb.IpvsSyncDaemon{
+		{
+			Role:       kglb_pb.IpvsSyncDaemon_MASTER,
+			Interface:  "eth0",
+			SyncId:     10,
+			McastGroup: &kglb_pb.IP{Address: &kglb_pb.IP_Ipv4{Ipv4: "224.0.0.81"}},
+			McastPort:  8848,
+			McastTtl:   1,
+			SyncMaxlen: 1472,
+		},
+		{
+			Role:       kglb_pb.IpvsSyncDaemon_BACKUP,
+			Interface:  "eth1",
+			SyncId:     11,
+			McastGroup: &kglb_pb.IP{Address: &kglb_pb.IP_Ipv6{Ipv6: "ff02::81"}},
+			McastPort:  8849,
+			McastTtl:   1,
+			SyncMaxlen: 1452,
+		},
+	})
+}
+
+func (s *IpvsNetlinkSuite) TestBatches(c *C) {
+	transport := &ackingNetlinkTransport{}
+	m := &IpvsNetlink{transport: transport, familyId: 0x1d}
+
+	var dsts []*kglb_pb.UpstreamState
+	for i := 0; i < ipvsNetlinkBatchSize+10; i++ {
+		dsts = append(dsts, &kglb_pb.UpstreamState{
+			Address:       &kglb_pb.IP{Address: &kglb_pb.IP_Ipv6{Ipv6: "fc00::1"}},
+			Port:          uint32(1000 + i),
+			Weight:        100,
+			ForwardMethod: kglb_pb.ForwardMethods_TUNNEL,
+		})
+	}
+	c.Assert(m.UpdateRealServers(testIpvsNetlinkService(), dsts), NoErr)
+	c.Assert(transport.batches, DeepEqualsPretty, []int{ipvsNetlinkBatchSize, 10})
+
+	// nothing is sent without destinations.
+	c.Assert(m.DeleteRealServers(testIpvsNetlinkService(), nil), NoErr)
+	c.Assert(transport.batches, HasLen, 2)
+}
+
+func (s *IpvsNetlinkSuite) TestTunnelEncapsulation(c *C) {
+	m, _ := newTestIpvsNetlink(c,
+		netlinkExchange{
+			request:   setDestGueRequest,
+			responses: []string{setDestGueResponse},
+		})
+	c.Assert(m.UpdateRealServers(testIpvsNetlinkService(), []*kglb_pb.UpstreamState{{
+		Address:       &kglb_pb.IP{Address: &kglb_pb.IP_Ipv4{Ipv4: "10.0.0.2"}},
+		Port:          443,
+		Weight:        100,
+		ForwardMethod: kglb_pb.ForwardMethods_TUNNEL,
+		Tunnel: &kglb_pb.TunnelEncapsulation{
+			Type:    kglb_pb.TunnelEncapsulation_GUE,
+			Port:    6080,
+			Csum:    true,
+			Remcsum: true,
+		},
+	}}), NoErr)
+
+	m, _ = newTestIpvsNetlink(c, netlinkExchange{
+		request:   getDestRequest,
+		responses: []string{getDestTunnelResponse},
+	})
+	reals, _, err := m.GetRealServers(testIpvsNetlinkService())
+	c.Assert(err, NoErr)
+	c.Assert(reals, DeepEqualsPretty, []*kglb_pb.UpstreamState{
+		{
+			Address:       &kglb_pb.IP{Address: &kglb_pb.IP_Ipv4{Ipv4: "10.0.0.2"}},
+			Port:          443,
+			Hostname:      "host2",
+			Weight:        100,
+			ForwardMethod: kglb_pb.ForwardMethods_TUNNEL,
+			Tunnel: &kglb_pb.TunnelEncapsulation{
+				Type: kglb_pb.TunnelEncapsulation_GRE,
+				Csum: true,
+			},
+		},
+		{
+			Address:       &kglb_pb.IP{Address: &kglb_pb.IP_Ipv4{Ipv4: "10.0.0.3"}},
+			Port:          443,
+			Hostname:      defaultHostname,
+			Weight:        50,
+			ForwardMethod: kglb_pb.ForwardMethods_DR,
+		},
+	})
+}
+
+// Netlink messages recorded on little-endian host, IPVS family id is 0x1d.
+const (
+	getFamilyRequest = "" +
+		"2000000010000500010000000000000003020000090002004950565300000000"
+
+	newFamilyResponse = "" +
+		"4000000010000000010000000000000001020000090002004950565300000000" +
+		"060001001d000000080003000100000008000400000000000800050011000000" +
+		"2400000002000001010000000000000000000000200000001000050001000000" +
+		"00000000"
+
+	familyNotFoundResponse = "" +
+		"24000000020000000100000000000000feffffff200000001000050001000000" +
+		"00000000"
+
+	newServiceRequest = "" +
+		"640000001d000500020000000000000001010000500001000600010002000000" +
+		"0600020006000000080003000a0000010600040001bb00000800060077727200" +
+		"0c00070001000000ffffffff080008002c01000008000900ffffff0008000b00" +
+		"73697000"
+
+	newServiceResponse = "" +
+		"2400000002000001020000000000000000000000640000001d00050002000000" +
+		"00000000"
+
+	newServiceExistsResponse = "" +
+		"24000000020000000200000000000000efffffff640000001d00050002000000" +
+		"00000000"
+
+	delServiceRequest = "" +
+		"380000001d000500020000000000000003010000240001000600010002000000" +
+		"0600020006000000080003000a0000010600040001bb0000"
+
+	delServiceNotFoundResponse = "" +
+		"24000000020000000200000000000000fdffffff380000001d00050002000000" +
+		"00000000"
+
+	getServiceRequest = "" +
+		"140000001d000103020000000000000004010000"
+
+	getServiceResponse = "" +
+		"480100001d000200020000000000000001010000340101800600010002000000" +
+		"0600020006000000140003000a00000100000000000000000000000006000400" +
+		"01bb000008000600777272000c00070003000000ffffffff080008002c010000" +
+		"08000900ffffff005c000a80080001000a000000080002001400000008000300" +
+		"000000000c000400e8030000000000000c000500000000000000000008000600" +
+		"0100000008000700000000000800080000000000080009000000000008000a00" +
+		"0000000008000b00736970007c000c800c0001000a000000000000000c000200" +
+		"14000000000000000c00030000000000000000000c000400e803000000000000" +
+		"0c00050000000000000000000c00060001000000000000000c00070000000000" +
+		"000000000c00080000000000000000000c00090000000000000000000c000a00" +
+		"0000000000000000a80000001d00020002000000000000000101000094000180" +
+		"060001000a000000080005001027000007000600727200000c00070002000000" +
+		"ffffffff080008000000000008000900800000005c000a800800010005000000" +
+		"080002000000000008000300000000000c00040000000000000000000c000500" +
+		"0000000000000000080006000000000008000700000000000800080000000000" +
+		"080009000000000008000a0000000000"
+
+	getServiceDoneResponse = "" +
+		"1400000003000200020000000000000000000000"
+
+	newDestRequest = "" +
+		"740000001d000500020000000000000005010000240001000600010002000000" +
+		"0600020006000000080003000a0000010600040001bb00003c00020006000b00" +
+		"02000000080001000a0000020600020001bb0000080003000200000008000400" +
+		"6400000008000500e803000008000600f4010000740000001d00050003000000" +
+		"0000000005010000240001000600010002000000060002000600000008000300" +
+		"0a0000010600040001bb00003c00020006000b0002000000080001000a000003" +
+		"0600020001bb0000080003000200000008000400320000000800050000000000" +
+		"0800060000000000"
+
+	newDestResponse = "" +
+		"2400000002000001020000000000000000000000740000001d00050002000000" +
+		"0000000024000000020000000300000000000000efffffff740000001d000500" +
+		"0300000000000000"
+
+	delDestRequest = "" +
+		"540000001d000500020000000000000007010000240001000600010002000000" +
+		"0600020006000000080003000a0000010600040001bb00001c00020006000b00" +
+		"02000000080001000a0000020600020001bb0000"
+
+	delDestNotFoundResponse = "" +
+		"24000000020000000200000000000000feffffff540000001d00050002000000" +
+		"00000000"
+
+	getDestRequest = "" +
+		"380000001d000103020000000000000008010000240001000600010002000000" +
+		"0600020006000000080003000a0000010600040001bb0000"
+
+	getDestResponse = "" +
+		"640100001d00020002000000000000000501000050010280140001000a000002" +
+		"0000000000000000000000000600020001bb0000080003000200000008000400" +
+		"6400000008000500e803000008000600f4010000080007000300000008000800" +
+		"0400000008000900050000005c000a8008000100070000000800020000000000" +
+		"08000300000000000c00040000000000000000000c0005000000000000000000" +
+		"0800060000000000080007000000000008000800000000000800090000000000" +
+		"08000a000000000006000b00020000007c000c800c0001000700000000000000" +
+		"0c00020000000000000000000c00030000000000000000000c00040000000000" +
+		"000000000c00050000000000000000000c00060000000000000000000c000700" +
+		"00000000000000000c00080000000000000000000c0009000000000000000000" +
+		"0c000a00000000000000000005000d000000000006000e000000000006000f00" +
+		"000000001400000003000200020000000000000000000000"
+
+	getConfigRequest = "" +
+		"140000001d00050002000000000000000d010000"
+
+	getConfigResponse = "" +
+		"2c0000001d00000002000000000000000c010000080004008403000008000500" +
+		"78000000080006002c0100002400000002000001020000000000000000000000" +
+		"140000001d0005000200000000000000"
+
+	setConfigRequest = "" +
+		"2c0000001d00050002000000000000000c010000080004005802000008000500" +
+		"00000000080006003c000000"
+
+	setConfigResponse = "" +
+		"24000000020000010200000000000000000000002c0000001d00050002000000" +
+		"00000000"
+
+	setDestGueRequest = "" +
+		"8c0000001d000500020000000000000006010000240001000600010002000000" +
+		"0600020006000000080003000a0000010600040001bb00005400020006000b00" +
+		"02000000080001000a0000020600020001bb0000080003000200000008000400" +
+		"640000000800050000000000080006000000000005000d000100000006000e00" +
+		"17c0000006000f0003000000"
+
+	setDestGueResponse = "" +
+		"24000000020000010200000000000000000000008c0000001d00050002000000" +
+		"00000000"
+
+	getDestTunnelResponse = "" +
+		"640100001d00020002000000000000000501000050010280140001000a000002" +
+		"0000000000000000000000000600020001bb0000080003000200000008000400" +
+		"6400000008000500000000000800060000000000080007000000000008000800" +
+		"0000000008000900000000005c000a8008000100000000000800020000000000" +
+		"08000300000000000c00040000000000000000000c0005000000000000000000" +
+		"0800060000000000080007000000000008000800000000000800090000000000" +
+		"08000a000000000006000b00020000007c000c800c0001000000000000000000" +
+		"0c00020000000000000000000c00030000000000000000000c00040000000000" +
+		"000000000c00050000000000000000000c00060000000000000000000c000700" +
+		"00000000000000000c00080000000000000000000c0009000000000000000000" +
+		"0c000a00000000000000000005000d000200000006000e000000000006000f00" +
+		"01000000d00000001d000200020000000000000005010000bc00028014000100" +
+		"0a0000030000000000000000000000000600020001bb00000800030003000000" +
+		"0800040032000000080005000000000008000600000000000800070000000000" +
+		"080008000000000008000900000000005c000a80080001000000000008000200" +
+		"0000000008000300000000000c00040000000000000000000c00050000000000" +
+		"0000000008000600000000000800070000000000080008000000000008000900" +
+		"0000000008000a000000000006000b0002000000140000000300020002000000" +
+		"0000000000000000"
+
+	newDaemonRequest = "" +
+		"540000001d000500020000000000000009010000400003000800010001000000" +
+		"090002006574683000000000080003000a000000060004007805000008000500" +
+		"e000005206000700912200000500080002000000"
+
+	newDaemonExistsResponse = "" +
+		"24000000020000000200000000000000efffffff540000001d00050002000000" +
+		"00000000"
+
+	delDaemonRequest = "" +
+		"200000001d00050002000000000000000a0100000c0003000800010002000000"
+
+	delDaemonResponse = "" +
+		"2400000002000001020000000000000000000000200000001d00050002000000" +
+		"00000000"
+
+	getDaemonRequest = "" +
+		"140000001d00010302000000000000000b010000"
+
+	getDaemonResponse = "" +
+		"540000001d000200020000000000000009010000400003800800010001000000" +
+		"090002006574683000000000080003000a00000006000400c005000006000700" +
+		"90220000050008000100000008000500e0000051600000001d00020002000000" +
+		"00000000090100004c0003800800010002000000090002006574683100000000" +
+		"080003000b00000006000400ac05000006000700912200000500080001000000" +
+		"14000600ff020000000000000000000000000081140000000300020002000000" +
+		"0000000000000000"
+)
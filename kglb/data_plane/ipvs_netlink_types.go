@@ -0,0 +1,784 @@
+package data_plane
+
+import (
+	"encoding/binary"
+	"net"
+	"syscall"
+
+	"github.com/mqliang/libipvs"
+	"github.com/vishvananda/netlink/nl"
+
+	"dropbox/kglb/common"
+	kglb_pb "dropbox/proto/kglb"
+	"godropbox/errors"
+)
+
+// IPVS generic netlink family, see include/uapi/linux/ip_vs.h.
+const (
+	ipvsGenlName    = "IPVS"
+	ipvsGenlVersion = 1
+)
+
+// IPVS generic netlink commands.
+const (
+	ipvsCmdNewService = 1
+	ipvsCmdSetService = 2
+	ipvsCmdDelService = 3
+	ipvsCmdGetService = 4
+	ipvsCmdNewDest    = 5
+	ipvsCmdSetDest    = 6
+	ipvsCmdDelDest    = 7
+	ipvsCmdGetDest    = 8
+	ipvsCmdNewDaemon  = 9
+	ipvsCmdDelDaemon  = 10
+	ipvsCmdGetDaemon  = 11
+	ipvsCmdSetConfig  = 12
+	ipvsCmdGetConfig  = 13
+)
+
+// Attributes of the first level of IPVS commands.
+const (
+	ipvsCmdAttrService       = 1
+	ipvsCmdAttrDest          = 2
+	ipvsCmdAttrDaemon        = 3
+	ipvsCmdAttrTimeoutTcp    = 4
+	ipvsCmdAttrTimeoutTcpFin = 5
+	ipvsCmdAttrTimeoutUdp    = 6
+)
+
+// Attributes of IPVS service nested into ipvsCmdAttrService.
+const (
+	ipvsSvcAttrAf        = 1
+	ipvsSvcAttrProtocol  = 2
+	ipvsSvcAttrAddr      = 3
+	ipvsSvcAttrPort      = 4
+	ipvsSvcAttrFwmark    = 5
+	ipvsSvcAttrSchedName = 6
+	ipvsSvcAttrFlags     = 7
+	ipvsSvcAttrTimeout   = 8
+	ipvsSvcAttrNetmask   = 9
+	ipvsSvcAttrStats     = 10
+	ipvsSvcAttrPeName    = 11
+	ipvsSvcAttrStats64   = 12
+)
+
+// Attributes of IPVS destination nested into ipvsCmdAttrDest.
+const (
+	ipvsDestAttrAddr         = 1
+	ipvsDestAttrPort         = 2
+	ipvsDestAttrFwdMethod    = 3
+	ipvsDestAttrWeight       = 4
+	ipvsDestAttrUThresh      = 5
+	ipvsDestAttrLThresh      = 6
+	ipvsDestAttrActiveConns  = 7
+	ipvsDestAttrInactConns   = 8
+	ipvsDestAttrPersistConns = 9
+	ipvsDestAttrStats        = 10
+	ipvsDestAttrAddrFamily   = 11
+	ipvsDestAttrStats64      = 12
+	ipvsDestAttrTunType      = 13
+	ipvsDestAttrTunPort      = 14
+	ipvsDestAttrTunFlags     = 15
+)
+
+// Attributes of sync daemon nested into ipvsCmdAttrDaemon.
+const (
+	ipvsDaemonAttrState       = 1
+	ipvsDaemonAttrMcastIfn    = 2
+	ipvsDaemonAttrSyncId      = 3
+	ipvsDaemonAttrSyncMaxlen  = 4
+	ipvsDaemonAttrMcastGroup  = 5
+	ipvsDaemonAttrMcastGroup6 = 6
+	ipvsDaemonAttrMcastPort   = 7
+	ipvsDaemonAttrMcastTtl    = 8
+)
+
+// States of sync daemon in ipvsDaemonAttrState attribute.
+const (
+	ipvsStateMaster = 1
+	ipvsStateBackup = 2
+)
+
+// Attributes of service and destination stats nested into ipvsSvcAttrStats
+// and ipvsDestAttrStats. Counters are 64-bit in *Stats64 attributes.
+const (
+	ipvsStatsAttrConns    = 1
+	ipvsStatsAttrInPkts   = 2
+	ipvsStatsAttrOutPkts  = 3
+	ipvsStatsAttrInBytes  = 4
+	ipvsStatsAttrOutBytes = 5
+	ipvsStatsAttrCps      = 6
+	ipvsStatsAttrInPps    = 7
+	ipvsStatsAttrOutPps   = 8
+	ipvsStatsAttrInBps    = 9
+	ipvsStatsAttrOutBps   = 10
+)
+
+// Tunnel types of ipvsDestAttrTunType attribute.
+const (
+	ipvsTunnelTypeIpip = 0
+	ipvsTunnelTypeGue  = 1
+	ipvsTunnelTypeGre  = 2
+)
+
+// Flags of ipvsDestAttrTunFlags attribute.
+const (
+	ipvsTunnelEncapFlagCsum    = 1 << 0
+	ipvsTunnelEncapFlagRemcsum = 1 << 1
+)
+
+const (
+	// mask of forwarding method in ipvsDestAttrFwdMethod attribute.
+	ipvsConnFFwdMask = 0x0007
+
+	// mask of attribute type excluding NLA_F_NESTED and NLA_F_NET_BYTEORDER
+	// flags.
+	netlinkAttrTypeMask = 0x3fff
+)
+
+// Returns value of netlink attributes by attribute type.
+func parseNetlinkAttrs(b []byte) (map[uint16][]byte, error) {
+	attrs, err := nl.ParseRouteAttr(b)
+	if err != nil {
+		return nil, errors.Wrap(err, "fails to parse netlink attributes: ")
+	}
+
+	result := make(map[uint16][]byte, len(attrs))
+	for _, attr := range attrs {
+		result[attr.Attr.Type&netlinkAttrTypeMask] = attr.Value
+	}
+	return result, nil
+}
+
+// Returns uint16 value of netlink attribute in host byte order.
+func netlinkAttrUint16(attrs map[uint16][]byte, attrType uint16) (uint16, error) {
+	value, ok := attrs[attrType]
+	if !ok || len(value) < 2 {
+		return 0, errors.Newf("missed or malformed netlink attribute: %d", attrType)
+	}
+	return nl.NativeEndian().Uint16(value), nil
+}
+
+// Returns uint32 value of netlink attribute in host byte order.
+func netlinkAttrUint32(attrs map[uint16][]byte, attrType uint16) (uint32, error) {
+	value, ok := attrs[attrType]
+	if !ok || len(value) < 4 {
+		return 0, errors.Newf("missed or malformed netlink attribute: %d", attrType)
+	}
+	return nl.NativeEndian().Uint32(value), nil
+}
+
+// Returns value of optional netlink attribute in host byte order, zero value
+// is returned for missed attribute.
+func netlinkAttrUint(attrs map[uint16][]byte, attrType uint16) uint64 {
+	value := attrs[attrType]
+	native := nl.NativeEndian()
+	switch len(value) {
+	case 1:
+		return uint64(value[0])
+	case 2:
+		return uint64(native.Uint16(value))
+	case 4:
+		return uint64(native.Uint32(value))
+	case 8:
+		return native.Uint64(value)
+	default:
+		return 0
+	}
+}
+
+// Returns string value of netlink attribute.
+func netlinkAttrString(attrs map[uint16][]byte, attrType uint16) string {
+	value := attrs[attrType]
+	for i, b := range value {
+		if b == 0 {
+			return string(value[:i])
+		}
+	}
+	return string(value)
+}
+
+// Returns address encoded in netlink attribute by address family.
+func netlinkAttrAddr(
+	attrs map[uint16][]byte,
+	attrType uint16,
+	af uint16) (net.IP, error) {
+
+	value := attrs[attrType]
+	size := net.IPv6len
+	if af == syscall.AF_INET {
+		size = net.IPv4len
+	}
+	if len(value) < size {
+		return nil, errors.Newf("missed or malformed netlink attribute: %d", attrType)
+	}
+	return net.IP(append([]byte{}, value[:size]...)), nil
+}
+
+// Encodes address by address family.
+func ipvsAddr(ip net.IP) (uint16, []byte) {
+	if ip4 := ip.To4(); ip4 != nil {
+		return syscall.AF_INET, ip4
+	}
+	return syscall.AF_INET6, ip.To16()
+}
+
+// Encodes port in network byte order.
+func ipvsPort(port uint32) []byte {
+	b := make([]byte, 2)
+	binary.BigEndian.PutUint16(b, uint16(port))
+	return b
+}
+
+// Encodes attributes identifying IPVS service.
+func ipvsServiceIdAttrs(service *kglb_pb.IpvsService) ([]*nl.RtAttr, uint16, error) {
+	var protocol uint16
+	var address *kglb_pb.IP
+	var port uint32
+	switch attr := service.GetAttributes().(type) {
+	case *kglb_pb.IpvsService_TcpAttributes:
+		protocol = syscall.IPPROTO_TCP
+		address = attr.TcpAttributes.GetAddress()
+		port = attr.TcpAttributes.GetPort()
+	case *kglb_pb.IpvsService_UdpAttributes:
+		protocol = syscall.IPPROTO_UDP
+		address = attr.UdpAttributes.GetAddress()
+		port = attr.UdpAttributes.GetPort()
+	case *kglb_pb.IpvsService_FwmarkAttributes:
+		af := uint16(syscall.AF_INET)
+		if attr.FwmarkAttributes.GetAddressFamily() == kglb_pb.AddressFamily_AF_INET6 {
+			af = syscall.AF_INET6
+		}
+		return []*nl.RtAttr{
+			nl.NewRtAttr(ipvsSvcAttrAf, nl.Uint16Attr(af)),
+			nl.NewRtAttr(ipvsSvcAttrFwmark, nl.Uint32Attr(attr.FwmarkAttributes.GetFwmark())),
+		}, af, nil
+	default:
+		return nil, 0, errors.Newf("Unknown attributes type: %s", attr)
+	}
+
+	ip := common.KglbAddrToNetIp(address)
+	if ip == nil {
+		return nil, 0, errors.Newf("invalid service address: %+v", address)
+	}
+	af, addr := ipvsAddr(ip)
+	return []*nl.RtAttr{
+		nl.NewRtAttr(ipvsSvcAttrAf, nl.Uint16Attr(af)),
+		nl.NewRtAttr(ipvsSvcAttrProtocol, nl.Uint16Attr(protocol)),
+		nl.NewRtAttr(ipvsSvcAttrAddr, addr),
+		nl.NewRtAttr(ipvsSvcAttrPort, ipvsPort(port)),
+	}, af, nil
+}
+
+// Encodes IPVS service into nested ipvsCmdAttrService attribute. Only
+// identifying attributes are encoded when full is false.
+func ipvsServiceAttr(service *kglb_pb.IpvsService, full bool) (*nl.RtAttr, error) {
+	attrs, af, err := ipvsServiceIdAttrs(service)
+	if err != nil {
+		return nil, err
+	}
+
+	result := nl.NewRtAttr(ipvsCmdAttrService, nil)
+	for _, attr := range attrs {
+		result.AddChild(attr)
+	}
+	if !full {
+		return result, nil
+	}
+
+	schedName, err := tolibIPVSScheduler(service.GetScheduler())
+	if err != nil {
+		return nil, err
+	}
+	flags, err := toLibIpvsFlags(service.GetFlags())
+	if err != nil {
+		return nil, err
+	}
+	flagsAttr := append(nl.Uint32Attr(flags.Flags), nl.Uint32Attr(flags.Mask)...)
+
+	result.AddChild(nl.NewRtAttr(ipvsSvcAttrSchedName, nl.ZeroTerminated(schedName)))
+	result.AddChild(nl.NewRtAttr(ipvsSvcAttrFlags, flagsAttr))
+	result.AddChild(nl.NewRtAttr(
+		ipvsSvcAttrTimeout,
+		nl.Uint32Attr(service.GetPersistenceTimeoutS())))
+	result.AddChild(nl.NewRtAttr(
+		ipvsSvcAttrNetmask,
+		ipvsNetmask(af, service.GetPersistenceNetmask())))
+	if service.GetPeName() != "" {
+		result.AddChild(nl.NewRtAttr(ipvsSvcAttrPeName, nl.ZeroTerminated(service.GetPeName())))
+	}
+
+	return result, nil
+}
+
+// Encodes prefix length into netmask of IPVS service. Zero prefix length means
+// full address.
+func ipvsNetmask(af uint16, prefixLen uint32) []byte {
+	if af == syscall.AF_INET6 {
+		if prefixLen == 0 {
+			prefixLen = 128
+		}
+		return nl.Uint32Attr(prefixLen)
+	}
+
+	if prefixLen == 0 {
+		prefixLen = 32
+	}
+	// ipv4 netmask is in network byte order.
+	return net.CIDRMask(int(prefixLen), 32)
+}
+
+// Decodes netmask of IPVS service into prefix length.
+func ipvsPrefixLen(af uint16, netmask []byte) (uint32, error) {
+	if len(netmask) < 4 {
+		return 0, errors.Newf("malformed netmask: %v", netmask)
+	}
+
+	if af == syscall.AF_INET6 {
+		return nl.NativeEndian().Uint32(netmask), nil
+	}
+
+	mask := net.IPMask(netmask[:4])
+	prefixLen, bits := mask.Size()
+	if bits == 0 {
+		return 0, errors.Newf("non-canonical ipv4 netmask: %v", mask)
+	}
+	return uint32(prefixLen), nil
+}
+
+// Decodes IPVS service from attributes nested into ipvsCmdAttrService.
+func tokglbIpvsService(b []byte) (*kglb_pb.IpvsService, *kglb_pb.Stats, error) {
+	attrs, err := parseNetlinkAttrs(b)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	af, err := netlinkAttrUint16(attrs, ipvsSvcAttrAf)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	service := &kglb_pb.IpvsService{}
+	if fwmark := netlinkAttrUint(attrs, ipvsSvcAttrFwmark); fwmark != 0 {
+		family := kglb_pb.AddressFamily_AF_INET
+		if af == syscall.AF_INET6 {
+			family = kglb_pb.AddressFamily_AF_INET6
+		}
+		service.Attributes = &kglb_pb.IpvsService_FwmarkAttributes{
+			FwmarkAttributes: &kglb_pb.IpvsFwmarkAttributes{
+				AddressFamily: family,
+				Fwmark:        uint32(fwmark),
+			},
+		}
+	} else {
+		protocol, err := netlinkAttrUint16(attrs, ipvsSvcAttrProtocol)
+		if err != nil {
+			return nil, nil, err
+		}
+		ip, err := netlinkAttrAddr(attrs, ipvsSvcAttrAddr, af)
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(attrs[ipvsSvcAttrPort]) < 2 {
+			return nil, nil, errors.New("missed or malformed service port")
+		}
+		port := uint32(binary.BigEndian.Uint16(attrs[ipvsSvcAttrPort]))
+
+		switch protocol {
+		case syscall.IPPROTO_TCP:
+			service.Attributes = &kglb_pb.IpvsService_TcpAttributes{
+				TcpAttributes: &kglb_pb.IpvsTcpAttributes{
+					Address: common.NetIpToKglbAddr(ip),
+					Port:    port,
+				}}
+		case syscall.IPPROTO_UDP:
+			service.Attributes = &kglb_pb.IpvsService_UdpAttributes{
+				UdpAttributes: &kglb_pb.IpvsUdpAttributes{
+					Address: common.NetIpToKglbAddr(ip),
+					Port:    port,
+				}}
+		default:
+			return nil, nil, errors.Newf("unknown service protocol: %d", protocol)
+		}
+	}
+
+	if service.Scheduler, err = tokglbIPVSScheduler(
+		netlinkAttrString(attrs, ipvsSvcAttrSchedName)); err != nil {
+
+		return nil, nil, err
+	}
+
+	flags := attrs[ipvsSvcAttrFlags]
+	if len(flags) < 8 {
+		return nil, nil, errors.New("missed or malformed service flags")
+	}
+	if service.Flags, err = toKglbFlags(libipvs.Flags{
+		Flags: nl.NativeEndian().Uint32(flags[:4]),
+		Mask:  nl.NativeEndian().Uint32(flags[4:8]),
+	}); err != nil {
+		return nil, nil, err
+	}
+
+	service.PersistenceTimeoutS = uint32(netlinkAttrUint(attrs, ipvsSvcAttrTimeout))
+	if service.PersistenceNetmask, err = ipvsPrefixLen(
+		af,
+		attrs[ipvsSvcAttrNetmask]); err != nil {
+
+		return nil, nil, err
+	}
+	service.PeName = netlinkAttrString(attrs, ipvsSvcAttrPeName)
+
+	stats, err := tokglbIpvsStats(attrs, ipvsSvcAttrStats, ipvsSvcAttrStats64)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return service, stats, nil
+}
+
+// Encodes IPVS destination into nested ipvsCmdAttrDest attribute. Only
+// identifying attributes are encoded when full is false.
+func ipvsDestAttr(dst *kglb_pb.UpstreamState, full bool) (*nl.RtAttr, error) {
+	ip := common.KglbAddrToNetIp(dst.GetAddress())
+	if ip == nil {
+		return nil, errors.Newf("invalid destination address: %+v", dst.GetAddress())
+	}
+	af, addr := ipvsAddr(ip)
+
+	result := nl.NewRtAttr(ipvsCmdAttrDest, nil)
+	result.AddChild(nl.NewRtAttr(ipvsDestAttrAddrFamily, nl.Uint16Attr(af)))
+	result.AddChild(nl.NewRtAttr(ipvsDestAttrAddr, addr))
+	result.AddChild(nl.NewRtAttr(ipvsDestAttrPort, ipvsPort(dst.GetPort())))
+	if !full {
+		return result, nil
+	}
+
+	fwd, err := tolibForwardMethod(dst.GetForwardMethod())
+	if err != nil {
+		return nil, err
+	}
+
+	result.AddChild(nl.NewRtAttr(ipvsDestAttrFwdMethod, nl.Uint32Attr(uint32(fwd))))
+	result.AddChild(nl.NewRtAttr(ipvsDestAttrWeight, nl.Uint32Attr(dst.GetWeight())))
+	result.AddChild(nl.NewRtAttr(ipvsDestAttrUThresh, nl.Uint32Attr(dst.GetUThreshold())))
+	result.AddChild(nl.NewRtAttr(ipvsDestAttrLThresh, nl.Uint32Attr(dst.GetLThreshold())))
+
+	// tunnel attributes are omitted for IPIP to support kernels without
+	// them.
+	if tunnel := dst.GetTunnel(); tunnel.GetType() != kglb_pb.TunnelEncapsulation_IPIP {
+		var tunType uint8
+		switch tunnel.GetType() {
+		case kglb_pb.TunnelEncapsulation_GUE:
+			tunType = ipvsTunnelTypeGue
+		case kglb_pb.TunnelEncapsulation_GRE:
+			tunType = ipvsTunnelTypeGre
+		default:
+			return nil, errors.Newf("unknown tunnel type: %v", tunnel.GetType())
+		}
+		var tunFlags uint16
+		if tunnel.GetCsum() {
+			tunFlags |= ipvsTunnelEncapFlagCsum
+		}
+		if tunnel.GetRemcsum() {
+			tunFlags |= ipvsTunnelEncapFlagRemcsum
+		}
+
+		result.AddChild(nl.NewRtAttr(ipvsDestAttrTunType, nl.Uint8Attr(tunType)))
+		result.AddChild(nl.NewRtAttr(ipvsDestAttrTunPort, ipvsPort(tunnel.GetPort())))
+		result.AddChild(nl.NewRtAttr(ipvsDestAttrTunFlags, nl.Uint16Attr(tunFlags)))
+	}
+
+	return result, nil
+}
+
+// Decodes tunnel encapsulation of destination, nil is returned for IPIP.
+func tokglbTunnel(attrs map[uint16][]byte) (*kglb_pb.TunnelEncapsulation, error) {
+	tunnel := &kglb_pb.TunnelEncapsulation{}
+	switch tunType := netlinkAttrUint(attrs, ipvsDestAttrTunType); tunType {
+	case ipvsTunnelTypeIpip:
+		return nil, nil
+	case ipvsTunnelTypeGue:
+		tunnel.Type = kglb_pb.TunnelEncapsulation_GUE
+	case ipvsTunnelTypeGre:
+		tunnel.Type = kglb_pb.TunnelEncapsulation_GRE
+	default:
+		return nil, errors.Newf("unknown tunnel type: %d", tunType)
+	}
+
+	if port := attrs[ipvsDestAttrTunPort]; len(port) >= 2 {
+		tunnel.Port = uint32(binary.BigEndian.Uint16(port))
+	}
+	tunFlags := netlinkAttrUint(attrs, ipvsDestAttrTunFlags)
+	tunnel.Csum = tunFlags&ipvsTunnelEncapFlagCsum != 0
+	tunnel.Remcsum = tunFlags&ipvsTunnelEncapFlagRemcsum != 0
+	return tunnel, nil
+}
+
+// Decodes IPVS destination from attributes nested into ipvsCmdAttrDest,
+// address family of the service is used when destination doesn't have own
+// one.
+func tokglbIpvsDest(
+	b []byte,
+	serviceAf uint16) (*kglb_pb.UpstreamState, *kglb_pb.Stats, error) {
+
+	attrs, err := parseNetlinkAttrs(b)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	af := serviceAf
+	if _, ok := attrs[ipvsDestAttrAddrFamily]; ok {
+		if af, err = netlinkAttrUint16(attrs, ipvsDestAttrAddrFamily); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	ip, err := netlinkAttrAddr(attrs, ipvsDestAttrAddr, af)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(attrs[ipvsDestAttrPort]) < 2 {
+		return nil, nil, errors.New("missed or malformed destination port")
+	}
+
+	fwdMethod, err := netlinkAttrUint32(attrs, ipvsDestAttrFwdMethod)
+	if err != nil {
+		return nil, nil, err
+	}
+	fwd, err := tokglbUpstreamForwardMethod(
+		libipvs.FwdMethod(fwdMethod & ipvsConnFFwdMask))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	tunnel, err := tokglbTunnel(attrs)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	dst := &kglb_pb.UpstreamState{
+		Address:       common.NetIpToKglbAddr(ip),
+		Port:          uint32(binary.BigEndian.Uint16(attrs[ipvsDestAttrPort])),
+		Weight:        uint32(netlinkAttrUint(attrs, ipvsDestAttrWeight)),
+		ForwardMethod: fwd,
+		UThreshold:    uint32(netlinkAttrUint(attrs, ipvsDestAttrUThresh)),
+		LThreshold:    uint32(netlinkAttrUint(attrs, ipvsDestAttrLThresh)),
+		Tunnel:        tunnel,
+	}
+
+	stats, err := tokglbIpvsStats(attrs, ipvsDestAttrStats, ipvsDestAttrStats64)
+	if err != nil {
+		return nil, nil, err
+	}
+	stats.ActiveConnsCount = netlinkAttrUint(attrs, ipvsDestAttrActiveConns)
+	stats.InactConnsCount = netlinkAttrUint(attrs, ipvsDestAttrInactConns)
+	stats.PersistConnsCount = netlinkAttrUint(attrs, ipvsDestAttrPersistConns)
+
+	return dst, stats, nil
+}
+
+// Decodes stats of service or destination, 64-bit stats available since
+// linux 4.1 take precedence.
+func tokglbIpvsStats(
+	attrs map[uint16][]byte,
+	statsType uint16,
+	stats64Type uint16) (*kglb_pb.Stats, error) {
+
+	value, ok := attrs[stats64Type]
+	if !ok {
+		value = attrs[statsType]
+	}
+
+	stats, err := parseNetlinkAttrs(value)
+	if err != nil {
+		return nil, err
+	}
+
+	return &kglb_pb.Stats{
+		ConnectionsCount: netlinkAttrUint(stats, ipvsStatsAttrConns),
+		PacketsInCount:   netlinkAttrUint(stats, ipvsStatsAttrInPkts),
+		PacketsOutCount:  netlinkAttrUint(stats, ipvsStatsAttrOutPkts),
+		BytesInCount:     netlinkAttrUint(stats, ipvsStatsAttrInBytes),
+		BytesOutCount:    netlinkAttrUint(stats, ipvsStatsAttrOutBytes),
+		ConnectionsRate:  netlinkAttrUint(stats, ipvsStatsAttrCps),
+		PacketsInRate:    netlinkAttrUint(stats, ipvsStatsAttrInPps),
+		PacketsOutRate:   netlinkAttrUint(stats, ipvsStatsAttrOutPps),
+		BytesInRate:      netlinkAttrUint(stats, ipvsStatsAttrInBps),
+		BytesOutRate:     netlinkAttrUint(stats, ipvsStatsAttrOutBps),
+	}, nil
+}
+
+// Encodes role of sync daemon into its state.
+func ipvsDaemonState(role kglb_pb.IpvsSyncDaemon_Role) (uint32, error) {
+	switch role {
+	case kglb_pb.IpvsSyncDaemon_MASTER:
+		return ipvsStateMaster, nil
+	case kglb_pb.IpvsSyncDaemon_BACKUP:
+		return ipvsStateBackup, nil
+	default:
+		return 0, errors.Newf("unknown sync daemon role: %s", role)
+	}
+}
+
+// Encodes sync daemon into nested ipvsCmdAttrDaemon attribute. Only state
+// identifying the daemon is encoded when full is false, optional attributes
+// are omitted when they aren't set to use kernel defaults.
+func ipvsDaemonAttr(daemon *kglb_pb.IpvsSyncDaemon, full bool) (*nl.RtAttr, error) {
+	state, err := ipvsDaemonState(daemon.GetRole())
+	if err != nil {
+		return nil, err
+	}
+
+	result := nl.NewRtAttr(ipvsCmdAttrDaemon, nil)
+	result.AddChild(nl.NewRtAttr(ipvsDaemonAttrState, nl.Uint32Attr(state)))
+	if !full {
+		return result, nil
+	}
+
+	result.AddChild(nl.NewRtAttr(
+		ipvsDaemonAttrMcastIfn,
+		nl.ZeroTerminated(daemon.GetInterface())))
+	result.AddChild(nl.NewRtAttr(
+		ipvsDaemonAttrSyncId,
+		nl.Uint32Attr(daemon.GetSyncId())))
+	if daemon.GetSyncMaxlen() != 0 {
+		result.AddChild(nl.NewRtAttr(
+			ipvsDaemonAttrSyncMaxlen,
+			nl.Uint16Attr(uint16(daemon.GetSyncMaxlen()))))
+	}
+	if daemon.GetMcastGroup() != nil {
+		group := common.KglbAddrToNetIp(daemon.GetMcastGroup())
+		if group4 := group.To4(); group4 != nil {
+			result.AddChild(nl.NewRtAttr(ipvsDaemonAttrMcastGroup, []byte(group4)))
+		} else {
+			result.AddChild(nl.NewRtAttr(ipvsDaemonAttrMcastGroup6, []byte(group.To16())))
+		}
+	}
+	// port is passed in host byte order unlike ports of services.
+	if daemon.GetMcastPort() != 0 {
+		result.AddChild(nl.NewRtAttr(
+			ipvsDaemonAttrMcastPort,
+			nl.Uint16Attr(uint16(daemon.GetMcastPort()))))
+	}
+	if daemon.GetMcastTtl() != 0 {
+		result.AddChild(nl.NewRtAttr(
+			ipvsDaemonAttrMcastTtl,
+			nl.Uint8Attr(uint8(daemon.GetMcastTtl()))))
+	}
+	return result, nil
+}
+
+// Decodes sync daemon from attributes nested into ipvsCmdAttrDaemon.
+func tokglbIpvsSyncDaemon(b []byte) (*kglb_pb.IpvsSyncDaemon, error) {
+	attrs, err := parseNetlinkAttrs(b)
+	if err != nil {
+		return nil, err
+	}
+
+	daemon := &kglb_pb.IpvsSyncDaemon{
+		Interface:  netlinkAttrString(attrs, ipvsDaemonAttrMcastIfn),
+		SyncId:     uint32(netlinkAttrUint(attrs, ipvsDaemonAttrSyncId)),
+		SyncMaxlen: uint32(netlinkAttrUint(attrs, ipvsDaemonAttrSyncMaxlen)),
+		McastPort:  uint32(netlinkAttrUint(attrs, ipvsDaemonAttrMcastPort)),
+		McastTtl:   uint32(netlinkAttrUint(attrs, ipvsDaemonAttrMcastTtl)),
+	}
+
+	state, err := netlinkAttrUint32(attrs, ipvsDaemonAttrState)
+	if err != nil {
+		return nil, err
+	}
+	switch state {
+	case ipvsStateMaster:
+		daemon.Role = kglb_pb.IpvsSyncDaemon_MASTER
+	case ipvsStateBackup:
+		daemon.Role = kglb_pb.IpvsSyncDaemon_BACKUP
+	default:
+		return nil, errors.Newf("unknown sync daemon state: %d", state)
+	}
+
+	if _, ok := attrs[ipvsDaemonAttrMcastGroup6]; ok {
+		group, err := netlinkAttrAddr(attrs, ipvsDaemonAttrMcastGroup6, syscall.AF_INET6)
+		if err != nil {
+			return nil, err
+		}
+		daemon.McastGroup = common.NetIpToKglbAddr(group)
+	} else if _, ok := attrs[ipvsDaemonAttrMcastGroup]; ok {
+		group, err := netlinkAttrAddr(attrs, ipvsDaemonAttrMcastGroup, syscall.AF_INET)
+		if err != nil {
+			return nil, err
+		}
+		daemon.McastGroup = common.NetIpToKglbAddr(group)
+	}
+	return daemon, nil
+}
+
+// Returns human readable description of errno returned by IPVS command.
+func ipvsErrorDescription(cmd uint8, errno syscall.Errno) string {
+	switch errno {
+	case syscall.EPERM:
+		return "operation not permitted (CAP_NET_ADMIN is required)"
+	case syscall.ESRCH:
+		if cmd == ipvsCmdDelDaemon {
+			return "sync daemon doesn't run"
+		}
+		return "service doesn't exist"
+	case syscall.EEXIST:
+		switch cmd {
+		case ipvsCmdNewDest:
+			return "destination already exists"
+		case ipvsCmdNewDaemon:
+			return "sync daemon already runs"
+		default:
+			return "service already exists"
+		}
+	case syscall.ENOENT:
+		switch cmd {
+		case ipvsCmdNewService, ipvsCmdSetService:
+			return "scheduler or persistence engine is not found"
+		default:
+			return "destination doesn't exist"
+		}
+	case syscall.EINVAL:
+		return "invalid attributes"
+	case syscall.EAFNOSUPPORT:
+		return "address family is not supported"
+	case syscall.EBUSY:
+		return "resource is busy"
+	default:
+		return errno.Error()
+	}
+}
+
+// Returns name of IPVS command for logging.
+func ipvsCmdName(cmd uint8) string {
+	switch cmd {
+	case ipvsCmdNewService:
+		return "NEW_SERVICE"
+	case ipvsCmdSetService:
+		return "SET_SERVICE"
+	case ipvsCmdDelService:
+		return "DEL_SERVICE"
+	case ipvsCmdGetService:
+		return "GET_SERVICE"
+	case ipvsCmdNewDest:
+		return "NEW_DEST"
+	case ipvsCmdSetDest:
+		return "SET_DEST"
+	case ipvsCmdDelDest:
+		return "DEL_DEST"
+	case ipvsCmdGetDest:
+		return "GET_DEST"
+	case ipvsCmdNewDaemon:
+		return "NEW_DAEMON"
+	case ipvsCmdDelDaemon:
+		return "DEL_DAEMON"
+	case ipvsCmdGetDaemon:
+		return "GET_DAEMON"
+	case ipvsCmdSetConfig:
+		return "SET_CONFIG"
+	case ipvsCmdGetConfig:
+		return "GET_CONFIG"
+	default:
+		return "UNKNOWN"
+	}
+}
@@ -0,0 +1,13 @@
+package data_plane
+
+import kglb_pb "dropbox/proto/kglb"
+
+// Management of IPVS connection synchronization daemons.
+type IpvsSyncDaemonModule interface {
+	// Start sync daemon.
+	StartSyncDaemon(daemon *kglb_pb.IpvsSyncDaemon) error
+	// Stop sync daemon of the role.
+	StopSyncDaemon(role kglb_pb.IpvsSyncDaemon_Role) error
+	// Get list of running sync daemons.
+	ListSyncDaemons() ([]*kglb_pb.IpvsSyncDaemon, error)
+}
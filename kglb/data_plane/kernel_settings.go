@@ -0,0 +1,241 @@
+package data_plane
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"dropbox/dlog"
+	kglb_pb "dropbox/proto/kglb"
+	"godropbox/errors"
+)
+
+// root of kernel parameters.
+const procSysRoot = "/proc/sys"
+
+// names of managed kernel parameters.
+const (
+	sysctlConntrack               = "net.ipv4.vs.conntrack"
+	sysctlExpireNodestConn        = "net.ipv4.vs.expire_nodest_conn"
+	sysctlExpireQuiescentTemplate = "net.ipv4.vs.expire_quiescent_template"
+	sysctlSloppyTcp               = "net.ipv4.vs.sloppy_tcp"
+	sysctlScheduleIcmp            = "net.ipv4.vs.schedule_icmp"
+
+	// global ipvs timeouts aren't sysctls, names are used in drift reports
+	// only.
+	ipvsTimeoutTcp    = "ipvs.timeout_tcp"
+	ipvsTimeoutTcpFin = "ipvs.timeout_tcpfin"
+	ipvsTimeoutUdp    = "ipvs.timeout_udp"
+)
+
+// Access to kernel parameters, abstracted to use fake /proc/sys in tests.
+type SysctlFs interface {
+	// Returns value of the parameter in dotted notation, e.g.
+	// "net.ipv4.vs.conntrack".
+	Read(name string) (string, error)
+	// Writes value of existent parameter.
+	Write(name string, value string) error
+}
+
+// Global IPVS connection timeouts, implemented by IpvsNetlink.
+type IpvsTimeoutsModule interface {
+	Timeouts() (IpvsTimeouts, error)
+	SetTimeouts(timeouts IpvsTimeouts) error
+}
+
+type KernelSettingsModule interface {
+	// Checks that settings are supported by the module.
+	Validate(settings *kglb_pb.KernelSettings) error
+	// Applies settings, parameters which already have desired values are
+	// not changed.
+	Apply(settings *kglb_pb.KernelSettings) error
+	// Returns names of parameters which differ from settings.
+	Drift(settings *kglb_pb.KernelSettings) ([]string, error)
+}
+
+// SysctlFs implementation over directory tree of kernel parameters.
+type procSysFs struct {
+	root string
+}
+
+var _ SysctlFs = &procSysFs{}
+
+// Returns SysctlFs of the tree mounted at root, /proc/sys is used when root is
+// empty.
+func NewProcSysFs(root string) SysctlFs {
+	if root == "" {
+		root = procSysRoot
+	}
+	return &procSysFs{root: root}
+}
+
+func (p *procSysFs) path(name string) string {
+	return filepath.Join(p.root, strings.Replace(name, ".", "/", -1))
+}
+
+func (p *procSysFs) Read(name string) (string, error) {
+	data, err := ioutil.ReadFile(p.path(name))
+	if err != nil {
+		return "", errors.Wrapf(err, "fails to read %s: ", name)
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+func (p *procSysFs) Write(name string, value string) error {
+	// parameters cannot be created, so missing file is an error.
+	file, err := os.OpenFile(p.path(name), os.O_WRONLY|os.O_TRUNC, 0)
+	if err != nil {
+		return errors.Wrapf(err, "fails to open %s: ", name)
+	}
+	if _, err = file.WriteString(value + "\n"); err != nil {
+		file.Close()
+		return errors.Wrapf(err, "fails to write %s: ", name)
+	}
+	if err = file.Close(); err != nil {
+		return errors.Wrapf(err, "fails to write %s: ", name)
+	}
+	return nil
+}
+
+// Manages ipvs sysctls and global timeouts.
+type KernelSettings struct {
+	fs SysctlFs
+	// (Optional) timeouts module, settings with timeouts are rejected
+	// without it.
+	timeouts IpvsTimeoutsModule
+}
+
+var _ KernelSettingsModule = &KernelSettings{}
+
+func NewKernelSettings(fs SysctlFs, timeouts IpvsTimeoutsModule) *KernelSettings {
+	return &KernelSettings{
+		fs:       fs,
+		timeouts: timeouts,
+	}
+}
+
+func (k *KernelSettings) Validate(settings *kglb_pb.KernelSettings) error {
+	if k.timeouts == nil && ipvsTimeouts(settings) != (IpvsTimeouts{}) {
+		return errors.New(
+			"IPVS timeouts are not supported by configured ipvs module")
+	}
+	return nil
+}
+
+func (k *KernelSettings) Apply(settings *kglb_pb.KernelSettings) error {
+	for _, param := range sysctlParams(settings) {
+		value, err := k.fs.Read(param.name)
+		if err != nil {
+			return err
+		}
+		if value == param.value {
+			continue
+		}
+		dlog.Infof("Setting %s: %s -> %s", param.name, value, param.value)
+		if err := k.fs.Write(param.name, param.value); err != nil {
+			return err
+		}
+	}
+
+	desired := ipvsTimeouts(settings)
+	if desired == (IpvsTimeouts{}) {
+		return nil
+	}
+	drift, err := k.timeoutsDrift(desired)
+	if err != nil {
+		return err
+	}
+	if len(drift) > 0 {
+		return k.timeouts.SetTimeouts(desired)
+	}
+	return nil
+}
+
+func (k *KernelSettings) Drift(settings *kglb_pb.KernelSettings) ([]string, error) {
+	var drift []string
+	for _, param := range sysctlParams(settings) {
+		value, err := k.fs.Read(param.name)
+		if err != nil {
+			return nil, err
+		}
+		if value != param.value {
+			drift = append(drift, param.name)
+		}
+	}
+
+	desired := ipvsTimeouts(settings)
+	if desired == (IpvsTimeouts{}) {
+		return drift, nil
+	}
+	timeoutsDrift, err := k.timeoutsDrift(desired)
+	if err != nil {
+		return nil, err
+	}
+	return append(drift, timeoutsDrift...), nil
+}
+
+// Returns names of timeouts which differ from desired non-zero timeouts.
+func (k *KernelSettings) timeoutsDrift(desired IpvsTimeouts) ([]string, error) {
+	if k.timeouts == nil {
+		return nil, errors.New(
+			"IPVS timeouts are not supported by configured ipvs module")
+	}
+	current, err := k.timeouts.Timeouts()
+	if err != nil {
+		return nil, errors.Wrap(err, "fails to get IPVS timeouts: ")
+	}
+
+	var drift []string
+	if desired.Tcp != 0 && desired.Tcp != current.Tcp {
+		drift = append(drift, ipvsTimeoutTcp)
+	}
+	if desired.TcpFin != 0 && desired.TcpFin != current.TcpFin {
+		drift = append(drift, ipvsTimeoutTcpFin)
+	}
+	if desired.Udp != 0 && desired.Udp != current.Udp {
+		drift = append(drift, ipvsTimeoutUdp)
+	}
+	return drift, nil
+}
+
+type sysctlParam struct {
+	name  string
+	value string
+}
+
+// Returns desired values of sysctls which are set.
+func sysctlParams(settings *kglb_pb.KernelSettings) []sysctlParam {
+	var params []sysctlParam
+	for _, param := range []struct {
+		name  string
+		value *kglb_pb.BoolValue
+	}{
+		{sysctlConntrack, settings.GetConntrack()},
+		{sysctlExpireNodestConn, settings.GetExpireNodestConn()},
+		{sysctlExpireQuiescentTemplate, settings.GetExpireQuiescentTemplate()},
+		{sysctlSloppyTcp, settings.GetSloppyTcp()},
+		{sysctlScheduleIcmp, settings.GetScheduleIcmp()},
+	} {
+		if param.value != nil {
+			params = append(params, sysctlParam{param.name, sysctlBool(param.value.GetValue())})
+		}
+	}
+	return params
+}
+
+func sysctlBool(value bool) string {
+	if value {
+		return "1"
+	}
+	return "0"
+}
+
+func ipvsTimeouts(settings *kglb_pb.KernelSettings) IpvsTimeouts {
+	return IpvsTimeouts{
+		Tcp:    time.Duration(settings.GetTcpTimeoutS()) * time.Second,
+		TcpFin: time.Duration(settings.GetTcpFinTimeoutS()) * time.Second,
+		Udp:    time.Duration(settings.GetUdpTimeoutS()) * time.Second,
+	}
+}
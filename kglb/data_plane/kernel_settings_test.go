@@ -0,0 +1,208 @@
+package data_plane
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "gopkg.in/check.v1"
+
+	kglb_pb "dropbox/proto/kglb"
+)
+
+type KernelSettingsSuite struct {
+	root string
+	fs   SysctlFs
+}
+
+var _ = Suite(&KernelSettingsSuite{})
+
+// Fake IpvsTimeoutsModule.
+type fakeIpvsTimeouts struct {
+	timeouts IpvsTimeouts
+	setCnt   int
+}
+
+func (f *fakeIpvsTimeouts) Timeouts() (IpvsTimeouts, error) {
+	return f.timeouts, nil
+}
+
+// Zero timeouts aren't changed as kernel does.
+func (f *fakeIpvsTimeouts) SetTimeouts(timeouts IpvsTimeouts) error {
+	f.setCnt++
+	if timeouts.Tcp != 0 {
+		f.timeouts.Tcp = timeouts.Tcp
+	}
+	if timeouts.TcpFin != 0 {
+		f.timeouts.TcpFin = timeouts.TcpFin
+	}
+	if timeouts.Udp != 0 {
+		f.timeouts.Udp = timeouts.Udp
+	}
+	return nil
+}
+
+// Creates fake /proc/sys with ipvs sysctls set to 0.
+func (s *KernelSettingsSuite) SetUpTest(c *C) {
+	s.root = c.MkDir()
+	s.fs = NewProcSysFs(s.root)
+
+	dir := filepath.Join(s.root, "net", "ipv4", "vs")
+	c.Assert(os.MkdirAll(dir, 0755), IsNil)
+	for _, name := range []string{
+		"conntrack",
+		"expire_nodest_conn",
+		"expire_quiescent_template",
+		"sloppy_tcp",
+		"schedule_icmp"} {
+
+		err := ioutil.WriteFile(filepath.Join(dir, name), []byte("0\n"), 0644)
+		c.Assert(err, IsNil)
+	}
+}
+
+func (s *KernelSettingsSuite) TestProcSysFs(c *C) {
+	value, err := s.fs.Read(sysctlConntrack)
+	c.Assert(err, IsNil)
+	c.Assert(value, Equals, "0")
+
+	c.Assert(s.fs.Write(sysctlConntrack, "1"), IsNil)
+	data, err := ioutil.ReadFile(filepath.Join(s.root, "net/ipv4/vs/conntrack"))
+	c.Assert(err, IsNil)
+	c.Assert(string(data), Equals, "1\n")
+
+	// parameters cannot be created.
+	c.Assert(s.fs.Write("net.ipv4.vs.unknown", "1"), NotNil)
+	_, err = s.fs.Read("net.ipv4.vs.unknown")
+	c.Assert(err, NotNil)
+}
+
+func (s *KernelSettingsSuite) TestApply(c *C) {
+	timeouts := &fakeIpvsTimeouts{
+		timeouts: IpvsTimeouts{
+			Tcp:    900 * time.Second,
+			TcpFin: 120 * time.Second,
+			Udp:    300 * time.Second,
+		},
+	}
+	module := NewKernelSettings(s.fs, timeouts)
+	settings := &kglb_pb.KernelSettings{
+		Conntrack:        &kglb_pb.BoolValue{Value: true},
+		ExpireNodestConn: &kglb_pb.BoolValue{Value: true},
+		SloppyTcp:        &kglb_pb.BoolValue{Value: true},
+		// explicitly disabled sysctl.
+		ExpireQuiescentTemplate: &kglb_pb.BoolValue{Value: false},
+		TcpTimeoutS:             600,
+		UdpTimeoutS:             300,
+	}
+
+	c.Assert(s.fs.Write(sysctlExpireQuiescentTemplate, "1"), IsNil)
+	drift, err := module.Drift(settings)
+	c.Assert(err, IsNil)
+	c.Assert(drift, DeepEquals, []string{
+		sysctlConntrack,
+		sysctlExpireNodestConn,
+		sysctlExpireQuiescentTemplate,
+		sysctlSloppyTcp,
+		ipvsTimeoutTcp,
+	})
+
+	c.Assert(module.Apply(settings), IsNil)
+	c.Assert(timeouts.setCnt, Equals, 1)
+	c.Assert(timeouts.timeouts, Equals, IpvsTimeouts{
+		Tcp:    600 * time.Second,
+		TcpFin: 120 * time.Second,
+		Udp:    300 * time.Second,
+	})
+	value, err := s.fs.Read(sysctlExpireQuiescentTemplate)
+	c.Assert(err, IsNil)
+	c.Assert(value, Equals, "0")
+
+	drift, err = module.Drift(settings)
+	c.Assert(err, IsNil)
+	c.Assert(drift, HasLen, 0)
+
+	// applying the same settings doesn't change timeouts.
+	c.Assert(module.Apply(settings), IsNil)
+	c.Assert(timeouts.setCnt, Equals, 1)
+
+	// out-of-band change is reported as drift.
+	c.Assert(s.fs.Write(sysctlSloppyTcp, "0"), IsNil)
+	drift, err = module.Drift(settings)
+	c.Assert(err, IsNil)
+	c.Assert(drift, DeepEquals, []string{sysctlSloppyTcp})
+}
+
+func (s *KernelSettingsSuite) TestTimeoutsNotSupported(c *C) {
+	module := NewKernelSettings(s.fs, nil)
+
+	settings := &kglb_pb.KernelSettings{Conntrack: &kglb_pb.BoolValue{Value: true}}
+	c.Assert(module.Validate(settings), IsNil)
+	c.Assert(module.Apply(settings), IsNil)
+	value, err := s.fs.Read(sysctlConntrack)
+	c.Assert(err, IsNil)
+	c.Assert(value, Equals, "1")
+
+	settings = &kglb_pb.KernelSettings{TcpTimeoutS: 600}
+	c.Assert(module.Validate(settings), NotNil)
+	err = module.Apply(settings)
+	c.Assert(err, ErrorMatches, "(?s).*IPVS timeouts are not supported.*")
+}
+
+func (s *KernelSettingsSuite) TestManagerSetState(c *C) {
+	modules, err := GetMockModules(nil)
+	c.Assert(err, IsNil)
+	modules.KernelSettings = NewKernelSettings(s.fs, &fakeIpvsTimeouts{})
+	mng, err := NewManager(*modules)
+	c.Assert(err, IsNil)
+
+	// kernel settings aren't touched without them in the state.
+	c.Assert(mng.SetState(&kglb_pb.DataPlaneState{}), IsNil)
+	value, err := s.fs.Read(sysctlConntrack)
+	c.Assert(err, IsNil)
+	c.Assert(value, Equals, "0")
+
+	// sysctls which aren't set keep their values.
+	c.Assert(s.fs.Write(sysctlSloppyTcp, "1"), IsNil)
+	settings := &kglb_pb.KernelSettings{
+		Conntrack:    &kglb_pb.BoolValue{Value: true},
+		ScheduleIcmp: &kglb_pb.BoolValue{Value: true},
+	}
+	c.Assert(mng.SetState(&kglb_pb.DataPlaneState{KernelSettings: settings}), IsNil)
+	for _, name := range []string{sysctlConntrack, sysctlScheduleIcmp, sysctlSloppyTcp} {
+		value, err := s.fs.Read(name)
+		c.Assert(err, IsNil)
+		c.Assert(value, Equals, "1")
+	}
+	c.Assert(mng.kernelSettings, Equals, settings)
+
+	// removed sysctl doesn't fail the rest of the state.
+	c.Assert(os.Remove(filepath.Join(s.root, "net/ipv4/vs/conntrack")), IsNil)
+	c.Assert(mng.SetState(&kglb_pb.DataPlaneState{KernelSettings: settings}), IsNil)
+	c.Assert(mng.kernelSettings, Equals, settings)
+
+	// settings are forgotten once removed from the state.
+	c.Assert(mng.SetState(&kglb_pb.DataPlaneState{}), IsNil)
+	c.Assert(mng.kernelSettings, IsNil)
+}
+
+func (s *KernelSettingsSuite) TestManagerTimeoutsNotSupported(c *C) {
+	modules, err := GetMockModules(nil)
+	c.Assert(err, IsNil)
+	modules.KernelSettings = NewKernelSettings(s.fs, nil)
+	mng, err := NewManager(*modules)
+	c.Assert(err, IsNil)
+
+	err = mng.SetState(&kglb_pb.DataPlaneState{
+		KernelSettings: &kglb_pb.KernelSettings{
+			Conntrack:   &kglb_pb.BoolValue{Value: true},
+			TcpTimeoutS: 600,
+		},
+	})
+	c.Assert(err, ErrorMatches, "(?s).*invalid kernel settings.*")
+	// nothing is applied.
+	value, err := s.fs.Read(sysctlConntrack)
+	c.Assert(err, IsNil)
+	c.Assert(value, Equals, "0")
+}
@@ -0,0 +1,301 @@
+package data_plane
+
+import (
+	"encoding/binary"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"golang.org/x/sys/unix"
+
+	"dropbox/dlog"
+	"dropbox/exclog"
+	"dropbox/kglb/common"
+	kglb_pb "dropbox/proto/kglb"
+	"godropbox/errors"
+)
+
+// default interval of repeating announcements of active addresses.
+const defaultL2RefreshInterval = 10 * time.Second
+
+const (
+	ethHeaderLen  = 14
+	ethTypeArp    = 0x0806
+	ethTypeIpv6   = 0x86dd
+	arpPayloadLen = 28
+	arpOpRequest  = 1
+	ipv6HeaderLen = 40
+	// neighbor advertisement with target link-layer address option.
+	ndpNaLen = 32
+
+	icmpv6TypeNeighborAdvert = 136
+	ndpOptTargetLinkAddr     = 2
+	// override flag of neighbor advertisement.
+	ndpNaFlagOverride = 0x20000000
+)
+
+var (
+	ethBroadcast = net.HardwareAddr{0xff, 0xff, 0xff, 0xff, 0xff, 0xff}
+	// ethernet address of all-nodes multicast group (ff02::1).
+	ethAllNodes = net.HardwareAddr{0x33, 0x33, 0x00, 0x00, 0x00, 0x01}
+)
+
+// Socket sending raw ethernet frames. It's abstracted to verify crafted
+// frames in tests.
+type rawSocket interface {
+	// Sends ethernet frame through the interface.
+	SendFrame(ifindex int, frame []byte) error
+	// Closes the socket.
+	Close() error
+}
+
+// AF_PACKET socket, it's used for sending only.
+type packetSocket struct {
+	fd int
+}
+
+func newPacketSocket() (*packetSocket, error) {
+	// zero protocol means that socket doesn't receive any frames.
+	fd, err := unix.Socket(unix.AF_PACKET, unix.SOCK_RAW|unix.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return nil, errors.Wrap(err, "fails to create packet socket: ")
+	}
+	return &packetSocket{fd: fd}, nil
+}
+
+func (s *packetSocket) SendFrame(ifindex int, frame []byte) error {
+	addr := &unix.SockaddrLinklayer{
+		Ifindex: ifindex,
+		Halen:   6,
+	}
+	copy(addr.Addr[:], frame[:6])
+	return unix.Sendto(s.fd, frame, 0, addr)
+}
+
+func (s *packetSocket) Close() error {
+	return unix.Close(s.fd)
+}
+
+// L2Module implementation announcing ownership of addresses by gratuitous
+// ARP (IPv4) and unsolicited neighbor advertisement (IPv6). Announcements
+// are sent on Advertise and repeated periodically until Withdraw. Socket and
+// refreshing are started by the first announcement, so nodes without l2
+// routes don't need raw sockets.
+type L2Announcer struct {
+	newSocket       func() (rawSocket, error)
+	interfaceByName func(name string) (*net.Interface, error)
+	refreshInterval time.Duration
+
+	mu sync.Mutex
+	// nil until the first announcement.
+	socket        rawSocket
+	announcements map[string]*kglb_pb.L2RouteAttributes
+	closed        bool
+
+	stop chan struct{}
+}
+
+var _ L2Module = &L2Announcer{}
+
+// Returns new announcer repeating announcements with provided interval,
+// default interval is used when it's zero.
+func NewL2Announcer(refreshInterval time.Duration) *L2Announcer {
+	return newL2Announcer(
+		func() (rawSocket, error) { return newPacketSocket() },
+		net.InterfaceByName,
+		refreshInterval)
+}
+
+func newL2Announcer(
+	newSocket func() (rawSocket, error),
+	interfaceByName func(name string) (*net.Interface, error),
+	refreshInterval time.Duration) *L2Announcer {
+
+	if refreshInterval == 0 {
+		refreshInterval = defaultL2RefreshInterval
+	}
+
+	return &L2Announcer{
+		newSocket:       newSocket,
+		interfaceByName: interfaceByName,
+		refreshInterval: refreshInterval,
+		announcements:   make(map[string]*kglb_pb.L2RouteAttributes),
+		stop:            make(chan struct{}),
+	}
+}
+
+func (a *L2Announcer) Advertise(config *kglb_pb.L2RouteAttributes) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.closed {
+		return errors.New("l2 announcer is closed")
+	}
+	if a.socket == nil {
+		socket, err := a.newSocket()
+		if err != nil {
+			return err
+		}
+		a.socket = socket
+		go a.refreshLoop(a.refreshInterval)
+	}
+
+	if err := a.announce(config); err != nil {
+		return err
+	}
+	a.announcements[common.L2RouteAttributesComparable.Key(config)] = config
+	return nil
+}
+
+func (a *L2Announcer) Withdraw(config *kglb_pb.L2RouteAttributes) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	// neighbors learn new owner from its announcements, so nothing is sent.
+	delete(a.announcements, common.L2RouteAttributesComparable.Key(config))
+	return nil
+}
+
+func (a *L2Announcer) ListAnnouncements() ([]*kglb_pb.L2RouteAttributes, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	keys := make([]string, 0, len(a.announcements))
+	for key := range a.announcements {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	result := make([]*kglb_pb.L2RouteAttributes, len(keys))
+	for i, key := range keys {
+		result[i] = a.announcements[key]
+	}
+	return result, nil
+}
+
+// Stops refreshing of announcements and closes the socket.
+func (a *L2Announcer) Close() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.closed {
+		return nil
+	}
+	a.closed = true
+	close(a.stop)
+	if a.socket == nil {
+		return nil
+	}
+	return a.socket.Close()
+}
+
+func (a *L2Announcer) refreshLoop(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-a.stop:
+			return
+		case <-ticker.C:
+			a.refresh()
+		}
+	}
+}
+
+// Repeats announcements of all active addresses.
+func (a *L2Announcer) refresh() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for _, config := range a.announcements {
+		if err := a.announce(config); err != nil {
+			exclog.Report(
+				errors.Wrap(err, "fails to refresh l2 announcement: "),
+				exclog.Noncritical, "")
+		}
+	}
+}
+
+// Sends single announcement of the address (non-thread safe).
+func (a *L2Announcer) announce(config *kglb_pb.L2RouteAttributes) error {
+	iface, err := a.interfaceByName(config.GetInterface())
+	if err != nil {
+		return errors.Wrapf(err, "fails to find interface: %s: ", config.GetInterface())
+	}
+	if len(iface.HardwareAddr) != 6 {
+		return errors.Newf(
+			"interface doesn't have ethernet address: %s, %v",
+			iface.Name,
+			iface.HardwareAddr)
+	}
+
+	var frame []byte
+	ip := common.KglbAddrToNetIp(config.GetAddress())
+	if ip4 := ip.To4(); ip4 != nil {
+		frame = garpFrame(iface.HardwareAddr, ip4)
+	} else if ip16 := ip.To16(); ip16 != nil {
+		frame = unsolicitedNaFrame(iface.HardwareAddr, ip16)
+	} else {
+		return errors.Newf("invalid address: %+v", config.GetAddress())
+	}
+
+	if err := a.socket.SendFrame(iface.Index, frame); err != nil {
+		return errors.Wrapf(err, "fails to send announcement: %s, %s: ", iface.Name, ip)
+	}
+	dlog.V(2).Infof("l2 announcement is sent: %s, %s", iface.Name, ip)
+	return nil
+}
+
+func putEthHeader(frame []byte, dst, src net.HardwareAddr, ethType uint16) {
+	copy(frame[0:6], dst)
+	copy(frame[6:12], src)
+	binary.BigEndian.PutUint16(frame[12:14], ethType)
+}
+
+// Returns broadcast ARP request of the address with sender and target
+// protocol addresses set to the address (RFC 5227 announcement).
+func garpFrame(hwAddr net.HardwareAddr, ip net.IP) []byte {
+	frame := make([]byte, ethHeaderLen+arpPayloadLen)
+	putEthHeader(frame, ethBroadcast, hwAddr, ethTypeArp)
+
+	arp := frame[ethHeaderLen:]
+	binary.BigEndian.PutUint16(arp[0:2], unix.ARPHRD_ETHER)
+	binary.BigEndian.PutUint16(arp[2:4], unix.ETH_P_IP)
+	// lengths of hardware and protocol addresses.
+	arp[4] = 6
+	arp[5] = 4
+	binary.BigEndian.PutUint16(arp[6:8], arpOpRequest)
+	copy(arp[8:14], hwAddr)
+	copy(arp[14:18], ip)
+	// target hardware address is zero.
+	copy(arp[24:28], ip)
+	return frame
+}
+
+// Returns neighbor advertisement of the address sent to all-nodes multicast
+// group with override flag and target link-layer address (RFC 4861 7.2.6).
+func unsolicitedNaFrame(hwAddr net.HardwareAddr, ip net.IP) []byte {
+	frame := make([]byte, ethHeaderLen+ipv6HeaderLen+ndpNaLen)
+	putEthHeader(frame, ethAllNodes, hwAddr, ethTypeIpv6)
+
+	ipv6 := frame[ethHeaderLen : ethHeaderLen+ipv6HeaderLen]
+	ipv6[0] = 0x60 // version.
+	binary.BigEndian.PutUint16(ipv6[4:6], ndpNaLen)
+	ipv6[6] = unix.IPPROTO_ICMPV6
+	ipv6[7] = 255 // hop limit required by ndp.
+	copy(ipv6[8:24], ip)
+	copy(ipv6[24:40], net.IPv6linklocalallnodes)
+
+	na := frame[ethHeaderLen+ipv6HeaderLen:]
+	na[0] = icmpv6TypeNeighborAdvert
+	binary.BigEndian.PutUint32(na[4:8], ndpNaFlagOverride)
+	copy(na[8:24], ip)
+	na[24] = ndpOptTargetLinkAddr
+	na[25] = 1 // option length in units of 8 bytes.
+	copy(na[26:32], hwAddr)
+
+	binary.BigEndian.PutUint16(na[2:4], pseudoHeaderChecksum(ipv6[8:24], ipv6[24:40], unix.IPPROTO_ICMPV6, na))
+	return frame
+}
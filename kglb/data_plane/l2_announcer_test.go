@@ -0,0 +1,136 @@
+package data_plane
+
+import (
+	"encoding/hex"
+	"net"
+	"time"
+
+	. "gopkg.in/check.v1"
+
+	kglb_pb "dropbox/proto/kglb"
+	"godropbox/errors"
+	. "godropbox/gocheck2"
+)
+
+type L2AnnouncerSuite struct {
+}
+
+var _ = Suite(&L2AnnouncerSuite{})
+
+type sentFrame struct {
+	ifindex int
+	frame   string
+}
+
+// rawSocket recording sent frames.
+type recordingRawSocket struct {
+	frames []sentFrame
+	closed bool
+}
+
+func (s *recordingRawSocket) SendFrame(ifindex int, frame []byte) error {
+	s.frames = append(s.frames, sentFrame{
+		ifindex: ifindex,
+		frame:   hex.EncodeToString(frame),
+	})
+	return nil
+}
+
+func (s *recordingRawSocket) Close() error {
+	s.closed = true
+	return nil
+}
+
+func testInterfaceByName(name string) (*net.Interface, error) {
+	switch name {
+	case "eth0":
+		return &net.Interface{
+			Index:        3,
+			Name:         name,
+			HardwareAddr: net.HardwareAddr{0x02, 0x00, 0x00, 0x00, 0x00, 0x01},
+		}, nil
+	case "tun0":
+		return &net.Interface{Index: 4, Name: name}, nil
+	default:
+		return nil, errors.Newf("no such interface: %s", name)
+	}
+}
+
+func (m *L2AnnouncerSuite) TestAnnouncements(c *C) {
+	socket := &recordingRawSocket{}
+	socketCnt := 0
+	// refresh is triggered manually.
+	announcer := newL2Announcer(
+		func() (rawSocket, error) {
+			socketCnt++
+			return socket, nil
+		},
+		testInterfaceByName,
+		time.Hour)
+
+	// socket isn't created without announcements.
+	routes, err := announcer.ListAnnouncements()
+	c.Assert(err, NoErr)
+	c.Assert(routes, HasLen, 0)
+	c.Assert(socketCnt, Equals, 0)
+
+	v4 := &kglb_pb.L2RouteAttributes{
+		Address:   &kglb_pb.IP{Address: &kglb_pb.IP_Ipv4{Ipv4: "10.0.0.2"}},
+		Interface: "eth0",
+	}
+	v6 := &kglb_pb.L2RouteAttributes{
+		Address:   &kglb_pb.IP{Address: &kglb_pb.IP_Ipv6{Ipv6: "fc00::2"}},
+		Interface: "eth0",
+	}
+	c.Assert(announcer.Advertise(v4), NoErr)
+	c.Assert(announcer.Advertise(v6), NoErr)
+	c.Assert(socket.frames, DeepEquals, []sentFrame{
+		{ifindex: 3, frame: garpFrameHex},
+		{ifindex: 3, frame: unsolicitedNaFrameHex},
+	})
+	c.Assert(socketCnt, Equals, 1)
+
+	routes, err = announcer.ListAnnouncements()
+	c.Assert(err, NoErr)
+	c.Assert(routes, HasLen, 2)
+
+	// withdrawn address isn't refreshed.
+	c.Assert(announcer.Withdraw(v6), NoErr)
+	announcer.refresh()
+	c.Assert(socket.frames, HasLen, 3)
+	c.Assert(socket.frames[2].frame, Equals, garpFrameHex)
+	routes, err = announcer.ListAnnouncements()
+	c.Assert(err, NoErr)
+	c.Assert(routes, DeepEquals, []*kglb_pb.L2RouteAttributes{v4})
+
+	// interface without ethernet address.
+	err = announcer.Advertise(&kglb_pb.L2RouteAttributes{
+		Address:   &kglb_pb.IP{Address: &kglb_pb.IP_Ipv4{Ipv4: "10.0.0.3"}},
+		Interface: "tun0",
+	})
+	c.Assert(err, ErrorMatches, "(?s).*doesn't have ethernet address.*")
+	c.Assert(announcer.Advertise(&kglb_pb.L2RouteAttributes{
+		Address:   &kglb_pb.IP{Address: &kglb_pb.IP_Ipv4{Ipv4: "10.0.0.3"}},
+		Interface: "eth1",
+	}), NotNil)
+	routes, err = announcer.ListAnnouncements()
+	c.Assert(err, NoErr)
+	c.Assert(routes, HasLen, 1)
+
+	c.Assert(announcer.Close(), NoErr)
+	c.Assert(socket.closed, Equals, true)
+	c.Assert(announcer.Advertise(v4), NotNil)
+}
+
+const (
+	// ARP request of 10.0.0.2 from 02:00:00:00:00:01.
+	garpFrameHex = "" +
+		"ffffffffffff020000000001080600010800060400010200000000010a000002" +
+		"0000000000000a000002"
+
+	// neighbor advertisement of fc00::2 from 02:00:00:00:00:01 to ff02::1.
+	unsolicitedNaFrameHex = "" +
+		"33330000000102000000000186dd6000000000203afffc000000000000000000" +
+		"000000000002ff02000000000000000000000000000188005c9920000000fc00" +
+		"00000000000000000000000000020201020000000001"
+)
@@ -0,0 +1,14 @@
+package data_plane
+
+import kglb_pb "dropbox/proto/kglb"
+
+// L2 announcement of addresses, alternative to Bgp module for sites without
+// BGP.
+type L2Module interface {
+	// start announcing of the address.
+	Advertise(config *kglb_pb.L2RouteAttributes) error
+	// stop announcing of the address.
+	Withdraw(config *kglb_pb.L2RouteAttributes) error
+	// get list of announced addresses.
+	ListAnnouncements() ([]*kglb_pb.L2RouteAttributes, error)
+}
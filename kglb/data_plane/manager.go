@@ -2,6 +2,7 @@ package data_plane
 
 import (
 	"fmt"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -9,7 +10,9 @@ import (
 	"dropbox/dlog"
 	"dropbox/exclog"
 	"dropbox/kglb/common"
+	"dropbox/kglb/utils/comparable"
 	"dropbox/kglb/utils/fwmark"
+	"dropbox/kglb/utils/passive_health"
 	kglb_pb "dropbox/proto/kglb"
 	"dropbox/vortex2/v2stats"
 	"godropbox/errors"
@@ -41,6 +44,28 @@ type ManagerModules struct {
 	// v2 manager state age
 	// NOTE: defined as a pointer, so we can pass it from the dbx_data_plane
 	ManagerStateAgeSec *v2stats.Gauge
+
+	// (Optional) passive health detector processing connection stats of
+	// reals collected by EmitStats.
+	PassiveHealth *passive_health.Detector
+
+	// (Optional) module applying kernel settings of the state.
+	KernelSettings KernelSettingsModule
+
+	// (Optional) module flushing connection entries of deleted and zero
+	// weighted upstreams.
+	ConnCleanup ConnCleanupModule
+	// Max number of upstreams flushed by ConnCleanup per minute.
+	ConnCleanupLimit int
+
+	// (Optional) module managing ipvs connection synchronization daemons.
+	SyncDaemon IpvsSyncDaemonModule
+
+	// (Optional) module announcing dynamic routes with l2 attributes.
+	L2 L2Module
+
+	// (Optional) transport of vrrp advertisements.
+	VrrpTransport VrrpTransportFactory
 }
 
 type Manager struct {
@@ -50,6 +75,9 @@ type Manager struct {
 	dynRoutingMng   *DynamicRoutingManager
 	balancerManager *BalancerManager
 	addressManager  *AddressManager
+	connCleanupMng  *ConnCleanupManager
+	syncDaemonMng   *SyncDaemonManager
+	vrrpMng         *VrrpManager
 
 	shutdownHandler ShutdownHandlerFunc
 
@@ -62,6 +90,17 @@ type Manager struct {
 
 	lastSuccessfulStateChange time.Time
 
+	// last applied kernel settings, drift is reported against them.
+	kernelSettings      *kglb_pb.KernelSettings
+	kernelSettingsDrift *v2stats.GaugeGroup
+
+	// running ipvs sync daemons.
+	syncDaemonStat *v2stats.GaugeGroup
+
+	// last desired state, it's reapplied on vrrp transitions.
+	desiredState *kglb_pb.DataPlaneState
+	vrrpStat     *v2stats.GaugeGroup
+
 	shutdownOnce bool
 }
 
@@ -92,6 +131,7 @@ func NewManager(params ManagerModules) (*Manager, error) {
 
 	dynRoutingMng, err := NewDynamicRoutingManager(DynamicRoutingManagerParams{
 		Bgp: params.Bgp,
+		L2:  params.L2,
 	})
 	if err != nil {
 		return nil, err
@@ -104,16 +144,46 @@ func NewManager(params ManagerModules) (*Manager, error) {
 		return nil, err
 	}
 
+	connCleanupMng, err := NewConnCleanupManager(ConnCleanupManagerParams{
+		ConnCleanup: params.ConnCleanup,
+		Limit:       params.ConnCleanupLimit,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	syncDaemonMng, err := NewSyncDaemonManager(SyncDaemonManagerParams{
+		SyncDaemon: params.SyncDaemon,
+	})
+	if err != nil {
+		return nil, err
+	}
+
 	manager := &Manager{
 		modules:                   &params,
 		balancerManager:           balancerMgn,
 		dynRoutingMng:             dynRoutingMng,
 		addressManager:            addressManager,
+		connCleanupMng:            connCleanupMng,
+		syncDaemonMng:             syncDaemonMng,
 		prevStats:                 make(map[string]map[string]*kglb_pb.Stats),
 		shutdownHandler:           params.ShutdownHandler,
 		serviceStats:              make(map[string]*commonStats),
 		upstreamStats:             make(map[string]*commonStats),
 		lastSuccessfulStateChange: time.Now(),
+		kernelSettingsDrift:       v2stats.NewGaugeGroup(kernelSettingsDriftGauge),
+		syncDaemonStat:            v2stats.NewGaugeGroup(syncDaemonGauge),
+		vrrpStat:                  v2stats.NewGaugeGroup(vrrpStateGauge),
+	}
+
+	manager.vrrpMng, err = NewVrrpManager(VrrpManagerParams{
+		NewTransport: params.VrrpTransport,
+		OnTransition: func() {
+			go manager.reapplyState()
+		},
+	})
+	if err != nil {
+		return nil, err
 	}
 
 	// use default shutdown handler when custom is not specified.
@@ -146,6 +216,14 @@ func (m *Manager) defaultShutdownHandler(
 		return err
 	}
 
+	// Stopping sync daemons.
+	if err := m.syncDaemonMng.StopDaemons(currentState.GetSyncDaemons()); err != nil {
+		return err
+	}
+
+	// Releasing vrrp groups.
+	m.vrrpMng.Stop()
+
 	return nil
 }
 
@@ -181,10 +259,29 @@ func (m *Manager) Shutdown() (err error) {
 	return nil
 }
 
-func (m *Manager) SetState(state *kglb_pb.DataPlaneState) (err error) {
+func (m *Manager) SetState(state *kglb_pb.DataPlaneState) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	return m.setStateNonThreadSafe(state)
+}
+
+// Reapplies last desired state after transition of vrrp instance.
+func (m *Manager) reapplyState() {
 	m.mutex.Lock()
 	defer m.mutex.Unlock()
 
+	if m.shutdownOnce || m.desiredState == nil {
+		return
+	}
+	if err := m.setStateNonThreadSafe(m.desiredState); err != nil {
+		exclog.Report(
+			errors.Wrap(err, "fails to apply state after vrrp transition: "),
+			exclog.Operational, "")
+	}
+}
+
+func (m *Manager) setStateNonThreadSafe(state *kglb_pb.DataPlaneState) (err error) {
 	defer func() {
 		if err != nil {
 			m.emitManagerState("set_state_failed")
@@ -204,16 +301,41 @@ func (m *Manager) SetState(state *kglb_pb.DataPlaneState) (err error) {
 		cacheResolver.UpdateCache(state)
 	}
 
+	// 0. Applying kernel settings, they don't depend on the rest of the state.
+	if m.modules.KernelSettings != nil && state.GetKernelSettings() != nil {
+		err = m.modules.KernelSettings.Validate(state.GetKernelSettings())
+		if err != nil {
+			return errors.Wrap(err, "invalid kernel settings: ")
+		}
+	}
+	m.applyKernelSettings(state.GetKernelSettings())
+
+	// Running vrrp instances, addresses of groups where the node isn't master
+	// are excluded from the state.
+	if err = m.vrrpMng.SetInstances(state.GetVrrpInstances()); err != nil {
+		return errors.Wrap(err, "fails to run vrrp instances: ")
+	}
+	m.desiredState = state
+	state = m.vrrpMng.FilterState(state)
+
 	// 1. Querying existent state first.
 	currentState, err := m.getStateNonThreadSafe()
 	if err != nil {
 		return err
 	}
 
-	// 2. Identifying difference in balancers and bgp announcements.
+	// 2. Keeping draining upstreams only while they have active connections.
+	balancers, err := m.balancerManager.ApplyDraining(
+		currentState.GetBalancers(),
+		normalizeBalancers(excludeFlushedUpstreams(state.GetBalancers())))
+	if err != nil {
+		return errors.Wrap(err, "fails to apply draining upstreams: ")
+	}
+
+	// 3. Identifying difference in balancers and bgp announcements.
 	balancersDiff := common.CompareBalancerState(
 		currentState.GetBalancers(),
-		state.GetBalancers())
+		balancers)
 
 	routingDiff := common.CompareDynamicRouting(
 		currentState.GetDynamicRoutes(),
@@ -223,10 +345,15 @@ func (m *Manager) SetState(state *kglb_pb.DataPlaneState) (err error) {
 		currentState.GetLinkAddresses(),
 		state.GetLinkAddresses())
 
+	syncDaemonsDiff := m.syncDaemonMng.Compare(
+		currentState.GetSyncDaemons(),
+		state.GetSyncDaemons())
+
 	// check if there is any changes.
 	if !balancersDiff.IsChanged() &&
 		!routingDiff.IsChanged() &&
-		!localAddressessDiff.IsChanged() {
+		!localAddressessDiff.IsChanged() &&
+		!syncDaemonsDiff.IsChanged() {
 
 		dlog.Infof("data plane state is not changed.")
 		return nil
@@ -234,12 +361,14 @@ func (m *Manager) SetState(state *kglb_pb.DataPlaneState) (err error) {
 
 	dlog.Infof("Applying new state...")
 
-	// 3. Applying changes in the right order:
+	// 4. Applying changes in the right order:
 	// a) remove deleted bgp routes.
+	// b) switch sync daemons, so node becomes master after withdrawing
+	//    routes and before advertising them.
 	// c) adding new balancers.
 	// d) adding ip address of the service.
-	// e) update existent balancers (their upstreams).
-	// f) advertise bgp routes.
+	// e) update existent balancers (their services and upstreams).
+	// f) advertise new and changed bgp routes.
 	// g) remove deleted balancer.
 	// h) deleted addresses related to deleted balancers.
 
@@ -252,6 +381,14 @@ func (m *Manager) SetState(state *kglb_pb.DataPlaneState) (err error) {
 		}
 	}
 
+	// b) switch sync daemons.
+	if syncDaemonsDiff.IsChanged() {
+		dlog.Infof("b) Switching sync daemons: %+v", state.GetSyncDaemons())
+		if err = m.syncDaemonMng.Apply(syncDaemonsDiff); err != nil {
+			return errors.Wrap(err, "fails to switch sync daemons: ")
+		}
+	}
+
 	// c) adding new balancers.
 	if len(balancersDiff.Added) > 0 {
 		dlog.Infof("c) Adding balancers: %+v", balancersDiff.Added)
@@ -272,7 +409,12 @@ func (m *Manager) SetState(state *kglb_pb.DataPlaneState) (err error) {
 		}
 	}
 
-	// e) update existent balancers (updating reals in our case).
+	// upstreams failing health checks, other zero weighted ones (drained by
+	// operator or passive health) keep their connections.
+	unhealthyUpstreams := unhealthyUpstreamKeys(state.GetBalancers())
+
+	// e) update existent balancers (their services and reals), connections
+	// of deleted and unhealthy zero weighted upstreams are flushed.
 	for _, pair := range balancersDiff.Changed {
 
 		upstreamDiff := common.CompareUpstreamState(
@@ -280,6 +422,19 @@ func (m *Manager) SetState(state *kglb_pb.DataPlaneState) (err error) {
 			pair.NewItem.(*kglb_pb.BalancerState).GetUpstreams())
 
 		lbService := pair.NewItem.(*kglb_pb.BalancerState).GetLbService()
+		if !common.LoadBalancerServiceComparable.Equal(
+			pair.OldItem.(*kglb_pb.BalancerState).GetLbService(),
+			lbService) {
+
+			dlog.Infof("e) Updating service of balancer: %s :%+v",
+				pair.NewItem.(*kglb_pb.BalancerState).GetName(),
+				lbService)
+			err = m.balancerManager.UpdateService(lbService)
+			if err != nil {
+				return errors.Wrapf(
+					err, "fails to update service: %+v, error: ", lbService)
+			}
+		}
 		if upstreamDiff.IsChanged() {
 			// adding upstreams.
 			if len(upstreamDiff.Added) > 0 {
@@ -299,12 +454,21 @@ func (m *Manager) SetState(state *kglb_pb.DataPlaneState) (err error) {
 				dlog.Infof("e) Updating upstreams for balancer: %s :%+v",
 					pair.NewItem.(*kglb_pb.BalancerState).GetName(),
 					upstreamDiff.NewChangedStates())
+				// old states are collected before the update since modules
+				// may share them.
+				zeroWeighted := zeroWeightedUpstreams(
+					pair.NewItem.(*kglb_pb.BalancerState),
+					upstreamDiff.Changed,
+					unhealthyUpstreams)
 				err = m.balancerManager.UpdateUpstreams(
 					lbService,
 					common.UpstreamStateConvBack(upstreamDiff.NewChangedStates()))
 				if err != nil {
 					return errors.Wrapf(err, "fails to update upstreams: %+v, error: ", lbService)
 				}
+				m.connCleanupMng.Cleanup(
+					pair.NewItem.(*kglb_pb.BalancerState),
+					zeroWeighted)
 			}
 			// deleting upstream.
 			if len(upstreamDiff.Deleted) > 0 {
@@ -317,15 +481,21 @@ func (m *Manager) SetState(state *kglb_pb.DataPlaneState) (err error) {
 				if err != nil {
 					return errors.Wrapf(err, "fails to delete upstreams: %+v, error: ", lbService)
 				}
+				m.connCleanupMng.Cleanup(
+					pair.NewItem.(*kglb_pb.BalancerState),
+					common.UpstreamStateConvBack(upstreamDiff.Deleted))
 			}
 		}
 	}
 
-	// f) advertise bgp routes.
-	if len(routingDiff.Added) > 0 {
-		dlog.Infof("f) Advertise routes: %+v", routingDiff.Added)
-		err = m.dynRoutingMng.AdvertiseRoutes(
-			common.DynamicRoutingConvBack(routingDiff.Added))
+	// f) advertise bgp routes, changed routes are re-advertised in place
+	// with new path attributes.
+	if len(routingDiff.Added) > 0 || len(routingDiff.Changed) > 0 {
+		routes := append(
+			common.DynamicRoutingConvBack(routingDiff.Added),
+			common.DynamicRoutingConvBack(routingDiff.NewChangedStates())...)
+		dlog.Infof("f) Advertise routes: %+v", routes)
+		err = m.dynRoutingMng.AdvertiseRoutes(routes)
 		if err != nil {
 			return errors.Wrap(err, "fails to advertise routing path: ")
 		}
@@ -355,6 +525,49 @@ func (m *Manager) SetState(state *kglb_pb.DataPlaneState) (err error) {
 	return nil
 }
 
+// Returns keys of unhealthy upstreams of balancers except draining ones, see
+// upstreamKey.
+func unhealthyUpstreamKeys(balancers []*kglb_pb.BalancerState) map[string]bool {
+	keys := make(map[string]bool)
+	for _, balancer := range balancers {
+		for _, upstream := range balancer.GetUpstreams() {
+			if upstream.GetUnhealthy() && !upstream.GetDraining() {
+				keys[upstreamKey(balancer, upstream)] = true
+			}
+		}
+	}
+	return keys
+}
+
+func upstreamKey(
+	balancer *kglb_pb.BalancerState,
+	upstream *kglb_pb.UpstreamState) string {
+
+	return common.BalancerStateComparable.Key(balancer) + "/" +
+		common.UpstreamStateComparable.Key(upstream)
+}
+
+// Returns unhealthy upstreams of the balancer whose weight became zero.
+func zeroWeightedUpstreams(
+	balancer *kglb_pb.BalancerState,
+	changed []comparable.ChangedPair,
+	unhealthy map[string]bool) []*kglb_pb.UpstreamState {
+
+	var result []*kglb_pb.UpstreamState
+	for _, pair := range changed {
+		oldUpstream := pair.OldItem.(*kglb_pb.UpstreamState)
+		newUpstream := pair.NewItem.(*kglb_pb.UpstreamState)
+		if oldUpstream.GetWeight() == 0 || newUpstream.GetWeight() != 0 {
+			continue
+		}
+		if !unhealthy[upstreamKey(balancer, newUpstream)] {
+			continue
+		}
+		result = append(result, newUpstream)
+	}
+	return result
+}
+
 func (m *Manager) getStateNonThreadSafe() (state *kglb_pb.DataPlaneState, err error) {
 	balancerState, err := m.balancerManager.GetBalancers()
 	if err != nil {
@@ -371,13 +584,70 @@ func (m *Manager) getStateNonThreadSafe() (state *kglb_pb.DataPlaneState, err er
 		return nil, errors.Wrap(err, "AddressManager error: ")
 	}
 
+	syncDaemons, err := m.syncDaemonMng.State()
+	if err != nil {
+		return nil, errors.Wrap(err, "SyncDaemonManager error: ")
+	}
+
 	return &kglb_pb.DataPlaneState{
-		Balancers:     balancerState,
+		// ipvs services created by versions without persistence netmask
+		// support have zero netmask, it's reported as full address to not
+		// update such services in place.
+		Balancers:     normalizeBalancers(balancerState),
 		DynamicRoutes: routingState,
 		LinkAddresses: linkAddresses,
+		SyncDaemons:   syncDaemons,
+		VrrpInstances: m.vrrpMng.State(),
 	}, nil
 }
 
+// Applies and verifies kernel settings, nothing is done when either settings or
+// the module isn't provided. Failures aren't fatal for the rest of the state,
+// they are reported and exposed through drift gauge.
+func (m *Manager) applyKernelSettings(settings *kglb_pb.KernelSettings) {
+	if m.modules.KernelSettings == nil {
+		return
+	}
+
+	m.kernelSettings = settings
+	if settings == nil {
+		m.emitKernelSettingsDrift(nil)
+		return
+	}
+
+	if err := m.modules.KernelSettings.Apply(settings); err != nil {
+		exclog.Report(
+			errors.Wrap(err, "fails to apply kernel settings: "),
+			exclog.Noncritical, "")
+	}
+
+	drift, err := m.modules.KernelSettings.Drift(settings)
+	if err != nil {
+		exclog.Report(
+			errors.Wrap(err, "fails to verify kernel settings: "),
+			exclog.Noncritical, "")
+		return
+	}
+	m.emitKernelSettingsDrift(drift)
+	if len(drift) > 0 {
+		exclog.Report(
+			errors.Newf("kernel settings are not applied: %v", drift),
+			exclog.Noncritical, "")
+	}
+}
+
+func (m *Manager) emitKernelSettingsDrift(drift []string) {
+	for _, name := range drift {
+		err := m.kernelSettingsDrift.PrepareToSet(1, v2stats.KV{"setting": name})
+		if err != nil {
+			exclog.Report(
+				errors.Wrap(err, "unable to PrepareToSet() kernelSettingsDrift gauge"),
+				exclog.Critical, "")
+		}
+	}
+	m.kernelSettingsDrift.SetAndReset()
+}
+
 // Get current manager state.
 func (m *Manager) GetState() (*kglb_pb.DataPlaneState, error) {
 	m.mutex.Lock()
@@ -391,12 +661,35 @@ func (m *Manager) EmitStats() error {
 	diffSec := time.Since(m.lastSuccessfulStateChange).Seconds()
 	m.modules.ManagerStateAgeSec.Set(diffSec)
 
+	// kernel settings may be changed out-of-band after applying.
+	m.mutex.Lock()
+	kernelSettings := m.kernelSettings
+	m.mutex.Unlock()
+	if kernelSettings != nil {
+		drift, err := m.modules.KernelSettings.Drift(kernelSettings)
+		if err != nil {
+			exclog.Report(
+				errors.Wrap(err, "fails to verify kernel settings: "),
+				exclog.Noncritical, "")
+		} else {
+			m.emitKernelSettingsDrift(drift)
+		}
+	}
+
+	if m.modules.SyncDaemon != nil {
+		m.emitSyncDaemons()
+	}
+	m.emitVrrpStates()
+
 	// get all ipvs services.
 	services, servicesStats, err := m.modules.Ipvs.ListServices()
 	if err != nil {
 		return errors.Wrap(err, "fails to get services: ")
 	}
 
+	// connection stats of reals for passive health detector.
+	var passiveHealthSamples []passive_health.Sample
+
 	// emit ipvs service and upstream stats
 	for i, ipvsService := range services {
 		if fwmark.IsFwmarkService(ipvsService) {
@@ -427,6 +720,10 @@ func (m *Manager) EmitStats() error {
 			// continue to emit what is possible.
 			continue
 		}
+		serviceKey, err := common.GetKeyFromIpvsService(ipvsService)
+		if err != nil {
+			serviceKey = ipvsService.String()
+		}
 
 		// emit upstream specific stats.
 		for j, realServer := range realServers {
@@ -443,11 +740,30 @@ func (m *Manager) EmitStats() error {
 					exclog.Report(
 						errors.Wrap(err, "fails to emit stats: "), exclog.Critical, "")
 				}
+				passiveHealthSamples = append(
+					passiveHealthSamples,
+					passive_health.Sample{
+						Service:     serviceKey,
+						Address:     common.KglbAddrToNetIp(realServer.Address),
+						Port:        realServer.Port,
+						ActiveConns: realStats.GetActiveConnsCount(),
+						InactConns:  realStats.GetInactConnsCount(),
+						NewConns: uint64(diffMetric(
+							realStats.GetConnectionsCount(),
+							prevUpstreamStats.GetConnectionsCount())),
+						BytesOut: uint64(diffMetric(
+							realStats.GetBytesOutCount(),
+							prevUpstreamStats.GetBytesOutCount())),
+					})
 			}
 			m.prevStats[ipvsService.String()][realServerKey] = realStats
 		}
 	}
 
+	if m.modules.PassiveHealth != nil {
+		m.modules.PassiveHealth.Process(passiveHealthSamples)
+	}
+
 	return nil
 }
 
@@ -495,6 +811,43 @@ func (m *Manager) emitManagerState(state string) {
 	m.modules.ManagerStateStat.SetAndReset()
 }
 
+func (m *Manager) emitSyncDaemons() {
+	daemons, err := m.modules.SyncDaemon.ListSyncDaemons()
+	if err != nil {
+		exclog.Report(
+			errors.Wrap(err, "fails to get sync daemons: "), exclog.Noncritical, "")
+		return
+	}
+	for _, daemon := range daemons {
+		err := m.syncDaemonStat.PrepareToSet(1, v2stats.KV{
+			"role":      strings.ToLower(daemon.GetRole().String()),
+			"interface": daemon.GetInterface(),
+		})
+		if err != nil {
+			exclog.Report(
+				errors.Wrap(err, "unable to PrepareToSet() syncDaemon gauge"),
+				exclog.Critical, "")
+		}
+	}
+	m.syncDaemonStat.SetAndReset()
+}
+
+func (m *Manager) emitVrrpStates() {
+	for config, state := range m.vrrpMng.States() {
+		err := m.vrrpStat.PrepareToSet(1, v2stats.KV{
+			"vrid":      strconv.Itoa(int(config.GetVrid())),
+			"interface": config.GetInterface(),
+			"state":     state.String(),
+		})
+		if err != nil {
+			exclog.Report(
+				errors.Wrap(err, "unable to PrepareToSet() vrrpState gauge"),
+				exclog.Critical, "")
+		}
+	}
+	m.vrrpStat.SetAndReset()
+}
+
 func instantiateCommonStats(name string,
 	byteCounter, packetCounter v2stats.CounterDefinition) (*commonStats, error) {
 
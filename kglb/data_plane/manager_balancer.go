@@ -1,6 +1,8 @@
 package data_plane
 
 import (
+	"github.com/gogo/protobuf/proto"
+
 	"dropbox/dlog"
 	"dropbox/kglb/common"
 	kglb_pb "dropbox/proto/kglb"
@@ -123,6 +125,22 @@ func (m *BalancerManager) DeleteBalancer(balancer *kglb_pb.BalancerState) error
 	return nil
 }
 
+// Update attributes of existent LoadBalancerService in place to keep its
+// connections.
+func (m *BalancerManager) UpdateService(lbService *kglb_pb.LoadBalancerService) error {
+	// 1. extracting ipvs service.
+	ipvsService, err := common.GetIpvsServiceFromLbService(lbService)
+	if err != nil {
+		return errors.Wrapf(err, "fails to extract ipvs service from LoadBalancerService: ")
+	}
+
+	// 2. updating ipvs service.
+	if err := m.params.Ipvs.UpdateService(ipvsService); err != nil {
+		return errors.Wrapf(err, "failed to update IPVS service: ")
+	}
+	return nil
+}
+
 // Add new upstreams for specific LoadBalancerService.
 func (m *BalancerManager) AddUpstreams(
 	lbService *kglb_pb.LoadBalancerService,
@@ -177,3 +195,76 @@ func (m *BalancerManager) UpdateUpstreams(
 
 	return nil
 }
+
+// Returns balancers where draining upstreams are kept with zero weight while
+// they have active connections and removed otherwise. Draining upstreams
+// missing in current balancers are never added.
+func (m *BalancerManager) ApplyDraining(
+	current []*kglb_pb.BalancerState,
+	balancers []*kglb_pb.BalancerState) ([]*kglb_pb.BalancerState, error) {
+
+	currentBalancers := make(map[string]*kglb_pb.BalancerState, len(current))
+	for _, balancer := range current {
+		currentBalancers[common.BalancerStateComparable.Key(balancer)] = balancer
+	}
+
+	result := make([]*kglb_pb.BalancerState, 0, len(balancers))
+	for _, balancer := range balancers {
+		hasDraining := false
+		for _, upstream := range balancer.GetUpstreams() {
+			if upstream.GetDraining() {
+				hasDraining = true
+				break
+			}
+		}
+		if !hasDraining {
+			result = append(result, balancer)
+			continue
+		}
+
+		// active connections of configured reals.
+		activeConns := make(map[string]uint64)
+		if _, ok := currentBalancers[common.BalancerStateComparable.Key(balancer)]; ok {
+			ipvsService, err := common.GetIpvsServiceFromBalancer(balancer)
+			if err != nil {
+				return nil, errors.Wrapf(err, "fails to extract ipvs service from balancer: ")
+			}
+			reals, stats, err := m.params.Ipvs.GetRealServers(ipvsService)
+			if err != nil {
+				return nil, errors.Wrapf(err, "fails to get upstreams: ")
+			}
+			for i, upstream := range reals {
+				activeConns[common.UpstreamStateComparable.Key(upstream)] =
+					stats[i].GetActiveConnsCount()
+			}
+		}
+
+		upstreams := make([]*kglb_pb.UpstreamState, 0, len(balancer.GetUpstreams()))
+		for _, upstream := range balancer.GetUpstreams() {
+			if !upstream.GetDraining() {
+				upstreams = append(upstreams, upstream)
+				continue
+			}
+			if activeConns[common.UpstreamStateComparable.Key(upstream)] == 0 {
+				dlog.Infof(
+					"draining upstream doesn't have active connections: %s, %s",
+					balancer.GetName(),
+					upstream.GetHostname())
+				continue
+			}
+			drained := proto.Clone(upstream).(*kglb_pb.UpstreamState)
+			drained.Draining = false
+			drained.Weight = 0
+			upstreams = append(upstreams, drained)
+		}
+
+		result = append(result, &kglb_pb.BalancerState{
+			Name:              balancer.GetName(),
+			LbService:         balancer.GetLbService(),
+			Upstreams:         upstreams,
+			ConnectionCleanup: balancer.GetConnectionCleanup(),
+		})
+	}
+
+	return result, nil
+}
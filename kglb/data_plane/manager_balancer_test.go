@@ -90,3 +90,76 @@ func (m *BalancerManagerSuite) TestAddBalancer(c *C) {
 	})
 	c.Assert(err, IsNil)
 }
+
+// Test ApplyDraining.
+func (m *BalancerManagerSuite) TestApplyDraining(c *C) {
+	lbService := &kglb_pb.LoadBalancerService{Service: &kglb_pb.LoadBalancerService_IpvsService{
+		IpvsService: &kglb_pb.IpvsService{
+			Attributes: &kglb_pb.IpvsService_TcpAttributes{
+				TcpAttributes: &kglb_pb.IpvsTcpAttributes{
+					Address: &kglb_pb.IP{Address: &kglb_pb.IP_Ipv4{Ipv4: "172.0.0.1"}},
+					Port:    443,
+				}},
+			Scheduler: kglb_pb.IpvsService_RR,
+		}}}
+	upstream := func(ip string, weight uint32, draining bool) *kglb_pb.UpstreamState {
+		return &kglb_pb.UpstreamState{
+			Address:       &kglb_pb.IP{Address: &kglb_pb.IP_Ipv4{Ipv4: ip}},
+			Port:          443,
+			Hostname:      ip,
+			Weight:        weight,
+			ForwardMethod: kglb_pb.ForwardMethods_TUNNEL,
+			Draining:      draining,
+		}
+	}
+
+	current := []*kglb_pb.BalancerState{{
+		Name:      "TestName1",
+		LbService: lbService,
+		Upstreams: []*kglb_pb.UpstreamState{
+			upstream("10.0.0.1", 50, false),
+			upstream("10.0.0.2", 50, false),
+			upstream("10.0.0.3", 50, false),
+		},
+	}}
+	m.mockIpvs.GetRealServersFunc = func(
+		service *kglb_pb.IpvsService) ([]*kglb_pb.UpstreamState, []*kglb_pb.Stats, error) {
+
+		return current[0].Upstreams, []*kglb_pb.Stats{
+			{ActiveConnsCount: 10},
+			{ActiveConnsCount: 5},
+			{InactConnsCount: 5},
+		}, nil
+	}
+
+	desired := []*kglb_pb.BalancerState{{
+		Name:      "TestName1",
+		LbService: lbService,
+		Upstreams: []*kglb_pb.UpstreamState{
+			upstream("10.0.0.1", 50, false),
+			upstream("10.0.0.2", 0, true),
+			upstream("10.0.0.3", 0, true),
+			upstream("10.0.0.4", 0, true),
+		},
+	}}
+
+	// draining upstream is kept with zero weight while it has active
+	// connections, others are removed.
+	balancers, err := m.manager.ApplyDraining(current, desired)
+	c.Assert(err, IsNil)
+	c.Assert(balancers, HasLen, 1)
+	c.Assert(balancers[0].Upstreams, DeepEqualsPretty, []*kglb_pb.UpstreamState{
+		upstream("10.0.0.1", 50, false),
+		upstream("10.0.0.2", 0, false),
+	})
+	// desired state is not modified.
+	c.Assert(desired[0].Upstreams, HasLen, 4)
+	c.Assert(desired[0].Upstreams[1].Draining, IsTrue)
+
+	// draining upstreams are not added to new balancer.
+	balancers, err = m.manager.ApplyDraining(nil, desired)
+	c.Assert(err, IsNil)
+	c.Assert(balancers[0].Upstreams, DeepEqualsPretty, []*kglb_pb.UpstreamState{
+		upstream("10.0.0.1", 50, false),
+	})
+}
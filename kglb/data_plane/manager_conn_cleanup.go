@@ -0,0 +1,149 @@
+package data_plane
+
+import (
+	"sync"
+	"time"
+
+	"dropbox/dlog"
+	"dropbox/exclog"
+	"dropbox/kglb/common"
+	kglb_pb "dropbox/proto/kglb"
+	"dropbox/vortex2/v2stats"
+	"godropbox/errors"
+)
+
+// default max number of destinations flushed per minute.
+const defaultConnCleanupLimit = 600
+
+type ConnCleanupManagerParams struct {
+	ConnCleanup ConnCleanupModule
+
+	// max number of destinations flushed per minute, destinations exceeding
+	// the limit are skipped.
+	Limit int
+
+	// current time, it's overridden in tests.
+	now func() time.Time
+}
+
+// Flushes conntrack entries of deleted and unhealthy destinations of balancers
+// with enabled ConnectionCleanup. Cleanup is best effort, its failures don't
+// affect applying of the state.
+type ConnCleanupManager struct {
+	params *ConnCleanupManagerParams
+
+	mu sync.Mutex
+	// token bucket of flushes.
+	tokens     float64
+	lastRefill time.Time
+}
+
+func NewConnCleanupManager(
+	params ConnCleanupManagerParams) (*ConnCleanupManager, error) {
+
+	if params.Limit < 0 {
+		return nil, errors.Newf("invalid conn cleanup limit: %d", params.Limit)
+	}
+	if params.Limit == 0 {
+		params.Limit = defaultConnCleanupLimit
+	}
+	if params.now == nil {
+		params.now = time.Now
+	}
+
+	return &ConnCleanupManager{
+		params:     &params,
+		tokens:     float64(params.Limit),
+		lastRefill: params.now(),
+	}, nil
+}
+
+// Flushes connection entries of destinations of the balancer, entries of all
+// destinations are flushed at once.
+func (m *ConnCleanupManager) Cleanup(
+	balancer *kglb_pb.BalancerState,
+	dsts []*kglb_pb.UpstreamState) {
+
+	if m.params.ConnCleanup == nil ||
+		!balancer.GetConnectionCleanup().GetFlushConntrack() ||
+		len(dsts) == 0 {
+
+		return
+	}
+
+	service, err := common.GetIpvsServiceFromBalancer(balancer)
+	if err != nil {
+		exclog.Report(
+			errors.Wrap(err, "fails to cleanup connections: "),
+			exclog.Noncritical, "")
+		return
+	}
+
+	allowed := make([]*kglb_pb.UpstreamState, 0, len(dsts))
+	for _, dst := range dsts {
+		if !m.allow() {
+			dlog.Infof(
+				"connection cleanup is rate limited: %s, %s",
+				balancer.GetName(),
+				dst.GetHostname())
+			m.emitResult(balancer.GetName(), "rate_limited", 1)
+			continue
+		}
+		allowed = append(allowed, dst)
+	}
+	if len(allowed) == 0 {
+		return
+	}
+
+	cnt, err := m.params.ConnCleanup.FlushConntrack(service, allowed)
+	if err != nil {
+		exclog.Report(
+			errors.Wrapf(
+				err,
+				"fails to cleanup connections: %s: ",
+				balancer.GetName()),
+			exclog.Noncritical, "")
+		m.emitResult(balancer.GetName(), "failed", len(allowed))
+		return
+	}
+	dlog.Infof(
+		"%d conntrack entries are flushed: %s, %d upstreams",
+		cnt,
+		balancer.GetName(),
+		len(allowed))
+	m.emitResult(balancer.GetName(), "flushed", len(allowed))
+}
+
+// Takes token from the bucket refilled with Limit tokens per minute.
+func (m *ConnCleanupManager) allow() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.params.now()
+	limit := float64(m.params.Limit)
+	m.tokens += now.Sub(m.lastRefill).Minutes() * limit
+	if m.tokens > limit {
+		m.tokens = limit
+	}
+	m.lastRefill = now
+
+	if m.tokens < 1 {
+		return false
+	}
+	m.tokens--
+	return true
+}
+
+// Counts destinations by result of their cleanup.
+func (m *ConnCleanupManager) emitResult(name, result string, cnt int) {
+	counter, err := connCleanupCounter.V(v2stats.KV{
+		"name":   name,
+		"result": result,
+	})
+	if err != nil {
+		exclog.Report(errors.Wrap(err,
+			"unable to instantiate connCleanupCounter"), exclog.Critical, "")
+		return
+	}
+	counter.Add(float64(cnt))
+}
@@ -0,0 +1,231 @@
+package data_plane
+
+import (
+	"time"
+
+	. "gopkg.in/check.v1"
+
+	"dropbox/kglb/common"
+	"dropbox/kglb/utils/comparable"
+	kglb_pb "dropbox/proto/kglb"
+	"godropbox/errors"
+)
+
+type ConnCleanupManagerSuite struct {
+}
+
+var _ = Suite(&ConnCleanupManagerSuite{})
+
+func testConnCleanupBalancer(
+	cleanup *kglb_pb.ConnectionCleanup,
+	weights ...uint32) *kglb_pb.BalancerState {
+
+	balancer := &kglb_pb.BalancerState{
+		Name: "TestName1",
+		LbService: &kglb_pb.LoadBalancerService{Service: &kglb_pb.LoadBalancerService_IpvsService{
+			IpvsService: &kglb_pb.IpvsService{
+				Attributes: &kglb_pb.IpvsService_UdpAttributes{
+					UdpAttributes: &kglb_pb.IpvsUdpAttributes{
+						Address: &kglb_pb.IP{Address: &kglb_pb.IP_Ipv4{Ipv4: "172.0.0.1"}},
+						Port:    53,
+					}},
+				Scheduler: kglb_pb.IpvsService_RR,
+			}}},
+		ConnectionCleanup: cleanup,
+	}
+	hosts := []string{"10.0.0.1", "10.0.0.2", "10.0.0.3", "10.0.0.4"}
+	for i, weight := range weights {
+		balancer.Upstreams = append(balancer.Upstreams, &kglb_pb.UpstreamState{
+			Address:       &kglb_pb.IP{Address: &kglb_pb.IP_Ipv4{Ipv4: hosts[i]}},
+			Port:          53,
+			Hostname:      hosts[i],
+			Weight:        weight,
+			ForwardMethod: kglb_pb.ForwardMethods_TUNNEL,
+		})
+	}
+	return balancer
+}
+
+func (m *ConnCleanupManagerSuite) TestRateLimit(c *C) {
+	now := time.Unix(1000, 0)
+	module := NewMockConnCleanupModuleWithState()
+	mng, err := NewConnCleanupManager(ConnCleanupManagerParams{
+		ConnCleanup: module,
+		Limit:       2,
+		now:         func() time.Time { return now },
+	})
+	c.Assert(err, IsNil)
+
+	// cleanup is disabled.
+	balancer := testConnCleanupBalancer(&kglb_pb.ConnectionCleanup{}, 0, 0, 0)
+	mng.Cleanup(balancer, balancer.GetUpstreams())
+	c.Assert(module.FlushCnt, Equals, 0)
+
+	balancer = testConnCleanupBalancer(
+		&kglb_pb.ConnectionCleanup{FlushConntrack: true}, 0, 0, 0)
+	mng.Cleanup(balancer, balancer.GetUpstreams())
+	c.Assert(module.FlushCnt, Equals, 1)
+	c.Assert(module.ConntrackFlushed, DeepEquals, []string{"10.0.0.1", "10.0.0.2"})
+
+	// bucket is refilled by one token in 30 seconds.
+	now = now.Add(30 * time.Second)
+	mng.Cleanup(balancer, balancer.GetUpstreams()[2:])
+	mng.Cleanup(balancer, balancer.GetUpstreams()[2:])
+	c.Assert(module.FlushCnt, Equals, 2)
+	c.Assert(
+		module.ConntrackFlushed,
+		DeepEquals,
+		[]string{"10.0.0.1", "10.0.0.2", "10.0.0.3"})
+
+	_, err = NewConnCleanupManager(ConnCleanupManagerParams{Limit: -1})
+	c.Assert(err, NotNil)
+}
+
+func (m *ConnCleanupManagerSuite) TestFailures(c *C) {
+	flushed := 0
+	module := &MockConnCleanupModule{
+		FlushConntrackFunc: func(
+			service *kglb_pb.IpvsService,
+			dsts []*kglb_pb.UpstreamState) (uint, error) {
+
+			flushed++
+			return 0, errors.New("fails to flush")
+		},
+	}
+	mng, err := NewConnCleanupManager(ConnCleanupManagerParams{
+		ConnCleanup: module,
+	})
+	c.Assert(err, IsNil)
+
+	// failures are only reported.
+	balancer := testConnCleanupBalancer(
+		&kglb_pb.ConnectionCleanup{FlushConntrack: true}, 0, 0)
+	mng.Cleanup(balancer, balancer.GetUpstreams())
+	mng.Cleanup(balancer, balancer.GetUpstreams())
+	c.Assert(flushed, Equals, 2)
+}
+
+func (m *ConnCleanupManagerSuite) TestZeroWeightedUpstreams(c *C) {
+	oldBalancer := testConnCleanupBalancer(nil, 10, 10, 10, 0)
+	newBalancer := testConnCleanupBalancer(nil, 0, 0, 0, 0)
+	newBalancer.Upstreams[0].Unhealthy = true
+	newBalancer.Upstreams[1].Unhealthy = true
+	newBalancer.Upstreams[1].Draining = true
+	newBalancer.Upstreams[3].Unhealthy = true
+
+	var changed []comparable.ChangedPair
+	for i := range oldBalancer.GetUpstreams() {
+		changed = append(changed, comparable.ChangedPair{
+			OldItem: oldBalancer.GetUpstreams()[i],
+			NewItem: newBalancer.GetUpstreams()[i],
+		})
+	}
+
+	// draining upstream, upstream drained by operator and upstream which
+	// already had zero weight are skipped.
+	upstreams := zeroWeightedUpstreams(
+		newBalancer,
+		changed,
+		unhealthyUpstreamKeys([]*kglb_pb.BalancerState{newBalancer}))
+	c.Assert(upstreams, DeepEquals, newBalancer.GetUpstreams()[:1])
+}
+
+func (m *ConnCleanupManagerSuite) TestManagerSetState(c *C) {
+	module := NewMockConnCleanupModuleWithState()
+	modules, err := GetMockModules(&ManagerModules{ConnCleanup: module})
+	c.Assert(err, IsNil)
+	mng, err := NewManager(*modules)
+	c.Assert(err, IsNil)
+
+	cleanup := &kglb_pb.ConnectionCleanup{FlushConntrack: true}
+	err = mng.SetState(&kglb_pb.DataPlaneState{
+		Balancers: []*kglb_pb.BalancerState{
+			testConnCleanupBalancer(cleanup, 10, 10, 10),
+		},
+	})
+	c.Assert(err, IsNil)
+	c.Assert(module.FlushCnt, Equals, 0)
+
+	// first upstream is unhealthy, the second one is drained by operator and
+	// the last one is deleted.
+	balancer := testConnCleanupBalancer(cleanup, 0, 0)
+	balancer.Upstreams[0].Unhealthy = true
+	err = mng.SetState(&kglb_pb.DataPlaneState{
+		Balancers: []*kglb_pb.BalancerState{balancer},
+	})
+	c.Assert(err, IsNil)
+	c.Assert(module.ConntrackFlushed, DeepEquals, []string{"10.0.0.1", "10.0.0.3"})
+
+	// health of upstreams isn't treated as change of the state.
+	state, err := mng.GetState()
+	c.Assert(err, IsNil)
+	c.Assert(
+		common.CompareBalancerState(
+			state.GetBalancers(),
+			normalizeBalancers([]*kglb_pb.BalancerState{balancer})).IsChanged(),
+		Equals, false)
+
+	// cleanup is disabled.
+	err = mng.SetState(&kglb_pb.DataPlaneState{
+		Balancers: []*kglb_pb.BalancerState{
+			testConnCleanupBalancer(nil, 10),
+		},
+	})
+	c.Assert(err, IsNil)
+	c.Assert(module.ConntrackFlushed, HasLen, 2)
+}
+
+func (m *ConnCleanupManagerSuite) TestManagerFlushIpvs(c *C) {
+	module := NewMockConnCleanupModuleWithState()
+	modules, err := GetMockModules(&ManagerModules{ConnCleanup: module})
+	c.Assert(err, IsNil)
+	mng, err := NewManager(*modules)
+	c.Assert(err, IsNil)
+
+	cleanup := &kglb_pb.ConnectionCleanup{FlushIpvs: true}
+	err = mng.SetState(&kglb_pb.DataPlaneState{
+		Balancers: []*kglb_pb.BalancerState{
+			testConnCleanupBalancer(cleanup, 10, 10, 10),
+		},
+	})
+	c.Assert(err, IsNil)
+
+	// unhealthy upstream is deleted from ipvs to expire its connection
+	// entries, healthy upstreams set to zero weight (e.g. by operator) keep
+	// it.
+	balancer := testConnCleanupBalancer(cleanup, 0, 0, 10)
+	balancer.Upstreams[0].Unhealthy = true
+	err = mng.SetState(&kglb_pb.DataPlaneState{
+		Balancers: []*kglb_pb.BalancerState{balancer},
+	})
+	c.Assert(err, IsNil)
+	state, err := mng.GetState()
+	c.Assert(err, IsNil)
+	c.Assert(state.GetBalancers(), HasLen, 1)
+	upstreams := state.GetBalancers()[0].GetUpstreams()
+	c.Assert(upstreams, HasLen, 2)
+	c.Assert(upstreams[0].GetHostname(), Equals, "10.0.0.2")
+	c.Assert(upstreams[0].GetWeight(), Equals, uint32(0))
+	c.Assert(upstreams[1].GetHostname(), Equals, "10.0.0.3")
+	// conntrack entries aren't flushed.
+	c.Assert(module.FlushCnt, Equals, 0)
+
+	// draining upstreams keep their connections.
+	drained := testConnCleanupBalancer(cleanup, 0)
+	drained.Upstreams[0].Unhealthy = true
+	drained.Upstreams[0].Draining = true
+	c.Assert(
+		excludeFlushedUpstreams([]*kglb_pb.BalancerState{drained})[0].GetUpstreams(),
+		HasLen, 1)
+
+	// recovered upstream is added back.
+	err = mng.SetState(&kglb_pb.DataPlaneState{
+		Balancers: []*kglb_pb.BalancerState{
+			testConnCleanupBalancer(cleanup, 10, 10, 10),
+		},
+	})
+	c.Assert(err, IsNil)
+	state, err = mng.GetState()
+	c.Assert(err, IsNil)
+	c.Assert(state.GetBalancers()[0].GetUpstreams(), HasLen, 3)
+}
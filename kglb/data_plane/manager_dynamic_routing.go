@@ -6,6 +6,7 @@ import (
 
 	"dropbox/dlog"
 	"dropbox/exclog"
+	"dropbox/kglb/common"
 	kglb_pb "dropbox/proto/kglb"
 	"dropbox/vortex2/v2stats"
 	"godropbox/errors"
@@ -13,6 +14,8 @@ import (
 
 type DynamicRoutingManagerParams struct {
 	Bgp BgpModule
+	// (Optional) routes with l2 attributes are rejected without it.
+	L2 L2Module
 }
 
 type DynamicRoutingManager struct {
@@ -21,17 +24,21 @@ type DynamicRoutingManager struct {
 	// map of hold timeouts per "prefix" key.
 	holdTimeouts map[string]time.Duration
 
-	bgpSessionStat *v2stats.GaugeGroup
-	bgpRouteStat   *v2stats.GaugeGroup
+	bgpSessionStat     *v2stats.GaugeGroup
+	bgpPeerSessionStat *v2stats.GaugeGroup
+	bgpRouteStat       *v2stats.GaugeGroup
+	l2RouteStat        *v2stats.GaugeGroup
 }
 
 func NewDynamicRoutingManager(
 	params DynamicRoutingManagerParams) (*DynamicRoutingManager, error) {
 	m := &DynamicRoutingManager{
-		params:         &params,
-		holdTimeouts:   make(map[string]time.Duration),
-		bgpSessionStat: v2stats.NewGaugeGroup(bgpSessionStateGauge),
-		bgpRouteStat:   v2stats.NewGaugeGroup(bgpRouteGauge),
+		params:             &params,
+		holdTimeouts:       make(map[string]time.Duration),
+		bgpSessionStat:     v2stats.NewGaugeGroup(bgpSessionStateGauge),
+		bgpPeerSessionStat: v2stats.NewGaugeGroup(bgpPeerSessionStateGauge),
+		bgpRouteStat:       v2stats.NewGaugeGroup(bgpRouteGauge),
+		l2RouteStat:        v2stats.NewGaugeGroup(l2RouteGauge),
 	}
 
 	m.startStatsCollector()
@@ -68,6 +75,25 @@ func (m *DynamicRoutingManager) collectBgpStats() {
 	}
 	m.bgpSessionStat.SetAndReset()
 
+	if peerStates, ok := m.params.Bgp.(BgpPeerStatesModule); ok {
+		for peer, established := range peerStates.PeerStates() {
+			peerState := "not_established"
+			if established {
+				peerState = "established"
+			}
+			err = m.bgpPeerSessionStat.PrepareToSet(1, v2stats.KV{
+				"peer":  peer,
+				"state": peerState,
+			})
+			if err != nil {
+				exclog.Report(
+					errors.Wrap(err, "unable to PrepareToSet BgpPeerSessionGauge"),
+					exclog.Critical, "")
+			}
+		}
+		m.bgpPeerSessionStat.SetAndReset()
+	}
+
 	//TODO(oleg): emits stats for IPv4 and IPv6 with different tags
 	routesAdvertised, err := m.ListRoutes()
 	if err != nil {
@@ -77,6 +103,18 @@ func (m *DynamicRoutingManager) collectBgpStats() {
 	}
 
 	for _, dynamicRoute := range routesAdvertised {
+		if l2Route := dynamicRoute.GetL2Attributes(); l2Route != nil {
+			err = m.l2RouteStat.PrepareToSet(1, v2stats.KV{
+				"address":   common.KglbAddrToNetIp(l2Route.GetAddress()).String(),
+				"interface": l2Route.GetInterface(),
+			})
+			if err != nil {
+				exclog.Report(
+					errors.Wrap(err, "unable to PrepareToSet L2RouteGauge"), exclog.Critical, "")
+			}
+			continue
+		}
+
 		bgpRoute, err := m.getBgpRouting(dynamicRoute)
 		if err != nil {
 			exclog.Report(
@@ -91,6 +129,7 @@ func (m *DynamicRoutingManager) collectBgpStats() {
 	}
 	// Emit all currently existing routes / reset removed routes
 	m.bgpRouteStat.SetAndReset()
+	m.l2RouteStat.SetAndReset()
 }
 
 func (m *DynamicRoutingManager) getBgpRouting(
@@ -104,6 +143,13 @@ func (m *DynamicRoutingManager) getBgpRouting(
 	}
 }
 
+func (m *DynamicRoutingManager) getL2Module() (L2Module, error) {
+	if m.params.L2 == nil {
+		return nil, errors.New("l2 module is not configured")
+	}
+	return m.params.L2, nil
+}
+
 func (m *DynamicRoutingManager) ListRoutes() ([]*kglb_pb.DynamicRoute, error) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
@@ -126,6 +172,21 @@ func (m *DynamicRoutingManager) ListRoutes() ([]*kglb_pb.DynamicRoute, error) {
 			},
 		}
 	}
+
+	if m.params.L2 != nil {
+		l2Routes, err := m.params.L2.ListAnnouncements()
+		if err != nil {
+			exclog.Report(errors.Wrapf(err, "fails to list l2 routes:"), exclog.Operational, "")
+			return nil, err
+		}
+		for _, route := range l2Routes {
+			results = append(results, &kglb_pb.DynamicRoute{
+				Attributes: &kglb_pb.DynamicRoute_L2Attributes{
+					L2Attributes: route,
+				},
+			})
+		}
+	}
 	return results, nil
 }
 
@@ -151,6 +212,17 @@ func (m *DynamicRoutingManager) AdvertiseRoutes(
 
 // Advertise single route (non-thread safe).
 func (m *DynamicRoutingManager) advertiseRouteLocked(route *kglb_pb.DynamicRoute) (err error) {
+	if l2Route := route.GetL2Attributes(); l2Route != nil {
+		l2, err := m.getL2Module()
+		if err != nil {
+			return err
+		}
+		if err = l2.Advertise(l2Route); err != nil {
+			return errors.Wrap(err, "failed to advertise l2 route: ")
+		}
+		return nil
+	}
+
 	// supporting bgp attributes for now only.
 	bgpRoute, err := m.getBgpRouting(route)
 	if err != nil {
@@ -212,6 +284,18 @@ func (m *DynamicRoutingManager) WithdrawRoutes(
 func (m *DynamicRoutingManager) withdrawRouteLocked(
 	route *kglb_pb.DynamicRoute) (time.Duration, error) {
 
+	// l2 routes don't have hold timeout.
+	if l2Route := route.GetL2Attributes(); l2Route != nil {
+		l2, err := m.getL2Module()
+		if err != nil {
+			return 0, err
+		}
+		if err := l2.Withdraw(l2Route); err != nil {
+			return 0, errors.Wrapf(err, "failed to withdraw dynamic route: %+v", route)
+		}
+		return 0, nil
+	}
+
 	bgpRoute, err := m.getBgpRouting(route)
 	if err != nil {
 		dlog.Errorf("fails to extract bgp attributes: %v", err)
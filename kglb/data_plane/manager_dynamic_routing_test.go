@@ -127,3 +127,34 @@ func (m *DynamicRoutingManagerSuite) TestHoldTimeouts(c *C) {
 	c.Assert(elapsed/time.Millisecond, GreaterThan, 50)
 
 }
+
+func (m *DynamicRoutingManagerSuite) TestL2Routes(c *C) {
+	route := &kglb_pb.DynamicRoute{
+		Attributes: &kglb_pb.DynamicRoute_L2Attributes{
+			L2Attributes: &kglb_pb.L2RouteAttributes{
+				Address:   &kglb_pb.IP{Address: &kglb_pb.IP_Ipv4{Ipv4: "10.0.0.2"}},
+				Interface: "eth0",
+			},
+		},
+	}
+
+	// l2 module is required.
+	err := m.manager.AdvertiseRoutes([]*kglb_pb.DynamicRoute{route})
+	c.Assert(err, ErrorMatches, "(?s).*l2 module is not configured.*")
+
+	manager, err := NewDynamicRoutingManager(DynamicRoutingManagerParams{
+		Bgp: NewMockBgpModuleWithState(),
+		L2:  NewMockL2ModuleWithState(),
+	})
+	c.Assert(err, IsNil)
+
+	c.Assert(manager.AdvertiseRoutes([]*kglb_pb.DynamicRoute{route}), IsNil)
+	routes, err := manager.ListRoutes()
+	c.Assert(err, IsNil)
+	c.Assert(routes, DeepEquals, []*kglb_pb.DynamicRoute{route})
+
+	c.Assert(manager.WithdrawRoutes([]*kglb_pb.DynamicRoute{route}), IsNil)
+	routes, err = manager.ListRoutes()
+	c.Assert(err, IsNil)
+	c.Assert(routes, HasLen, 0)
+}
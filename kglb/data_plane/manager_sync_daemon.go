@@ -0,0 +1,123 @@
+package data_plane
+
+import (
+	"net"
+
+	"github.com/gogo/protobuf/proto"
+
+	"dropbox/kglb/common"
+	"dropbox/kglb/utils/comparable"
+	kglb_pb "dropbox/proto/kglb"
+	"godropbox/errors"
+)
+
+// kernel defaults of optional attributes of sync daemon.
+const (
+	defaultIpvsSyncMcastPort = 8848
+	defaultIpvsSyncMcastTtl  = 1
+)
+
+var defaultIpvsSyncMcastGroup = net.ParseIP("224.0.0.81")
+
+type SyncDaemonManagerParams struct {
+	// (Optional) states with sync daemons are rejected without it.
+	SyncDaemon IpvsSyncDaemonModule
+}
+
+type SyncDaemonManager struct {
+	params *SyncDaemonManagerParams
+}
+
+func NewSyncDaemonManager(
+	params SyncDaemonManagerParams) (*SyncDaemonManager, error) {
+
+	return &SyncDaemonManager{
+		params: &params,
+	}, nil
+}
+
+// Returns running sync daemons, nothing is returned without the module.
+func (m *SyncDaemonManager) State() ([]*kglb_pb.IpvsSyncDaemon, error) {
+	if m.params.SyncDaemon == nil {
+		return nil, nil
+	}
+	return m.params.SyncDaemon.ListSyncDaemons()
+}
+
+// Compares running sync daemons with desired ones. Kernel fills optional
+// attributes with defaults, so they are assumed for unset attributes of
+// desired daemons.
+func (m *SyncDaemonManager) Compare(
+	current,
+	desired []*kglb_pb.IpvsSyncDaemon) *comparable.ComparableResult {
+
+	currentMaxlen := make(map[kglb_pb.IpvsSyncDaemon_Role]uint32, len(current))
+	for _, daemon := range current {
+		currentMaxlen[daemon.GetRole()] = daemon.GetSyncMaxlen()
+	}
+
+	normalized := make([]*kglb_pb.IpvsSyncDaemon, len(desired))
+	for i, daemon := range desired {
+		daemon = proto.Clone(daemon).(*kglb_pb.IpvsSyncDaemon)
+		if daemon.McastGroup == nil {
+			daemon.McastGroup = common.NetIpToKglbAddr(defaultIpvsSyncMcastGroup)
+		}
+		if daemon.McastPort == 0 {
+			daemon.McastPort = defaultIpvsSyncMcastPort
+		}
+		if daemon.McastTtl == 0 {
+			daemon.McastTtl = defaultIpvsSyncMcastTtl
+		}
+		// default max length depends on mtu of the interface.
+		if daemon.SyncMaxlen == 0 {
+			daemon.SyncMaxlen = currentMaxlen[daemon.GetRole()]
+		}
+		normalized[i] = daemon
+	}
+
+	return common.CompareIpvsSyncDaemons(current, normalized)
+}
+
+// Stops deleted and changed sync daemons and starts new and changed ones.
+func (m *SyncDaemonManager) Apply(diff *comparable.ComparableResult) error {
+	if !diff.IsChanged() {
+		return nil
+	}
+	if m.params.SyncDaemon == nil {
+		return errors.New("ipvs sync daemon module is not configured")
+	}
+
+	var stopped, started []*kglb_pb.IpvsSyncDaemon
+	stopped = append(stopped, common.IpvsSyncDaemonConvBack(diff.Deleted)...)
+	started = append(started, common.IpvsSyncDaemonConvBack(diff.Added)...)
+	for _, pair := range diff.Changed {
+		stopped = append(stopped, pair.OldItem.(*kglb_pb.IpvsSyncDaemon))
+		started = append(started, pair.NewItem.(*kglb_pb.IpvsSyncDaemon))
+	}
+
+	if err := m.StopDaemons(stopped); err != nil {
+		return err
+	}
+	for _, daemon := range started {
+		if err := m.params.SyncDaemon.StartSyncDaemon(daemon); err != nil {
+			return errors.Wrapf(err, "fails to start sync daemon: %+v: ", daemon)
+		}
+	}
+	return nil
+}
+
+// Stops sync daemons.
+func (m *SyncDaemonManager) StopDaemons(daemons []*kglb_pb.IpvsSyncDaemon) error {
+	if len(daemons) == 0 {
+		return nil
+	}
+	if m.params.SyncDaemon == nil {
+		return errors.New("ipvs sync daemon module is not configured")
+	}
+	for _, daemon := range daemons {
+		if err := m.params.SyncDaemon.StopSyncDaemon(daemon.GetRole()); err != nil {
+			return errors.Wrapf(err, "fails to stop sync daemon: %+v: ", daemon)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,142 @@
+package data_plane
+
+import (
+	. "gopkg.in/check.v1"
+
+	"dropbox/kglb/common"
+	kglb_pb "dropbox/proto/kglb"
+)
+
+type SyncDaemonManagerSuite struct {
+}
+
+var _ = Suite(&SyncDaemonManagerSuite{})
+
+func (m *SyncDaemonManagerSuite) TestCompare(c *C) {
+	mng, err := NewSyncDaemonManager(SyncDaemonManagerParams{})
+	c.Assert(err, IsNil)
+
+	current := []*kglb_pb.IpvsSyncDaemon{
+		{
+			Role:       kglb_pb.IpvsSyncDaemon_MASTER,
+			Interface:  "eth0",
+			SyncId:     10,
+			McastGroup: common.NetIpToKglbAddr(defaultIpvsSyncMcastGroup),
+			McastPort:  defaultIpvsSyncMcastPort,
+			McastTtl:   defaultIpvsSyncMcastTtl,
+			SyncMaxlen: 1472,
+		},
+	}
+
+	// kernel defaults are assumed for unset attributes.
+	diff := mng.Compare(current, []*kglb_pb.IpvsSyncDaemon{
+		{
+			Role:      kglb_pb.IpvsSyncDaemon_MASTER,
+			Interface: "eth0",
+			SyncId:    10,
+		},
+	})
+	c.Assert(diff.IsChanged(), Equals, false)
+
+	diff = mng.Compare(current, []*kglb_pb.IpvsSyncDaemon{
+		{
+			Role:      kglb_pb.IpvsSyncDaemon_MASTER,
+			Interface: "eth0",
+			SyncId:    10,
+			McastPort: 8849,
+		},
+	})
+	c.Assert(diff.Changed, HasLen, 1)
+
+	diff = mng.Compare(current, []*kglb_pb.IpvsSyncDaemon{
+		{
+			Role:      kglb_pb.IpvsSyncDaemon_BACKUP,
+			Interface: "eth0",
+			SyncId:    10,
+		},
+	})
+	c.Assert(diff.Added, HasLen, 1)
+	c.Assert(diff.Deleted, HasLen, 1)
+
+	// module is required to apply changes.
+	c.Assert(mng.Apply(diff), NotNil)
+}
+
+func (m *SyncDaemonManagerSuite) TestManagerSetState(c *C) {
+	syncDaemon := NewMockIpvsSyncDaemonModuleWithState().(*MockIpvsSyncDaemonModule)
+	started := 0
+	startFunc := syncDaemon.StartSyncDaemonFunc
+	syncDaemon.StartSyncDaemonFunc = func(daemon *kglb_pb.IpvsSyncDaemon) error {
+		started++
+		return startFunc(daemon)
+	}
+
+	modules, err := GetMockModules(&ManagerModules{SyncDaemon: syncDaemon})
+	c.Assert(err, IsNil)
+	mng, err := NewManager(*modules)
+	c.Assert(err, IsNil)
+
+	master := &kglb_pb.IpvsSyncDaemon{
+		Role:      kglb_pb.IpvsSyncDaemon_MASTER,
+		Interface: "eth0",
+		SyncId:    10,
+	}
+	err = mng.SetState(&kglb_pb.DataPlaneState{
+		SyncDaemons: []*kglb_pb.IpvsSyncDaemon{master},
+	})
+	c.Assert(err, IsNil)
+	c.Assert(started, Equals, 1)
+
+	state, err := mng.GetState()
+	c.Assert(err, IsNil)
+	c.Assert(state.GetSyncDaemons(), HasLen, 1)
+	c.Assert(state.GetSyncDaemons()[0].GetRole(), Equals, kglb_pb.IpvsSyncDaemon_MASTER)
+	c.Assert(state.GetSyncDaemons()[0].GetMcastPort(), Equals, uint32(defaultIpvsSyncMcastPort))
+
+	// running daemon isn't restarted.
+	err = mng.SetState(&kglb_pb.DataPlaneState{
+		SyncDaemons: []*kglb_pb.IpvsSyncDaemon{master},
+	})
+	c.Assert(err, IsNil)
+	c.Assert(started, Equals, 1)
+
+	// switching to backup role.
+	err = mng.SetState(&kglb_pb.DataPlaneState{
+		SyncDaemons: []*kglb_pb.IpvsSyncDaemon{
+			{
+				Role:      kglb_pb.IpvsSyncDaemon_BACKUP,
+				Interface: "eth0",
+				SyncId:    10,
+			},
+		},
+	})
+	c.Assert(err, IsNil)
+	c.Assert(started, Equals, 2)
+	daemons, err := syncDaemon.ListSyncDaemons()
+	c.Assert(err, IsNil)
+	c.Assert(daemons, HasLen, 1)
+	c.Assert(daemons[0].GetRole(), Equals, kglb_pb.IpvsSyncDaemon_BACKUP)
+
+	// daemons are stopped on shutdown.
+	c.Assert(mng.Shutdown(), IsNil)
+	daemons, err = syncDaemon.ListSyncDaemons()
+	c.Assert(err, IsNil)
+	c.Assert(daemons, HasLen, 0)
+}
+
+func (m *SyncDaemonManagerSuite) TestManagerWithoutModule(c *C) {
+	modules, err := GetMockModules(nil)
+	c.Assert(err, IsNil)
+	mng, err := NewManager(*modules)
+	c.Assert(err, IsNil)
+
+	err = mng.SetState(&kglb_pb.DataPlaneState{
+		SyncDaemons: []*kglb_pb.IpvsSyncDaemon{
+			{
+				Role:      kglb_pb.IpvsSyncDaemon_MASTER,
+				Interface: "eth0",
+			},
+		},
+	})
+	c.Assert(err, ErrorMatches, "(?s).*sync daemon module is not configured.*")
+}
@@ -5,8 +5,10 @@ import (
 	"net"
 	"time"
 
+	"github.com/gogo/protobuf/proto"
 	. "gopkg.in/check.v1"
 
+	"dropbox/kglb/utils/passive_health"
 	kglb_pb "dropbox/proto/kglb"
 	. "godropbox/gocheck2"
 )
@@ -96,7 +98,8 @@ func (m *ManagerSuite) TestSimpleSetGetState(c *C) {
 								Address: &kglb_pb.IP{Address: &kglb_pb.IP_Ipv4{Ipv4: "172.0.0.1"}},
 								Port:    443,
 							}},
-						Scheduler: kglb_pb.IpvsService_RR,
+						Scheduler:          kglb_pb.IpvsService_RR,
+						PersistenceNetmask: 32,
 					}}},
 				Upstreams: []*kglb_pb.UpstreamState{
 					{
@@ -158,7 +161,8 @@ func (m *ManagerSuite) TestSimpleSetGetState(c *C) {
 								Address: &kglb_pb.IP{Address: &kglb_pb.IP_Ipv4{Ipv4: "172.0.0.1"}},
 								Port:    443,
 							}},
-						Scheduler: kglb_pb.IpvsService_RR,
+						Scheduler:          kglb_pb.IpvsService_RR,
+						PersistenceNetmask: 32,
 					}}},
 				Upstreams: []*kglb_pb.UpstreamState{
 					{
@@ -282,7 +286,8 @@ func (m *ManagerSuite) TestUpdateUpstreamWeight(c *C) {
 								Address: &kglb_pb.IP{Address: &kglb_pb.IP_Ipv4{Ipv4: "172.0.0.1"}},
 								Port:    443,
 							}},
-						Scheduler: kglb_pb.IpvsService_RR,
+						Scheduler:          kglb_pb.IpvsService_RR,
+						PersistenceNetmask: 32,
 					}}},
 				Upstreams: []*kglb_pb.UpstreamState{
 					{
@@ -497,7 +502,8 @@ func (m *ManagerSuite) TestDeleteBalancer(c *C) {
 								Address: &kglb_pb.IP{Address: &kglb_pb.IP_Ipv4{Ipv4: "172.0.0.2"}},
 								Port:    443,
 							}},
-						Scheduler: kglb_pb.IpvsService_RR,
+						Scheduler:          kglb_pb.IpvsService_RR,
+						PersistenceNetmask: 32,
 					}}},
 				Upstreams: []*kglb_pb.UpstreamState{
 					{
@@ -603,7 +609,8 @@ func (m *ManagerSuite) TestSetFwMarkBalancer(c *C) {
 								Fwmark:        10,
 							},
 						},
-						Scheduler: kglb_pb.IpvsService_RR,
+						Scheduler:          kglb_pb.IpvsService_RR,
+						PersistenceNetmask: 32,
 					}}},
 				Upstreams: []*kglb_pb.UpstreamState{
 					{
@@ -950,9 +957,11 @@ func (m *ManagerSuite) TestSetConfigurationAddingService(c *C) {
 	err = mng.SetState(state)
 	c.Assert(err, NoErr)
 
-	// check value in constructed state.
+	// check value in constructed state (unset persistence netmask is reported
+	// as full address).
 	stateCheck, err := mng.GetState()
 	c.Assert(err, IsNil)
+	state.Balancers = normalizeBalancers(state.Balancers)
 	c.Assert(stateCheck, DeepEqualsPretty, state)
 
 	// check that timeout is applied.
@@ -1081,3 +1090,251 @@ func (m *ManagerSuite) TestCustomShutdown(c *C) {
 	c.Assert(err, IsNil)
 	c.Assert(len(state), Equals, 3) // 2 custom + 127.0.0.1 default.
 }
+
+// EmitStats() feeds passive health detector with connection stats of reals.
+func (m *ManagerSuite) TestEmitStatsPassiveHealth(c *C) {
+	service := &kglb_pb.IpvsService{
+		Attributes: &kglb_pb.IpvsService_TcpAttributes{
+			TcpAttributes: &kglb_pb.IpvsTcpAttributes{
+				Address: &kglb_pb.IP{Address: &kglb_pb.IP_Ipv4{Ipv4: "172.0.0.1"}},
+				Port:    443,
+			}},
+		Scheduler: kglb_pb.IpvsService_RR,
+	}
+
+	// reals stats, the last one has most of connections in inactive state.
+	iteration := uint64(0)
+	ipvsModule := &MockIpvsModule{
+		ListServicesFunc: func() ([]*kglb_pb.IpvsService, []*kglb_pb.Stats, error) {
+			return []*kglb_pb.IpvsService{service}, []*kglb_pb.Stats{{}}, nil
+		},
+		GetRealServersFunc: func(
+			service *kglb_pb.IpvsService) ([]*kglb_pb.UpstreamState, []*kglb_pb.Stats, error) {
+
+			iteration++
+			var reals []*kglb_pb.UpstreamState
+			var stats []*kglb_pb.Stats
+			for i := 1; i <= 4; i++ {
+				reals = append(reals, &kglb_pb.UpstreamState{
+					Address: &kglb_pb.IP{
+						Address: &kglb_pb.IP_Ipv4{Ipv4: fmt.Sprintf("10.0.0.%d", i)},
+					},
+					Port:     443,
+					Hostname: fmt.Sprintf("host%d", i),
+					Weight:   100,
+				})
+				realStats := &kglb_pb.Stats{
+					ConnectionsCount: 100 * iteration,
+					BytesOutCount:    10000 * iteration,
+					ActiveConnsCount: 90,
+					InactConnsCount:  10,
+				}
+				if i == 4 {
+					realStats.ActiveConnsCount = 5
+					realStats.InactConnsCount = 95
+				}
+				stats = append(stats, realStats)
+			}
+			return reals, stats, nil
+		},
+	}
+
+	detector := passive_health.NewDetector(passive_health.DetectorParams{
+		ConsecutiveDetections: 1,
+	})
+	modules, err := GetMockModules(&ManagerModules{
+		Ipvs:          ipvsModule,
+		PassiveHealth: detector,
+	})
+	c.Assert(err, IsNil)
+	mng, err := NewManager(*modules)
+	c.Assert(err, IsNil)
+
+	// the first call only collects stats.
+	c.Assert(mng.EmitStats(), IsNil)
+	c.Assert(detector.Store().Outliers(), HasLen, 0)
+
+	c.Assert(mng.EmitStats(), IsNil)
+	c.Assert(
+		detector.Store().Outliers(),
+		DeepEquals,
+		[]string{"172.0.0.1:443-tcp/10.0.0.4:443"})
+}
+
+func (m *ManagerSuite) TestUpdateService(c *C) {
+	ipvsModule := NewMockIpvsModuleWithState().(*MockIpvsModuleWithState)
+	modules, err := GetMockModules(&ManagerModules{Ipvs: ipvsModule})
+	c.Assert(err, IsNil)
+	mng, err := NewManager(*modules)
+	c.Assert(err, IsNil)
+
+	balancer := &kglb_pb.BalancerState{
+		Name: "TestName1",
+		LbService: &kglb_pb.LoadBalancerService{Service: &kglb_pb.LoadBalancerService_IpvsService{
+			IpvsService: &kglb_pb.IpvsService{
+				Attributes: &kglb_pb.IpvsService_TcpAttributes{
+					TcpAttributes: &kglb_pb.IpvsTcpAttributes{
+						Address: &kglb_pb.IP{Address: &kglb_pb.IP_Ipv4{Ipv4: "172.0.0.1"}},
+						Port:    443,
+					}},
+				Scheduler: kglb_pb.IpvsService_RR,
+			}}},
+		Upstreams: []*kglb_pb.UpstreamState{
+			{
+				Address:       &kglb_pb.IP{Address: &kglb_pb.IP_Ipv4{Ipv4: "10.0.0.1"}},
+				Port:          443,
+				Hostname:      "hostname1",
+				Weight:        50,
+				ForwardMethod: kglb_pb.ForwardMethods_TUNNEL,
+			},
+		},
+	}
+	err = mng.SetState(&kglb_pb.DataPlaneState{
+		Balancers: []*kglb_pb.BalancerState{balancer},
+	})
+	c.Assert(err, IsNil)
+
+	// service should be updated in place instead of re-creation.
+	ipvsModule.MockIpvsModule.DeleteServiceFunc = func(service *kglb_pb.IpvsService) error {
+		c.Fatalf("unexpected deletion of service: %+v", service)
+		return nil
+	}
+	ipvsModule.MockIpvsModule.AddServiceFunc = func(service *kglb_pb.IpvsService) error {
+		c.Fatalf("unexpected creation of service: %+v", service)
+		return nil
+	}
+
+	updated := proto.Clone(balancer).(*kglb_pb.BalancerState)
+	ipvsService := updated.GetLbService().GetIpvsService()
+	ipvsService.Scheduler = kglb_pb.IpvsService_MH
+	ipvsService.Flags = []kglb_pb.IpvsService_Flag{
+		kglb_pb.IpvsService_PERSISTENT,
+		kglb_pb.IpvsService_SH_PORT,
+	}
+	ipvsService.PersistenceTimeoutS = 300
+	updated.Upstreams[0].Weight = 90
+	err = mng.SetState(&kglb_pb.DataPlaneState{
+		Balancers: []*kglb_pb.BalancerState{updated},
+	})
+	c.Assert(err, IsNil)
+
+	newState, err := mng.GetState()
+	c.Assert(err, IsNil)
+	c.Assert(newState.Balancers, HasLen, 1)
+	c.Assert(
+		newState.Balancers[0],
+		DeepEqualsPretty,
+		normalizeBalancers([]*kglb_pb.BalancerState{updated})[0])
+}
+
+func (m *ManagerSuite) TestZeroPersistenceNetmask(c *C) {
+	ipvsModule := NewMockIpvsModuleWithState().(*MockIpvsModuleWithState)
+	modules, err := GetMockModules(&ManagerModules{Ipvs: ipvsModule})
+	c.Assert(err, IsNil)
+	mng, err := NewManager(*modules)
+	c.Assert(err, IsNil)
+
+	balancer := &kglb_pb.BalancerState{
+		Name: "TestName1",
+		LbService: &kglb_pb.LoadBalancerService{Service: &kglb_pb.LoadBalancerService_IpvsService{
+			IpvsService: &kglb_pb.IpvsService{
+				Attributes: &kglb_pb.IpvsService_TcpAttributes{
+					TcpAttributes: &kglb_pb.IpvsTcpAttributes{
+						Address: &kglb_pb.IP{Address: &kglb_pb.IP_Ipv4{Ipv4: "172.0.0.1"}},
+						Port:    443,
+					}},
+				Scheduler: kglb_pb.IpvsService_RR,
+			}}},
+		Upstreams: []*kglb_pb.UpstreamState{
+			{
+				Address:       &kglb_pb.IP{Address: &kglb_pb.IP_Ipv4{Ipv4: "10.0.0.1"}},
+				Port:          443,
+				Hostname:      "hostname1",
+				Weight:        50,
+				ForwardMethod: kglb_pb.ForwardMethods_TUNNEL,
+			},
+		},
+	}
+	err = mng.SetState(&kglb_pb.DataPlaneState{
+		Balancers: []*kglb_pb.BalancerState{balancer},
+	})
+	c.Assert(err, IsNil)
+
+	// service created by older version without persistence netmask.
+	c.Assert(ipvsModule.Services, HasLen, 1)
+	ipvsModule.Services[0].service.PersistenceNetmask = 0
+
+	// it isn't changed.
+	ipvsModule.MockIpvsModule.UpdateServiceFunc = func(service *kglb_pb.IpvsService) error {
+		c.Fatalf("unexpected update of service: %+v", service)
+		return nil
+	}
+	ipvsModule.MockIpvsModule.DeleteServiceFunc = func(service *kglb_pb.IpvsService) error {
+		c.Fatalf("unexpected deletion of service: %+v", service)
+		return nil
+	}
+	ipvsModule.MockIpvsModule.AddServiceFunc = func(service *kglb_pb.IpvsService) error {
+		c.Fatalf("unexpected creation of service: %+v", service)
+		return nil
+	}
+	err = mng.SetState(&kglb_pb.DataPlaneState{
+		Balancers: []*kglb_pb.BalancerState{balancer},
+	})
+	c.Assert(err, IsNil)
+
+	// and reported with full netmask.
+	state, err := mng.GetState()
+	c.Assert(err, IsNil)
+	c.Assert(state.Balancers, HasLen, 1)
+	c.Assert(
+		state.Balancers[0].GetLbService().GetIpvsService().GetPersistenceNetmask(),
+		Equals,
+		uint32(32))
+	c.Assert(ipvsModule.Services[0].service.PersistenceNetmask, Equals, uint32(0))
+}
+
+func (m *ManagerSuite) TestUpdateBgpRoute(c *C) {
+	bgpModule := NewMockBgpModuleWithState().(*MockBgpModule)
+	modules, err := GetMockModules(&ManagerModules{Bgp: bgpModule})
+	c.Assert(err, IsNil)
+	mng, err := NewManager(*modules)
+	c.Assert(err, IsNil)
+
+	route := &kglb_pb.BgpRouteAttributes{
+		LocalAsn:  10,
+		PeerAsn:   20,
+		Community: "my_community",
+		Prefix: &kglb_pb.IP{
+			Address: &kglb_pb.IP_Ipv4{Ipv4: "10.0.0.2"},
+		},
+		Prefixlen: 32,
+	}
+	err = mng.SetState(&kglb_pb.DataPlaneState{
+		DynamicRoutes: []*kglb_pb.DynamicRoute{
+			{Attributes: &kglb_pb.DynamicRoute_BgpAttributes{BgpAttributes: route}},
+		},
+	})
+	c.Assert(err, IsNil)
+
+	// path attributes should be re-advertised in place without withdrawal.
+	bgpModule.WithdrawFunc = func(route *kglb_pb.BgpRouteAttributes) error {
+		c.Fatalf("unexpected withdrawal of route: %+v", route)
+		return nil
+	}
+
+	updated := proto.Clone(route).(*kglb_pb.BgpRouteAttributes)
+	updated.Community = "65000:200"
+	updated.Med = &kglb_pb.UInt32Value{Value: 0}
+	updated.LocalPref = &kglb_pb.UInt32Value{Value: 0}
+	err = mng.SetState(&kglb_pb.DataPlaneState{
+		DynamicRoutes: []*kglb_pb.DynamicRoute{
+			{Attributes: &kglb_pb.DynamicRoute_BgpAttributes{BgpAttributes: updated}},
+		},
+	})
+	c.Assert(err, IsNil)
+
+	paths, err := bgpModule.ListPaths()
+	c.Assert(err, IsNil)
+	c.Assert(paths, HasLen, 1)
+	c.Assert(paths[0], DeepEqualsPretty, updated)
+}
@@ -0,0 +1,183 @@
+package data_plane
+
+import (
+	"sort"
+	"sync"
+
+	"dropbox/dlog"
+	"dropbox/kglb/common"
+	kglb_pb "dropbox/proto/kglb"
+	"godropbox/errors"
+)
+
+type VrrpManagerParams struct {
+	// (Optional) states with vrrp instances are rejected without it.
+	NewTransport VrrpTransportFactory
+	// Called on transitions of instances to and from master state.
+	OnTransition func()
+}
+
+// Runs vrrp instances of the state and excludes addresses of groups where
+// the node isn't master from the state.
+type VrrpManager struct {
+	params *VrrpManagerParams
+
+	mu sync.Mutex
+	// running instances by VrrpInstanceComparable key.
+	instances map[string]*vrrpInstance
+}
+
+func NewVrrpManager(params VrrpManagerParams) (*VrrpManager, error) {
+	return &VrrpManager{
+		params:    &params,
+		instances: make(map[string]*vrrpInstance),
+	}, nil
+}
+
+// Returns configs of running instances.
+func (m *VrrpManager) State() []*kglb_pb.VrrpInstance {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var result []*kglb_pb.VrrpInstance
+	for _, instance := range m.instances {
+		result = append(result, instance.Config())
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return common.VrrpInstanceComparable.Key(result[i]) <
+			common.VrrpInstanceComparable.Key(result[j])
+	})
+	return result
+}
+
+// Starts new instances, updates changed ones and stops deleted ones.
+// Instances are restarted when their interface is changed.
+func (m *VrrpManager) SetInstances(configs []*kglb_pb.VrrpInstance) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var current []*kglb_pb.VrrpInstance
+	for _, instance := range m.instances {
+		current = append(current, instance.Config())
+	}
+	diff := common.CompareVrrpInstances(current, configs)
+	if !diff.IsChanged() {
+		return nil
+	}
+
+	var stopped, started []*kglb_pb.VrrpInstance
+	stopped = append(stopped, common.VrrpInstanceConvBack(diff.Deleted)...)
+	started = append(started, common.VrrpInstanceConvBack(diff.Added)...)
+	for _, pair := range diff.Changed {
+		oldConfig := pair.OldItem.(*kglb_pb.VrrpInstance)
+		newConfig := pair.NewItem.(*kglb_pb.VrrpInstance)
+		if oldConfig.GetInterface() != newConfig.GetInterface() {
+			stopped = append(stopped, oldConfig)
+			started = append(started, newConfig)
+			continue
+		}
+		dlog.Infof("Updating vrrp instance: %+v", newConfig)
+		m.instances[common.VrrpInstanceComparable.Key(newConfig)].Update(newConfig)
+	}
+
+	for _, config := range stopped {
+		dlog.Infof("Stopping vrrp instance: %+v", config)
+		key := common.VrrpInstanceComparable.Key(config)
+		m.instances[key].Stop()
+		delete(m.instances, key)
+	}
+
+	if len(started) > 0 && m.params.NewTransport == nil {
+		return errors.New("vrrp transport is not configured")
+	}
+	for _, config := range started {
+		dlog.Infof("Starting vrrp instance: %+v", config)
+		transport, err := m.params.NewTransport(
+			config.GetInterface(),
+			isVrrpInstanceIpv6(config))
+		if err != nil {
+			return errors.Wrapf(err, "fails to start vrrp instance: %+v: ", config)
+		}
+		instance := newVrrpInstance(config, transport, m.params.OnTransition)
+		instance.Start()
+		m.instances[common.VrrpInstanceComparable.Key(config)] = instance
+	}
+	return nil
+}
+
+// Stops all instances, master releases its groups.
+func (m *VrrpManager) Stop() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for key, instance := range m.instances {
+		instance.Stop()
+		delete(m.instances, key)
+	}
+}
+
+// Returns running instances with their states.
+func (m *VrrpManager) States() map[*kglb_pb.VrrpInstance]vrrpState {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	result := make(map[*kglb_pb.VrrpInstance]vrrpState, len(m.instances))
+	for _, instance := range m.instances {
+		result[instance.Config()] = instance.State()
+	}
+	return result
+}
+
+// Returns the state without link addresses and dynamic routes of addresses
+// of groups where the node isn't master. Node with vrrp instances runs
+// master sync daemon only when it's master of any group.
+func (m *VrrpManager) FilterState(
+	state *kglb_pb.DataPlaneState) *kglb_pb.DataPlaneState {
+
+	states := m.States()
+	if len(states) == 0 {
+		return state
+	}
+
+	isMaster := false
+	standbyAddrs := make(map[string]bool)
+	for config, vrrpState := range states {
+		if vrrpState == vrrpStateMaster {
+			isMaster = true
+			continue
+		}
+		for _, addr := range config.GetAddresses() {
+			standbyAddrs[common.KglbAddrToNetIp(addr).String()] = true
+		}
+	}
+
+	filtered := *state
+	filtered.LinkAddresses = nil
+	for _, linkAddr := range state.GetLinkAddresses() {
+		if !standbyAddrs[common.KglbAddrToNetIp(linkAddr.GetAddress()).String()] {
+			filtered.LinkAddresses = append(filtered.LinkAddresses, linkAddr)
+		}
+	}
+
+	filtered.DynamicRoutes = nil
+	for _, route := range state.GetDynamicRoutes() {
+		addr := route.GetL2Attributes().GetAddress()
+		if addr == nil {
+			addr = route.GetBgpAttributes().GetPrefix()
+		}
+		if !standbyAddrs[common.KglbAddrToNetIp(addr).String()] {
+			filtered.DynamicRoutes = append(filtered.DynamicRoutes, route)
+		}
+	}
+
+	filtered.SyncDaemons = nil
+	for _, daemon := range state.GetSyncDaemons() {
+		if daemon.GetRole() == kglb_pb.IpvsSyncDaemon_MASTER && !isMaster {
+			backup := *daemon
+			backup.Role = kglb_pb.IpvsSyncDaemon_BACKUP
+			daemon = &backup
+		}
+		filtered.SyncDaemons = append(filtered.SyncDaemons, daemon)
+	}
+	return &filtered
+}
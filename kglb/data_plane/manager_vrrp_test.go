@@ -0,0 +1,152 @@
+package data_plane
+
+import (
+	"time"
+
+	. "gopkg.in/check.v1"
+
+	kglb_pb "dropbox/proto/kglb"
+)
+
+type VrrpManagerSuite struct {
+}
+
+var _ = Suite(&VrrpManagerSuite{})
+
+func vrrpTestState(instances ...*kglb_pb.VrrpInstance) *kglb_pb.DataPlaneState {
+	return &kglb_pb.DataPlaneState{
+		LinkAddresses: []*kglb_pb.LinkAddress{
+			{
+				LinkName: "lo",
+				Address:  &kglb_pb.IP{Address: &kglb_pb.IP_Ipv4{Ipv4: "172.0.0.1"}},
+			},
+			{
+				LinkName: "lo",
+				Address:  &kglb_pb.IP{Address: &kglb_pb.IP_Ipv4{Ipv4: "172.0.0.2"}},
+			},
+		},
+		DynamicRoutes: []*kglb_pb.DynamicRoute{
+			{
+				Attributes: &kglb_pb.DynamicRoute_L2Attributes{
+					L2Attributes: &kglb_pb.L2RouteAttributes{
+						Address:   &kglb_pb.IP{Address: &kglb_pb.IP_Ipv4{Ipv4: "172.0.0.1"}},
+						Interface: "eth0",
+					},
+				},
+			},
+		},
+		SyncDaemons: []*kglb_pb.IpvsSyncDaemon{
+			{
+				Role:      kglb_pb.IpvsSyncDaemon_MASTER,
+				Interface: "eth0",
+				SyncId:    10,
+			},
+		},
+		VrrpInstances: instances,
+	}
+}
+
+func (m *VrrpManagerSuite) TestFilterState(c *C) {
+	network := newFakeVrrpNetwork()
+	// peer with higher priority keeps the node in backup state.
+	peer := newVrrpInstance(testVrrpInstance(200), network.NewTransport("10.0.0.2"), nil)
+	peer.Start()
+
+	mng, err := NewVrrpManager(VrrpManagerParams{
+		NewTransport: func(iface string, ipv6 bool) (VrrpTransport, error) {
+			return network.NewTransport("10.0.0.1"), nil
+		},
+	})
+	c.Assert(err, IsNil)
+	defer mng.Stop()
+
+	// state without instances is not filtered.
+	state := vrrpTestState()
+	c.Assert(mng.FilterState(state), Equals, state)
+
+	state = vrrpTestState(testVrrpInstance(100))
+	c.Assert(mng.SetInstances(state.GetVrrpInstances()), IsNil)
+	c.Assert(mng.State(), HasLen, 1)
+
+	filtered := mng.FilterState(state)
+	c.Assert(filtered.GetLinkAddresses(), HasLen, 1)
+	c.Assert(
+		filtered.GetLinkAddresses()[0].GetAddress().GetIpv4(),
+		Equals,
+		"172.0.0.2")
+	c.Assert(filtered.GetDynamicRoutes(), HasLen, 0)
+	c.Assert(filtered.GetSyncDaemons(), HasLen, 1)
+	c.Assert(
+		filtered.GetSyncDaemons()[0].GetRole(),
+		Equals,
+		kglb_pb.IpvsSyncDaemon_BACKUP)
+	// original state is untouched.
+	c.Assert(state.GetLinkAddresses(), HasLen, 2)
+	c.Assert(
+		state.GetSyncDaemons()[0].GetRole(),
+		Equals,
+		kglb_pb.IpvsSyncDaemon_MASTER)
+
+	// node becomes master once the peer releases the group.
+	peer.Stop()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		filtered = mng.FilterState(state)
+		if len(filtered.GetLinkAddresses()) == 2 {
+			break
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	c.Assert(filtered.GetLinkAddresses(), HasLen, 2)
+	c.Assert(filtered.GetDynamicRoutes(), HasLen, 1)
+	c.Assert(
+		filtered.GetSyncDaemons()[0].GetRole(),
+		Equals,
+		kglb_pb.IpvsSyncDaemon_MASTER)
+
+	// deleted instances are stopped.
+	c.Assert(mng.SetInstances(nil), IsNil)
+	c.Assert(mng.State(), HasLen, 0)
+}
+
+func (m *VrrpManagerSuite) TestManagerSetState(c *C) {
+	network := newFakeVrrpNetwork()
+	modules, err := GetMockModules(&ManagerModules{
+		VrrpTransport: func(iface string, ipv6 bool) (VrrpTransport, error) {
+			return network.NewTransport("10.0.0.1"), nil
+		},
+	})
+	c.Assert(err, IsNil)
+	mng, err := NewManager(*modules)
+	c.Assert(err, IsNil)
+	defer mng.Shutdown()
+
+	state := vrrpTestState(testVrrpInstance(100))
+	state.DynamicRoutes = nil
+	state.SyncDaemons = nil
+	c.Assert(mng.SetState(state), IsNil)
+
+	// addresses of the group are added after transition to master state,
+	// state is read through manager since it's reapplied concurrently.
+	linkAddresses := func() int {
+		current, err := mng.GetState()
+		c.Assert(err, IsNil)
+		c.Assert(current.GetVrrpInstances(), HasLen, 1)
+		return len(current.GetLinkAddresses())
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for linkAddresses() != 2 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	c.Assert(linkAddresses(), Equals, 2)
+}
+
+func (m *VrrpManagerSuite) TestMissingTransport(c *C) {
+	modules, err := GetMockModules(nil)
+	c.Assert(err, IsNil)
+	mng, err := NewManager(*modules)
+	c.Assert(err, IsNil)
+
+	err = mng.SetState(vrrpTestState(testVrrpInstance(100)))
+	c.Assert(err, ErrorMatches, "(?s).*vrrp transport is not configured.*")
+}
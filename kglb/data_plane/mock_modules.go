@@ -102,6 +102,8 @@ type MockIpvsModule struct {
 	AddServiceFunc func(service *kglb_pb.IpvsService) error
 	// Delete ipvs service.
 	DeleteServiceFunc func(service *kglb_pb.IpvsService) error
+	// Update ipvs service.
+	UpdateServiceFunc func(service *kglb_pb.IpvsService) error
 	// Get list of existent ipvs Services.
 	ListServicesFunc func() ([]*kglb_pb.IpvsService, []*kglb_pb.Stats, error)
 
@@ -131,6 +133,14 @@ func (m *MockIpvsModule) DeleteService(service *kglb_pb.IpvsService) error {
 	return notImplErr
 }
 
+// Update ipvs service.
+func (m *MockIpvsModule) UpdateService(service *kglb_pb.IpvsService) error {
+	if m.UpdateServiceFunc != nil {
+		return m.UpdateServiceFunc(service)
+	}
+	return notImplErr
+}
+
 // Get list of existent ipvs Services.
 func (m *MockIpvsModule) ListServices() ([]*kglb_pb.IpvsService, []*kglb_pb.Stats, error) {
 	if m.ListServicesFunc != nil {
@@ -192,3 +202,74 @@ func (m *MockIpvsModule) GetRealServers(
 }
 
 var _ IpvsModule = &MockIpvsModule{}
+
+// ConnCleanupModule.
+type MockConnCleanupModule struct {
+	FlushConntrackFunc func(service *kglb_pb.IpvsService, dsts []*kglb_pb.UpstreamState) (uint, error)
+}
+
+func (m *MockConnCleanupModule) FlushConntrack(
+	service *kglb_pb.IpvsService,
+	dsts []*kglb_pb.UpstreamState) (uint, error) {
+
+	if m.FlushConntrackFunc != nil {
+		return m.FlushConntrackFunc(service, dsts)
+	}
+	return 0, notImplErr
+}
+
+// IpvsSyncDaemonModule.
+type MockIpvsSyncDaemonModule struct {
+	StartSyncDaemonFunc func(daemon *kglb_pb.IpvsSyncDaemon) error
+	StopSyncDaemonFunc  func(role kglb_pb.IpvsSyncDaemon_Role) error
+	ListSyncDaemonsFunc func() ([]*kglb_pb.IpvsSyncDaemon, error)
+}
+
+func (m *MockIpvsSyncDaemonModule) StartSyncDaemon(daemon *kglb_pb.IpvsSyncDaemon) error {
+	if m.StartSyncDaemonFunc != nil {
+		return m.StartSyncDaemonFunc(daemon)
+	}
+	return notImplErr
+}
+
+func (m *MockIpvsSyncDaemonModule) StopSyncDaemon(role kglb_pb.IpvsSyncDaemon_Role) error {
+	if m.StopSyncDaemonFunc != nil {
+		return m.StopSyncDaemonFunc(role)
+	}
+	return notImplErr
+}
+
+func (m *MockIpvsSyncDaemonModule) ListSyncDaemons() ([]*kglb_pb.IpvsSyncDaemon, error) {
+	if m.ListSyncDaemonsFunc != nil {
+		return m.ListSyncDaemonsFunc()
+	}
+	return nil, notImplErr
+}
+
+// L2Module.
+type MockL2Module struct {
+	AdvertiseFunc         func(config *kglb_pb.L2RouteAttributes) error
+	WithdrawFunc          func(config *kglb_pb.L2RouteAttributes) error
+	ListAnnouncementsFunc func() ([]*kglb_pb.L2RouteAttributes, error)
+}
+
+func (m *MockL2Module) Advertise(config *kglb_pb.L2RouteAttributes) error {
+	if m.AdvertiseFunc != nil {
+		return m.AdvertiseFunc(config)
+	}
+	return notImplErr
+}
+
+func (m *MockL2Module) Withdraw(config *kglb_pb.L2RouteAttributes) error {
+	if m.WithdrawFunc != nil {
+		return m.WithdrawFunc(config)
+	}
+	return notImplErr
+}
+
+func (m *MockL2Module) ListAnnouncements() ([]*kglb_pb.L2RouteAttributes, error) {
+	if m.ListAnnouncementsFunc != nil {
+		return m.ListAnnouncementsFunc()
+	}
+	return nil, notImplErr
+}
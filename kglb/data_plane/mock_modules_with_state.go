@@ -14,6 +14,9 @@ var _ BgpModule = NewMockBgpModuleWithState()
 var _ BgpModule = NewEmptyBgpModuleWithState()
 var _ IpvsModule = NewMockIpvsModuleWithState()
 var _ AddressTableModule = NewMockAddressTableWithState()
+var _ ConnCleanupModule = NewMockConnCleanupModuleWithState()
+var _ IpvsSyncDaemonModule = NewMockIpvsSyncDaemonModuleWithState()
+var _ L2Module = NewMockL2ModuleWithState()
 
 // BGP unfunctional modules.
 func NewEmptyBgpModuleWithState() BgpModule {
@@ -45,12 +48,18 @@ func NewMockBgpModuleWithState() BgpModule {
 			return nil
 		},
 		AdvertiseFunc: func(bgpConfig *kglb_pb.BgpRouteAttributes) error {
-			for _, cfg := range bgpConfigsState {
+			for i, cfg := range bgpConfigsState {
 				if common.BgpRoutingAttributesComparable.Equal(cfg, bgpConfig) {
 					return fmt.Errorf(
 						"Bgp route already advertised: %+v",
 						bgpConfig)
 				}
+				// path attributes are replaced in place.
+				if common.BgpRoutingAttributesComparable.Key(cfg) ==
+					common.BgpRoutingAttributesComparable.Key(bgpConfig) {
+					bgpConfigsState[i] = bgpConfig
+					return nil
+				}
 			}
 			bgpConfigsState = append(bgpConfigsState, bgpConfig)
 			return nil
@@ -113,6 +122,25 @@ func NewMockIpvsModuleWithState() IpvsModule {
 			}
 			return fmt.Errorf("no service")
 		},
+		UpdateServiceFunc: func(service *kglb_pb.IpvsService) error {
+			key, err := common.GetKeyFromIpvsService(service)
+			if err != nil {
+				return err
+			}
+			m.mu.Lock()
+			defer m.mu.Unlock()
+			for _, configuredService := range m.Services {
+				configuredKey, err := common.GetKeyFromIpvsService(configuredService.service)
+				if err != nil {
+					return err
+				}
+				if configuredKey == key {
+					configuredService.service = service
+					return nil
+				}
+			}
+			return fmt.Errorf("no service")
+		},
 		// Get list of existent ipvs Services.
 		ListServicesFunc: func() ([]*kglb_pb.IpvsService, []*kglb_pb.Stats, error) {
 			m.mu.Lock()
@@ -155,9 +183,14 @@ func NewMockIpvsModuleWithState() IpvsModule {
 				return fmt.Errorf("no service")
 			}
 
+			// destinations are identified by address and port, so thresholds
+			// are updated along with weight.
+			dstKey := common.UpstreamStateComparable.Key(dst)
 			for _, real := range cService.reals {
-				if common.UpstreamsEqual(real, dst) {
+				if common.UpstreamStateComparable.Key(real) == dstKey {
 					real.Weight = dst.Weight
+					real.UThreshold = dst.UThreshold
+					real.LThreshold = dst.LThreshold
 				}
 			}
 			return nil
@@ -251,3 +284,122 @@ func NewMockAddressTableWithState() AddressTableModule {
 		},
 	}
 }
+
+// ConnCleanupModule recording hostnames of flushed destinations.
+type MockConnCleanupModuleWithState struct {
+	MockConnCleanupModule
+
+	mu sync.Mutex
+	// number of FlushConntrack calls.
+	FlushCnt int
+	// hostnames of destinations with flushed conntrack entries.
+	ConntrackFlushed []string
+}
+
+func NewMockConnCleanupModuleWithState() *MockConnCleanupModuleWithState {
+	m := &MockConnCleanupModuleWithState{}
+	m.MockConnCleanupModule = MockConnCleanupModule{
+		FlushConntrackFunc: func(
+			service *kglb_pb.IpvsService,
+			dsts []*kglb_pb.UpstreamState) (uint, error) {
+
+			m.mu.Lock()
+			defer m.mu.Unlock()
+			m.FlushCnt++
+			for _, dst := range dsts {
+				m.ConntrackFlushed = append(m.ConntrackFlushed, dst.GetHostname())
+			}
+			return uint(len(dsts)), nil
+		},
+	}
+	return m
+}
+
+// IpvsSyncDaemonModule keeping running daemons, kernel defaults are filled
+// in as kernel does.
+func NewMockIpvsSyncDaemonModuleWithState() IpvsSyncDaemonModule {
+	var mu sync.Mutex
+	daemons := make(map[kglb_pb.IpvsSyncDaemon_Role]*kglb_pb.IpvsSyncDaemon)
+
+	return &MockIpvsSyncDaemonModule{
+		StartSyncDaemonFunc: func(daemon *kglb_pb.IpvsSyncDaemon) error {
+			mu.Lock()
+			defer mu.Unlock()
+			if _, ok := daemons[daemon.GetRole()]; ok {
+				return fmt.Errorf("sync daemon already runs: %s", daemon.GetRole())
+			}
+			running := *daemon
+			if running.McastGroup == nil {
+				running.McastGroup = common.NetIpToKglbAddr(defaultIpvsSyncMcastGroup)
+			}
+			if running.McastPort == 0 {
+				running.McastPort = defaultIpvsSyncMcastPort
+			}
+			if running.McastTtl == 0 {
+				running.McastTtl = defaultIpvsSyncMcastTtl
+			}
+			if running.SyncMaxlen == 0 {
+				running.SyncMaxlen = 1472
+			}
+			daemons[daemon.GetRole()] = &running
+			return nil
+		},
+		StopSyncDaemonFunc: func(role kglb_pb.IpvsSyncDaemon_Role) error {
+			mu.Lock()
+			defer mu.Unlock()
+			if _, ok := daemons[role]; !ok {
+				return fmt.Errorf("sync daemon doesn't run: %s", role)
+			}
+			delete(daemons, role)
+			return nil
+		},
+		ListSyncDaemonsFunc: func() ([]*kglb_pb.IpvsSyncDaemon, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			var result []*kglb_pb.IpvsSyncDaemon
+			for _, daemon := range daemons {
+				result = append(result, daemon)
+			}
+			sort.Slice(result, func(i, j int) bool {
+				return result[i].GetRole() < result[j].GetRole()
+			})
+			return result, nil
+		},
+	}
+}
+
+// L2 module maintaining fake state.
+func NewMockL2ModuleWithState() L2Module {
+	var mu sync.Mutex
+	var announcements []*kglb_pb.L2RouteAttributes
+
+	return &MockL2Module{
+		AdvertiseFunc: func(config *kglb_pb.L2RouteAttributes) error {
+			mu.Lock()
+			defer mu.Unlock()
+			for _, cfg := range announcements {
+				if common.L2RouteAttributesComparable.Equal(cfg, config) {
+					return fmt.Errorf("l2 route already advertised: %+v", config)
+				}
+			}
+			announcements = append(announcements, config)
+			return nil
+		},
+		WithdrawFunc: func(config *kglb_pb.L2RouteAttributes) error {
+			mu.Lock()
+			defer mu.Unlock()
+			for i, cfg := range announcements {
+				if common.L2RouteAttributesComparable.Equal(cfg, config) {
+					announcements = append(announcements[:i], announcements[i+1:]...)
+					return nil
+				}
+			}
+			return fmt.Errorf("l2 route not found: %+v", config)
+		},
+		ListAnnouncementsFunc: func() ([]*kglb_pb.L2RouteAttributes, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			return append([]*kglb_pb.L2RouteAttributes(nil), announcements...), nil
+		},
+	}
+}
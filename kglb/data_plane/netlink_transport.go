@@ -0,0 +1,86 @@
+package data_plane
+
+import (
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+
+	"godropbox/errors"
+)
+
+const (
+	// size of receive buffer, it's enough for the largest dump message
+	// allocated by kernel.
+	netlinkReceiveBufferSize = 64 * 1024
+
+	// default timeout of netlink socket operations.
+	defaultNetlinkTimeout = 10 * time.Second
+)
+
+// Transport of serialized netlink messages. It's abstracted to replay recorded
+// messages in tests.
+type netlinkTransport interface {
+	// Sends serialized messages by single write.
+	Send(buf []byte) error
+	// Receives messages of single read.
+	Receive() ([]syscall.NetlinkMessage, error)
+	// Closes the transport.
+	Close() error
+}
+
+// Netlink socket of specific protocol.
+type netlinkSocket struct {
+	fd int
+}
+
+// Returns new socket of provided netlink protocol, send and receive
+// operations fail after timeout.
+func newNetlinkSocket(protocol int, timeout time.Duration) (*netlinkSocket, error) {
+	fd, err := unix.Socket(
+		unix.AF_NETLINK,
+		unix.SOCK_RAW|unix.SOCK_CLOEXEC,
+		protocol)
+	if err != nil {
+		return nil, errors.Wrap(err, "fails to create netlink socket: ")
+	}
+
+	if err := unix.Bind(fd, &unix.SockaddrNetlink{Family: unix.AF_NETLINK}); err != nil {
+		unix.Close(fd)
+		return nil, errors.Wrap(err, "fails to bind netlink socket: ")
+	}
+
+	tv := unix.NsecToTimeval(timeout.Nanoseconds())
+	for _, opt := range []int{unix.SO_RCVTIMEO, unix.SO_SNDTIMEO} {
+		if err := unix.SetsockoptTimeval(fd, unix.SOL_SOCKET, opt, &tv); err != nil {
+			unix.Close(fd)
+			return nil, errors.Wrap(err, "fails to set netlink socket timeout: ")
+		}
+	}
+
+	// error acks contain header of failed request only, it's optional since
+	// older kernels don't support it.
+	unix.SetsockoptInt(fd, unix.SOL_NETLINK, unix.NETLINK_CAP_ACK, 1)
+
+	return &netlinkSocket{fd: fd}, nil
+}
+
+func (s *netlinkSocket) Send(buf []byte) error {
+	return unix.Sendto(s.fd, buf, 0, &unix.SockaddrNetlink{Family: unix.AF_NETLINK})
+}
+
+func (s *netlinkSocket) Receive() ([]syscall.NetlinkMessage, error) {
+	buf := make([]byte, netlinkReceiveBufferSize)
+	n, _, err := unix.Recvfrom(s.fd, buf, 0)
+	if err != nil {
+		return nil, err
+	}
+	if n < unix.NLMSG_HDRLEN {
+		return nil, errors.Newf("short netlink message: %d bytes", n)
+	}
+	return syscall.ParseNetlinkMessage(buf[:n])
+}
+
+func (s *netlinkSocket) Close() error {
+	return unix.Close(s.fd)
+}
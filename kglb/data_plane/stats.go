@@ -19,6 +19,30 @@ var ManagerStateAgeSec = v2stats.MustDefineGauge("kglb/data_plane/manager_state_
 // - state: [alive, add_failed, delete_failed]
 var linkAddressGauge = v2stats.MustDefineGauge("kglb/data_plane/link_address", "address", "state")
 
+// Kernel settings which differ from desired ones.
+// Tags:
+// - setting: name of kernel parameter, e.g. "net.ipv4.vs.conntrack"
+var kernelSettingsDriftGauge = v2stats.MustDefineGauge("kglb/data_plane/kernel_settings_drift", "setting")
+
+// Connection cleanup of deleted and zero weighted upstreams.
+// Tags:
+// - name: balancer name
+// - result: [flushed, failed, rate_limited]
+var connCleanupCounter = v2stats.MustDefineCounter("kglb/data_plane/conn_cleanup", "name", "result")
+
+// Running IPVS connection synchronization daemons.
+// Tags:
+// - role: [master, backup]
+// - interface: interface of multicast sync messages
+var syncDaemonGauge = v2stats.MustDefineGauge("kglb/data_plane/ipvs_sync_daemon", "role", "interface")
+
+// States of vrrp instances.
+// Tags:
+// - vrid: virtual router id
+// - interface: interface of advertisements
+// - state: [master, backup]
+var vrrpStateGauge = v2stats.MustDefineGauge("kglb/data_plane/vrrp_state", "vrid", "interface", "state")
+
 // Per Service stats //
 //
 // bytes received / sent
@@ -54,7 +78,21 @@ var upstreamPacketsCounter = v2stats.MustDefineCounter("kglb/data_plane/upstream
 // - state [established, not_established]
 var bgpSessionStateGauge = v2stats.MustDefineGauge("kglb/data_plane/bgp_session", "state")
 
+// BGP session state of the peer (Bgp modules implementing
+// BgpPeerStatesModule only).
+// Tags:
+// - peer - peer address
+// - state [established, not_established]
+var bgpPeerSessionStateGauge = v2stats.MustDefineGauge(
+	"kglb/data_plane/bgp_peer_session", "peer", "state")
+
 // BGP routes state.
 // Tags:
 // - route - advertised IP CIDR, e.g. 162.125.248.1/32
 var bgpRouteGauge = v2stats.MustDefineGauge("kglb/data_plane/bgp_route", "route")
+
+// L2 announced addresses.
+// Tags:
+// - address - announced IP, e.g. 162.125.248.1
+// - interface - interface of announcements
+var l2RouteGauge = v2stats.MustDefineGauge("kglb/data_plane/l2_route", "address", "interface")
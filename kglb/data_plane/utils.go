@@ -1,9 +1,13 @@
 package data_plane
 
 import (
+	"encoding/binary"
 	"fmt"
+	"net"
 	"strings"
 
+	"github.com/gogo/protobuf/proto"
+
 	"dropbox/kglb/common"
 	kglb_pb "dropbox/proto/kglb"
 )
@@ -27,6 +31,59 @@ func getBgpRouteName(route *kglb_pb.BgpRouteAttributes) string {
 		" ", "_", -1)
 }
 
+// Returns balancers with ipvs services normalized into form reported by
+// ipvs module, so unset defaults aren't treated as change of the service.
+// Health of upstreams isn't kept by the kernel, so it's cleared as well.
+func normalizeBalancers(balancers []*kglb_pb.BalancerState) []*kglb_pb.BalancerState {
+	result := make([]*kglb_pb.BalancerState, len(balancers))
+	for i, balancer := range balancers {
+		normalized := *balancer
+		if ipvsService := balancer.GetLbService().GetIpvsService(); ipvsService != nil {
+			normalized.LbService = &kglb_pb.LoadBalancerService{
+				Service: &kglb_pb.LoadBalancerService_IpvsService{
+					IpvsService: common.NormalizeIpvsService(ipvsService),
+				},
+			}
+		}
+		normalized.Upstreams = make([]*kglb_pb.UpstreamState, len(balancer.GetUpstreams()))
+		for j, upstream := range balancer.GetUpstreams() {
+			if upstream.GetUnhealthy() {
+				upstream = proto.Clone(upstream).(*kglb_pb.UpstreamState)
+				upstream.Unhealthy = false
+			}
+			normalized.Upstreams[j] = upstream
+		}
+		result[i] = &normalized
+	}
+	return result
+}
+
+// Returns balancers without unhealthy zero weighted upstreams of balancers
+// with ConnectionCleanup.FlushIpvs, they are deleted from IPVS to expire their
+// connection entries. Draining upstreams keep their connections.
+func excludeFlushedUpstreams(balancers []*kglb_pb.BalancerState) []*kglb_pb.BalancerState {
+	result := make([]*kglb_pb.BalancerState, len(balancers))
+	for i, balancer := range balancers {
+		result[i] = balancer
+		if !balancer.GetConnectionCleanup().GetFlushIpvs() {
+			continue
+		}
+		filtered := *balancer
+		filtered.Upstreams = make([]*kglb_pb.UpstreamState, 0, len(balancer.GetUpstreams()))
+		for _, upstream := range balancer.GetUpstreams() {
+			if upstream.GetUnhealthy() &&
+				upstream.GetWeight() == 0 &&
+				!upstream.GetDraining() {
+
+				continue
+			}
+			filtered.Upstreams = append(filtered.Upstreams, upstream)
+		}
+		result[i] = &filtered
+	}
+	return result
+}
+
 // returns either Hostname or IP address.
 func getUpstreamHostname(dst *kglb_pb.UpstreamState) string {
 	if len(dst.Hostname) > 0 {
@@ -42,3 +99,31 @@ func getUpstreamHostname(dst *kglb_pb.UpstreamState) string {
 	// if no hostname present - return IP
 	return fmt.Sprintf("%v", common.KglbAddrToNetIp(dst.Address))
 }
+
+// Returns internet checksum of the message of upper-layer protocol including
+// IPv4 or IPv6 pseudo-header, both of them sum up to the same value.
+func pseudoHeaderChecksum(src, dst net.IP, proto int, msg []byte) uint16 {
+	if src4, dst4 := src.To4(), dst.To4(); src4 != nil && dst4 != nil {
+		src, dst = src4, dst4
+	}
+
+	var sum uint32
+	add := func(b []byte) {
+		for i := 0; i+1 < len(b); i += 2 {
+			sum += uint32(binary.BigEndian.Uint16(b[i : i+2]))
+		}
+		if len(b)%2 == 1 {
+			sum += uint32(b[len(b)-1]) << 8
+		}
+	}
+	add(src)
+	add(dst)
+	sum += uint32(len(msg))
+	sum += uint32(proto)
+	add(msg)
+
+	for sum>>16 != 0 {
+		sum = (sum & 0xffff) + (sum >> 16)
+	}
+	return ^uint16(sum)
+}
@@ -0,0 +1,412 @@
+package data_plane
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"sync"
+	"time"
+
+	"dropbox/dlog"
+	"dropbox/exclog"
+	"dropbox/kglb/common"
+	kglb_pb "dropbox/proto/kglb"
+	"godropbox/errors"
+)
+
+// VRRPv3 (RFC 5798) constants.
+const (
+	vrrpProto             = 112
+	vrrpVersion           = 3
+	vrrpTypeAdvertisement = 1
+	vrrpHeaderLen         = 8
+	// ttl (hop limit) of advertisements, packets with other ttl are dropped.
+	vrrpTtl = 255
+	// priority of master releasing the group.
+	vrrpPriorityRelease = 0
+
+	defaultVrrpAdvertInterval = time.Second
+)
+
+var (
+	vrrpGroupIpv4 = net.IPv4(224, 0, 0, 18)
+	vrrpGroupIpv6 = net.ParseIP("ff02::12")
+)
+
+func vrrpGroup(ipv6 bool) net.IP {
+	if ipv6 {
+		return vrrpGroupIpv6
+	}
+	return vrrpGroupIpv4
+}
+
+// VRRP advertisement.
+type vrrpAdvertisement struct {
+	vrid     uint8
+	priority uint8
+	// max advertisement interval, it's sent in centiseconds.
+	interval  time.Duration
+	addresses []net.IP
+}
+
+// Serializes advertisement, checksum covers pseudo-header with the source
+// and VRRP multicast group.
+func (a *vrrpAdvertisement) marshal(src net.IP, ipv6 bool) []byte {
+	addrLen := net.IPv4len
+	if ipv6 {
+		addrLen = net.IPv6len
+	}
+
+	packet := make([]byte, vrrpHeaderLen+addrLen*len(a.addresses))
+	packet[0] = vrrpVersion<<4 | vrrpTypeAdvertisement
+	packet[1] = a.vrid
+	packet[2] = a.priority
+	packet[3] = uint8(len(a.addresses))
+	// 4 bits are reserved.
+	binary.BigEndian.PutUint16(packet[4:6], uint16(a.interval/(10*time.Millisecond))&0xfff)
+	for i, addr := range a.addresses {
+		offset := vrrpHeaderLen + i*addrLen
+		if ipv6 {
+			copy(packet[offset:offset+addrLen], addr.To16())
+		} else {
+			copy(packet[offset:offset+addrLen], addr.To4())
+		}
+	}
+	binary.BigEndian.PutUint16(
+		packet[6:8],
+		pseudoHeaderChecksum(src, vrrpGroup(ipv6), vrrpProto, packet))
+	return packet
+}
+
+// Parses and verifies advertisement received from the source.
+func parseVrrpAdvertisement(
+	packet []byte,
+	src net.IP,
+	ipv6 bool) (*vrrpAdvertisement, error) {
+
+	if len(packet) < vrrpHeaderLen {
+		return nil, errors.Newf("short vrrp packet: %d bytes", len(packet))
+	}
+	if packet[0] != vrrpVersion<<4|vrrpTypeAdvertisement {
+		return nil, errors.Newf("unsupported vrrp version or type: %#x", packet[0])
+	}
+	if pseudoHeaderChecksum(src, vrrpGroup(ipv6), vrrpProto, packet) != 0 {
+		return nil, errors.Newf("invalid vrrp checksum from %s", src)
+	}
+
+	addrLen := net.IPv4len
+	if ipv6 {
+		addrLen = net.IPv6len
+	}
+	count := int(packet[3])
+	if len(packet) < vrrpHeaderLen+count*addrLen {
+		return nil, errors.Newf(
+			"vrrp packet is shorter than %d addresses: %d bytes",
+			count,
+			len(packet))
+	}
+
+	adv := &vrrpAdvertisement{
+		vrid:     packet[1],
+		priority: packet[2],
+		interval: time.Duration(binary.BigEndian.Uint16(packet[4:6])&0xfff) *
+			10 * time.Millisecond,
+		addresses: make([]net.IP, count),
+	}
+	for i := range adv.addresses {
+		offset := vrrpHeaderLen + i*addrLen
+		adv.addresses[i] = net.IP(append([]byte(nil), packet[offset:offset+addrLen]...))
+	}
+	return adv, nil
+}
+
+type vrrpState int
+
+const (
+	vrrpStateInit vrrpState = iota
+	vrrpStateBackup
+	vrrpStateMaster
+)
+
+func (s vrrpState) String() string {
+	switch s {
+	case vrrpStateBackup:
+		return "backup"
+	case vrrpStateMaster:
+		return "master"
+	default:
+		return "init"
+	}
+}
+
+// Advertisement received by transport.
+type vrrpPacket struct {
+	packet []byte
+	src    net.IP
+	ttl    int
+}
+
+// VRRP instance running state machine of single virtual router. The node
+// never owns the addresses, so it starts in backup state.
+type vrrpInstance struct {
+	transport VrrpTransport
+	ipv6      bool
+	// called on transitions to and from master state.
+	onTransition func()
+
+	mu     sync.Mutex
+	config *kglb_pb.VrrpInstance
+	state  vrrpState
+
+	// advertisement interval of current master.
+	masterInterval time.Duration
+
+	updates chan struct{}
+	packets chan *vrrpPacket
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+func newVrrpInstance(
+	config *kglb_pb.VrrpInstance,
+	transport VrrpTransport,
+	onTransition func()) *vrrpInstance {
+
+	return &vrrpInstance{
+		transport:    transport,
+		ipv6:         isVrrpInstanceIpv6(config),
+		onTransition: onTransition,
+		config:       config,
+		updates:      make(chan struct{}, 1),
+		packets:      make(chan *vrrpPacket),
+		stop:         make(chan struct{}),
+		done:         make(chan struct{}),
+	}
+}
+
+func isVrrpInstanceIpv6(config *kglb_pb.VrrpInstance) bool {
+	return len(config.GetAddresses()) > 0 && config.GetAddresses()[0].GetIpv6() != ""
+}
+
+// Starts the state machine.
+func (v *vrrpInstance) Start() {
+	go v.receiveLoop()
+	go v.run()
+}
+
+// Stops the state machine, master releases the group by advertisement with
+// zero priority.
+func (v *vrrpInstance) Stop() {
+	close(v.stop)
+	<-v.done
+	if err := v.transport.Close(); err != nil {
+		exclog.Report(
+			errors.Wrap(err, "fails to close vrrp transport: "),
+			exclog.Noncritical, "")
+	}
+}
+
+// Updates attributes of running instance, interface and family of the
+// addresses are not expected to change.
+func (v *vrrpInstance) Update(config *kglb_pb.VrrpInstance) {
+	v.mu.Lock()
+	v.config = config
+	v.mu.Unlock()
+
+	select {
+	case v.updates <- struct{}{}:
+	default:
+	}
+}
+
+func (v *vrrpInstance) Config() *kglb_pb.VrrpInstance {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.config
+}
+
+func (v *vrrpInstance) State() vrrpState {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.state
+}
+
+func (v *vrrpInstance) IsMaster() bool {
+	return v.State() == vrrpStateMaster
+}
+
+func (v *vrrpInstance) receiveLoop() {
+	for {
+		packet, src, ttl, err := v.transport.Receive()
+		if err != nil {
+			select {
+			case <-v.stop:
+				return
+			default:
+			}
+			exclog.Report(
+				errors.Wrap(err, "fails to receive vrrp advertisement: "),
+				exclog.Noncritical, "")
+			time.Sleep(time.Second)
+			continue
+		}
+
+		select {
+		case v.packets <- &vrrpPacket{packet: packet, src: src, ttl: ttl}:
+		case <-v.stop:
+			return
+		}
+	}
+}
+
+func (v *vrrpInstance) run() {
+	defer close(v.done)
+
+	interval := v.advertInterval()
+	v.mu.Lock()
+	v.masterInterval = interval
+	v.mu.Unlock()
+	v.setState(vrrpStateBackup)
+	timer := time.NewTimer(v.masterDownInterval())
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-v.stop:
+			if v.State() == vrrpStateMaster {
+				v.sendAdvertisement(vrrpPriorityRelease)
+			}
+			v.setState(vrrpStateInit)
+			return
+		case <-v.updates:
+			// new priority is advertised immediately.
+			if v.State() == vrrpStateMaster {
+				v.sendAdvertisement(v.priority())
+				resetTimer(timer, v.advertInterval())
+			}
+		case packet := <-v.packets:
+			v.handlePacket(packet, timer)
+		case <-timer.C:
+			if v.State() == vrrpStateBackup {
+				v.setState(vrrpStateMaster)
+			}
+			v.sendAdvertisement(v.priority())
+			timer.Reset(v.advertInterval())
+		}
+	}
+}
+
+func (v *vrrpInstance) handlePacket(packet *vrrpPacket, timer *time.Timer) {
+	if packet.ttl != vrrpTtl {
+		dlog.Infof("vrrp advertisement with invalid ttl: %s, %d", packet.src, packet.ttl)
+		return
+	}
+	adv, err := parseVrrpAdvertisement(packet.packet, packet.src, v.ipv6)
+	if err != nil {
+		dlog.Infof("invalid vrrp advertisement: %v", err)
+		return
+	}
+	if uint32(adv.vrid) != v.Config().GetVrid() {
+		return
+	}
+
+	priority := v.priority()
+	switch v.State() {
+	case vrrpStateBackup:
+		if adv.priority == vrrpPriorityRelease {
+			resetTimer(timer, v.skewTime())
+		} else if v.Config().GetNopreempt() || adv.priority >= priority {
+			v.mu.Lock()
+			v.masterInterval = adv.interval
+			v.mu.Unlock()
+			resetTimer(timer, v.masterDownInterval())
+		}
+		// master with lower priority is preempted when down timer fires.
+	case vrrpStateMaster:
+		if adv.priority == vrrpPriorityRelease {
+			v.sendAdvertisement(priority)
+			resetTimer(timer, v.advertInterval())
+		} else if adv.priority > priority ||
+			(adv.priority == priority &&
+				bytes.Compare(packet.src.To16(), v.transport.LocalAddr().To16()) > 0) {
+
+			v.mu.Lock()
+			v.masterInterval = adv.interval
+			v.mu.Unlock()
+			v.setState(vrrpStateBackup)
+			resetTimer(timer, v.masterDownInterval())
+		}
+	}
+}
+
+func (v *vrrpInstance) setState(state vrrpState) {
+	v.mu.Lock()
+	prev := v.state
+	v.state = state
+	vrid := v.config.GetVrid()
+	v.mu.Unlock()
+
+	if prev == state {
+		return
+	}
+	dlog.Infof("vrrp instance %d: %s -> %s", vrid, prev, state)
+	if (prev == vrrpStateMaster || state == vrrpStateMaster) && v.onTransition != nil {
+		v.onTransition()
+	}
+}
+
+func (v *vrrpInstance) sendAdvertisement(priority uint8) {
+	config := v.Config()
+	adv := &vrrpAdvertisement{
+		vrid:     uint8(config.GetVrid()),
+		priority: priority,
+		interval: v.advertInterval(),
+	}
+	for _, addr := range config.GetAddresses() {
+		adv.addresses = append(adv.addresses, common.KglbAddrToNetIp(addr))
+	}
+
+	packet := adv.marshal(v.transport.LocalAddr(), v.ipv6)
+	if err := v.transport.Send(packet); err != nil {
+		exclog.Report(
+			errors.Wrapf(err, "fails to send vrrp advertisement: %d: ", config.GetVrid()),
+			exclog.Noncritical, "")
+	}
+}
+
+func (v *vrrpInstance) priority() uint8 {
+	return uint8(v.Config().GetPriority())
+}
+
+func (v *vrrpInstance) advertInterval() time.Duration {
+	if interval := v.Config().GetAdvertIntervalMs(); interval > 0 {
+		return time.Duration(interval) * time.Millisecond
+	}
+	return defaultVrrpAdvertInterval
+}
+
+// Skew_Time = ((256 - priority) * Master_Adver_Interval) / 256
+func (v *vrrpInstance) skewTime() time.Duration {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return time.Duration(256-int64(v.config.GetPriority())) * v.masterInterval / 256
+}
+
+// Master_Down_Interval = (3 * Master_Adver_Interval) + Skew_time
+func (v *vrrpInstance) masterDownInterval() time.Duration {
+	skew := v.skewTime()
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return 3*v.masterInterval + skew
+}
+
+// Resets timer which channel isn't drained.
+func resetTimer(timer *time.Timer, d time.Duration) {
+	if !timer.Stop() {
+		select {
+		case <-timer.C:
+		default:
+		}
+	}
+	timer.Reset(d)
+}
@@ -0,0 +1,234 @@
+package data_plane
+
+import (
+	"encoding/hex"
+	"net"
+	"sync"
+	"time"
+
+	. "gopkg.in/check.v1"
+
+	kglb_pb "dropbox/proto/kglb"
+	"godropbox/errors"
+	. "godropbox/gocheck2"
+)
+
+type VrrpSuite struct {
+}
+
+var _ = Suite(&VrrpSuite{})
+
+// In-process network delivering advertisements to all other transports.
+type fakeVrrpNetwork struct {
+	mu         sync.Mutex
+	transports map[*fakeVrrpTransport]bool
+}
+
+func newFakeVrrpNetwork() *fakeVrrpNetwork {
+	return &fakeVrrpNetwork{transports: make(map[*fakeVrrpTransport]bool)}
+}
+
+func (n *fakeVrrpNetwork) NewTransport(local string) *fakeVrrpTransport {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	t := &fakeVrrpTransport{
+		network: n,
+		local:   net.ParseIP(local),
+		packets: make(chan *vrrpPacket, 100),
+		closed:  make(chan struct{}),
+	}
+	n.transports[t] = true
+	return t
+}
+
+type fakeVrrpTransport struct {
+	network *fakeVrrpNetwork
+	local   net.IP
+	packets chan *vrrpPacket
+	closed  chan struct{}
+}
+
+func (t *fakeVrrpTransport) LocalAddr() net.IP {
+	return t.local
+}
+
+func (t *fakeVrrpTransport) Send(packet []byte) error {
+	t.network.mu.Lock()
+	defer t.network.mu.Unlock()
+
+	for peer := range t.network.transports {
+		if peer == t {
+			continue
+		}
+		select {
+		case peer.packets <- &vrrpPacket{
+			packet: append([]byte(nil), packet...),
+			src:    t.local,
+			ttl:    vrrpTtl,
+		}:
+		default:
+		}
+	}
+	return nil
+}
+
+func (t *fakeVrrpTransport) Receive() ([]byte, net.IP, int, error) {
+	select {
+	case p := <-t.packets:
+		return p.packet, p.src, p.ttl, nil
+	case <-t.closed:
+		return nil, nil, 0, errors.New("transport is closed")
+	}
+}
+
+func (t *fakeVrrpTransport) Close() error {
+	t.network.mu.Lock()
+	defer t.network.mu.Unlock()
+
+	delete(t.network.transports, t)
+	close(t.closed)
+	return nil
+}
+
+// Waits until instances reach expected states.
+func waitVrrpStates(c *C, expected map[*vrrpInstance]vrrpState) {
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		reached := true
+		for instance, state := range expected {
+			if instance.State() != state {
+				reached = false
+			}
+		}
+		if reached {
+			return
+		}
+		if time.Now().After(deadline) {
+			for instance, state := range expected {
+				c.Assert(instance.State(), Equals, state)
+			}
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func testVrrpInstance(priority uint32) *kglb_pb.VrrpInstance {
+	return &kglb_pb.VrrpInstance{
+		Vrid:      10,
+		Interface: "eth0",
+		Addresses: []*kglb_pb.IP{
+			{Address: &kglb_pb.IP_Ipv4{Ipv4: "172.0.0.1"}},
+		},
+		Priority:         priority,
+		AdvertIntervalMs: 10,
+	}
+}
+
+func (m *VrrpSuite) TestAdvertisement(c *C) {
+	adv := &vrrpAdvertisement{
+		vrid:     10,
+		priority: 100,
+		interval: time.Second,
+		addresses: []net.IP{
+			net.ParseIP("172.0.0.1"),
+			net.ParseIP("172.0.0.2"),
+		},
+	}
+	packet := adv.marshal(net.ParseIP("10.0.0.1"), false)
+	c.Assert(hex.EncodeToString(packet), Equals, "310a6402006427f7ac000001ac000002")
+
+	parsed, err := parseVrrpAdvertisement(packet, net.ParseIP("10.0.0.1"), false)
+	c.Assert(err, NoErr)
+	c.Assert(parsed.vrid, Equals, uint8(10))
+	c.Assert(parsed.priority, Equals, uint8(100))
+	c.Assert(parsed.interval, Equals, time.Second)
+	c.Assert(parsed.addresses, HasLen, 2)
+	c.Assert(parsed.addresses[1].Equal(net.ParseIP("172.0.0.2")), IsTrue)
+
+	// checksum covers source address.
+	_, err = parseVrrpAdvertisement(packet, net.ParseIP("10.0.0.2"), false)
+	c.Assert(err, ErrorMatches, "(?s).*invalid vrrp checksum.*")
+	_, err = parseVrrpAdvertisement(packet[:10], net.ParseIP("10.0.0.1"), false)
+	c.Assert(err, NotNil)
+
+	adv = &vrrpAdvertisement{
+		vrid:      10,
+		priority:  100,
+		interval:  time.Second,
+		addresses: []net.IP{net.ParseIP("fc00::1")},
+	}
+	packet = adv.marshal(net.ParseIP("fe80::1"), true)
+	c.Assert(
+		hex.EncodeToString(packet),
+		Equals,
+		"310a64010064706ffc000000000000000000000000000001")
+	_, err = parseVrrpAdvertisement(packet, net.ParseIP("fe80::1"), true)
+	c.Assert(err, NoErr)
+}
+
+func (m *VrrpSuite) TestElection(c *C) {
+	network := newFakeVrrpNetwork()
+	var mu sync.Mutex
+	transitions := 0
+	onTransition := func() {
+		mu.Lock()
+		transitions++
+		mu.Unlock()
+	}
+
+	node1 := newVrrpInstance(
+		testVrrpInstance(100), network.NewTransport("10.0.0.1"), onTransition)
+	node2 := newVrrpInstance(
+		testVrrpInstance(200), network.NewTransport("10.0.0.2"), onTransition)
+	node1.Start()
+	node2.Start()
+	defer node2.Stop()
+
+	// node with higher priority becomes master.
+	waitVrrpStates(c, map[*vrrpInstance]vrrpState{
+		node1: vrrpStateBackup,
+		node2: vrrpStateMaster,
+	})
+
+	// lower priority of master is preempted.
+	node2.Update(testVrrpInstance(50))
+	waitVrrpStates(c, map[*vrrpInstance]vrrpState{
+		node1: vrrpStateMaster,
+		node2: vrrpStateBackup,
+	})
+
+	// master releases the group on stop.
+	node1.Stop()
+	c.Assert(node1.State(), Equals, vrrpStateInit)
+	waitVrrpStates(c, map[*vrrpInstance]vrrpState{
+		node2: vrrpStateMaster,
+	})
+
+	mu.Lock()
+	defer mu.Unlock()
+	c.Assert(transitions >= 5, IsTrue)
+}
+
+func (m *VrrpSuite) TestNopreempt(c *C) {
+	network := newFakeVrrpNetwork()
+	node1 := newVrrpInstance(
+		testVrrpInstance(100), network.NewTransport("10.0.0.1"), nil)
+	node1.Start()
+	defer node1.Stop()
+	waitVrrpStates(c, map[*vrrpInstance]vrrpState{
+		node1: vrrpStateMaster,
+	})
+
+	config := testVrrpInstance(200)
+	config.Nopreempt = true
+	node2 := newVrrpInstance(config, network.NewTransport("10.0.0.2"), nil)
+	node2.Start()
+	defer node2.Stop()
+
+	// backup with higher priority doesn't preempt master.
+	time.Sleep(100 * time.Millisecond)
+	c.Assert(node1.State(), Equals, vrrpStateMaster)
+	c.Assert(node2.State(), Equals, vrrpStateBackup)
+}
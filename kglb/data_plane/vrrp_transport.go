@@ -0,0 +1,191 @@
+package data_plane
+
+import (
+	"net"
+
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+
+	"godropbox/errors"
+)
+
+// size of receive buffer, it fits advertisement with 255 ipv6 addresses.
+const vrrpReceiveBufferSize = 8 * 1024
+
+// Transport of VRRP advertisements of single address family on the
+// interface. It's abstracted to run instances over fake transport in tests.
+type VrrpTransport interface {
+	// Source address of advertisements, it's covered by checksum.
+	LocalAddr() net.IP
+	// Sends advertisement to VRRP multicast group.
+	Send(packet []byte) error
+	// Receives next advertisement with its source address and ttl (hop
+	// limit), it fails after Close.
+	Receive() (packet []byte, src net.IP, ttl int, err error)
+	// Closes the transport.
+	Close() error
+}
+
+// Returns transport of VRRP advertisements of the family on the interface.
+type VrrpTransportFactory func(iface string, ipv6 bool) (VrrpTransport, error)
+
+// VrrpTransport based on raw ip socket joined to VRRP multicast group.
+type vrrpRawTransport struct {
+	iface *net.Interface
+	local net.IP
+	group net.IP
+	conn  net.PacketConn
+
+	// one of them is set depending on address family.
+	conn4 *ipv4.PacketConn
+	conn6 *ipv6.PacketConn
+}
+
+var _ VrrpTransportFactory = NewVrrpTransport
+
+func NewVrrpTransport(ifaceName string, isIpv6 bool) (VrrpTransport, error) {
+	iface, err := net.InterfaceByName(ifaceName)
+	if err != nil {
+		return nil, errors.Wrapf(err, "fails to find interface: %s: ", ifaceName)
+	}
+	local, err := vrrpLocalAddr(iface, isIpv6)
+	if err != nil {
+		return nil, err
+	}
+
+	t := &vrrpRawTransport{
+		iface: iface,
+		local: local,
+		group: vrrpGroup(isIpv6),
+	}
+	if isIpv6 {
+		err = t.listen6()
+	} else {
+		err = t.listen4()
+	}
+	if err != nil {
+		if t.conn != nil {
+			t.conn.Close()
+		}
+		return nil, errors.Wrapf(err, "fails to create vrrp transport: %s: ", ifaceName)
+	}
+	return t, nil
+}
+
+// Returns primary address of the family on the interface, RFC 5798 requires
+// link-local source address of ipv6 advertisements.
+func vrrpLocalAddr(iface *net.Interface, isIpv6 bool) (net.IP, error) {
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return nil, errors.Wrapf(err, "fails to list addresses: %s: ", iface.Name)
+	}
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if !ok {
+			continue
+		}
+		if isIpv6 && ipNet.IP.To4() == nil && ipNet.IP.IsLinkLocalUnicast() {
+			return ipNet.IP, nil
+		}
+		if !isIpv6 && ipNet.IP.To4() != nil {
+			return ipNet.IP.To4(), nil
+		}
+	}
+	return nil, errors.Newf("interface doesn't have vrrp source address: %s", iface.Name)
+}
+
+func (t *vrrpRawTransport) listen4() (err error) {
+	if t.conn, err = net.ListenPacket("ip4:112", "0.0.0.0"); err != nil {
+		return err
+	}
+	t.conn4 = ipv4.NewPacketConn(t.conn)
+	if err = t.conn4.JoinGroup(t.iface, &net.IPAddr{IP: t.group}); err != nil {
+		return err
+	}
+	if err = t.conn4.SetMulticastInterface(t.iface); err != nil {
+		return err
+	}
+	if err = t.conn4.SetMulticastTTL(vrrpTtl); err != nil {
+		return err
+	}
+	if err = t.conn4.SetMulticastLoopback(false); err != nil {
+		return err
+	}
+	return t.conn4.SetControlMessage(ipv4.FlagTTL|ipv4.FlagInterface, true)
+}
+
+func (t *vrrpRawTransport) listen6() (err error) {
+	if t.conn, err = net.ListenPacket("ip6:112", "::"); err != nil {
+		return err
+	}
+	t.conn6 = ipv6.NewPacketConn(t.conn)
+	if err = t.conn6.JoinGroup(t.iface, &net.IPAddr{IP: t.group}); err != nil {
+		return err
+	}
+	if err = t.conn6.SetMulticastInterface(t.iface); err != nil {
+		return err
+	}
+	if err = t.conn6.SetMulticastHopLimit(vrrpTtl); err != nil {
+		return err
+	}
+	if err = t.conn6.SetMulticastLoopback(false); err != nil {
+		return err
+	}
+	return t.conn6.SetControlMessage(ipv6.FlagHopLimit|ipv6.FlagInterface, true)
+}
+
+func (t *vrrpRawTransport) LocalAddr() net.IP {
+	return t.local
+}
+
+func (t *vrrpRawTransport) Send(packet []byte) error {
+	var err error
+	if t.conn6 != nil {
+		_, err = t.conn6.WriteTo(
+			packet,
+			&ipv6.ControlMessage{IfIndex: t.iface.Index, Src: t.local},
+			&net.IPAddr{IP: t.group, Zone: t.iface.Name})
+	} else {
+		_, err = t.conn4.WriteTo(
+			packet,
+			&ipv4.ControlMessage{IfIndex: t.iface.Index, Src: t.local},
+			&net.IPAddr{IP: t.group})
+	}
+	return err
+}
+
+func (t *vrrpRawTransport) Receive() ([]byte, net.IP, int, error) {
+	buf := make([]byte, vrrpReceiveBufferSize)
+	for {
+		var n, ifIndex, ttl int
+		var src net.Addr
+		var err error
+		if t.conn6 != nil {
+			var cm *ipv6.ControlMessage
+			n, cm, src, err = t.conn6.ReadFrom(buf)
+			if cm != nil {
+				ifIndex, ttl = cm.IfIndex, cm.HopLimit
+			}
+		} else {
+			var cm *ipv4.ControlMessage
+			n, cm, src, err = t.conn4.ReadFrom(buf)
+			if cm != nil {
+				ifIndex, ttl = cm.IfIndex, cm.TTL
+			}
+		}
+		if err != nil {
+			return nil, nil, 0, err
+		}
+
+		// socket receives advertisements of all interfaces.
+		ipAddr, ok := src.(*net.IPAddr)
+		if !ok || ifIndex != t.iface.Index {
+			continue
+		}
+		return buf[:n], ipAddr.IP, ttl, nil
+	}
+}
+
+func (t *vrrpRawTransport) Close() error {
+	return t.conn.Close()
+}
@@ -0,0 +1,138 @@
+package health_checker
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"strconv"
+	"strings"
+
+	hc_pb "dropbox/proto/kglb/healthchecker"
+	"godropbox/errors"
+)
+
+const (
+	// max weight in percents reported by agent.
+	maxAgentWeightPercent = 256
+	// max length of agent reply.
+	maxAgentReplySize = 512
+)
+
+var _ WeightedHealthChecker = &AgentChecker{}
+
+// Agent health checker. It reads health state and weight reported by agent
+// running on upstream.
+type AgentChecker struct {
+	params      *hc_pb.AgentCheckerAttributes
+	dialContext DialContextFunc
+}
+
+func NewAgentChecker(params *hc_pb.AgentCheckerAttributes, dialContext DialContextFunc) (*AgentChecker, error) {
+	checker := &AgentChecker{
+		params:      params,
+		dialContext: defaultDialContext,
+	}
+
+	if dialContext != nil {
+		checker.dialContext = dialContext
+	}
+
+	return checker, nil
+}
+
+func (h *AgentChecker) GetConfiguration() *hc_pb.HealthCheckerAttributes {
+	return &hc_pb.HealthCheckerAttributes{
+		Attributes: &hc_pb.HealthCheckerAttributes_Agent{
+			Agent: h.params,
+		},
+	}
+}
+
+// Performs test and returns nil when agent reports healthy state.
+func (h *AgentChecker) Check(host string, port int) error {
+	_, err := h.CheckWeight(host, port)
+	return err
+}
+
+// Performs test and returns weight reported by agent.
+func (h *AgentChecker) CheckWeight(host string, port int) (uint32, error) {
+	timeout := timeoutMsToDuration(h.params.GetCheckTimeoutMs())
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	if h.params.GetPort() > 0 {
+		port = int(h.params.GetPort())
+	}
+
+	address := net.JoinHostPort(host, strconv.Itoa(port))
+	conn, err := h.dialContext(ctx, "tcp", address)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	if h.params.GetSend() != "" {
+		if _, err := conn.Write([]byte(h.params.GetSend())); err != nil {
+			return 0, err
+		}
+	}
+
+	// agent may close connection right after reply without trailing newline.
+	reader := bufio.NewReaderSize(conn, maxAgentReplySize)
+	line, err := reader.ReadSlice('\n')
+	if err != nil && len(line) == 0 {
+		return 0, errors.Wrapf(err, "fails to read agent reply from %s: ", address)
+	}
+
+	weight, err := ParseAgentReply(string(line))
+	if err != nil {
+		return 0, errors.Wrapf(err, "agent of %s reports failure: ", address)
+	}
+	return weight, nil
+}
+
+// Parses agent reply and returns weight of upstream in percents or error when
+// upstream is unhealthy.
+func ParseAgentReply(reply string) (uint32, error) {
+	words := strings.FieldsFunc(
+		strings.ToLower(strings.TrimSpace(reply)),
+		func(r rune) bool {
+			return r == ' ' || r == ',' || r == '\t'
+		})
+	if len(words) == 0 {
+		return 0, errors.New("empty agent reply")
+	}
+
+	weight := DefaultWeightPercent
+	drain := false
+	for _, word := range words {
+		switch word {
+		case "up", "ready":
+		case "drain":
+			drain = true
+		case "down", "fail", "failed", "stopped", "maint":
+			return 0, errors.Newf("agent state: %q", reply)
+		default:
+			if !strings.HasSuffix(word, "%") {
+				// unknown words are ignored for compatibility.
+				continue
+			}
+			value, err := strconv.ParseUint(strings.TrimSuffix(word, "%"), 10, 32)
+			if err != nil {
+				return 0, errors.Wrapf(err, "invalid agent weight %q: ", word)
+			}
+			if value > maxAgentWeightPercent {
+				value = maxAgentWeightPercent
+			}
+			weight = uint32(value)
+		}
+	}
+
+	if drain {
+		return 0, nil
+	}
+	return weight, nil
+}
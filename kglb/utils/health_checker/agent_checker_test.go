@@ -0,0 +1,90 @@
+package health_checker
+
+import (
+	"bufio"
+	"net"
+
+	. "gopkg.in/check.v1"
+
+	hc_pb "dropbox/proto/kglb/healthchecker"
+	. "godropbox/gocheck2"
+)
+
+type AgentCheckerSuite struct{}
+
+var _ = Suite(&AgentCheckerSuite{})
+
+func (s *AgentCheckerSuite) TestParseAgentReply(c *C) {
+	testCases := []struct {
+		reply  string
+		weight uint32
+		failed bool
+	}{
+		{reply: "up\n", weight: 100},
+		{reply: "ready", weight: 100},
+		{reply: "up 75%\n", weight: 75},
+		{reply: "75%", weight: 75},
+		{reply: "UP,50%\r\n", weight: 50},
+		{reply: "1000%", weight: 256},
+		{reply: "drain", weight: 0},
+		{reply: "up 75% drain", weight: 0},
+		{reply: "down", failed: true},
+		{reply: "50% maint", failed: true},
+		{reply: "stopped", failed: true},
+		{reply: "fail #connection refused", failed: true},
+		{reply: "", failed: true},
+		{reply: "abc%", failed: true},
+	}
+
+	for _, testCase := range testCases {
+		weight, err := ParseAgentReply(testCase.reply)
+		if testCase.failed {
+			c.Assert(err, NotNil, Commentf("reply: %q", testCase.reply))
+		} else {
+			c.Assert(err, NoErr, Commentf("reply: %q", testCase.reply))
+			c.Assert(weight, Equals, testCase.weight, Commentf("reply: %q", testCase.reply))
+		}
+	}
+}
+
+func (s *AgentCheckerSuite) TestCheckWeight(c *C) {
+	replies := make(chan string, 1)
+	received := make(chan string, 1)
+	host, port, stop := startFakeServer(c, func(conn net.Conn) {
+		line, _ := bufio.NewReader(conn).ReadString('\n')
+		received <- line
+		conn.Write([]byte(<-replies))
+	})
+	defer stop()
+
+	checker, err := NewAgentChecker(&hc_pb.AgentCheckerAttributes{
+		Port: uint32(port),
+		Send: "status\n",
+	}, nil)
+	c.Assert(err, NoErr)
+
+	// upstream port is overridden by agent port.
+	replies <- "up 30%\n"
+	weight, err := checker.CheckWeight(host, 1)
+	c.Assert(err, NoErr)
+	c.Assert(weight, Equals, uint32(30))
+	c.Assert(<-received, Equals, "status\n")
+
+	replies <- "down\n"
+	c.Assert(checker.Check(host, 1), NotNil)
+	<-received
+}
+
+func (s *AgentCheckerSuite) TestReplyWithoutNewline(c *C) {
+	host, port, stop := startFakeServer(c, func(conn net.Conn) {
+		conn.Write([]byte("drain"))
+	})
+	defer stop()
+
+	checker, err := NewAgentChecker(&hc_pb.AgentCheckerAttributes{}, nil)
+	c.Assert(err, NoErr)
+
+	weight, err := checker.CheckWeight(host, port)
+	c.Assert(err, NoErr)
+	c.Assert(weight, Equals, uint32(0))
+}
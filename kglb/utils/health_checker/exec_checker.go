@@ -0,0 +1,220 @@
+package health_checker
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	hc_pb "dropbox/proto/kglb/healthchecker"
+	"godropbox/errors"
+)
+
+const (
+	// default max number of simultaneously running commands of all exec
+	// checkers.
+	DefaultExecConcurrencyLimit = 10
+	// default max number of stderr bytes attached to the check error.
+	defaultMaxStderrBytes = 256
+	// max time to wait for killed command, its children may keep stderr
+	// open after leaving the process group.
+	execKillTimeout = time.Second
+
+	execHostEnv = "KGLB_HOST"
+	execPortEnv = "KGLB_PORT"
+)
+
+var _ HealthChecker = &ExecChecker{}
+
+var (
+	execSlotsMu sync.Mutex
+	// semaphore shared by all exec checkers to limit number of running
+	// commands.
+	execSlots = make(chan struct{}, DefaultExecConcurrencyLimit)
+)
+
+// Sets max number of simultaneously running commands of all exec checkers,
+// DefaultExecConcurrencyLimit is used when limit isn't positive. Commands
+// which are already running are not counted against the new limit.
+func SetExecConcurrencyLimit(limit int) {
+	if limit <= 0 {
+		limit = DefaultExecConcurrencyLimit
+	}
+
+	execSlotsMu.Lock()
+	defer execSlotsMu.Unlock()
+	execSlots = make(chan struct{}, limit)
+}
+
+func getExecSlots() chan struct{} {
+	execSlotsMu.Lock()
+	defer execSlotsMu.Unlock()
+	return execSlots
+}
+
+// External command health checker. It runs configured executable and treats
+// zero exit status as healthy upstream.
+type ExecChecker struct {
+	params *hc_pb.ExecCheckerAttributes
+
+	// environment passed to every command.
+	env []string
+	// semaphore to limit number of running commands of the checker, nil
+	// when only shared limit is applied.
+	slots chan struct{}
+	// max number of stderr bytes attached to the check error.
+	maxStderrBytes int
+}
+
+func NewExecChecker(params *hc_pb.ExecCheckerAttributes, dialContext DialContextFunc) (*ExecChecker, error) {
+	// command establishes connections by itself.
+	if dialContext != nil {
+		return nil, errors.New("custom dial context is not supported in ExecChecker")
+	}
+
+	if params.GetPath() == "" {
+		return nil, errors.Newf("Path is required: %+v", params)
+	}
+
+	var slots chan struct{}
+	if params.GetConcurrencyLimit() > 0 {
+		slots = make(chan struct{}, params.GetConcurrencyLimit())
+	}
+
+	maxStderrBytes := defaultMaxStderrBytes
+	if params.GetMaxStderrBytes() > 0 {
+		maxStderrBytes = int(params.GetMaxStderrBytes())
+	}
+
+	env := os.Environ()
+	for k, v := range params.GetEnv() {
+		env = append(env, k+"="+v)
+	}
+
+	return &ExecChecker{
+		params:         params,
+		env:            env,
+		slots:          slots,
+		maxStderrBytes: maxStderrBytes,
+	}, nil
+}
+
+func (h *ExecChecker) GetConfiguration() *hc_pb.HealthCheckerAttributes {
+	return &hc_pb.HealthCheckerAttributes{
+		Attributes: &hc_pb.HealthCheckerAttributes_Exec{
+			Exec: h.params,
+		},
+	}
+}
+
+// Performs test and returns nil when command exits with zero status.
+func (h *ExecChecker) Check(host string, port int) error {
+	timeout := timeoutMsToDuration(h.params.GetCheckTimeoutMs())
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	// waiting free slots is the part of the check timeout, nil channel of
+	// the checker without own limit is skipped.
+	for _, slots := range []chan struct{}{h.slots, getExecSlots()} {
+		if slots == nil {
+			continue
+		}
+		select {
+		case slots <- struct{}{}:
+			defer func(slots chan struct{}) { <-slots }(slots)
+		case <-ctx.Done():
+			return errors.Newf(
+				"exec health check of %s:%d fails: no free slot to run command "+
+					"within %v (concurrency limit: %d)",
+				host, port, timeout, cap(slots))
+		}
+	}
+
+	portStr := strconv.Itoa(port)
+	args := append(append([]string{}, h.params.GetArgs()...), host, portStr)
+
+	cmd := exec.Command(h.params.GetPath(), args...)
+	cmd.Env = append(
+		append([]string{}, h.env...),
+		execHostEnv+"="+host,
+		execPortEnv+"="+portStr)
+	// run the command in own process group to be able to kill all children
+	// on timeout.
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	stderr := &limitedBuffer{limit: h.maxStderrBytes}
+	cmd.Stderr = stderr
+
+	if err := cmd.Start(); err != nil {
+		return errors.Wrapf(err, "fails to start %s: ", h.params.GetPath())
+	}
+
+	waitChan := make(chan error, 1)
+	go func() {
+		waitChan <- cmd.Wait()
+	}()
+
+	var err error
+	select {
+	case err = <-waitChan:
+	case <-ctx.Done():
+		// negative pid means the whole process group.
+		_ = syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+		select {
+		case <-waitChan:
+		case <-time.After(execKillTimeout):
+			// Wait() is blocked by children which left the process group
+			// and keep stderr open, stderr is still being written, so it
+			// isn't attached.
+			return errors.Newf(
+				"exec health check of %s:%d fails: timeout %v exceeded, "+
+					"command doesn't exit after kill",
+				host, port, timeout)
+		}
+		return errors.Newf(
+			"exec health check of %s:%d fails: timeout %v exceeded, stderr: %q",
+			host, port, timeout, stderr.String())
+	}
+
+	if err != nil {
+		return errors.Newf(
+			"exec health check of %s:%d fails: %v, stderr: %q",
+			host, port, err, stderr.String())
+	}
+	return nil
+}
+
+// io.Writer keeping only first limit bytes.
+type limitedBuffer struct {
+	buf   bytes.Buffer
+	limit int
+	// true when some bytes were dropped.
+	truncated bool
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if left := b.limit - b.buf.Len(); left > 0 {
+		if len(p) > left {
+			b.buf.Write(p[:left])
+			b.truncated = true
+		} else {
+			b.buf.Write(p)
+		}
+	} else if len(p) > 0 {
+		b.truncated = true
+	}
+	// pretend that everything is written to not break the command.
+	return len(p), nil
+}
+
+func (b *limitedBuffer) String() string {
+	out := strings.TrimSpace(b.buf.String())
+	if b.truncated {
+		out += "..."
+	}
+	return out
+}
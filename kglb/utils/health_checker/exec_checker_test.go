@@ -0,0 +1,219 @@
+package health_checker
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	. "gopkg.in/check.v1"
+
+	hc_pb "dropbox/proto/kglb/healthchecker"
+	. "godropbox/gocheck2"
+)
+
+type ExecCheckerSuite struct {
+	dir string
+}
+
+var _ = Suite(&ExecCheckerSuite{})
+
+func (s *ExecCheckerSuite) SetUpTest(c *C) {
+	s.dir = c.MkDir()
+}
+
+// creates executable shell script with provided body.
+func (s *ExecCheckerSuite) script(c *C, body string) string {
+	path := filepath.Join(s.dir, "check.sh")
+	err := ioutil.WriteFile(path, []byte("#!/bin/sh\n"+body+"\n"), 0755)
+	c.Assert(err, NoErr)
+	return path
+}
+
+func (s *ExecCheckerSuite) TestCheckSuccess(c *C) {
+	checker, err := NewExecChecker(&hc_pb.ExecCheckerAttributes{
+		Path: s.script(c, "exit 0"),
+	}, nil)
+	c.Assert(err, NoErr)
+
+	c.Assert(checker.Check("127.0.0.1", 80), NoErr)
+}
+
+func (s *ExecCheckerSuite) TestCheckFailure(c *C) {
+	checker, err := NewExecChecker(&hc_pb.ExecCheckerAttributes{
+		Path: s.script(c, "echo 'backend is broken' >&2; exit 3"),
+	}, nil)
+	c.Assert(err, NoErr)
+
+	err = checker.Check("127.0.0.1", 80)
+	c.Assert(err, NotNil)
+	c.Assert(strings.Contains(err.Error(), "backend is broken"), IsTrue)
+	c.Assert(strings.Contains(err.Error(), "exit status 3"), IsTrue)
+}
+
+func (s *ExecCheckerSuite) TestStderrTruncation(c *C) {
+	checker, err := NewExecChecker(&hc_pb.ExecCheckerAttributes{
+		Path:           s.script(c, "echo 0123456789abcdef >&2; exit 1"),
+		MaxStderrBytes: 4,
+	}, nil)
+	c.Assert(err, NoErr)
+
+	err = checker.Check("127.0.0.1", 80)
+	c.Assert(err, NotNil)
+	c.Assert(strings.Contains(err.Error(), `"0123..."`), IsTrue)
+	c.Assert(strings.Contains(err.Error(), "4567"), IsFalse)
+}
+
+func (s *ExecCheckerSuite) TestArgsAndEnv(c *C) {
+	out := filepath.Join(s.dir, "out")
+	checker, err := NewExecChecker(&hc_pb.ExecCheckerAttributes{
+		Path: s.script(
+			c,
+			`echo "$@ $KGLB_HOST $KGLB_PORT $CUSTOM" > `+out),
+		Args: []string{"--check"},
+		Env:  map[string]string{"CUSTOM": "value"},
+	}, nil)
+	c.Assert(err, NoErr)
+
+	c.Assert(checker.Check("10.0.0.1", 8080), NoErr)
+	data, err := ioutil.ReadFile(out)
+	c.Assert(err, NoErr)
+	c.Assert(
+		string(data),
+		Equals,
+		"--check 10.0.0.1 8080 10.0.0.1 8080 value\n")
+}
+
+func (s *ExecCheckerSuite) TestTimeoutKillsProcessGroup(c *C) {
+	pidFile := filepath.Join(s.dir, "pid")
+	// child process keeps running in background after the script is killed
+	// unless whole process group is terminated.
+	checker, err := NewExecChecker(&hc_pb.ExecCheckerAttributes{
+		Path:           s.script(c, "sleep 30 & echo $! > "+pidFile+"; wait"),
+		CheckTimeoutMs: 200,
+	}, nil)
+	c.Assert(err, NoErr)
+
+	startTime := time.Now()
+	err = checker.Check("127.0.0.1", 80)
+	c.Assert(err, NotNil)
+	c.Assert(strings.Contains(err.Error(), "timeout"), IsTrue)
+	c.Assert(time.Since(startTime) < 5*time.Second, IsTrue)
+
+	data, err := ioutil.ReadFile(pidFile)
+	c.Assert(err, NoErr)
+	pid := strings.TrimSpace(string(data))
+	// the child is either gone or zombie waiting for reaping by init.
+	for i := 0; i < 50; i++ {
+		stat, err := ioutil.ReadFile(filepath.Join("/proc", pid, "stat"))
+		if os.IsNotExist(err) || strings.Contains(string(stat), ") Z ") {
+			return
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	c.Fatalf("child process %s is still alive", pid)
+}
+
+func (s *ExecCheckerSuite) TestConcurrencyLimit(c *C) {
+	lockDir := filepath.Join(s.dir, "lock")
+	// mkdir is atomic, so it fails when another command is running.
+	checker, err := NewExecChecker(&hc_pb.ExecCheckerAttributes{
+		Path: s.script(
+			c,
+			"mkdir "+lockDir+" || exit 1; sleep 0.05; rmdir "+lockDir),
+		ConcurrencyLimit: 1,
+	}, nil)
+	c.Assert(err, NoErr)
+
+	var failures int32
+	wg := sync.WaitGroup{}
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := checker.Check("127.0.0.1", 80); err != nil {
+				atomic.AddInt32(&failures, 1)
+			}
+		}()
+	}
+	wg.Wait()
+	c.Assert(atomic.LoadInt32(&failures), Equals, int32(0))
+}
+
+func (s *ExecCheckerSuite) TestSharedConcurrencyLimit(c *C) {
+	SetExecConcurrencyLimit(1)
+	defer SetExecConcurrencyLimit(0)
+
+	lockDir := filepath.Join(s.dir, "lock")
+	// commands of different checkers don't run at the same time.
+	var checkers []*ExecChecker
+	for i := 0; i < 2; i++ {
+		checker, err := NewExecChecker(&hc_pb.ExecCheckerAttributes{
+			Path: s.script(
+				c,
+				"mkdir "+lockDir+" || exit 1; sleep 0.05; rmdir "+lockDir),
+		}, nil)
+		c.Assert(err, NoErr)
+		checkers = append(checkers, checker)
+	}
+
+	var failures int32
+	wg := sync.WaitGroup{}
+	for i := 0; i < 6; i++ {
+		wg.Add(1)
+		go func(checker *ExecChecker) {
+			defer wg.Done()
+			if err := checker.Check("127.0.0.1", 80); err != nil {
+				atomic.AddInt32(&failures, 1)
+			}
+		}(checkers[i%2])
+	}
+	wg.Wait()
+	c.Assert(atomic.LoadInt32(&failures), Equals, int32(0))
+}
+
+func (s *ExecCheckerSuite) TestTimeoutWithDetachedChild(c *C) {
+	pidFile := filepath.Join(s.dir, "pid")
+	// child leaves the process group and keeps stderr open, so it isn't
+	// killed and Wait() doesn't return until it exits.
+	checker, err := NewExecChecker(&hc_pb.ExecCheckerAttributes{
+		Path: s.script(
+			c,
+			"setsid sleep 30 & echo $! > "+pidFile+"; wait"),
+		CheckTimeoutMs: 200,
+	}, nil)
+	c.Assert(err, NoErr)
+	defer func() {
+		data, err := ioutil.ReadFile(pidFile)
+		c.Assert(err, NoErr)
+		pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+		c.Assert(err, NoErr)
+		_ = syscall.Kill(pid, syscall.SIGKILL)
+	}()
+
+	startTime := time.Now()
+	err = checker.Check("127.0.0.1", 80)
+	c.Assert(err, NotNil)
+	c.Assert(strings.Contains(err.Error(), "doesn't exit after kill"), IsTrue)
+	c.Assert(time.Since(startTime) < 5*time.Second, IsTrue)
+}
+
+func (s *ExecCheckerSuite) TestInvalidParams(c *C) {
+	_, err := NewExecChecker(&hc_pb.ExecCheckerAttributes{}, nil)
+	c.Assert(err, NotNil)
+
+	dialContext := func(ctx context.Context, network, addr string) (net.Conn, error) {
+		return nil, nil
+	}
+	_, err = NewExecChecker(&hc_pb.ExecCheckerAttributes{
+		Path: "/bin/true",
+	}, dialContext)
+	c.Assert(err, NotNil)
+}
@@ -12,6 +12,9 @@ import (
 const (
 	// default Timeout to perform individual health check.
 	defaultTimeout = 5 * time.Second
+
+	// weight of the upstream when health checker doesn't report it.
+	DefaultWeightPercent = uint32(100)
 )
 
 // Common interface for all kind of health checkers.
@@ -24,6 +27,16 @@ type HealthChecker interface {
 	GetConfiguration() *hc_pb.HealthCheckerAttributes
 }
 
+// Optional interface of health checkers which are able to report weight of
+// the upstream in addition to its health.
+type WeightedHealthChecker interface {
+	HealthChecker
+	// Performs test and returns weight of the upstream in percents of
+	// configured weight. Error means unhealthy upstream, zero weight means
+	// healthy upstream which should not receive new connections.
+	CheckWeight(host string, port int) (uint32, error)
+}
+
 func NewHealthChecker(checker *hc_pb.UpstreamChecker, dialContext DialContextFunc) (HealthChecker, error) {
 	switch attr := checker.GetChecker().GetAttributes().(type) {
 	case *hc_pb.HealthCheckerAttributes_Dummy:
@@ -36,6 +49,16 @@ func NewHealthChecker(checker *hc_pb.UpstreamChecker, dialContext DialContextFun
 		return NewDnsChecker(attr.Dns, dialContext)
 	case *hc_pb.HealthCheckerAttributes_Tcp:
 		return NewTcpChecker(attr.Tcp, dialContext)
+	case *hc_pb.HealthCheckerAttributes_Exec:
+		return NewExecChecker(attr.Exec, dialContext)
+	case *hc_pb.HealthCheckerAttributes_Redis:
+		return NewRedisChecker(attr.Redis, dialContext)
+	case *hc_pb.HealthCheckerAttributes_Mysql:
+		return NewMysqlChecker(attr.Mysql, dialContext)
+	case *hc_pb.HealthCheckerAttributes_Postgres:
+		return NewPostgresChecker(attr.Postgres, dialContext)
+	case *hc_pb.HealthCheckerAttributes_Agent:
+		return NewAgentChecker(attr.Agent, dialContext)
 	default:
 		return nil, errors.Newf("Unknown Health Checker type: %s", attr)
 	}
@@ -0,0 +1,307 @@
+package health_checker
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/binary"
+	"io"
+	"net"
+	"strconv"
+
+	hc_pb "dropbox/proto/kglb/healthchecker"
+	"godropbox/errors"
+)
+
+const (
+	mysqlProtocolVersion = 10
+	mysqlMaxPacketSize   = 1<<24 - 1
+	mysqlNativePassword  = "mysql_native_password"
+
+	// capability flags.
+	mysqlClientLongPassword     = 0x00000001
+	mysqlClientConnectWithDb    = 0x00000008
+	mysqlClientProtocol41       = 0x00000200
+	mysqlClientTransactions     = 0x00002000
+	mysqlClientSecureConnection = 0x00008000
+	mysqlClientPluginAuth       = 0x00080000
+
+	// packet headers.
+	mysqlOkPacket         = 0x00
+	mysqlAuthSwitchPacket = 0xfe
+	mysqlErrPacket        = 0xff
+
+	// commands.
+	mysqlComQuit  = 0x01
+	mysqlComQuery = 0x03
+)
+
+var _ HealthChecker = &MysqlChecker{}
+
+// MySQL health checker. Without user it only validates initial handshake
+// packet sent by server, otherwise it authenticates and optionally runs
+// "SELECT 1" query.
+type MysqlChecker struct {
+	params      *hc_pb.MysqlCheckerAttributes
+	dialContext DialContextFunc
+}
+
+func NewMysqlChecker(params *hc_pb.MysqlCheckerAttributes, dialContext DialContextFunc) (*MysqlChecker, error) {
+	checker := &MysqlChecker{
+		params:      params,
+		dialContext: defaultDialContext,
+	}
+
+	if dialContext != nil {
+		checker.dialContext = dialContext
+	}
+
+	if params.GetQuery() && params.GetUser() == "" {
+		return nil, errors.Newf("User is required to run query: %+v", params)
+	}
+
+	return checker, nil
+}
+
+func (h *MysqlChecker) GetConfiguration() *hc_pb.HealthCheckerAttributes {
+	return &hc_pb.HealthCheckerAttributes{
+		Attributes: &hc_pb.HealthCheckerAttributes_Mysql{
+			Mysql: h.params,
+		},
+	}
+}
+
+// Performs test and returns nil when server accepts the connection (and
+// credentials and query when they are configured).
+func (h *MysqlChecker) Check(host string, port int) error {
+	timeout := timeoutMsToDuration(h.params.GetCheckTimeoutMs())
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	address := net.JoinHostPort(host, strconv.Itoa(port))
+	c, err := h.dialContext(ctx, "tcp", address)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		c.SetDeadline(deadline)
+	}
+
+	conn := &mysqlConn{conn: c}
+
+	payload, err := conn.readPacket()
+	if err != nil {
+		return errors.Wrapf(err, "fails to read handshake from %s: ", address)
+	}
+	scramble, err := parseMysqlHandshake(payload)
+	if err != nil {
+		return errors.Wrapf(err, "invalid handshake from %s: ", address)
+	}
+
+	if h.params.GetUser() == "" {
+		return nil
+	}
+
+	if err := h.authenticate(conn, scramble); err != nil {
+		return errors.Wrapf(err, "authentication fails on %s: ", address)
+	}
+
+	if h.params.GetQuery() {
+		conn.seq = 0
+		query := append([]byte{mysqlComQuery}, "SELECT 1"...)
+		if err := conn.writePacket(query); err != nil {
+			return err
+		}
+		// the first packet is either error or column count of result set.
+		payload, err := conn.readPacket()
+		if err != nil {
+			return errors.Wrapf(err, "fails to read query result from %s: ", address)
+		}
+		if err := mysqlPacketError(payload); err != nil {
+			return errors.Wrapf(err, "query fails on %s: ", address)
+		}
+	}
+
+	conn.seq = 0
+	// server closes connection in response, so result is ignored.
+	_ = conn.writePacket([]byte{mysqlComQuit})
+	return nil
+}
+
+func (h *MysqlChecker) authenticate(conn *mysqlConn, scramble []byte) error {
+	capabilities := uint32(mysqlClientLongPassword |
+		mysqlClientProtocol41 |
+		mysqlClientTransactions |
+		mysqlClientSecureConnection |
+		mysqlClientPluginAuth)
+	if h.params.GetDatabase() != "" {
+		capabilities |= mysqlClientConnectWithDb
+	}
+
+	authResponse := mysqlNativePasswordHash(scramble, h.params.GetPassword())
+
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.LittleEndian, capabilities)
+	binary.Write(&buf, binary.LittleEndian, uint32(mysqlMaxPacketSize))
+	// utf8_general_ci charset and 23 reserved bytes.
+	buf.WriteByte(33)
+	buf.Write(make([]byte, 23))
+	buf.WriteString(h.params.GetUser())
+	buf.WriteByte(0)
+	buf.WriteByte(byte(len(authResponse)))
+	buf.Write(authResponse)
+	if h.params.GetDatabase() != "" {
+		buf.WriteString(h.params.GetDatabase())
+		buf.WriteByte(0)
+	}
+	buf.WriteString(mysqlNativePassword)
+	buf.WriteByte(0)
+
+	if err := conn.writePacket(buf.Bytes()); err != nil {
+		return err
+	}
+
+	payload, err := conn.readPacket()
+	if err != nil {
+		return err
+	}
+
+	// server may ask to switch auth method with new scramble.
+	if len(payload) > 0 && payload[0] == mysqlAuthSwitchPacket {
+		pluginEnd := bytes.IndexByte(payload[1:], 0)
+		if pluginEnd < 0 {
+			return errors.New("malformed auth switch request")
+		}
+		plugin := string(payload[1 : 1+pluginEnd])
+		if plugin != mysqlNativePassword {
+			return errors.Newf("unsupported auth method: %s", plugin)
+		}
+		data := bytes.TrimRight(payload[2+pluginEnd:], "\x00")
+		if err := conn.writePacket(mysqlNativePasswordHash(data, h.params.GetPassword())); err != nil {
+			return err
+		}
+		if payload, err = conn.readPacket(); err != nil {
+			return err
+		}
+	}
+
+	if err := mysqlPacketError(payload); err != nil {
+		return err
+	}
+	if len(payload) == 0 || payload[0] != mysqlOkPacket {
+		return errors.Newf("unexpected auth response: %v", payload)
+	}
+	return nil
+}
+
+// Parses initial handshake packet (protocol version 10) and returns
+// scramble for authentication.
+func parseMysqlHandshake(payload []byte) ([]byte, error) {
+	if err := mysqlPacketError(payload); err != nil {
+		return nil, err
+	}
+	if len(payload) == 0 || payload[0] != mysqlProtocolVersion {
+		return nil, errors.Newf("unsupported protocol version: %v", payload)
+	}
+
+	versionEnd := bytes.IndexByte(payload[1:], 0)
+	if versionEnd < 0 {
+		return nil, errors.New("malformed server version")
+	}
+	// server version, connection id (4 bytes).
+	pos := 1 + versionEnd + 1 + 4
+	if len(payload) < pos+8+1 {
+		return nil, errors.New("handshake packet is too short")
+	}
+	scramble := append([]byte{}, payload[pos:pos+8]...)
+	// first part of scramble, filler (1 byte), capability flags (2 bytes),
+	// charset (1 byte), status flags (2 bytes), capability flags (2 bytes),
+	// auth data length (1 byte) and reserved (10 bytes).
+	pos += 8 + 1 + 2 + 1 + 2 + 2 + 1 + 10
+	if len(payload) > pos {
+		// second part of scramble is at least 13 bytes including trailing
+		// zero.
+		rest := payload[pos:]
+		if end := bytes.IndexByte(rest, 0); end >= 0 {
+			rest = rest[:end]
+		}
+		scramble = append(scramble, rest...)
+	}
+	return scramble, nil
+}
+
+// Returns error when payload is error packet.
+func mysqlPacketError(payload []byte) error {
+	if len(payload) == 0 || payload[0] != mysqlErrPacket {
+		return nil
+	}
+	if len(payload) < 3 {
+		return errors.New("malformed error packet")
+	}
+	code := binary.LittleEndian.Uint16(payload[1:3])
+	msg := payload[3:]
+	// sql state marker and 5 bytes of sql state.
+	if len(msg) >= 6 && msg[0] == '#' {
+		msg = msg[6:]
+	}
+	return errors.Newf("mysql error %d: %s", code, msg)
+}
+
+// Computes mysql_native_password auth response:
+// SHA1(password) XOR SHA1(scramble + SHA1(SHA1(password))).
+func mysqlNativePasswordHash(scramble []byte, password string) []byte {
+	if password == "" {
+		return nil
+	}
+
+	stage1 := sha1.Sum([]byte(password))
+	stage2 := sha1.Sum(stage1[:])
+
+	h := sha1.New()
+	h.Write(scramble)
+	h.Write(stage2[:])
+	result := h.Sum(nil)
+	for i := range result {
+		result[i] ^= stage1[i]
+	}
+	return result
+}
+
+// Minimal MySQL packets reader/writer.
+type mysqlConn struct {
+	conn net.Conn
+	// sequence id of the next packet.
+	seq byte
+}
+
+func (c *mysqlConn) readPacket() ([]byte, error) {
+	var header [4]byte
+	if _, err := io.ReadFull(c.conn, header[:]); err != nil {
+		return nil, err
+	}
+	size := int(header[0]) | int(header[1])<<8 | int(header[2])<<16
+	c.seq = header[3] + 1
+
+	payload := make([]byte, size)
+	if _, err := io.ReadFull(c.conn, payload); err != nil {
+		return nil, err
+	}
+	return payload, nil
+}
+
+func (c *mysqlConn) writePacket(payload []byte) error {
+	if len(payload) >= mysqlMaxPacketSize {
+		return errors.Newf("packet is too large: %d", len(payload))
+	}
+	packet := make([]byte, 4+len(payload))
+	packet[0] = byte(len(payload))
+	packet[1] = byte(len(payload) >> 8)
+	packet[2] = byte(len(payload) >> 16)
+	packet[3] = c.seq
+	copy(packet[4:], payload)
+	c.seq++
+
+	_, err := c.conn.Write(packet)
+	return err
+}
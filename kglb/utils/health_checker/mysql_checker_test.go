@@ -0,0 +1,205 @@
+package health_checker
+
+import (
+	"bytes"
+	"net"
+
+	. "gopkg.in/check.v1"
+
+	hc_pb "dropbox/proto/kglb/healthchecker"
+	. "godropbox/gocheck2"
+)
+
+var mysqlTestScramble = []byte("0123456789abcdefghij")
+
+// Fake mysql server supporting mysql_native_password authentication.
+type fakeMysql struct {
+	user     string
+	password string
+	// error packet sent instead of handshake.
+	handshakeErr []byte
+	// send auth switch request with the scramble.
+	switchScramble []byte
+	// error packet sent in response to query.
+	queryErr []byte
+}
+
+func mysqlErrPayload(code uint16, msg string) []byte {
+	return append(
+		[]byte{mysqlErrPacket, byte(code), byte(code >> 8), '#', 'H', 'Y', '0', '0', '0'},
+		msg...)
+}
+
+func (m *fakeMysql) handshake() []byte {
+	var buf bytes.Buffer
+	buf.WriteByte(mysqlProtocolVersion)
+	buf.WriteString("5.7.30-fake\x00")
+	// connection id.
+	buf.Write([]byte{1, 0, 0, 0})
+	buf.Write(mysqlTestScramble[:8])
+	buf.WriteByte(0)
+	// capabilities, charset, status, capabilities, auth data len, reserved.
+	buf.Write([]byte{0xff, 0xf7, 33, 2, 0, 0xff, 0x81, 21})
+	buf.Write(make([]byte, 10))
+	buf.Write(mysqlTestScramble[8:])
+	buf.WriteByte(0)
+	buf.WriteString(mysqlNativePassword + "\x00")
+	return buf.Bytes()
+}
+
+func (m *fakeMysql) serve(conn net.Conn) {
+	mc := &mysqlConn{conn: conn}
+	if m.handshakeErr != nil {
+		mc.writePacket(m.handshakeErr)
+		return
+	}
+	if err := mc.writePacket(m.handshake()); err != nil {
+		return
+	}
+
+	payload, err := mc.readPacket()
+	if err != nil {
+		return
+	}
+	// capabilities, max packet size, charset and reserved bytes.
+	payload = payload[32:]
+	userEnd := bytes.IndexByte(payload, 0)
+	user := string(payload[:userEnd])
+	authLen := int(payload[userEnd+1])
+	authResponse := payload[userEnd+2 : userEnd+2+authLen]
+
+	scramble := mysqlTestScramble
+	if m.switchScramble != nil {
+		scramble = m.switchScramble
+		switchReq := append([]byte{mysqlAuthSwitchPacket}, mysqlNativePassword+"\x00"...)
+		switchReq = append(switchReq, scramble...)
+		switchReq = append(switchReq, 0)
+		if err := mc.writePacket(switchReq); err != nil {
+			return
+		}
+		if authResponse, err = mc.readPacket(); err != nil {
+			return
+		}
+	}
+
+	expected := mysqlNativePasswordHash(scramble, m.password)
+	if user != m.user || !bytes.Equal(authResponse, expected) {
+		mc.writePacket(mysqlErrPayload(1045, "Access denied"))
+		return
+	}
+	if err := mc.writePacket([]byte{mysqlOkPacket, 0, 0, 2, 0, 0, 0}); err != nil {
+		return
+	}
+
+	for {
+		payload, err := mc.readPacket()
+		if err != nil || payload[0] == mysqlComQuit {
+			return
+		}
+		if m.queryErr != nil {
+			mc.writePacket(m.queryErr)
+			continue
+		}
+		// column count, the rest of result set is not needed by checker.
+		mc.writePacket([]byte{1})
+	}
+}
+
+type MysqlCheckerSuite struct{}
+
+var _ = Suite(&MysqlCheckerSuite{})
+
+func (s *MysqlCheckerSuite) TestHandshake(c *C) {
+	server := &fakeMysql{}
+	host, port, stop := startFakeServer(c, server.serve)
+	defer stop()
+
+	checker, err := NewMysqlChecker(&hc_pb.MysqlCheckerAttributes{}, nil)
+	c.Assert(err, NoErr)
+	c.Assert(checker.Check(host, port), NoErr)
+}
+
+func (s *MysqlCheckerSuite) TestHandshakeError(c *C) {
+	server := &fakeMysql{
+		handshakeErr: mysqlErrPayload(1040, "Too many connections"),
+	}
+	host, port, stop := startFakeServer(c, server.serve)
+	defer stop()
+
+	checker, err := NewMysqlChecker(&hc_pb.MysqlCheckerAttributes{}, nil)
+	c.Assert(err, NoErr)
+	err = checker.Check(host, port)
+	c.Assert(err, NotNil)
+	c.Assert(err, ErrorMatches, "(?s).*Too many connections.*")
+}
+
+func (s *MysqlCheckerSuite) TestAuthAndQuery(c *C) {
+	server := &fakeMysql{user: "kglb", password: "secret"}
+	host, port, stop := startFakeServer(c, server.serve)
+	defer stop()
+
+	// 1. valid credentials.
+	checker, err := NewMysqlChecker(&hc_pb.MysqlCheckerAttributes{
+		User:     "kglb",
+		Password: "secret",
+		Query:    true,
+	}, nil)
+	c.Assert(err, NoErr)
+	c.Assert(checker.Check(host, port), NoErr)
+
+	// 2. invalid password.
+	checker, err = NewMysqlChecker(&hc_pb.MysqlCheckerAttributes{
+		User:     "kglb",
+		Password: "wrong",
+	}, nil)
+	c.Assert(err, NoErr)
+	c.Assert(checker.Check(host, port), NotNil)
+}
+
+func (s *MysqlCheckerSuite) TestAuthSwitch(c *C) {
+	server := &fakeMysql{
+		user:           "kglb",
+		password:       "secret",
+		switchScramble: []byte("jihgfedcba9876543210"),
+	}
+	host, port, stop := startFakeServer(c, server.serve)
+	defer stop()
+
+	checker, err := NewMysqlChecker(&hc_pb.MysqlCheckerAttributes{
+		User:     "kglb",
+		Password: "secret",
+	}, nil)
+	c.Assert(err, NoErr)
+	c.Assert(checker.Check(host, port), NoErr)
+}
+
+func (s *MysqlCheckerSuite) TestQueryError(c *C) {
+	server := &fakeMysql{
+		user:     "kglb",
+		password: "secret",
+		queryErr: mysqlErrPayload(1205, "Lock wait timeout exceeded"),
+	}
+	host, port, stop := startFakeServer(c, server.serve)
+	defer stop()
+
+	checker, err := NewMysqlChecker(&hc_pb.MysqlCheckerAttributes{
+		User:     "kglb",
+		Password: "secret",
+	}, nil)
+	c.Assert(err, NoErr)
+	// query is disabled.
+	c.Assert(checker.Check(host, port), NoErr)
+
+	checker, err = NewMysqlChecker(&hc_pb.MysqlCheckerAttributes{
+		User:     "kglb",
+		Password: "secret",
+		Query:    true,
+	}, nil)
+	c.Assert(err, NoErr)
+	c.Assert(checker.Check(host, port), NotNil)
+}
+
+func (s *MysqlCheckerSuite) TestInvalidParams(c *C) {
+	_, err := NewMysqlChecker(&hc_pb.MysqlCheckerAttributes{Query: true}, nil)
+	c.Assert(err, NotNil)
+}
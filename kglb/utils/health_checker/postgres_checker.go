@@ -0,0 +1,447 @@
+package health_checker
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+
+	hc_pb "dropbox/proto/kglb/healthchecker"
+	"godropbox/errors"
+)
+
+const (
+	postgresProtocolVersion = 3 << 16
+	postgresScramSha256     = "SCRAM-SHA-256"
+
+	// authentication request codes.
+	postgresAuthOk           = 0
+	postgresAuthCleartext    = 3
+	postgresAuthMd5          = 5
+	postgresAuthSasl         = 10
+	postgresAuthSaslContinue = 11
+	postgresAuthSaslFinal    = 12
+
+	postgresSelectOneQuery  = "SELECT 1"
+	postgresInRecoveryQuery = "SELECT pg_is_in_recovery()"
+
+	// max iterations of SCRAM key derivation, they are chosen by the server
+	// and derivation isn't interrupted by deadline of the connection.
+	maxScramIterations = 1 << 20
+)
+
+// generates client nonce for SCRAM authentication, it's replaced in tests.
+var generateScramNonce = func() (string, error) {
+	buf := make([]byte, 18)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(buf), nil
+}
+
+var _ HealthChecker = &PostgresChecker{}
+
+// PostgreSQL health checker. It authenticates and runs query depending on
+// required role of the instance: "SELECT 1" for any role and
+// pg_is_in_recovery() to distinguish primary and replica.
+type PostgresChecker struct {
+	params      *hc_pb.PostgresCheckerAttributes
+	dialContext DialContextFunc
+}
+
+func NewPostgresChecker(params *hc_pb.PostgresCheckerAttributes, dialContext DialContextFunc) (*PostgresChecker, error) {
+	checker := &PostgresChecker{
+		params:      params,
+		dialContext: defaultDialContext,
+	}
+
+	if dialContext != nil {
+		checker.dialContext = dialContext
+	}
+
+	if params.GetUser() == "" {
+		return nil, errors.Newf("User is required: %+v", params)
+	}
+
+	return checker, nil
+}
+
+func (h *PostgresChecker) GetConfiguration() *hc_pb.HealthCheckerAttributes {
+	return &hc_pb.HealthCheckerAttributes{
+		Attributes: &hc_pb.HealthCheckerAttributes_Postgres{
+			Postgres: h.params,
+		},
+	}
+}
+
+// Performs test and returns nil when query succeeds and the instance has
+// required role.
+func (h *PostgresChecker) Check(host string, port int) error {
+	timeout := timeoutMsToDuration(h.params.GetCheckTimeoutMs())
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	address := net.JoinHostPort(host, strconv.Itoa(port))
+	c, err := h.dialContext(ctx, "tcp", address)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		c.SetDeadline(deadline)
+	}
+
+	conn := &postgresConn{conn: c}
+
+	if err := h.startup(conn); err != nil {
+		return errors.Wrapf(err, "startup fails on %s: ", address)
+	}
+
+	query := postgresSelectOneQuery
+	if h.params.GetRole() != hc_pb.PostgresCheckerAttributes_ANY {
+		query = postgresInRecoveryQuery
+	}
+	value, err := conn.query(query)
+	if err != nil {
+		return errors.Wrapf(err, "query fails on %s: ", address)
+	}
+	// server closes connection in response, so result is ignored.
+	_ = conn.writeMessage('X', nil)
+
+	switch h.params.GetRole() {
+	case hc_pb.PostgresCheckerAttributes_ANY:
+		return nil
+	case hc_pb.PostgresCheckerAttributes_PRIMARY:
+		if value != "f" {
+			return errors.Newf("%s is in recovery, but primary is required", address)
+		}
+	case hc_pb.PostgresCheckerAttributes_REPLICA:
+		if value != "t" {
+			return errors.Newf("%s is not in recovery, but replica is required", address)
+		}
+	default:
+		return errors.Newf("unknown role: %v", h.params.GetRole())
+	}
+	return nil
+}
+
+// Sends startup message and passes authentication until ReadyForQuery.
+func (h *PostgresChecker) startup(conn *postgresConn) error {
+	user := h.params.GetUser()
+
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.BigEndian, uint32(postgresProtocolVersion))
+	buf.WriteString("user\x00" + user + "\x00")
+	if h.params.GetDatabase() != "" {
+		buf.WriteString("database\x00" + h.params.GetDatabase() + "\x00")
+	}
+	buf.WriteByte(0)
+	// startup message has no type byte.
+	if err := conn.writeMessage(0, buf.Bytes()); err != nil {
+		return err
+	}
+
+	var scram *scramClient
+	for {
+		msgType, payload, err := conn.readMessage()
+		if err != nil {
+			return err
+		}
+
+		switch msgType {
+		case 'E':
+			return postgresError(payload)
+		case 'Z':
+			return nil
+		case 'R':
+		default:
+			// ParameterStatus, BackendKeyData, NoticeResponse and etc.
+			continue
+		}
+
+		if len(payload) < 4 {
+			return errors.New("malformed authentication request")
+		}
+		code := binary.BigEndian.Uint32(payload)
+		data := payload[4:]
+
+		switch code {
+		case postgresAuthOk:
+		case postgresAuthCleartext:
+			err = conn.writeMessage('p', []byte(h.params.GetPassword()+"\x00"))
+		case postgresAuthMd5:
+			if len(data) != 4 {
+				return errors.New("malformed md5 salt")
+			}
+			err = conn.writeMessage(
+				'p',
+				[]byte(postgresMd5Password(user, h.params.GetPassword(), data)+"\x00"))
+		case postgresAuthSasl:
+			mechanisms := strings.Split(strings.TrimRight(string(data), "\x00"), "\x00")
+			supported := false
+			for _, mechanism := range mechanisms {
+				if mechanism == postgresScramSha256 {
+					supported = true
+				}
+			}
+			if !supported {
+				return errors.Newf("unsupported SASL mechanisms: %v", mechanisms)
+			}
+			nonce, err := generateScramNonce()
+			if err != nil {
+				return err
+			}
+			scram = newScramClient(user, h.params.GetPassword(), nonce)
+			clientFirst := scram.clientFirstMessage()
+
+			var msg bytes.Buffer
+			msg.WriteString(postgresScramSha256 + "\x00")
+			binary.Write(&msg, binary.BigEndian, uint32(len(clientFirst)))
+			msg.WriteString(clientFirst)
+			if err := conn.writeMessage('p', msg.Bytes()); err != nil {
+				return err
+			}
+		case postgresAuthSaslContinue:
+			if scram == nil {
+				return errors.New("unexpected SASL continue message")
+			}
+			clientFinal, err := scram.clientFinalMessage(string(data))
+			if err != nil {
+				return err
+			}
+			if err := conn.writeMessage('p', []byte(clientFinal)); err != nil {
+				return err
+			}
+		case postgresAuthSaslFinal:
+			if scram == nil {
+				return errors.New("unexpected SASL final message")
+			}
+			if err := scram.verifyServerFinal(string(data)); err != nil {
+				return err
+			}
+		default:
+			return errors.Newf("unsupported authentication method: %d", code)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// Computes md5 password response: "md5" + md5(md5(password + user) + salt).
+func postgresMd5Password(user, password string, salt []byte) string {
+	inner := md5.Sum([]byte(password + user))
+	innerHex := hex.EncodeToString(inner[:])
+	outer := md5.Sum(append([]byte(innerHex), salt...))
+	return "md5" + hex.EncodeToString(outer[:])
+}
+
+// Converts ErrorResponse payload into error.
+func postgresError(payload []byte) error {
+	var severity, code, message string
+	for _, field := range bytes.Split(payload, []byte{0}) {
+		if len(field) == 0 {
+			continue
+		}
+		switch field[0] {
+		case 'S':
+			severity = string(field[1:])
+		case 'C':
+			code = string(field[1:])
+		case 'M':
+			message = string(field[1:])
+		}
+	}
+	return errors.Newf("postgres %s %s: %s", severity, code, message)
+}
+
+// Minimal PostgreSQL frontend messages reader/writer.
+type postgresConn struct {
+	conn net.Conn
+}
+
+// Writes message, zero msgType means message without type byte.
+func (c *postgresConn) writeMessage(msgType byte, payload []byte) error {
+	var buf bytes.Buffer
+	if msgType != 0 {
+		buf.WriteByte(msgType)
+	}
+	binary.Write(&buf, binary.BigEndian, uint32(len(payload)+4))
+	buf.Write(payload)
+
+	_, err := c.conn.Write(buf.Bytes())
+	return err
+}
+
+func (c *postgresConn) readMessage() (byte, []byte, error) {
+	var header [5]byte
+	if _, err := io.ReadFull(c.conn, header[:]); err != nil {
+		return 0, nil, err
+	}
+	size := binary.BigEndian.Uint32(header[1:])
+	if size < 4 || size > 1<<20 {
+		return 0, nil, errors.Newf("invalid message size: %d", size)
+	}
+
+	payload := make([]byte, size-4)
+	if _, err := io.ReadFull(c.conn, payload); err != nil {
+		return 0, nil, err
+	}
+	return header[0], payload, nil
+}
+
+// Runs simple query and returns text value of the first column of the first
+// row.
+func (c *postgresConn) query(query string) (string, error) {
+	if err := c.writeMessage('Q', []byte(query+"\x00")); err != nil {
+		return "", err
+	}
+
+	var value string
+	var queryErr error
+	for {
+		msgType, payload, err := c.readMessage()
+		if err != nil {
+			return "", err
+		}
+
+		switch msgType {
+		case 'E':
+			queryErr = postgresError(payload)
+		case 'D':
+			if len(payload) < 6 || binary.BigEndian.Uint16(payload) == 0 {
+				continue
+			}
+			size := int32(binary.BigEndian.Uint32(payload[2:]))
+			if size > 0 && int(size) <= len(payload)-6 {
+				value = string(payload[6 : 6+size])
+			}
+		case 'Z':
+			return value, queryErr
+		}
+	}
+}
+
+// Client side of SCRAM-SHA-256 authentication (RFC 5802, RFC 7677).
+type scramClient struct {
+	user        string
+	password    string
+	clientNonce string
+
+	clientFirstBare string
+	serverSignature []byte
+}
+
+func newScramClient(user, password, nonce string) *scramClient {
+	return &scramClient{
+		user:        user,
+		password:    password,
+		clientNonce: nonce,
+	}
+}
+
+func (s *scramClient) clientFirstMessage() string {
+	// postgres ignores user name and takes it from startup message.
+	user := strings.NewReplacer("=", "=3D", ",", "=2C").Replace(s.user)
+	s.clientFirstBare = "n=" + user + ",r=" + s.clientNonce
+	return "n,," + s.clientFirstBare
+}
+
+func (s *scramClient) clientFinalMessage(serverFirst string) (string, error) {
+	var nonce, salt string
+	var iterations int
+	for _, attr := range strings.Split(serverFirst, ",") {
+		if len(attr) < 2 || attr[1] != '=' {
+			return "", errors.Newf("malformed SCRAM server message: %q", serverFirst)
+		}
+		switch attr[0] {
+		case 'r':
+			nonce = attr[2:]
+		case 's':
+			salt = attr[2:]
+		case 'i':
+			var err error
+			if iterations, err = strconv.Atoi(attr[2:]); err != nil {
+				return "", errors.Wrapf(err, "invalid SCRAM iterations: ")
+			}
+		}
+	}
+	if !strings.HasPrefix(nonce, s.clientNonce) || iterations <= 0 {
+		return "", errors.Newf("invalid SCRAM server message: %q", serverFirst)
+	}
+	if iterations > maxScramIterations {
+		return "", errors.Newf("too many SCRAM iterations: %d", iterations)
+	}
+	saltBytes, err := base64.StdEncoding.DecodeString(salt)
+	if err != nil {
+		return "", errors.Wrapf(err, "invalid SCRAM salt: ")
+	}
+
+	saltedPassword := pbkdf2Sha256([]byte(s.password), saltBytes, iterations)
+	clientKey := hmacSha256(saltedPassword, []byte("Client Key"))
+	storedKey := sha256.Sum256(clientKey)
+	serverKey := hmacSha256(saltedPassword, []byte("Server Key"))
+
+	// "biws" is base64 of "n,," gs2 header.
+	clientFinalWithoutProof := "c=biws,r=" + nonce
+	authMessage := []byte(
+		s.clientFirstBare + "," + serverFirst + "," + clientFinalWithoutProof)
+
+	clientSignature := hmacSha256(storedKey[:], authMessage)
+	proof := make([]byte, len(clientKey))
+	for i := range clientKey {
+		proof[i] = clientKey[i] ^ clientSignature[i]
+	}
+	s.serverSignature = hmacSha256(serverKey, authMessage)
+
+	return clientFinalWithoutProof + ",p=" + base64.StdEncoding.EncodeToString(proof), nil
+}
+
+func (s *scramClient) verifyServerFinal(serverFinal string) error {
+	if !strings.HasPrefix(serverFinal, "v=") {
+		return errors.Newf("invalid SCRAM server final message: %q", serverFinal)
+	}
+	signature, err := base64.StdEncoding.DecodeString(serverFinal[2:])
+	if err != nil {
+		return errors.Wrapf(err, "invalid SCRAM server signature: ")
+	}
+	if !hmac.Equal(signature, s.serverSignature) {
+		return errors.New("SCRAM server signature mismatch")
+	}
+	return nil
+}
+
+func hmacSha256(key, data []byte) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write(data)
+	return h.Sum(nil)
+}
+
+// PBKDF2 with HMAC-SHA-256 producing single block of derived key which is
+// enough for SCRAM-SHA-256.
+func pbkdf2Sha256(password, salt []byte, iterations int) []byte {
+	prf := hmac.New(sha256.New, password)
+	prf.Write(salt)
+	prf.Write([]byte{0, 0, 0, 1})
+	u := prf.Sum(nil)
+
+	result := append([]byte{}, u...)
+	for i := 1; i < iterations; i++ {
+		prf.Reset()
+		prf.Write(u)
+		u = prf.Sum(u[:0])
+		for j := range result {
+			result[j] ^= u[j]
+		}
+	}
+	return result
+}
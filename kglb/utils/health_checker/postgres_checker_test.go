@@ -0,0 +1,266 @@
+package health_checker
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+
+	. "gopkg.in/check.v1"
+
+	hc_pb "dropbox/proto/kglb/healthchecker"
+	. "godropbox/gocheck2"
+)
+
+// SCRAM-SHA-256 exchange from RFC 7677.
+const (
+	scramTestPassword    = "pencil"
+	scramTestClientNonce = "rOprNGfwEbeRWgbNEkqO"
+	scramTestServerFirst = "r=rOprNGfwEbeRWgbNEkqO%hvYDpWUa2RaTCAfuxFIlj)hNlF$k0," +
+		"s=W22ZaJ0SNY7soEsUEjb6gQ==,i=4096"
+	scramTestClientFinal = "c=biws,r=rOprNGfwEbeRWgbNEkqO%hvYDpWUa2RaTCAfuxFIlj)hNlF$k0," +
+		"p=dHzbZapWIk4jUhN+Ute9ytag9zjfMHgsqmmiz7AndVQ="
+	scramTestServerFinal = "v=6rriTRBi23WpRR/wtup+mMhUZUn/dB5nLTJRsjl95G4="
+)
+
+// Fake postgres server.
+type fakePostgres struct {
+	user string
+	// authentication method code.
+	authMethod uint32
+	password   string
+	inRecovery bool
+	// error sent instead of ReadyForQuery after authentication.
+	startupErr string
+}
+
+func (p *fakePostgres) writeAuth(conn *postgresConn, code uint32, data []byte) error {
+	payload := make([]byte, 4, 4+len(data))
+	binary.BigEndian.PutUint32(payload, code)
+	return conn.writeMessage('R', append(payload, data...))
+}
+
+func (p *fakePostgres) writeError(conn *postgresConn, msg string) {
+	conn.writeMessage('E', []byte("SFATAL\x00C28P01\x00M"+msg+"\x00\x00"))
+}
+
+// Reads password message.
+func (p *fakePostgres) readPassword(conn *postgresConn) (string, bool) {
+	msgType, payload, err := conn.readMessage()
+	if err != nil || msgType != 'p' {
+		return "", false
+	}
+	return string(payload), true
+}
+
+func (p *fakePostgres) serve(c net.Conn) {
+	conn := &postgresConn{conn: c}
+
+	// startup message.
+	var size uint32
+	if err := binary.Read(c, binary.BigEndian, &size); err != nil {
+		return
+	}
+	startup := make([]byte, size-4)
+	if _, err := io.ReadFull(c, startup); err != nil {
+		return
+	}
+	params := bytes.Split(startup[4:], []byte{0})
+	var user string
+	for i := 0; i+1 < len(params); i += 2 {
+		if string(params[i]) == "user" {
+			user = string(params[i+1])
+		}
+	}
+	if user != p.user {
+		p.writeError(conn, "role does not exist")
+		return
+	}
+
+	switch p.authMethod {
+	case postgresAuthCleartext:
+		p.writeAuth(conn, postgresAuthCleartext, nil)
+		password, ok := p.readPassword(conn)
+		if !ok || password != p.password+"\x00" {
+			p.writeError(conn, "password authentication failed")
+			return
+		}
+	case postgresAuthMd5:
+		salt := []byte{1, 2, 3, 4}
+		p.writeAuth(conn, postgresAuthMd5, salt)
+		password, ok := p.readPassword(conn)
+		if !ok || password != postgresMd5Password(user, p.password, salt)+"\x00" {
+			p.writeError(conn, "password authentication failed")
+			return
+		}
+	case postgresAuthSasl:
+		p.writeAuth(conn, postgresAuthSasl, []byte(postgresScramSha256+"\x00\x00"))
+		initial, ok := p.readPassword(conn)
+		expected := postgresScramSha256 + "\x00\x00\x00\x00\x20" +
+			"n,,n=user,r=" + scramTestClientNonce
+		if !ok || initial != expected {
+			p.writeError(conn, "invalid SASL initial response")
+			return
+		}
+		p.writeAuth(conn, postgresAuthSaslContinue, []byte(scramTestServerFirst))
+		final, ok := p.readPassword(conn)
+		if !ok || final != scramTestClientFinal {
+			p.writeError(conn, "password authentication failed")
+			return
+		}
+		p.writeAuth(conn, postgresAuthSaslFinal, []byte(scramTestServerFinal))
+	}
+	p.writeAuth(conn, postgresAuthOk, nil)
+	conn.writeMessage('S', []byte("server_version\x0012.3\x00"))
+
+	if p.startupErr != "" {
+		p.writeError(conn, p.startupErr)
+		return
+	}
+	conn.writeMessage('Z', []byte{'I'})
+
+	for {
+		msgType, payload, err := conn.readMessage()
+		if err != nil || msgType == 'X' {
+			return
+		}
+
+		value := "1"
+		if string(payload) == postgresInRecoveryQuery+"\x00" {
+			value = "f"
+			if p.inRecovery {
+				value = "t"
+			}
+		}
+		conn.writeMessage('T', []byte("\x00\x01col\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x17\x00\x04\xff\xff\xff\xff\x00\x00"))
+		row := []byte{0, 1, 0, 0, 0, byte(len(value))}
+		conn.writeMessage('D', append(row, value...))
+		conn.writeMessage('C', []byte("SELECT 1\x00"))
+		conn.writeMessage('Z', []byte{'I'})
+	}
+}
+
+type PostgresCheckerSuite struct {
+	origNonceFunc func() (string, error)
+}
+
+var _ = Suite(&PostgresCheckerSuite{})
+
+func (s *PostgresCheckerSuite) SetUpTest(c *C) {
+	s.origNonceFunc = generateScramNonce
+	generateScramNonce = func() (string, error) {
+		return scramTestClientNonce, nil
+	}
+}
+
+func (s *PostgresCheckerSuite) TearDownTest(c *C) {
+	generateScramNonce = s.origNonceFunc
+}
+
+func (s *PostgresCheckerSuite) TestAuthMethods(c *C) {
+	for _, method := range []uint32{
+		postgresAuthOk,
+		postgresAuthCleartext,
+		postgresAuthMd5,
+		postgresAuthSasl,
+	} {
+		server := &fakePostgres{
+			user:       "user",
+			password:   scramTestPassword,
+			authMethod: method,
+		}
+		host, port, stop := startFakeServer(c, server.serve)
+
+		checker, err := NewPostgresChecker(&hc_pb.PostgresCheckerAttributes{
+			User:     "user",
+			Password: scramTestPassword,
+		}, nil)
+		c.Assert(err, NoErr)
+		c.Assert(checker.Check(host, port), NoErr, Commentf("method: %d", method))
+
+		checker, err = NewPostgresChecker(&hc_pb.PostgresCheckerAttributes{
+			User:     "user",
+			Password: "wrong",
+		}, nil)
+		c.Assert(err, NoErr)
+		err = checker.Check(host, port)
+		if method == postgresAuthOk {
+			c.Assert(err, NoErr)
+		} else {
+			c.Assert(err, NotNil, Commentf("method: %d", method))
+		}
+
+		stop()
+	}
+}
+
+func (s *PostgresCheckerSuite) TestRole(c *C) {
+	primary := &fakePostgres{user: "user"}
+	primaryHost, primaryPort, stopPrimary := startFakeServer(c, primary.serve)
+	defer stopPrimary()
+	replica := &fakePostgres{user: "user", inRecovery: true}
+	replicaHost, replicaPort, stopReplica := startFakeServer(c, replica.serve)
+	defer stopReplica()
+
+	primaryChecker, err := NewPostgresChecker(&hc_pb.PostgresCheckerAttributes{
+		User: "user",
+		Role: hc_pb.PostgresCheckerAttributes_PRIMARY,
+	}, nil)
+	c.Assert(err, NoErr)
+	c.Assert(primaryChecker.Check(primaryHost, primaryPort), NoErr)
+	c.Assert(primaryChecker.Check(replicaHost, replicaPort), NotNil)
+
+	replicaChecker, err := NewPostgresChecker(&hc_pb.PostgresCheckerAttributes{
+		User: "user",
+		Role: hc_pb.PostgresCheckerAttributes_REPLICA,
+	}, nil)
+	c.Assert(err, NoErr)
+	c.Assert(replicaChecker.Check(primaryHost, primaryPort), NotNil)
+	c.Assert(replicaChecker.Check(replicaHost, replicaPort), NoErr)
+}
+
+func (s *PostgresCheckerSuite) TestStartupError(c *C) {
+	server := &fakePostgres{
+		user:       "user",
+		startupErr: "the database system is starting up",
+	}
+	host, port, stop := startFakeServer(c, server.serve)
+	defer stop()
+
+	checker, err := NewPostgresChecker(&hc_pb.PostgresCheckerAttributes{
+		User: "user",
+	}, nil)
+	c.Assert(err, NoErr)
+	err = checker.Check(host, port)
+	c.Assert(err, NotNil)
+	c.Assert(err, ErrorMatches, "(?s).*starting up.*")
+
+	// unknown user.
+	checker, err = NewPostgresChecker(&hc_pb.PostgresCheckerAttributes{
+		User: "unknown",
+	}, nil)
+	c.Assert(err, NoErr)
+	c.Assert(checker.Check(host, port), NotNil)
+}
+
+func (s *PostgresCheckerSuite) TestScramClient(c *C) {
+	client := newScramClient("user", scramTestPassword, scramTestClientNonce)
+	c.Assert(client.clientFirstMessage(), Equals, "n,,n=user,r="+scramTestClientNonce)
+
+	final, err := client.clientFinalMessage(scramTestServerFirst)
+	c.Assert(err, NoErr)
+	c.Assert(final, Equals, scramTestClientFinal)
+
+	c.Assert(client.verifyServerFinal(scramTestServerFinal), NoErr)
+	c.Assert(client.verifyServerFinal("v=AAAA"), NotNil)
+
+	// iterations are limited.
+	_, err = client.clientFinalMessage(
+		"r=" + scramTestClientNonce + "abc,s=QSXCR+Q6sek8bf92,i=2000000")
+	c.Assert(err, NotNil)
+}
+
+func (s *PostgresCheckerSuite) TestInvalidParams(c *C) {
+	_, err := NewPostgresChecker(&hc_pb.PostgresCheckerAttributes{}, nil)
+	c.Assert(err, NotNil)
+}
@@ -0,0 +1,191 @@
+package health_checker
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+
+	hc_pb "dropbox/proto/kglb/healthchecker"
+	"godropbox/errors"
+)
+
+var _ HealthChecker = &RedisChecker{}
+
+// max size of bulk string of the reply, the size is sent by the server.
+const maxRedisBulkSize = 1 << 20
+
+// Redis health checker. It validates PING reply and optionally role of the
+// instance.
+type RedisChecker struct {
+	params      *hc_pb.RedisCheckerAttributes
+	dialContext DialContextFunc
+}
+
+func NewRedisChecker(params *hc_pb.RedisCheckerAttributes, dialContext DialContextFunc) (*RedisChecker, error) {
+	checker := &RedisChecker{
+		params:      params,
+		dialContext: defaultDialContext,
+	}
+
+	if dialContext != nil {
+		checker.dialContext = dialContext
+	}
+
+	return checker, nil
+}
+
+func (h *RedisChecker) GetConfiguration() *hc_pb.HealthCheckerAttributes {
+	return &hc_pb.HealthCheckerAttributes{
+		Attributes: &hc_pb.HealthCheckerAttributes_Redis{
+			Redis: h.params,
+		},
+	}
+}
+
+// Performs test and returns nil when instance replies to PING and has
+// required role.
+func (h *RedisChecker) Check(host string, port int) error {
+	timeout := timeoutMsToDuration(h.params.GetCheckTimeoutMs())
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	address := net.JoinHostPort(host, strconv.Itoa(port))
+	conn, err := h.dialContext(ctx, "tcp", address)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	reader := bufio.NewReader(conn)
+
+	if h.params.GetPassword() != "" {
+		reply, err := redisCommand(conn, reader, "AUTH", h.params.GetPassword())
+		if err != nil {
+			return errors.Wrapf(err, "AUTH fails on %s: ", address)
+		}
+		if reply != "OK" {
+			return errors.Newf("unexpected AUTH reply from %s: %q", address, reply)
+		}
+	}
+
+	reply, err := redisCommand(conn, reader, "PING")
+	if err != nil {
+		return errors.Wrapf(err, "PING fails on %s: ", address)
+	}
+	if reply != "PONG" {
+		return errors.Newf("unexpected PING reply from %s: %q", address, reply)
+	}
+
+	var expectedRole string
+	switch h.params.GetRole() {
+	case hc_pb.RedisCheckerAttributes_ANY:
+		return nil
+	case hc_pb.RedisCheckerAttributes_MASTER:
+		expectedRole = "master"
+	case hc_pb.RedisCheckerAttributes_SLAVE:
+		expectedRole = "slave"
+	default:
+		return errors.Newf("unknown role: %v", h.params.GetRole())
+	}
+
+	// ROLE replies with array where the first element is the role name.
+	reply, err = redisCommand(conn, reader, "ROLE")
+	if err != nil {
+		return errors.Wrapf(err, "ROLE fails on %s: ", address)
+	}
+	if reply != expectedRole {
+		return errors.Newf(
+			"unexpected role of %s: %q, expected: %q",
+			address,
+			reply,
+			expectedRole)
+	}
+
+	return nil
+}
+
+// Sends command and returns simplified reply: status, integer or bulk string
+// value, or the first element of array reply.
+func redisCommand(conn net.Conn, reader *bufio.Reader, args ...string) (string, error) {
+	var b strings.Builder
+	fmt.Fprintf(&b, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	if _, err := conn.Write([]byte(b.String())); err != nil {
+		return "", err
+	}
+
+	return readRedisReply(reader)
+}
+
+// Reads single RESP reply. Nested elements of array are read and skipped
+// except the first one.
+func readRedisReply(reader *bufio.Reader) (string, error) {
+	line, err := readRedisLine(reader)
+	if err != nil {
+		return "", err
+	}
+	if len(line) == 0 {
+		return "", errors.New("empty reply")
+	}
+
+	switch line[0] {
+	case '+', ':':
+		return line[1:], nil
+	case '-':
+		return "", errors.Newf("error reply: %s", line[1:])
+	case '$':
+		size, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return "", errors.Wrapf(err, "invalid bulk string length: %q: ", line)
+		}
+		if size < 0 {
+			return "", nil
+		}
+		if size > maxRedisBulkSize {
+			return "", errors.Newf("bulk string is too long: %d", size)
+		}
+		buf := make([]byte, size+2)
+		if _, err := io.ReadFull(reader, buf); err != nil {
+			return "", err
+		}
+		return string(buf[:size]), nil
+	case '*':
+		count, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return "", errors.Wrapf(err, "invalid array length: %q: ", line)
+		}
+		var first string
+		for i := 0; i < count; i++ {
+			value, err := readRedisReply(reader)
+			if err != nil {
+				return "", err
+			}
+			if i == 0 {
+				first = value
+			}
+		}
+		return first, nil
+	default:
+		return "", errors.Newf("unknown reply type: %q", line)
+	}
+}
+
+func readRedisLine(reader *bufio.Reader) (string, error) {
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	if !strings.HasSuffix(line, "\r\n") {
+		return "", errors.Newf("malformed reply line: %q", line)
+	}
+	return line[:len(line)-2], nil
+}
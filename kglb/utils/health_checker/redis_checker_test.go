@@ -0,0 +1,185 @@
+package health_checker
+
+import (
+	"bufio"
+	"net"
+	"strconv"
+	"strings"
+
+	. "gopkg.in/check.v1"
+
+	hc_pb "dropbox/proto/kglb/healthchecker"
+	. "godropbox/gocheck2"
+)
+
+// Starts tcp server on localhost calling handler for every accepted
+// connection. Returns host, port and function to stop the server.
+func startFakeServer(c *C, handler func(conn net.Conn)) (string, int, func()) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	c.Assert(err, NoErr)
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				handler(conn)
+			}()
+		}
+	}()
+
+	host, portStr, err := net.SplitHostPort(ln.Addr().String())
+	c.Assert(err, NoErr)
+	port, err := strconv.Atoi(portStr)
+	c.Assert(err, NoErr)
+	return host, port, func() { ln.Close() }
+}
+
+// Fake redis server replying to known commands.
+type fakeRedis struct {
+	password string
+	// raw ROLE reply.
+	roleReply string
+}
+
+func (r *fakeRedis) serve(conn net.Conn) {
+	reader := bufio.NewReader(conn)
+	authenticated := r.password == ""
+	for {
+		// reading command: "*<n>" followed by n bulk strings.
+		line, err := readRedisLine(reader)
+		if err != nil {
+			return
+		}
+		count, _ := strconv.Atoi(strings.TrimPrefix(line, "*"))
+		var args []string
+		for i := 0; i < count; i++ {
+			value, err := readRedisReply(reader)
+			if err != nil {
+				return
+			}
+			args = append(args, value)
+		}
+
+		var reply string
+		switch {
+		case args[0] == "AUTH":
+			if args[1] == r.password {
+				authenticated = true
+				reply = "+OK\r\n"
+			} else {
+				reply = "-ERR invalid password\r\n"
+			}
+		case !authenticated:
+			reply = "-NOAUTH Authentication required.\r\n"
+		case args[0] == "PING":
+			reply = "+PONG\r\n"
+		case args[0] == "ROLE":
+			reply = r.roleReply
+		default:
+			reply = "-ERR unknown command\r\n"
+		}
+		if _, err := conn.Write([]byte(reply)); err != nil {
+			return
+		}
+	}
+}
+
+const (
+	redisMasterRoleReply = "*3\r\n$6\r\nmaster\r\n:3129659\r\n*1\r\n" +
+		"*3\r\n$9\r\n127.0.0.1\r\n$4\r\n9001\r\n$7\r\n3129242\r\n"
+	redisSlaveRoleReply = "*5\r\n$5\r\nslave\r\n$9\r\n127.0.0.1\r\n" +
+		":9000\r\n$9\r\nconnected\r\n:3167038\r\n"
+)
+
+type RedisCheckerSuite struct{}
+
+var _ = Suite(&RedisCheckerSuite{})
+
+func (s *RedisCheckerSuite) TestPing(c *C) {
+	server := &fakeRedis{}
+	host, port, stop := startFakeServer(c, server.serve)
+	defer stop()
+
+	checker, err := NewRedisChecker(&hc_pb.RedisCheckerAttributes{}, nil)
+	c.Assert(err, NoErr)
+	c.Assert(checker.Check(host, port), NoErr)
+}
+
+func (s *RedisCheckerSuite) TestAuth(c *C) {
+	server := &fakeRedis{password: "secret"}
+	host, port, stop := startFakeServer(c, server.serve)
+	defer stop()
+
+	// 1. without password.
+	checker, err := NewRedisChecker(&hc_pb.RedisCheckerAttributes{}, nil)
+	c.Assert(err, NoErr)
+	c.Assert(checker.Check(host, port), NotNil)
+
+	// 2. wrong password.
+	checker, err = NewRedisChecker(&hc_pb.RedisCheckerAttributes{
+		Password: "wrong",
+	}, nil)
+	c.Assert(err, NoErr)
+	c.Assert(checker.Check(host, port), NotNil)
+
+	// 3. valid password.
+	checker, err = NewRedisChecker(&hc_pb.RedisCheckerAttributes{
+		Password: "secret",
+	}, nil)
+	c.Assert(err, NoErr)
+	c.Assert(checker.Check(host, port), NoErr)
+}
+
+func (s *RedisCheckerSuite) TestRole(c *C) {
+	master := &fakeRedis{roleReply: redisMasterRoleReply}
+	masterHost, masterPort, stopMaster := startFakeServer(c, master.serve)
+	defer stopMaster()
+	slave := &fakeRedis{roleReply: redisSlaveRoleReply}
+	slaveHost, slavePort, stopSlave := startFakeServer(c, slave.serve)
+	defer stopSlave()
+
+	masterChecker, err := NewRedisChecker(&hc_pb.RedisCheckerAttributes{
+		Role: hc_pb.RedisCheckerAttributes_MASTER,
+	}, nil)
+	c.Assert(err, NoErr)
+	c.Assert(masterChecker.Check(masterHost, masterPort), NoErr)
+	c.Assert(masterChecker.Check(slaveHost, slavePort), NotNil)
+
+	slaveChecker, err := NewRedisChecker(&hc_pb.RedisCheckerAttributes{
+		Role: hc_pb.RedisCheckerAttributes_SLAVE,
+	}, nil)
+	c.Assert(err, NoErr)
+	c.Assert(slaveChecker.Check(masterHost, masterPort), NotNil)
+	c.Assert(slaveChecker.Check(slaveHost, slavePort), NoErr)
+}
+
+func (s *RedisCheckerSuite) TestLoading(c *C) {
+	host, port, stop := startFakeServer(c, func(conn net.Conn) {
+		conn.Write([]byte(
+			"-LOADING Redis is loading the dataset in memory\r\n"))
+	})
+	defer stop()
+
+	checker, err := NewRedisChecker(&hc_pb.RedisCheckerAttributes{}, nil)
+	c.Assert(err, NoErr)
+	c.Assert(checker.Check(host, port), NotNil)
+}
+
+func (s *RedisCheckerSuite) TestReadReply(c *C) {
+	reply := func(data string) (string, error) {
+		return readRedisReply(bufio.NewReader(strings.NewReader(data)))
+	}
+	value, err := reply("$4\r\nPONG\r\n")
+	c.Assert(err, NoErr)
+	c.Assert(value, Equals, "PONG")
+
+	// size of bulk string is limited.
+	_, err = reply("$2147483647\r\nPONG\r\n")
+	c.Assert(err, NotNil)
+	_, err = reply("$9223372036854775807\r\nPONG\r\n")
+	c.Assert(err, NotNil)
+}
@@ -0,0 +1,95 @@
+package health_manager
+
+import (
+	"math/rand"
+	"time"
+
+	hc_pb "dropbox/proto/kglb/healthchecker"
+)
+
+// Max deviation of intervals, it keeps jittered intervals at least half of
+// configured ones instead of scheduling checks right away.
+const maxJitter = 0.5
+
+// Intervals of health checks of single entry with applied defaults.
+type checkIntervals struct {
+	healthy      time.Duration
+	unhealthy    time.Duration
+	maxUnhealthy time.Duration
+	transition   time.Duration
+	// max deviation of intervals in [0, maxJitter] range.
+	jitter float64
+}
+
+// Returns intervals based on configuration, interval is used for statuses
+// without configured interval.
+func newCheckIntervals(
+	interval time.Duration,
+	conf *hc_pb.CheckIntervals) *checkIntervals {
+
+	toDuration := func(ms uint32, defaultValue time.Duration) time.Duration {
+		if ms == 0 {
+			return defaultValue
+		}
+		return time.Duration(ms) * time.Millisecond
+	}
+
+	intervals := &checkIntervals{
+		healthy:    toDuration(conf.GetHealthyMs(), interval),
+		unhealthy:  toDuration(conf.GetUnhealthyMs(), interval),
+		transition: toDuration(conf.GetTransitionMs(), interval),
+		jitter:     float64(conf.GetJitterPercent()) / 100,
+	}
+	intervals.maxUnhealthy = toDuration(
+		conf.GetMaxUnhealthyMs(),
+		intervals.unhealthy)
+	if intervals.maxUnhealthy < intervals.unhealthy {
+		intervals.maxUnhealthy = intervals.unhealthy
+	}
+	if intervals.jitter > maxJitter {
+		intervals.jitter = maxJitter
+	}
+
+	return intervals
+}
+
+// Returns interval until next check of the entry based on its raw health
+// status and number of consecutive passed (positive) or failed (negative)
+// checks.
+func (c *checkIntervals) next(
+	isHealthy bool,
+	healthCount int,
+	fallCount int) time.Duration {
+
+	var interval time.Duration
+	switch {
+	case isHealthy && healthCount < 0, !isHealthy && healthCount > 0:
+		interval = c.transition
+	case isHealthy:
+		interval = c.healthy
+	default:
+		// exponential backoff for entries which keep failing after they
+		// have been marked as unhealthy.
+		interval = c.unhealthy
+		for failures := -healthCount - fallCount; failures > 0; failures-- {
+			interval *= 2
+			if interval >= c.maxUnhealthy {
+				break
+			}
+		}
+		if interval > c.maxUnhealthy {
+			interval = c.maxUnhealthy
+		}
+	}
+
+	return c.applyJitter(interval)
+}
+
+// Returns interval randomly deviated by jitter.
+func (c *checkIntervals) applyJitter(interval time.Duration) time.Duration {
+	if c.jitter == 0 {
+		return interval
+	}
+	deviation := (rand.Float64()*2 - 1) * c.jitter
+	return time.Duration(float64(interval) * (1 + deviation))
+}
@@ -0,0 +1,65 @@
+package health_manager
+
+import (
+	"time"
+
+	. "gopkg.in/check.v1"
+
+	hc_pb "dropbox/proto/kglb/healthchecker"
+	. "godropbox/gocheck2"
+)
+
+type CheckIntervalsSuite struct{}
+
+var _ = Suite(&CheckIntervalsSuite{})
+
+func (s *CheckIntervalsSuite) TestDefaults(c *C) {
+	intervals := newCheckIntervals(5*time.Second, nil)
+	c.Assert(intervals.next(true, 10, 2), Equals, 5*time.Second)
+	c.Assert(intervals.next(false, -10, 2), Equals, 5*time.Second)
+	c.Assert(intervals.next(true, -1, 2), Equals, 5*time.Second)
+}
+
+func (s *CheckIntervalsSuite) TestNext(c *C) {
+	intervals := newCheckIntervals(5*time.Second, &hc_pb.CheckIntervals{
+		HealthyMs:      2000,
+		UnhealthyMs:    10000,
+		MaxUnhealthyMs: 60000,
+		TransitionMs:   500,
+	})
+
+	c.Assert(intervals.next(true, 3, 2), Equals, 2*time.Second)
+	// transitioning entries.
+	c.Assert(intervals.next(true, -1, 2), Equals, 500*time.Millisecond)
+	c.Assert(intervals.next(false, 1, 2), Equals, 500*time.Millisecond)
+	// just discovered entry.
+	c.Assert(intervals.next(false, 0, 2), Equals, 10*time.Second)
+	// backoff of entries which keep failing after reaching fall count.
+	c.Assert(intervals.next(false, -2, 2), Equals, 10*time.Second)
+	c.Assert(intervals.next(false, -3, 2), Equals, 20*time.Second)
+	c.Assert(intervals.next(false, -4, 2), Equals, 40*time.Second)
+	c.Assert(intervals.next(false, -5, 2), Equals, 60*time.Second)
+	c.Assert(intervals.next(false, -1000, 2), Equals, 60*time.Second)
+}
+
+func (s *CheckIntervalsSuite) TestJitter(c *C) {
+	intervals := newCheckIntervals(10*time.Second, &hc_pb.CheckIntervals{
+		JitterPercent: 20,
+	})
+
+	for i := 0; i < 100; i++ {
+		interval := intervals.next(true, 1, 2)
+		c.Assert(interval >= 8*time.Second, IsTrue)
+		c.Assert(interval <= 12*time.Second, IsTrue)
+	}
+
+	// deviation is capped to keep intervals above zero.
+	intervals = newCheckIntervals(10*time.Second, &hc_pb.CheckIntervals{
+		JitterPercent: 100,
+	})
+	for i := 0; i < 100; i++ {
+		interval := intervals.next(true, 1, 2)
+		c.Assert(interval >= 5*time.Second, IsTrue)
+		c.Assert(interval <= 15*time.Second, IsTrue)
+	}
+}
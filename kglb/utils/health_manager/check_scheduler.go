@@ -0,0 +1,137 @@
+package health_manager
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gogo/protobuf/proto"
+
+	"dropbox/kglb/utils/health_checker"
+	"dropbox/vortex2/v2stats"
+)
+
+// default max age of check result which can be shared between health
+// managers.
+const DefaultSharedCheckMaxAge = 5 * time.Second
+
+// Result of check performed by CheckScheduler.
+type sharedCheck struct {
+	// closed when the check is completed.
+	done chan struct{}
+
+	startTs time.Time
+	weight  uint32
+	err     error
+}
+
+// Deduplicates health checks of the same host and port with the same checker
+// configuration performed by multiple health managers. Concurrent checks are
+// joined and results are reused by health managers which haven't observed
+// them yet, so every health manager still applies own rise/fall counts to
+// every result.
+type CheckScheduler struct {
+	mu sync.Mutex
+
+	// max age of reused results.
+	maxAge time.Duration
+
+	// latest or in-flight checks by key.
+	checks map[string]*sharedCheck
+	// time of the latest removal of outdated results.
+	lastCleanupTs time.Time
+
+	statShared v2stats.Counter
+}
+
+// Returns new instance of CheckScheduler, results older than maxAge are
+// never reused.
+func NewCheckScheduler(maxAge time.Duration) *CheckScheduler {
+	if maxAge <= 0 {
+		maxAge = DefaultSharedCheckMaxAge
+	}
+
+	return &CheckScheduler{
+		maxAge:     maxAge,
+		checks:     make(map[string]*sharedCheck),
+		statShared: healthCheckSharedCounter.Must(v2stats.KV{}),
+	}
+}
+
+// Returns key of the check. Checkers without configuration are never shared
+// with other checkers.
+func sharedCheckKey(
+	checker health_checker.HealthChecker,
+	host string,
+	port int) string {
+
+	conf := checker.GetConfiguration()
+	confKey := fmt.Sprintf("%p", checker)
+	if conf != nil {
+		confKey = proto.CompactTextString(conf)
+	}
+	return net.JoinHostPort(host, strconv.Itoa(port)) + "/" + confKey
+}
+
+// Checks host and port by provided checker or reuses result of the same check
+// started after notBefore by another health manager. Returns weight in
+// percents (health_checker.DefaultWeightPercent for non-weighted checkers).
+func (s *CheckScheduler) Check(
+	checker health_checker.HealthChecker,
+	host string,
+	port int,
+	notBefore time.Time) (uint32, error) {
+
+	key := sharedCheckKey(checker, host, port)
+	now := time.Now()
+	if oldest := now.Add(-s.maxAge); notBefore.Before(oldest) {
+		notBefore = oldest
+	}
+
+	s.mu.Lock()
+	if check, ok := s.checks[key]; ok && check.startTs.After(notBefore) {
+		s.mu.Unlock()
+		s.statShared.Add(1)
+		<-check.done
+		return check.weight, check.err
+	}
+
+	check := &sharedCheck{
+		done:    make(chan struct{}),
+		startTs: now,
+	}
+	s.checks[key] = check
+	s.cleanup(now)
+	s.mu.Unlock()
+
+	if weightedChecker, ok := checker.(health_checker.WeightedHealthChecker); ok {
+		check.weight, check.err = weightedChecker.CheckWeight(host, port)
+	} else {
+		check.weight = health_checker.DefaultWeightPercent
+		check.err = checker.Check(host, port)
+	}
+	close(check.done)
+
+	return check.weight, check.err
+}
+
+// Removes outdated results of completed checks. Should be called under s.mu.
+func (s *CheckScheduler) cleanup(now time.Time) {
+	if now.Sub(s.lastCleanupTs) < s.maxAge {
+		return
+	}
+	s.lastCleanupTs = now
+
+	for key, check := range s.checks {
+		if now.Sub(check.startTs) < s.maxAge {
+			continue
+		}
+		select {
+		case <-check.done:
+			delete(s.checks, key)
+		default:
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package health_manager
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	. "gopkg.in/check.v1"
+
+	"dropbox/kglb/utils/health_checker"
+	hc_pb "dropbox/proto/kglb/healthchecker"
+	. "godropbox/gocheck2"
+)
+
+type CheckSchedulerSuite struct{}
+
+var _ = Suite(&CheckSchedulerSuite{})
+
+// checker with configuration counting performed checks.
+type countingChecker struct {
+	MockChecker
+	conf *hc_pb.HealthCheckerAttributes
+}
+
+func (m *countingChecker) GetConfiguration() *hc_pb.HealthCheckerAttributes {
+	return m.conf
+}
+
+func newCountingChecker(
+	checks *int32,
+	timeoutMs uint32,
+	delay time.Duration) *countingChecker {
+
+	return &countingChecker{
+		MockChecker: MockChecker{
+			checkFunc: func(host string, port int) error {
+				atomic.AddInt32(checks, 1)
+				time.Sleep(delay)
+				if host == "down" {
+					return fmt.Errorf("connection refused")
+				}
+				return nil
+			},
+		},
+		conf: &hc_pb.HealthCheckerAttributes{
+			Attributes: &hc_pb.HealthCheckerAttributes_Tcp{
+				Tcp: &hc_pb.TcpCheckerAttributes{CheckTimeoutMs: timeoutMs},
+			},
+		},
+	}
+}
+
+func (s *CheckSchedulerSuite) TestConcurrentChecks(c *C) {
+	scheduler := NewCheckScheduler(time.Minute)
+	var checks int32
+	// two instances of checker with the same configuration.
+	checkers := []health_checker.HealthChecker{
+		newCountingChecker(&checks, 1000, 50*time.Millisecond),
+		newCountingChecker(&checks, 1000, 50*time.Millisecond),
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(checker health_checker.HealthChecker) {
+			defer wg.Done()
+			weight, err := scheduler.Check(checker, "down", 80, time.Time{})
+			c.Check(err, ErrorMatches, "connection refused")
+			c.Check(weight, Equals, health_checker.DefaultWeightPercent)
+		}(checkers[i%2])
+	}
+	wg.Wait()
+	c.Assert(atomic.LoadInt32(&checks), Equals, int32(1))
+}
+
+func (s *CheckSchedulerSuite) TestReuse(c *C) {
+	scheduler := NewCheckScheduler(time.Minute)
+	var checks int32
+	checker := newCountingChecker(&checks, 1000, 0)
+
+	previousCheckTs := time.Now()
+	_, err := scheduler.Check(checker, "host1", 80, time.Time{})
+	c.Assert(err, NoErr)
+	c.Assert(atomic.LoadInt32(&checks), Equals, int32(1))
+
+	// result of the check started after previous check of the caller is
+	// reused.
+	_, err = scheduler.Check(checker, "host1", 80, previousCheckTs)
+	c.Assert(err, NoErr)
+	c.Assert(atomic.LoadInt32(&checks), Equals, int32(1))
+
+	// but not when the caller has already observed it.
+	_, err = scheduler.Check(checker, "host1", 80, time.Now())
+	c.Assert(err, NoErr)
+	c.Assert(atomic.LoadInt32(&checks), Equals, int32(2))
+
+	// different host, port or checker configuration are checked separately.
+	_, err = scheduler.Check(checker, "host2", 80, time.Time{})
+	c.Assert(err, NoErr)
+	_, err = scheduler.Check(checker, "host1", 81, time.Time{})
+	c.Assert(err, NoErr)
+	_, err = scheduler.Check(
+		newCountingChecker(&checks, 2000, 0), "host1", 80, time.Time{})
+	c.Assert(err, NoErr)
+	c.Assert(atomic.LoadInt32(&checks), Equals, int32(5))
+}
+
+func (s *CheckSchedulerSuite) TestMaxAge(c *C) {
+	scheduler := NewCheckScheduler(10 * time.Millisecond)
+	var checks int32
+	checker := newCountingChecker(&checks, 1000, 0)
+
+	_, err := scheduler.Check(checker, "host1", 80, time.Time{})
+	c.Assert(err, NoErr)
+	time.Sleep(20 * time.Millisecond)
+	_, err = scheduler.Check(checker, "host1", 80, time.Time{})
+	c.Assert(err, NoErr)
+	c.Assert(atomic.LoadInt32(&checks), Equals, int32(2))
+
+	// outdated results are removed.
+	scheduler.mu.Lock()
+	c.Assert(scheduler.checks, HasLen, 1)
+	scheduler.mu.Unlock()
+}
+
+func (s *CheckSchedulerSuite) TestWeightedChecker(c *C) {
+	scheduler := NewCheckScheduler(time.Minute)
+	checker := &MockWeightedChecker{
+		checkWeightFunc: func(host string, port int) (uint32, error) {
+			return 40, nil
+		},
+	}
+
+	weight, err := scheduler.Check(checker, "host1", 80, time.Time{})
+	c.Assert(err, NoErr)
+	c.Assert(weight, Equals, uint32(40))
+}
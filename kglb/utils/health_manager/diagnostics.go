@@ -0,0 +1,78 @@
+package health_manager
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Stats of single health checking cycle (configured interval): its start time
+// and the longest delay of health checks within it.
+type cycleStats struct {
+	startTs  time.Time
+	duration time.Duration
+}
+
+// Diagnostics of single health manager entry.
+type EntryDiagnostics struct {
+	Host          string      `json:"host"`
+	Address       string      `json:"address"`
+	Port          int         `json:"port"`
+	Enabled       bool        `json:"enabled"`
+	Healthy       bool        `json:"healthy"`
+	WeightPercent uint32      `json:"weight_percent"`
+	LastCheck     CheckResult `json:"last_check"`
+	// flap damping state.
+	Suppressed  bool    `json:"suppressed"`
+	FlapPenalty float64 `json:"flap_penalty"`
+}
+
+// Introspection data of health manager to tune its configuration (interval,
+// concurrency limit and timeouts of health checker).
+type HealthManagerDiagnostics struct {
+	Id               string        `json:"id"`
+	Interval         time.Duration `json:"interval"`
+	ConcurrencyLimit uint32        `json:"concurrency_limit"`
+	// start time of the latest health checking cycle and the longest delay
+	// of health checks (time between the check became due and its
+	// completion) within it.
+	LastCycleStart    time.Time     `json:"last_cycle_start"`
+	LastCycleDuration time.Duration `json:"last_cycle_duration"`
+	// number of cycles with checks delayed longer than the interval.
+	CycleOverruns uint64             `json:"cycle_overruns"`
+	Entries       []EntryDiagnostics `json:"entries"`
+}
+
+// Returns diagnostics of the entries of the state.
+func (h HealthManagerState) diagnostics() []EntryDiagnostics {
+	entries := make([]EntryDiagnostics, len(h))
+	for i, entry := range h {
+		entries[i] = EntryDiagnostics{
+			Host:          entry.HostPort.Host,
+			Address:       entry.HostPort.Address,
+			Port:          entry.HostPort.Port,
+			Enabled:       entry.HostPort.Enabled,
+			Healthy:       entry.Status.IsHealthy(),
+			WeightPercent: entry.Status.WeightPercent(),
+			LastCheck:     entry.Status.LastCheck(),
+			Suppressed:    entry.Status.IsSuppressed(),
+			FlapPenalty:   entry.Status.FlapPenalty(),
+		}
+	}
+	return entries
+}
+
+// Returns diagnostics of the health manager. Entries reflect the state after
+// the latest health checking cycle, state update or discovery update.
+func (h *HealthManager) GetDiagnostics() HealthManagerDiagnostics {
+	lastCycle := h.lastCycle.Load().(cycleStats)
+
+	return HealthManagerDiagnostics{
+		Id:                h.GetId(),
+		Interval:          h.interval.Load().(time.Duration),
+		ConcurrencyLimit:  atomic.LoadUint32(&h.concurrencyLimit),
+		LastCycleStart:    lastCycle.startTs,
+		LastCycleDuration: lastCycle.duration,
+		CycleOverruns:     atomic.LoadUint64(&h.cycleOverruns),
+		Entries:           h.entriesDiagnostics.Load().([]EntryDiagnostics),
+	}
+}
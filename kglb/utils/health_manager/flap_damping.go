@@ -0,0 +1,71 @@
+package health_manager
+
+import (
+	"math"
+	"time"
+
+	hc_pb "dropbox/proto/kglb/healthchecker"
+)
+
+const (
+	defaultFlapPenalty           = 1000
+	defaultFlapSuppressThreshold = 2000
+	defaultFlapReuseThreshold    = 750
+	defaultFlapHalfLife          = time.Minute
+	// default max suppress time in half lifes.
+	defaultFlapMaxSuppressHalfLifes = 4
+)
+
+// Flap damping parameters with applied defaults.
+type flapDamping struct {
+	penalty           float64
+	suppressThreshold float64
+	reuseThreshold    float64
+	halfLife          time.Duration
+	// max penalty which decays to reuse threshold during max suppress time.
+	maxPenalty float64
+}
+
+// Returns flap damping parameters or nil when flap damping is disabled.
+func newFlapDamping(conf *hc_pb.FlapDamping) *flapDamping {
+	if !conf.GetEnabled() {
+		return nil
+	}
+
+	damping := &flapDamping{
+		penalty:           defaultFlapPenalty,
+		suppressThreshold: defaultFlapSuppressThreshold,
+		reuseThreshold:    defaultFlapReuseThreshold,
+		halfLife:          defaultFlapHalfLife,
+	}
+	if conf.GetPenalty() > 0 {
+		damping.penalty = float64(conf.GetPenalty())
+	}
+	if conf.GetSuppressThreshold() > 0 {
+		damping.suppressThreshold = float64(conf.GetSuppressThreshold())
+	}
+	if conf.GetReuseThreshold() > 0 {
+		damping.reuseThreshold = float64(conf.GetReuseThreshold())
+	}
+	if conf.GetHalfLifeMs() > 0 {
+		damping.halfLife = time.Duration(conf.GetHalfLifeMs()) * time.Millisecond
+	}
+
+	maxSuppress := defaultFlapMaxSuppressHalfLifes * damping.halfLife
+	if conf.GetMaxSuppressMs() > 0 {
+		maxSuppress = time.Duration(conf.GetMaxSuppressMs()) * time.Millisecond
+	}
+	damping.maxPenalty = damping.reuseThreshold * math.Pow(
+		2,
+		float64(maxSuppress)/float64(damping.halfLife))
+
+	return damping
+}
+
+// Returns penalty decayed during elapsed time.
+func (f *flapDamping) decay(penalty float64, elapsed time.Duration) float64 {
+	if elapsed <= 0 {
+		return penalty
+	}
+	return penalty * math.Pow(0.5, float64(elapsed)/float64(f.halfLife))
+}
@@ -3,6 +3,7 @@ package health_manager
 import (
 	"context"
 	"fmt"
+	"sort"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -11,7 +12,6 @@ import (
 	"dropbox/dlog"
 	"dropbox/exclog"
 	"dropbox/kglb/common"
-	"dropbox/kglb/utils/concurrency"
 	"dropbox/kglb/utils/discovery"
 	"dropbox/kglb/utils/dns_resolver"
 	"dropbox/kglb/utils/health_checker"
@@ -53,6 +53,10 @@ type HealthManagerParams struct {
 
 	// Initial health status of the just discovered HostPort entries.
 	InitialHealthyState bool
+
+	// (Optional) scheduler to share results of health checks with other
+	// health managers.
+	CheckScheduler *CheckScheduler
 }
 
 type HealthManager struct {
@@ -70,6 +74,10 @@ type HealthManager struct {
 	statLock  sync.Mutex
 	riseCount int
 	fallCount int
+	// nil when flap damping is disabled.
+	flapDamping *flapDamping
+	// intervals of health checks of single entry.
+	intervals *checkIntervals
 
 	interval         atomic.Value // time.Duration
 	concurrencyLimit uint32       // atomic
@@ -80,8 +88,25 @@ type HealthManager struct {
 	passCounters statCounterMap
 	failCounters statCounterMap
 
+	// v2 histograms of health checks latency: by hostname
+	latencyHistograms statHistogramMap
+
 	// v2 gauges
-	aliveGauge v2stats.Gauge
+	aliveGauge      v2stats.Gauge
+	suppressedGauge v2stats.Gauge
+
+	// v2 counter of suppressions of flapping entries.
+	suppressionCounter v2stats.Counter
+
+	// v2 stats of health checking cycles.
+	cycleHistogram v2stats.Histogram
+	overrunCounter v2stats.Counter
+
+	// stats of the latest health checking cycle and diagnostics of entries
+	// taken right after it (used by GetDiagnostics()).
+	lastCycle          atomic.Value // cycleStats
+	cycleOverruns      uint64       // atomic
+	entriesDiagnostics atomic.Value // []EntryDiagnostics
 
 	// Health manager state.
 	state HealthManagerState
@@ -94,9 +119,33 @@ type HealthManager struct {
 
 	// boolean flag to help properly handle initial state from resolver.
 	initialResolverStateRecv bool
+
+	// due time of entries which checks are in progress and chan to receive
+	// results of the checks. Owned by healthCheckLoop.
+	inFlight     map[*healthStatusEntry]time.Time
+	checkResults chan checkResult
+
+	// start time, number of completed checks and the longest delay of checks
+	// of the current health checking cycle. Owned by healthCheckLoop.
+	cycleStartTs  time.Time
+	cycleChecked  int
+	cycleMaxDelay time.Duration
+	// time of the earliest change of the state which hasn't been sent yet,
+	// zero when there is no such change. Owned by healthCheckLoop.
+	changeTs time.Time
+}
+
+// Result of single health check sent to healthCheckLoop.
+type checkResult struct {
+	status *healthStatusEntry
+	// time between the check became due and its completion.
+	delay time.Duration
+	// true when status or weight of the entry has been changed.
+	changed bool
 }
 
 type statCounterMap map[string]*v2stats.Counter
+type statHistogramMap map[string]*v2stats.Histogram
 
 func NewHealthManager(ctx context.Context, params HealthManagerParams) (*HealthManager, error) {
 	if params.Resolver == nil {
@@ -118,21 +167,42 @@ func NewHealthManager(ctx context.Context, params HealthManagerParams) (*HealthM
 	}
 
 	mng := &HealthManager{
-		params:         &params,
-		updateChan:     make(chan HealthManagerState, 1),
-		updateConfChan: make(chan struct{}, 1),
-		passCounters:   make(statCounterMap),
-		failCounters:   make(statCounterMap),
+		params:            &params,
+		updateChan:        make(chan HealthManagerState, 1),
+		updateConfChan:    make(chan struct{}, 1),
+		inFlight:          make(map[*healthStatusEntry]time.Time),
+		checkResults:      make(chan checkResult),
+		passCounters:      make(statCounterMap),
+		failCounters:      make(statCounterMap),
+		latencyHistograms: make(statHistogramMap),
 		aliveGauge: aliveRatioGauge.Must(v2stats.KV{
 			"setup":   params.SetupName,
 			"service": params.ServiceName,
 		}),
+		suppressedGauge: suppressedUpstreamsGauge.Must(v2stats.KV{
+			"setup":   params.SetupName,
+			"service": params.ServiceName,
+		}),
+		suppressionCounter: healthCheckSuppressionCounter.Must(v2stats.KV{
+			"setup":   params.SetupName,
+			"service": params.ServiceName,
+		}),
+		cycleHistogram: healthCheckCycleHistogram.Must(v2stats.KV{
+			"setup":   params.SetupName,
+			"service": params.ServiceName,
+		}),
+		overrunCounter: healthCheckCycleOverrunCounter.Must(v2stats.KV{
+			"setup":   params.SetupName,
+			"service": params.ServiceName,
+		}),
 	}
 	mng.ctx, mng.cancelFunc = context.WithCancel(ctx)
 
 	mng.resolver.Store(params.Resolver)
 	mng.checker.Store(params.HealthChecker)
 	mng.lastUpdateState.Store(HealthManagerState{})
+	mng.lastCycle.Store(cycleStats{})
+	mng.entriesDiagnostics.Store([]EntryDiagnostics{})
 
 	if err := mng.Update(params.UpstreamCheckerAttributes); err != nil {
 		return nil, err
@@ -189,6 +259,8 @@ func (h *HealthManager) Update(upstreamChecker *hc_pb.UpstreamChecker) error {
 	defer h.countLock.Unlock()
 	h.riseCount = int(riseCount)
 	h.fallCount = int(fallCount)
+	h.flapDamping = newFlapDamping(upstreamChecker.GetFlapDamping())
+	h.intervals = newCheckIntervals(interval, upstreamChecker.GetIntervals())
 	h.interval.Store(interval)
 	atomic.StoreUint32(&h.concurrencyLimit, concurrencyLimit)
 
@@ -271,6 +343,7 @@ func (h *HealthManager) applyResolverState(discoveryState discovery.DiscoverySta
 		h.state.String(),
 		newState.String())
 	h.state = newState
+	h.entriesDiagnostics.Store(newState.diagnostics())
 
 	h.initialResolverStateRecv = true
 
@@ -286,6 +359,7 @@ func (h *HealthManager) applyResolverState(discoveryState discovery.DiscoverySta
 func (h *HealthManager) healthCheckLoop() {
 	// schedule next healthcheck
 	nextHealthCheck := time.After(0)
+	h.cycleStartTs = time.Now()
 	for {
 		select {
 		// resolver's updates.
@@ -301,52 +375,110 @@ func (h *HealthManager) healthCheckLoop() {
 			}
 			// applying new state and notify about the change.
 			h.applyResolverState(resolverState)
+			// new entries should be checked right away.
+			nextHealthCheck = h.nextHealthCheckTimer()
 		case <-h.ctx.Done(): // stop chan.
 			// update channel is not needed anymore.
 			close(h.updateChan)
 			dlog.Infof("Closing Health Manager: %s", h.GetId())
 			return
 		case <-nextHealthCheck: // health checking loop.
-			nextHealthCheck = time.After(h.interval.Load().(time.Duration))
-
 			if len(h.state) == 0 && !h.initialResolverStateRecv {
+				nextHealthCheck = time.After(h.interval.Load().(time.Duration))
 				dlog.Infof("Skipping healthcheck cycle as resolver state is not ready yet")
 				continue
 			}
 
-			startTs := time.Now()
-			changed := h.performHealthChecks()
-			checkDuration := time.Since(startTs)
-
-			// TODO(dkopytkov): report metric to properly monitor the value and
-			//  adjust ConcurrencyLimit in the configs if it's needed.
-			dlog.Infof("%s health manager took %v to check %d items",
-				h.GetId(),
-				checkDuration,
-				len(h.state))
-
-			if changed || !h.initialStateSent {
-				h.notifyStateChange()
-				// no more bypases of changed flag.
-				h.initialStateSent = true
+			h.processHealthChecks(false)
+			nextHealthCheck = h.nextHealthCheckTimer()
+		case result := <-h.checkResults: // single health check is completed.
+			// next check is scheduled relative to due time of the check to
+			// keep checks of entries aligned.
+			dueTs := h.inFlight[result.status]
+			delete(h.inFlight, result.status)
+			h.countLock.RLock()
+			result.status.ScheduleNextCheck(dueTs, h.intervals, h.fallCount)
+			h.countLock.RUnlock()
+			h.cycleChecked++
+			if result.delay > h.cycleMaxDelay {
+				h.cycleMaxDelay = result.delay
 			}
+
+			h.processHealthChecks(result.changed)
+			nextHealthCheck = h.nextHealthCheckTimer()
 		case <-h.updateConfChan: // configuration has been updated.
+			// concurrency limit might be changed.
+			nextHealthCheck = h.nextHealthCheckTimer()
+		}
+	}
+}
+
+// Notifies about the change in the state, starts health checks which are due
+// and reports health checking cycle once per interval. Changes are batched
+// until all checks in progress are completed, but not longer than the
+// interval, so slow checks of some entries don't delay updates of others.
+// Initial state is sent only after all entries have been checked.
+func (h *HealthManager) processHealthChecks(changed bool) {
+	now := time.Now()
+	interval := h.interval.Load().(time.Duration)
+
+	if changed && h.changeTs.IsZero() {
+		h.changeTs = now
+	}
+	if h.initialStateSent {
+		if !h.changeTs.IsZero() &&
+			(len(h.inFlight) == 0 || now.Sub(h.changeTs) >= interval) {
+
+			h.notifyStateChange()
+		}
+	} else if h.state.checked() {
+		h.notifyStateChange()
+		// no more bypases of changed flag.
+		h.initialStateSent = true
+	}
+
+	h.startHealthChecks(now)
+
+	if now.Sub(h.cycleStartTs) >= interval {
+		// checks which are still in progress are delayed as well.
+		for _, dueTs := range h.inFlight {
+			if delay := now.Sub(dueTs); delay > h.cycleMaxDelay {
+				h.cycleMaxDelay = delay
+			}
 		}
+		dlog.Infof("%s health manager checked %d items (%d in progress) of %d "+
+			"in %v, max check delay %v",
+			h.GetId(),
+			h.cycleChecked,
+			len(h.inFlight),
+			len(h.state),
+			now.Sub(h.cycleStartTs),
+			h.cycleMaxDelay)
+		h.reportCycle(h.cycleStartTs, h.cycleMaxDelay)
+
+		h.cycleStartTs = now
+		h.cycleChecked = 0
+		h.cycleMaxDelay = 0
 	}
 }
 
 // Notifies about the change in the state through channel.
 func (h *HealthManager) notifyStateChange() {
-	// no need extra lock during Clone() call since performHealthChecks and
-	// notifyStateChange is happening in the same goroutine (healthCheckLoop).
+	// no need extra lock during Clone() call since applyResolverState and
+	// notifyStateChange is happening in the same goroutine (healthCheckLoop),
+	// status of entries is protected by their own mutex.
 	stateToSend := h.state.Clone()
 
 	healthyCnt := 0
+	suppressedCnt := 0
 
 	for _, entry := range stateToSend {
 		if entry.Status.IsHealthy() {
 			healthyCnt++
 		}
+		if entry.Status.IsSuppressed() {
+			suppressedCnt++
+		}
 	}
 
 	aliveRatio := float64(0)
@@ -355,8 +487,12 @@ func (h *HealthManager) notifyStateChange() {
 	}
 
 	h.setAliveRatioGauge(aliveRatio)
+	h.suppressedGauge.Set(float64(suppressedCnt))
 
 	h.lastUpdateState.Store(stateToSend)
+	h.entriesDiagnostics.Store(stateToSend.diagnostics())
+	// all pending changes are sent.
+	h.changeTs = time.Time{}
 
 	// remove state from chan if any
 	select {
@@ -370,67 +506,201 @@ func (h *HealthManager) notifyStateChange() {
 	h.updateChan <- stateToSend
 }
 
-// Perform health checking of all entries. The call is blocking until
-// all entries have been checked or context is canceled.
-// TODO(oleg) upper-bound the performHealthChecks execution time to avoid
-//  goroutine leakage
-func (h *HealthManager) performHealthChecks() bool {
-	numWorkers := int(atomic.LoadUint32(&h.concurrencyLimit))
-	checker := h.checker.Load().(health_checker.HealthChecker)
+// Returns channel to wake up healthCheckLoop when the earliest scheduled
+// health check is due, the current health checking cycle ends or pending
+// changes of the state should be sent. Scheduled checks are ignored when
+// concurrency limit is reached since healthCheckLoop is woken up by completed
+// checks in this case.
+func (h *HealthManager) nextHealthCheckTimer() <-chan time.Time {
+	interval := h.interval.Load().(time.Duration)
+	deadline := h.cycleStartTs.Add(interval)
+	if !h.changeTs.IsZero() && h.changeTs.Add(interval).Before(deadline) {
+		deadline = h.changeTs.Add(interval)
+	}
 
-	h.countLock.RLock()
-	defer h.countLock.RUnlock()
+	delay := time.Until(deadline)
+	if len(h.inFlight) < int(atomic.LoadUint32(&h.concurrencyLimit)) {
+		if checkDelay := h.nextCheckDelay(); checkDelay < delay {
+			delay = checkDelay
+		}
+	}
+	if delay < 0 {
+		return time.After(0)
+	}
+	return time.After(delay)
+}
 
-	changed := uint32(0) // indicates change at least in single HostPort entry.
-	err := concurrency.CompleteTasks(
-		h.ctx,
-		numWorkers,
-		len(h.state),
-		func(numWorker int, numTask int) {
-			checkStatus := true
-			enabled := h.state[numTask].Enabled
-			// 1. perform check.
-			if enabled {
-				err := checker.Check(
-					h.state[numTask].HostPort.Address,
-					h.state[numTask].HostPort.Port)
-				if err != nil {
-					checkStatus = false
-					// report about the issue.
-					exclog.Report(
-						errors.Wrapf(
-							err,
-							"%s health manager failed to check %s entry: ",
-							h.params.Id,
-							h.state[numTask].HostPort.String()),
-						exclog.Operational, "")
-				}
-			} else {
-				// do not run healthcheck (report host as down unconditionally) if host is marked
-				// as disabled by Discovery service
-				checkStatus = false
+// Returns delay until the earliest scheduled health check of entries which
+// aren't being checked right now. Configured interval is used when there are
+// no such entries.
+func (h *HealthManager) nextCheckDelay() time.Duration {
+	var nextCheck time.Time
+	for _, entry := range h.state {
+		if _, ok := h.inFlight[entry.Status]; ok {
+			continue
+		}
+		entryNextCheck := entry.Status.NextCheck()
+		if nextCheck.IsZero() || entryNextCheck.Before(nextCheck) {
+			nextCheck = entryNextCheck
+			if nextCheck.IsZero() {
+				// new entry.
+				return 0
 			}
-			// 2. update status.
-			if h.state[numTask].Status.UpdateHealthCheckStatus(checkStatus, h.riseCount, h.fallCount) {
-				dlog.Infof(
-					"%s health manager updated %s entry status to %v",
+		}
+	}
+	if nextCheck.IsZero() {
+		return h.interval.Load().(time.Duration)
+	}
+
+	delay := time.Until(nextCheck)
+	if delay < 0 {
+		return 0
+	}
+	return delay
+}
+
+// Starts health checks of entries which checks are scheduled before now and
+// aren't in progress. Entries which have been waiting longer are checked
+// first, number of checks in progress is limited by concurrency limit. Each
+// check runs in its own goroutine, so slow checks of some entries don't delay
+// checks of others, results are sent to healthCheckLoop via checkResults.
+func (h *HealthManager) startHealthChecks(now time.Time) {
+	limit := int(atomic.LoadUint32(&h.concurrencyLimit))
+	if len(h.inFlight) >= limit {
+		return
+	}
+
+	type dueEntry struct {
+		entry HealthManagerEntry
+		dueTs time.Time
+	}
+	var due []dueEntry
+	for _, entry := range h.state {
+		if _, ok := h.inFlight[entry.Status]; ok {
+			continue
+		}
+		dueTs := entry.Status.NextCheck()
+		if dueTs.After(now) {
+			continue
+		}
+		if dueTs.IsZero() {
+			// new entry.
+			dueTs = now
+		}
+		due = append(due, dueEntry{entry: entry, dueTs: dueTs})
+	}
+	sort.SliceStable(due, func(i, j int) bool {
+		return due[i].dueTs.Before(due[j].dueTs)
+	})
+
+	checker := h.checker.Load().(health_checker.HealthChecker)
+	for _, item := range due {
+		if len(h.inFlight) >= limit {
+			return
+		}
+		h.inFlight[item.entry.Status] = item.dueTs
+		go h.performHealthCheck(checker, item.entry, item.dueTs)
+	}
+}
+
+// Performs health check of the entry, updates its status and sends the result
+// to healthCheckLoop.
+func (h *HealthManager) performHealthCheck(
+	checker health_checker.HealthChecker,
+	entry HealthManagerEntry,
+	dueTs time.Time) {
+
+	now := time.Now()
+	changed := false
+	checkStatus := true
+	// 1. perform check.
+	if entry.Enabled {
+		var err error
+		var weight uint32
+		if h.params.CheckScheduler != nil {
+			// reusing results of the same checks of other health
+			// managers performed since previous check of the entry.
+			weight, err = h.params.CheckScheduler.Check(
+				checker,
+				entry.HostPort.Address,
+				entry.HostPort.Port,
+				entry.Status.LastCheck().Timestamp)
+		} else if weightedChecker, ok := checker.(health_checker.WeightedHealthChecker); ok {
+			weight, err = weightedChecker.CheckWeight(
+				entry.HostPort.Address,
+				entry.HostPort.Port)
+		} else {
+			weight = health_checker.DefaultWeightPercent
+			err = checker.Check(
+				entry.HostPort.Address,
+				entry.HostPort.Port)
+		}
+		if err == nil && entry.Status.UpdateWeightPercent(weight) {
+			dlog.Infof(
+				"%s health manager updated %s entry weight to %d%%",
+				h.params.Id,
+				entry.HostPort,
+				weight)
+			changed = true
+		}
+		h.reportCheck(&entry, now, err)
+		if err != nil {
+			checkStatus = false
+			// report about the issue.
+			exclog.Report(
+				errors.Wrapf(
+					err,
+					"%s health manager failed to check %s entry: ",
 					h.params.Id,
-					h.state[numTask].HostPort,
-					h.state[numTask].Status.IsHealthy())
-				atomic.StoreUint32(&changed, 1)
-			}
-			// 3. update realserver health status stats
-			if checkStatus {
-				h.increasePassCounter(h.state[numTask].HostPort.Host)
-			} else if enabled {
-				h.increaseFailCounter(h.state[numTask].HostPort.Host)
-			}
-		})
-	//TODO(oleg) emit stats
-	if err != nil {
-		exclog.Report(err, exclog.Operational, "")
+					entry.HostPort.String()),
+				exclog.Operational, "")
+		}
+	} else {
+		// do not run healthcheck (report host as down unconditionally) if host is marked
+		// as disabled by Discovery service
+		checkStatus = false
+	}
+
+	h.countLock.RLock()
+	// 2. update status.
+	status := entry.Status
+	flapped := status.UpdateHealthCheckStatus(checkStatus, h.riseCount, h.fallCount)
+	if flapped {
+		dlog.Infof(
+			"%s health manager updated %s entry status to %v",
+			h.params.Id,
+			entry.HostPort,
+			status.IsHealthy())
+		changed = true
+	}
+	if status.UpdateFlapPenalty(h.flapDamping, flapped, now) {
+		if status.IsSuppressed() {
+			h.suppressionCounter.Add(1)
+		}
+		dlog.Infof(
+			"%s health manager updated %s entry suppression to %v, penalty: %.0f",
+			h.params.Id,
+			entry.HostPort,
+			status.IsSuppressed(),
+			status.FlapPenalty())
+		changed = true
+	}
+	h.countLock.RUnlock()
+	// 3. update realserver health status stats
+	if checkStatus {
+		h.increasePassCounter(entry.HostPort.Host)
+	} else if entry.Enabled {
+		h.increaseFailCounter(entry.HostPort.Host)
+	}
+
+	select {
+	case h.checkResults <- checkResult{
+		status:  status,
+		delay:   time.Since(dueTs),
+		changed: changed,
+	}:
+	case <-h.ctx.Done():
 	}
-	return atomic.LoadUint32(&changed) == 1
 }
 
 func (h *HealthManager) increasePassCounter(host string) {
@@ -451,6 +721,48 @@ func (h *HealthManager) increaseFailCounter(host string) {
 	}
 }
 
+// Saves result of the check in the entry and reports its latency.
+func (h *HealthManager) reportCheck(
+	entry *HealthManagerEntry,
+	startTs time.Time,
+	err error) {
+
+	result := CheckResult{
+		Timestamp: startTs,
+		Latency:   time.Since(startTs),
+	}
+	if err != nil {
+		result.Error = err.Error()
+	}
+	entry.Status.SetLastCheck(result)
+
+	if histogram := h.getLatencyHistogram(entry.HostPort.Host); histogram != nil {
+		histogram.Observe(result.Latency.Seconds())
+	}
+}
+
+// Reports the longest delay of health checks (time between the check became
+// due and its completion) during health checking cycle and saves diagnostics
+// of entries.
+func (h *HealthManager) reportCycle(startTs time.Time, duration time.Duration) {
+	h.cycleHistogram.Observe(duration.Seconds())
+
+	interval := h.interval.Load().(time.Duration)
+	if duration > interval {
+		atomic.AddUint64(&h.cycleOverruns, 1)
+		h.overrunCounter.Add(1)
+		dlog.Warningf(
+			"%s health manager checks were delayed up to %v which is longer "+
+				"than %v interval, consider to increase concurrency limit or interval",
+			h.GetId(),
+			duration,
+			interval)
+	}
+
+	h.lastCycle.Store(cycleStats{startTs: startTs, duration: duration})
+	h.entriesDiagnostics.Store(h.state.diagnostics())
+}
+
 func (h *HealthManager) setAliveRatioGauge(value float64) {
 	h.statLock.Lock()
 	defer h.statLock.Unlock()
@@ -486,3 +798,32 @@ func (h *HealthManager) getHealthCheckCounter(host, result string, srcMap statCo
 	srcMap[host] = &newCounter
 	return &newCounter
 }
+
+func (h *HealthManager) getLatencyHistogram(host string) *v2stats.Histogram {
+	h.statLock.Lock()
+	defer h.statLock.Unlock()
+
+	if histogram, ok := h.latencyHistograms[host]; ok {
+		return histogram
+	}
+
+	newHistogram, err := healthCheckLatencyHistogram.V(v2stats.KV{
+		"setup":   h.params.SetupName,
+		"service": h.params.ServiceName,
+		"host":    host,
+	})
+	if err != nil {
+		exclog.Report(
+			errors.Wrapf(err,
+				"Failed to instantiate v2 healthcheck latency histogram for setup %s, service %s",
+				h.params.SetupName,
+				h.params.ServiceName,
+			),
+			exclog.Critical, "",
+		)
+		return nil
+	}
+
+	h.latencyHistograms[host] = &newHistogram
+	return &newHistogram
+}
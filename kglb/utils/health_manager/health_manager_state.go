@@ -4,6 +4,7 @@ import (
 	"fmt"
 
 	"dropbox/kglb/utils/discovery"
+	"dropbox/kglb/utils/health_checker"
 )
 
 type HealthManagerEntry struct {
@@ -64,6 +65,17 @@ func (h HealthManagerState) IsHealthy() bool {
 	return false
 }
 
+// Returns true when all entries have been checked at least once.
+func (h HealthManagerState) checked() bool {
+	for _, entry := range h {
+		if entry.Status.NextCheck().IsZero() {
+			return false
+		}
+	}
+
+	return true
+}
+
 // Convert all entries into the string.
 func (h HealthManagerState) String() string {
 	out := "["
@@ -77,12 +89,18 @@ func (h HealthManagerState) String() string {
 			prefix,
 			entry.HostPort.String(),
 			entry.Status.IsHealthy())
+		if weight := entry.Status.WeightPercent(); weight != health_checker.DefaultWeightPercent {
+			out += fmt.Sprintf("/%d%%", weight)
+		}
+		if entry.Status.IsSuppressed() {
+			out += "/suppressed"
+		}
 	}
 	out += "]"
 	return out
 }
 
-// Returns new copy of HealthManagerState (it's not thread-safe call).
+// Returns new copy of HealthManagerState.
 func (h HealthManagerState) Clone() HealthManagerState {
 	newState := make(HealthManagerState, len(h))
 
@@ -92,10 +110,7 @@ func (h HealthManagerState) Clone() HealthManagerState {
 				entry.HostPort.Host,
 				entry.HostPort.Port,
 				entry.HostPort.Enabled),
-			Status: &healthStatusEntry{
-				isHealthy:   entry.Status.isHealthy,
-				healthCount: entry.Status.healthCount,
-			},
+			Status: entry.Status.clone(),
 		}
 	}
 
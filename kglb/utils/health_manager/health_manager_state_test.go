@@ -72,6 +72,13 @@ func (m *HealthManagerStateSuite) TestString(c *C) {
 			NewHealthManagerEntry(true, "host1", 81),
 			NewHealthManagerEntry(false, "host2", 80),
 		}).String(), Equals, "[host1:80/false, host1:81/true, host2:80/false]")
+
+	suppressed := NewHealthManagerEntry(true, "host1", 80)
+	suppressed.Status.suppressed = true
+	c.Assert(
+		HealthManagerState([]HealthManagerEntry{suppressed}).String(),
+		Equals,
+		"[host1:80/false/suppressed]")
 }
 
 func (m *HealthManagerStateSuite) TestIsHealthy(c *C) {
@@ -459,3 +459,263 @@ func (m *HealthManagerSuite) TestCounterStatMaps(c *C) {
 	c.Assert(passCounter1 == passCounter2, IsTrue)
 	c.Assert(failCounter1 == failCounter2, IsTrue)
 }
+
+// mock weighted health checker.
+type MockWeightedChecker struct {
+	MockChecker
+	checkWeightFunc func(host string, port int) (uint32, error)
+}
+
+func (m *MockWeightedChecker) CheckWeight(host string, port int) (uint32, error) {
+	return m.checkWeightFunc(host, port)
+}
+
+var _ health_checker.WeightedHealthChecker = &MockWeightedChecker{}
+
+func (m *HealthManagerSuite) TestWeightedChecker(c *C) {
+	// resolver.
+	resolver, err := discovery.NewStaticResolver(discovery.StaticResolverParams{
+		Id: "resolver",
+		Hosts: discovery.DiscoveryState([]*discovery.HostPort{
+			discovery.NewHostPort("host1", 80, true),
+		}),
+	})
+	c.Assert(err, NoErr)
+
+	// checker.
+	var weight uint32 = 100
+	checker := &MockWeightedChecker{
+		checkWeightFunc: func(host string, port int) (uint32, error) {
+			return atomic.LoadUint32(&weight), nil
+		},
+	}
+
+	params := HealthManagerParams{
+		Id:            c.TestName(),
+		Resolver:      resolver,
+		HealthChecker: checker,
+		UpstreamCheckerAttributes: &hc_pb.UpstreamChecker{
+			RiseCount:  1,
+			FallCount:  1,
+			IntervalMs: 1,
+		},
+	}
+
+	ctx, cancelFunc := context.WithCancel(context.Background())
+	defer cancelFunc()
+	mng, err := NewHealthManager(ctx, params)
+	c.Assert(err, NoErr)
+
+	select {
+	case state, ok := <-mng.Updates():
+		c.Assert(ok, IsTrue)
+		c.Assert(len(state), Equals, 1)
+		c.Assert(state[0].Status.IsHealthy(), IsTrue)
+		c.Assert(state[0].Status.WeightPercent(), Equals, uint32(100))
+	case <-time.After(5 * time.Second):
+		c.Fatal("fails to wait update")
+	}
+
+	// weight change is reported without change of health status.
+	atomic.StoreUint32(&weight, 40)
+	select {
+	case state, ok := <-mng.Updates():
+		c.Assert(ok, IsTrue)
+		c.Assert(len(state), Equals, 1)
+		c.Assert(state[0].Status.IsHealthy(), IsTrue)
+		c.Assert(state[0].Status.WeightPercent(), Equals, uint32(40))
+		c.Assert(state.String(), Equals, "[host1:80/true/40%]")
+	case <-time.After(5 * time.Second):
+		c.Fatal("fails to wait update")
+	}
+}
+
+func (m *HealthManagerSuite) TestDiagnostics(c *C) {
+	// resolver.
+	resolver, err := discovery.NewStaticResolver(discovery.StaticResolverParams{
+		Id: "resolver",
+		Hosts: discovery.DiscoveryState([]*discovery.HostPort{
+			discovery.NewHostPort("host1", 80, true),
+			discovery.NewHostPort("host2", 80, true),
+		}),
+	})
+	c.Assert(err, NoErr)
+
+	// checker is slower than health checking interval.
+	checker := &MockChecker{
+		checkFunc: func(host string, port int) error {
+			time.Sleep(20 * time.Millisecond)
+			if host == "host2" {
+				return fmt.Errorf("connection refused")
+			}
+			return nil
+		},
+	}
+
+	params := HealthManagerParams{
+		Id:            c.TestName(),
+		Resolver:      resolver,
+		HealthChecker: checker,
+		UpstreamCheckerAttributes: &hc_pb.UpstreamChecker{
+			RiseCount:        1,
+			FallCount:        1,
+			IntervalMs:       10,
+			ConcurrencyLimit: 2,
+		},
+	}
+
+	ctx, cancelFunc := context.WithCancel(context.Background())
+	defer cancelFunc()
+	mng, err := NewHealthManager(ctx, params)
+	c.Assert(err, NoErr)
+
+	select {
+	case _, ok := <-mng.Updates():
+		c.Assert(ok, IsTrue)
+	case <-time.After(5 * time.Second):
+		c.Fatal("fails to wait update")
+	}
+
+	diagnostics := mng.GetDiagnostics()
+	c.Assert(diagnostics.Id, Equals, c.TestName())
+	c.Assert(diagnostics.Interval, Equals, 10*time.Millisecond)
+	c.Assert(diagnostics.ConcurrencyLimit, Equals, uint32(2))
+	c.Assert(diagnostics.LastCycleStart.IsZero(), IsFalse)
+	c.Assert(diagnostics.LastCycleDuration >= 20*time.Millisecond, IsTrue)
+	c.Assert(diagnostics.CycleOverruns >= 1, IsTrue)
+	c.Assert(diagnostics.Entries, HasLen, 2)
+
+	entry := diagnostics.Entries[0]
+	c.Assert(entry.Host, Equals, "host1")
+	c.Assert(entry.Healthy, IsTrue)
+	c.Assert(entry.LastCheck.Error, Equals, "")
+	c.Assert(entry.LastCheck.Latency >= 20*time.Millisecond, IsTrue)
+
+	entry = diagnostics.Entries[1]
+	c.Assert(entry.Host, Equals, "host2")
+	c.Assert(entry.Healthy, IsFalse)
+	c.Assert(entry.LastCheck.Error, Equals, "connection refused")
+	c.Assert(entry.LastCheck.Timestamp.IsZero(), IsFalse)
+}
+
+func (m *HealthManagerSuite) TestCheckIntervals(c *C) {
+	// resolver.
+	resolver, err := discovery.NewStaticResolver(discovery.StaticResolverParams{
+		Id: "resolver",
+		Hosts: discovery.DiscoveryState([]*discovery.HostPort{
+			discovery.NewHostPort("host1", 80, true),
+			discovery.NewHostPort("host2", 80, true),
+		}),
+	})
+	c.Assert(err, NoErr)
+
+	// host2 is always down.
+	var host1Checks, host2Checks int32
+	checker := &MockChecker{
+		checkFunc: func(host string, port int) error {
+			if host == "host1" {
+				atomic.AddInt32(&host1Checks, 1)
+				return nil
+			}
+			atomic.AddInt32(&host2Checks, 1)
+			return fmt.Errorf("connection refused")
+		},
+	}
+
+	params := HealthManagerParams{
+		Id:            c.TestName(),
+		Resolver:      resolver,
+		HealthChecker: checker,
+		UpstreamCheckerAttributes: &hc_pb.UpstreamChecker{
+			RiseCount:  1,
+			FallCount:  1,
+			IntervalMs: 10,
+			Intervals: &hc_pb.CheckIntervals{
+				UnhealthyMs:    100,
+				MaxUnhealthyMs: 10000,
+			},
+		},
+	}
+
+	ctx, cancelFunc := context.WithCancel(context.Background())
+	defer cancelFunc()
+	_, err = NewHealthManager(ctx, params)
+	c.Assert(err, NoErr)
+
+	time.Sleep(500 * time.Millisecond)
+	// host2 is checked at 0, 100ms, 300ms.
+	c.Assert(atomic.LoadInt32(&host2Checks) <= 4, IsTrue)
+	c.Assert(atomic.LoadInt32(&host1Checks) > 10, IsTrue)
+}
+
+func (m *HealthManagerSuite) TestSlowCheck(c *C) {
+	// resolver.
+	resolver, err := discovery.NewStaticResolver(discovery.StaticResolverParams{
+		Id: "resolver",
+		Hosts: discovery.DiscoveryState([]*discovery.HostPort{
+			discovery.NewHostPort("host1", 80, true),
+			discovery.NewHostPort("host2", 80, true),
+		}),
+	})
+	c.Assert(err, NoErr)
+
+	// checks of host2 are stuck after the first one until release is closed.
+	var host1Checks, host2Checks int32
+	var host1Down int32
+	release := make(chan struct{})
+	defer close(release)
+	checker := &MockChecker{
+		checkFunc: func(host string, port int) error {
+			if host == "host1" {
+				atomic.AddInt32(&host1Checks, 1)
+				if atomic.LoadInt32(&host1Down) == 1 {
+					return fmt.Errorf("connection refused")
+				}
+				return nil
+			}
+			if atomic.AddInt32(&host2Checks, 1) > 1 {
+				<-release
+			}
+			return nil
+		},
+	}
+
+	params := HealthManagerParams{
+		Id:            c.TestName(),
+		Resolver:      resolver,
+		HealthChecker: checker,
+		UpstreamCheckerAttributes: &hc_pb.UpstreamChecker{
+			RiseCount:  1,
+			FallCount:  1,
+			IntervalMs: 10,
+		},
+	}
+
+	ctx, cancelFunc := context.WithCancel(context.Background())
+	defer cancelFunc()
+	mng, err := NewHealthManager(ctx, params)
+	c.Assert(err, NoErr)
+
+	select {
+	case state, ok := <-mng.Updates():
+		c.Assert(ok, IsTrue)
+		c.Assert(state.String(), Equals, "[host1:80/true, host2:80/true]")
+	case <-time.After(5 * time.Second):
+		c.Fatal("fails to wait update")
+	}
+
+	// host1 is still checked while check of host2 is stuck.
+	time.Sleep(300 * time.Millisecond)
+	c.Assert(atomic.LoadInt32(&host2Checks), Equals, int32(2))
+	c.Assert(atomic.LoadInt32(&host1Checks) > 10, IsTrue)
+
+	// and changes of its status aren't delayed.
+	atomic.StoreInt32(&host1Down, 1)
+	select {
+	case state, ok := <-mng.Updates():
+		c.Assert(ok, IsTrue)
+		c.Assert(state.String(), Equals, "[host1:80/false, host2:80/true]")
+	case <-time.After(time.Second):
+		c.Fatal("fails to wait update")
+	}
+}
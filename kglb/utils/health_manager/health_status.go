@@ -2,6 +2,9 @@ package health_manager
 
 import (
 	"sync"
+	"time"
+
+	"dropbox/kglb/utils/health_checker"
 )
 
 type healthStatusEntry struct {
@@ -12,24 +15,182 @@ type healthStatusEntry struct {
 	// negative = # of consecutive failed health checks
 	healthCount int
 
+	// weight of the entry in percents reported by weighted health checker.
+	weightPercent uint32
+
+	// result of the latest health check.
+	lastCheck CheckResult
+
+	// flap damping penalty, time of its latest update and flag indicating
+	// that the entry is kept down because of flapping.
+	flapPenalty   float64
+	flapPenaltyTs time.Time
+	suppressed    bool
+
+	// time of the next health check, zero time means the entry should be
+	// checked right away.
+	nextCheck time.Time
+
 	// Mutex to protect fields of the struct.
 	mutex sync.Mutex
 }
 
+// Result of single health check.
+type CheckResult struct {
+	// time when the check was started.
+	Timestamp time.Time `json:"timestamp"`
+	// duration of the check.
+	Latency time.Duration `json:"latency"`
+	// error returned by health checker, empty when the check passed.
+	Error string `json:"error,omitempty"`
+}
+
 // Returns new and initialized entry of healthStatusEntry.
 func NewHealthStatusEntry(initialHealthyState bool) *healthStatusEntry {
 	return &healthStatusEntry{
-		isHealthy: initialHealthyState,
+		isHealthy:     initialHealthyState,
+		weightPercent: health_checker.DefaultWeightPercent,
+	}
+}
+
+// Returns copy of the entry.
+func (h *healthStatusEntry) clone() *healthStatusEntry {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	return &healthStatusEntry{
+		isHealthy:     h.isHealthy,
+		healthCount:   h.healthCount,
+		weightPercent: h.weightPercent,
+		lastCheck:     h.lastCheck,
+		flapPenalty:   h.flapPenalty,
+		flapPenaltyTs: h.flapPenaltyTs,
+		suppressed:    h.suppressed,
+		nextCheck:     h.nextCheck,
 	}
 }
 
 // Getter to access internal isHealthy field. Returns true when current status
-// of the entry is healthy, otherwise false.
+// of the entry is healthy and it's not suppressed because of flapping,
+// otherwise false.
 func (h *healthStatusEntry) IsHealthy() bool {
 	h.mutex.Lock()
 	defer h.mutex.Unlock()
 
-	return h.isHealthy
+	return h.isHealthy && !h.suppressed
+}
+
+// Returns true when the entry is kept down because of flapping.
+func (h *healthStatusEntry) IsSuppressed() bool {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	return h.suppressed
+}
+
+// Returns flap damping penalty of the entry as of its latest update.
+func (h *healthStatusEntry) FlapPenalty() float64 {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	return h.flapPenalty
+}
+
+// Decays flap damping penalty, adds penalty when health status of the entry
+// has been changed and returns true when suppression of the entry has been
+// changed. Penalty is reset when damping is nil (disabled).
+func (h *healthStatusEntry) UpdateFlapPenalty(
+	damping *flapDamping,
+	flapped bool,
+	now time.Time) bool {
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	wasSuppressed := h.suppressed
+	if damping == nil {
+		h.flapPenalty = 0
+		h.flapPenaltyTs = time.Time{}
+		h.suppressed = false
+		return wasSuppressed
+	}
+
+	if !h.flapPenaltyTs.IsZero() {
+		h.flapPenalty = damping.decay(h.flapPenalty, now.Sub(h.flapPenaltyTs))
+	}
+	h.flapPenaltyTs = now
+
+	if flapped {
+		h.flapPenalty += damping.penalty
+		if h.flapPenalty > damping.maxPenalty {
+			h.flapPenalty = damping.maxPenalty
+		}
+	}
+
+	if !h.suppressed && h.flapPenalty >= damping.suppressThreshold {
+		h.suppressed = true
+	} else if h.suppressed && h.flapPenalty < damping.reuseThreshold {
+		h.suppressed = false
+	}
+
+	return wasSuppressed != h.suppressed
+}
+
+// Returns weight of the entry in percents of configured weight.
+func (h *healthStatusEntry) WeightPercent() uint32 {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	return h.weightPercent
+}
+
+// Updates weight of the entry and returns true when it has been changed.
+func (h *healthStatusEntry) UpdateWeightPercent(weightPercent uint32) bool {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if h.weightPercent == weightPercent {
+		return false
+	}
+	h.weightPercent = weightPercent
+	return true
+}
+
+// Returns result of the latest health check. Timestamp is zero when the entry
+// wasn't checked yet.
+func (h *healthStatusEntry) LastCheck() CheckResult {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	return h.lastCheck
+}
+
+// Saves result of the latest health check.
+func (h *healthStatusEntry) SetLastCheck(result CheckResult) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	h.lastCheck = result
+}
+
+// Returns time of the next health check of the entry.
+func (h *healthStatusEntry) NextCheck() time.Time {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	return h.nextCheck
+}
+
+// Schedules next health check of the entry based on its current status.
+func (h *healthStatusEntry) ScheduleNextCheck(
+	now time.Time,
+	intervals *checkIntervals,
+	fallCount int) {
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	h.nextCheck = now.Add(intervals.next(h.isHealthy, h.healthCount, fallCount))
 }
 
 // Updates status of the entry based on latest health check results and returns
@@ -83,5 +244,8 @@ func (h *healthStatusEntry) Equal(entry *healthStatusEntry) bool {
 	h.mutex.Lock()
 	defer h.mutex.Unlock()
 
-	return h.healthCount == entry.healthCount && h.isHealthy == entry.isHealthy
+	return h.healthCount == entry.healthCount &&
+		h.isHealthy == entry.isHealthy &&
+		h.weightPercent == entry.weightPercent &&
+		h.suppressed == entry.suppressed
 }
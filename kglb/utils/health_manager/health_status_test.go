@@ -1,8 +1,11 @@
 package health_manager
 
 import (
+	"time"
+
 	. "gopkg.in/check.v1"
 
+	hc_pb "dropbox/proto/kglb/healthchecker"
 	. "godropbox/gocheck2"
 )
 
@@ -90,3 +93,66 @@ func (m *StatusCheckerSuite) TestConcurrency(c *C) {
 
 	close(stopChan)
 }
+
+func (m *StatusCheckerSuite) TestWeightPercent(c *C) {
+	entry := NewHealthStatusEntry(true)
+	c.Assert(entry.WeightPercent(), Equals, uint32(100))
+
+	c.Assert(entry.UpdateWeightPercent(100), IsFalse)
+	c.Assert(entry.UpdateWeightPercent(50), IsTrue)
+	c.Assert(entry.WeightPercent(), Equals, uint32(50))
+	c.Assert(entry.UpdateWeightPercent(50), IsFalse)
+
+	// weight is the part of entry comparison.
+	c.Assert(entry.Equal(NewHealthStatusEntry(true)), IsFalse)
+}
+
+func (m *StatusCheckerSuite) TestFlapDamping(c *C) {
+	damping := newFlapDamping(&hc_pb.FlapDamping{
+		Enabled:    true,
+		HalfLifeMs: 60000,
+	})
+	c.Assert(damping, NotNil)
+	// reuse threshold * 2^4 since max suppress time is 4 half lifes.
+	c.Assert(damping.maxPenalty, Equals, float64(12000))
+	c.Assert(newFlapDamping(&hc_pb.FlapDamping{}), IsNil)
+
+	now := time.Now()
+	entry := NewHealthStatusEntry(true)
+
+	// single status change is not enough to suppress the entry.
+	c.Assert(entry.UpdateHealthCheckStatus(false, 1, 1), IsTrue)
+	c.Assert(entry.UpdateFlapPenalty(damping, true, now), IsFalse)
+	c.Assert(entry.FlapPenalty(), Equals, float64(1000))
+
+	// penalty decays, so the third change within a half life suppresses it.
+	now = now.Add(10 * time.Second)
+	c.Assert(entry.UpdateHealthCheckStatus(true, 1, 1), IsTrue)
+	c.Assert(entry.UpdateFlapPenalty(damping, true, now), IsFalse)
+	c.Assert(entry.FlapPenalty() < 2000, IsTrue)
+	now = now.Add(10 * time.Second)
+	c.Assert(entry.UpdateHealthCheckStatus(false, 1, 1), IsTrue)
+	c.Assert(entry.UpdateFlapPenalty(damping, true, now), IsTrue)
+	c.Assert(entry.UpdateHealthCheckStatus(true, 1, 1), IsTrue)
+	c.Assert(entry.UpdateFlapPenalty(damping, true, now), IsFalse)
+	c.Assert(entry.IsSuppressed(), IsTrue)
+	c.Assert(entry.IsHealthy(), IsFalse)
+
+	// stable entry is kept down until penalty decays below reuse threshold.
+	now = now.Add(time.Minute)
+	c.Assert(entry.UpdateFlapPenalty(damping, false, now), IsFalse)
+	c.Assert(entry.IsHealthy(), IsFalse)
+	now = now.Add(2 * time.Minute)
+	c.Assert(entry.UpdateFlapPenalty(damping, false, now), IsTrue)
+	c.Assert(entry.IsSuppressed(), IsFalse)
+	c.Assert(entry.IsHealthy(), IsTrue)
+
+	// disabled damping resets suppression.
+	c.Assert(entry.UpdateHealthCheckStatus(false, 1, 1), IsTrue)
+	c.Assert(entry.UpdateFlapPenalty(damping, true, now), IsFalse)
+	c.Assert(entry.UpdateHealthCheckStatus(true, 1, 1), IsTrue)
+	c.Assert(entry.UpdateFlapPenalty(damping, true, now), IsTrue)
+	c.Assert(entry.UpdateFlapPenalty(nil, false, now), IsTrue)
+	c.Assert(entry.IsHealthy(), IsTrue)
+	c.Assert(entry.FlapPenalty(), Equals, float64(0))
+}
@@ -13,3 +13,38 @@ import (
 var healthCheckCounter = v2stats.MustDefineCounter("kglb/control_plane/healthcheck", "setup", "service", "host", "result")
 
 var aliveRatioGauge = v2stats.MustDefineGauge("kglb/control_plane/alive_ratio", "setup", "service")
+
+// Latency of single health check in seconds.
+// Tags:
+// - setup: setup name
+// - service: service name
+// - host: actual host name being health checked
+var healthCheckLatencyHistogram = v2stats.MustDefineHistogram(
+	"kglb/control_plane/healthcheck_latency",
+	[]float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10},
+	"setup", "service", "host")
+
+// The longest delay of health checks of the service (time between the check
+// became due and its completion) per health checking cycle in seconds.
+var healthCheckCycleHistogram = v2stats.MustDefineHistogram(
+	"kglb/control_plane/healthcheck_cycle_duration",
+	[]float64{.01, .05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60},
+	"setup", "service")
+
+// Number of health checking cycles with checks delayed longer than configured
+// interval.
+var healthCheckCycleOverrunCounter = v2stats.MustDefineCounter(
+	"kglb/control_plane/healthcheck_cycle_overrun", "setup", "service")
+
+// Number of upstreams kept down because of flapping.
+var suppressedUpstreamsGauge = v2stats.MustDefineGauge(
+	"kglb/control_plane/suppressed_upstreams", "setup", "service")
+
+// Number of suppressions of flapping upstreams.
+var healthCheckSuppressionCounter = v2stats.MustDefineCounter(
+	"kglb/control_plane/healthcheck_suppression", "setup", "service")
+
+// Number of health checks which results have been shared between health
+// managers instead of performing them.
+var healthCheckSharedCounter = v2stats.MustDefineCounter(
+	"kglb/control_plane/healthcheck_shared")
@@ -0,0 +1,64 @@
+package host_override
+
+import (
+	"strings"
+	"time"
+
+	"godropbox/errors"
+)
+
+type Action string
+
+const (
+	// weight of the host is set to zero, existing connections are kept.
+	Drain Action = "drain"
+	// host is considered unhealthy regardless of health checks.
+	ForceDown Action = "force_down"
+	// host is considered healthy regardless of health checks.
+	ForceUp Action = "force_up"
+)
+
+// Manual override of health status of the host.
+type HostOverride struct {
+	Hostname string `json:"hostname"`
+	// name of balancer the override is applied to, empty value means all
+	// balancers.
+	Balancer string `json:"balancer,omitempty"`
+	Action   Action `json:"action"`
+	// time when the override expires, zero value means no expiration.
+	Expiry time.Time `json:"expiry,omitempty"`
+	// optional human readable reason of the override.
+	Reason string `json:"reason,omitempty"`
+	// time when the override was set.
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Returns true when the override has been expired at provided time.
+func (h *HostOverride) Expired(now time.Time) bool {
+	return !h.Expiry.IsZero() && !now.Before(h.Expiry)
+}
+
+// Validates the override.
+func (h *HostOverride) Validate() error {
+	if strings.TrimSpace(h.Hostname) == "" {
+		return errors.Newf("hostname cannot be empty: %+v", h)
+	}
+
+	switch h.Action {
+	case Drain, ForceDown, ForceUp:
+	default:
+		return errors.Newf("unknown action: %+v", h)
+	}
+
+	return nil
+}
+
+// Returns key of the override, only single override per hostname and balancer
+// is allowed.
+func (h *HostOverride) key() string {
+	return key(h.Hostname, h.Balancer)
+}
+
+func key(hostname, balancer string) string {
+	return hostname + "/" + balancer
+}
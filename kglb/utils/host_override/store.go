@@ -0,0 +1,287 @@
+package host_override
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"dropbox/dlog"
+	"godropbox/errors"
+)
+
+// Thread-safe store of host overrides optionally persisted in json file. It
+// notifies subscribers about every change including expiration of overrides.
+type Store struct {
+	mutex sync.RWMutex
+
+	// path to the file to persist overrides, overrides are kept in memory
+	// only when it's empty.
+	path string
+
+	overrides map[string]HostOverride
+	// channels to notify about changes.
+	subscribers map[chan struct{}]struct{}
+
+	// timer to notify subscribers about the nearest expiration.
+	expiryTimer *time.Timer
+}
+
+// Returns new instance of Store with overrides loaded from provided path
+// (when the file exists).
+func NewStore(path string) (*Store, error) {
+	s := &Store{
+		path:        path,
+		overrides:   make(map[string]HostOverride),
+		subscribers: make(map[chan struct{}]struct{}),
+	}
+
+	if path == "" {
+		return s, nil
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	} else if err != nil {
+		return nil, errors.Wrapf(err, "fails to read host overrides %s: ", path)
+	}
+
+	var overrides []HostOverride
+	if err := json.Unmarshal(data, &overrides); err != nil {
+		return nil, errors.Wrapf(err, "fails to parse host overrides %s: ", path)
+	}
+
+	now := time.Now()
+	for _, override := range overrides {
+		if err := override.Validate(); err != nil {
+			return nil, errors.Wrapf(err, "invalid host override in %s: ", path)
+		}
+		if override.Expired(now) {
+			continue
+		}
+		s.overrides[override.key()] = override
+	}
+	s.scheduleExpiry(now)
+
+	return s, nil
+}
+
+// Sets the override replacing existent one with the same hostname and
+// balancer.
+func (s *Store) Set(override HostOverride) error {
+	if err := override.Validate(); err != nil {
+		return err
+	}
+	if override.CreatedAt.IsZero() {
+		override.CreatedAt = time.Now()
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	prev, existed := s.overrides[override.key()]
+	s.overrides[override.key()] = override
+	if err := s.persist(); err != nil {
+		if existed {
+			s.overrides[override.key()] = prev
+		} else {
+			delete(s.overrides, override.key())
+		}
+		return err
+	}
+
+	dlog.Infof("host override has been set: %+v", override)
+	s.scheduleExpiry(time.Now())
+	s.notify()
+	return nil
+}
+
+// Removes the override of the hostname in the balancer (empty balancer means
+// global override). Returns false when the override doesn't exist.
+func (s *Store) Delete(hostname, balancer string) (bool, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	k := key(hostname, balancer)
+	override, ok := s.overrides[k]
+	if !ok {
+		return false, nil
+	}
+
+	delete(s.overrides, k)
+	if err := s.persist(); err != nil {
+		s.overrides[k] = override
+		return false, err
+	}
+
+	dlog.Infof("host override has been removed: %+v", override)
+	s.scheduleExpiry(time.Now())
+	s.notify()
+	return true, nil
+}
+
+// Returns active override of the host in the balancer. Override scoped by
+// balancer takes precedence over global one.
+func (s *Store) Get(balancer, hostname string, now time.Time) (HostOverride, bool) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	for _, k := range []string{key(hostname, balancer), key(hostname, "")} {
+		if override, ok := s.overrides[k]; ok && !override.Expired(now) {
+			return override, true
+		}
+	}
+
+	return HostOverride{}, false
+}
+
+// Returns all active overrides sorted by hostname and balancer.
+func (s *Store) List() []HostOverride {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	now := time.Now()
+	overrides := make([]HostOverride, 0, len(s.overrides))
+	for _, override := range s.overrides {
+		if !override.Expired(now) {
+			overrides = append(overrides, override)
+		}
+	}
+	sortOverrides(overrides)
+	return overrides
+}
+
+// Returns channel receiving notification about every change of overrides.
+func (s *Store) Subscribe() <-chan struct{} {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	ch := make(chan struct{}, 1)
+	s.subscribers[ch] = struct{}{}
+	return ch
+}
+
+// Removes subscription created by Subscribe call.
+func (s *Store) Unsubscribe(ch <-chan struct{}) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for subscriber := range s.subscribers {
+		if subscriber == ch {
+			delete(s.subscribers, subscriber)
+			return
+		}
+	}
+}
+
+// Stops expiration timer.
+func (s *Store) Close() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.expiryTimer != nil {
+		s.expiryTimer.Stop()
+		s.expiryTimer = nil
+	}
+}
+
+// Notifies subscribers. Should be called under s.mutex.
+func (s *Store) notify() {
+	for ch := range s.subscribers {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Writes active overrides into the file. Should be called under s.mutex.
+func (s *Store) persist() error {
+	if s.path == "" {
+		return nil
+	}
+
+	now := time.Now()
+	overrides := make([]HostOverride, 0, len(s.overrides))
+	for _, override := range s.overrides {
+		if !override.Expired(now) {
+			overrides = append(overrides, override)
+		}
+	}
+	sortOverrides(overrides)
+
+	data, err := json.MarshalIndent(overrides, "", "  ")
+	if err != nil {
+		return errors.Wrapf(err, "fails to serialize host overrides: ")
+	}
+
+	// writing into temporary file first to avoid partially written file.
+	tmpFile, err := ioutil.TempFile(filepath.Dir(s.path), filepath.Base(s.path))
+	if err != nil {
+		return errors.Wrapf(err, "fails to create host overrides file: ")
+	}
+	defer os.Remove(tmpFile.Name())
+
+	if _, err = tmpFile.Write(data); err != nil {
+		tmpFile.Close()
+		return errors.Wrapf(err, "fails to write host overrides: ")
+	}
+	if err = tmpFile.Close(); err != nil {
+		return errors.Wrapf(err, "fails to write host overrides: ")
+	}
+	if err = os.Rename(tmpFile.Name(), s.path); err != nil {
+		return errors.Wrapf(err, "fails to write host overrides: ")
+	}
+	return nil
+}
+
+// Removes expired overrides and schedules notification about the nearest
+// expiration. Should be called under s.mutex.
+func (s *Store) scheduleExpiry(now time.Time) {
+	if s.expiryTimer != nil {
+		s.expiryTimer.Stop()
+		s.expiryTimer = nil
+	}
+
+	var nextExpiry time.Time
+	for k, override := range s.overrides {
+		if override.Expired(now) {
+			dlog.Infof("host override has been expired: %+v", override)
+			delete(s.overrides, k)
+			continue
+		}
+		if !override.Expiry.IsZero() &&
+			(nextExpiry.IsZero() || override.Expiry.Before(nextExpiry)) {
+
+			nextExpiry = override.Expiry
+		}
+	}
+
+	if nextExpiry.IsZero() {
+		return
+	}
+
+	s.expiryTimer = time.AfterFunc(nextExpiry.Sub(now), func() {
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+
+		s.scheduleExpiry(time.Now())
+		if err := s.persist(); err != nil {
+			dlog.Errorf("fails to persist host overrides: %v", err)
+		}
+		s.notify()
+	})
+}
+
+func sortOverrides(overrides []HostOverride) {
+	sort.Slice(overrides, func(i, j int) bool {
+		if overrides[i].Hostname != overrides[j].Hostname {
+			return overrides[i].Hostname < overrides[j].Hostname
+		}
+		return overrides[i].Balancer < overrides[j].Balancer
+	})
+}
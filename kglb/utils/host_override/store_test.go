@@ -0,0 +1,151 @@
+package host_override
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "gopkg.in/check.v1"
+
+	. "godropbox/gocheck2"
+)
+
+type StoreSuite struct{}
+
+var _ = Suite(&StoreSuite{})
+
+func (s *StoreSuite) TestValidate(c *C) {
+	store, err := NewStore("")
+	c.Assert(err, IsNil)
+
+	c.Assert(store.Set(HostOverride{Action: Drain}), NotNil)
+	c.Assert(store.Set(HostOverride{Hostname: "host1", Action: "up"}), NotNil)
+	c.Assert(store.List(), HasLen, 0)
+}
+
+func (s *StoreSuite) TestGet(c *C) {
+	store, err := NewStore("")
+	c.Assert(err, IsNil)
+	updates := store.Subscribe()
+	now := time.Now()
+
+	c.Assert(store.Set(HostOverride{
+		Hostname: "host1",
+		Action:   ForceDown,
+	}), IsNil)
+	select {
+	case <-updates:
+	default:
+		c.Fatal("no notification about the change")
+	}
+
+	// global override.
+	override, ok := store.Get("balancer1", "host1", now)
+	c.Assert(ok, IsTrue)
+	c.Assert(override.Action, Equals, ForceDown)
+	_, ok = store.Get("balancer1", "host2", now)
+	c.Assert(ok, IsFalse)
+
+	// balancer scoped override takes precedence.
+	c.Assert(store.Set(HostOverride{
+		Hostname: "host1",
+		Balancer: "balancer1",
+		Action:   Drain,
+	}), IsNil)
+	override, ok = store.Get("balancer1", "host1", now)
+	c.Assert(ok, IsTrue)
+	c.Assert(override.Action, Equals, Drain)
+	override, ok = store.Get("balancer2", "host1", now)
+	c.Assert(ok, IsTrue)
+	c.Assert(override.Action, Equals, ForceDown)
+
+	list := store.List()
+	c.Assert(list, HasLen, 2)
+	c.Assert(list[0].Balancer, Equals, "")
+	c.Assert(list[1].Balancer, Equals, "balancer1")
+
+	deleted, err := store.Delete("host1", "")
+	c.Assert(err, IsNil)
+	c.Assert(deleted, IsTrue)
+	deleted, err = store.Delete("host1", "")
+	c.Assert(err, IsNil)
+	c.Assert(deleted, IsFalse)
+	_, ok = store.Get("balancer2", "host1", now)
+	c.Assert(ok, IsFalse)
+
+	// unsubscribed channel doesn't receive notifications.
+	<-updates
+	store.Unsubscribe(updates)
+	_, err = store.Delete("host1", "balancer1")
+	c.Assert(err, IsNil)
+	select {
+	case <-updates:
+		c.Fatal("unexpected notification")
+	default:
+	}
+}
+
+func (s *StoreSuite) TestExpiry(c *C) {
+	store, err := NewStore("")
+	c.Assert(err, IsNil)
+	defer store.Close()
+	updates := store.Subscribe()
+
+	c.Assert(store.Set(HostOverride{
+		Hostname: "host1",
+		Action:   ForceUp,
+		Expiry:   time.Now().Add(100 * time.Millisecond),
+	}), IsNil)
+	<-updates
+	c.Assert(store.List(), HasLen, 1)
+
+	// subscribers are notified about expiration.
+	select {
+	case <-updates:
+	case <-time.After(5 * time.Second):
+		c.Fatal("no notification about the expiration")
+	}
+	c.Assert(store.List(), HasLen, 0)
+	_, ok := store.Get("", "host1", time.Now())
+	c.Assert(ok, IsFalse)
+}
+
+func (s *StoreSuite) TestPersist(c *C) {
+	dir, err := ioutil.TempDir("", "host_override")
+	c.Assert(err, IsNil)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "overrides.json")
+
+	store, err := NewStore(path)
+	c.Assert(err, IsNil)
+	c.Assert(store.Set(HostOverride{
+		Hostname: "host1",
+		Balancer: "balancer1",
+		Action:   Drain,
+		Reason:   "maintenance",
+	}), IsNil)
+	c.Assert(store.Set(HostOverride{
+		Hostname: "host2",
+		Action:   ForceDown,
+		Expiry:   time.Now().Add(time.Hour),
+	}), IsNil)
+	store.Close()
+
+	// overrides are restored after restart.
+	store, err = NewStore(path)
+	c.Assert(err, IsNil)
+	defer store.Close()
+	list := store.List()
+	c.Assert(list, HasLen, 2)
+	c.Assert(list[0].Hostname, Equals, "host1")
+	c.Assert(list[0].Action, Equals, Drain)
+	c.Assert(list[0].Reason, Equals, "maintenance")
+	c.Assert(list[1].Hostname, Equals, "host2")
+	c.Assert(list[1].Expiry.IsZero(), IsFalse)
+
+	// broken file.
+	c.Assert(ioutil.WriteFile(path, []byte("{"), 0644), IsNil)
+	_, err = NewStore(path)
+	c.Assert(err, NotNil)
+}
@@ -0,0 +1,192 @@
+package passive_health
+
+import (
+	"net"
+	"sort"
+
+	"dropbox/dlog"
+	"dropbox/vortex2/v2stats"
+)
+
+const (
+	defaultMinPeers               = 3
+	defaultMinConns               = 20
+	defaultInactiveRatioDeviation = 0.5
+	defaultMinNewConns            = 20
+	defaultConsecutiveDetections  = 2
+)
+
+// Connection stats of single real server collected by data plane.
+type Sample struct {
+	// identity of ipvs service of the real (common.GetKeyFromIpvsService),
+	// reals are compared only with peers of the same service.
+	Service string
+	Address net.IP
+	Port    uint32
+
+	ActiveConns uint64
+	InactConns  uint64
+	// number of new connections and outgoing bytes since previous sample.
+	NewConns uint64
+	BytesOut uint64
+}
+
+type DetectorParams struct {
+	// Store where detected outliers are published.
+	Store *Store
+
+	// Min number of reals in the service to compare them. Default is 3.
+	MinPeers int
+	// Min number of connections (active and inactive) of the real to take
+	// its inactive ratio into account. Default is 20.
+	MinConns uint64
+	// Real is outlier when its inactive connections ratio exceeds median ratio
+	// of peers by the value. Default is 0.5.
+	InactiveRatioDeviation float64
+	// Min number of new connections of the real to take zero outgoing bytes
+	// into account. Default is 20.
+	MinNewConns uint64
+	// Number of consecutive samples when real deviates from peers before
+	// flagging it. Default is 2.
+	ConsecutiveDetections int
+}
+
+// Detects reals which connection stats deviate from peers. It's not
+// thread-safe and expected to be called from single goroutine.
+type Detector struct {
+	params DetectorParams
+
+	// number of consecutive detections by upstream key.
+	detections map[string]int
+
+	outliersGauge v2stats.Gauge
+}
+
+func NewDetector(params DetectorParams) *Detector {
+	if params.Store == nil {
+		params.Store = NewStore()
+	}
+	if params.MinPeers <= 0 {
+		params.MinPeers = defaultMinPeers
+	}
+	if params.MinConns == 0 {
+		params.MinConns = defaultMinConns
+	}
+	if params.InactiveRatioDeviation <= 0 {
+		params.InactiveRatioDeviation = defaultInactiveRatioDeviation
+	}
+	if params.MinNewConns == 0 {
+		params.MinNewConns = defaultMinNewConns
+	}
+	if params.ConsecutiveDetections <= 0 {
+		params.ConsecutiveDetections = defaultConsecutiveDetections
+	}
+
+	return &Detector{
+		params:        params,
+		detections:    make(map[string]int),
+		outliersGauge: outliersGauge.Must(),
+	}
+}
+
+// Returns store where detector publishes outliers.
+func (d *Detector) Store() *Store {
+	return d.params.Store
+}
+
+// Processes samples of all reals and publishes outliers into the store.
+func (d *Detector) Process(samples []Sample) {
+	byService := make(map[string][]Sample)
+	for _, sample := range samples {
+		byService[sample.Service] = append(byService[sample.Service], sample)
+	}
+
+	detections := make(map[string]int)
+	outliers := make(map[string]struct{})
+	for service, peers := range byService {
+		for _, sample := range d.detectOutliers(peers) {
+			key := Key(service, sample.Address, sample.Port)
+			detections[key] = d.detections[key] + 1
+			if detections[key] >= d.params.ConsecutiveDetections {
+				outliers[key] = struct{}{}
+				if detections[key] == d.params.ConsecutiveDetections {
+					dlog.Infof(
+						"passive health: %s real is outlier: %+v",
+						key,
+						sample)
+				}
+			}
+		}
+	}
+	d.detections = detections
+
+	d.outliersGauge.Set(float64(len(outliers)))
+	d.params.Store.Set(outliers)
+}
+
+// Returns reals deviating from peers.
+func (d *Detector) detectOutliers(peers []Sample) []Sample {
+	if len(peers) < d.params.MinPeers {
+		return nil
+	}
+
+	inactiveRatios := make([]float64, 0, len(peers))
+	bytesOut := make([]float64, 0, len(peers))
+	for _, sample := range peers {
+		if ratio, ok := d.inactiveRatio(sample); ok {
+			inactiveRatios = append(inactiveRatios, ratio)
+		}
+		if sample.NewConns >= d.params.MinNewConns {
+			bytesOut = append(bytesOut, float64(sample.BytesOut))
+		}
+	}
+	medianInactiveRatio := median(inactiveRatios)
+	medianBytesOut := median(bytesOut)
+
+	var outliers []Sample
+	for _, sample := range peers {
+		ratio, ok := d.inactiveRatio(sample)
+		if ok &&
+			len(inactiveRatios) >= d.params.MinPeers &&
+			ratio-medianInactiveRatio > d.params.InactiveRatioDeviation {
+
+			outliers = append(outliers, sample)
+			continue
+		}
+
+		// real accepts connections without any reply while peers reply.
+		// (outgoing bytes are not accounted by ipvs in tunnel mode, so peers
+		// have zero values as well).
+		if sample.NewConns >= d.params.MinNewConns &&
+			sample.BytesOut == 0 &&
+			len(bytesOut) >= d.params.MinPeers &&
+			medianBytesOut > 0 {
+
+			outliers = append(outliers, sample)
+		}
+	}
+	return outliers
+}
+
+// Returns ratio of inactive connections and false when the real doesn't have
+// enough connections.
+func (d *Detector) inactiveRatio(sample Sample) (float64, bool) {
+	total := sample.ActiveConns + sample.InactConns
+	if total < d.params.MinConns {
+		return 0, false
+	}
+	return float64(sample.InactConns) / float64(total), true
+}
+
+func median(values []float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+	sorted := append([]float64{}, values...)
+	sort.Float64s(sorted)
+	middle := len(sorted) / 2
+	if len(sorted)%2 == 0 {
+		return (sorted[middle-1] + sorted[middle]) / 2
+	}
+	return sorted[middle]
+}
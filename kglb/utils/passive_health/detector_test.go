@@ -0,0 +1,122 @@
+package passive_health
+
+import (
+	"net"
+
+	. "gopkg.in/check.v1"
+
+	. "godropbox/gocheck2"
+)
+
+type DetectorSuite struct{}
+
+var _ = Suite(&DetectorSuite{})
+
+// Returns healthy sample of the real.
+func healthySample(service, address string) Sample {
+	return Sample{
+		Service:     service,
+		Address:     net.ParseIP(address),
+		Port:        443,
+		ActiveConns: 90,
+		InactConns:  10,
+		NewConns:    100,
+		BytesOut:    10000,
+	}
+}
+
+func (s *DetectorSuite) TestInactiveRatio(c *C) {
+	detector := NewDetector(DetectorParams{ConsecutiveDetections: 1})
+	store := detector.Store()
+
+	outlier := healthySample("svc1", "10.0.0.4")
+	outlier.ActiveConns = 10
+	outlier.InactConns = 90
+
+	detector.Process([]Sample{
+		healthySample("svc1", "10.0.0.1"),
+		healthySample("svc1", "10.0.0.2"),
+		healthySample("svc1", "10.0.0.3"),
+		outlier,
+	})
+	c.Assert(store.Outliers(), DeepEquals, []string{"svc1/10.0.0.4:443"})
+	c.Assert(store.IsOutlier("svc1", net.ParseIP("10.0.0.4"), 443), IsTrue)
+	c.Assert(store.IsOutlier("svc1", net.ParseIP("10.0.0.1"), 443), IsFalse)
+
+	// recovered.
+	detector.Process([]Sample{
+		healthySample("svc1", "10.0.0.1"),
+		healthySample("svc1", "10.0.0.2"),
+		healthySample("svc1", "10.0.0.3"),
+		healthySample("svc1", "10.0.0.4"),
+	})
+	c.Assert(store.Outliers(), HasLen, 0)
+}
+
+func (s *DetectorSuite) TestZeroBytesOut(c *C) {
+	detector := NewDetector(DetectorParams{ConsecutiveDetections: 1})
+	store := detector.Store()
+
+	outlier := healthySample("svc1", "10.0.0.4")
+	outlier.BytesOut = 0
+
+	detector.Process([]Sample{
+		healthySample("svc1", "10.0.0.1"),
+		healthySample("svc1", "10.0.0.2"),
+		healthySample("svc1", "10.0.0.3"),
+		outlier,
+	})
+	c.Assert(store.Outliers(), DeepEquals, []string{"svc1/10.0.0.4:443"})
+
+	// zero outgoing bytes on all reals (tunnel mode) is fine.
+	samples := []Sample{
+		healthySample("svc1", "10.0.0.1"),
+		healthySample("svc1", "10.0.0.2"),
+		healthySample("svc1", "10.0.0.3"),
+		healthySample("svc1", "10.0.0.4"),
+	}
+	for i := range samples {
+		samples[i].BytesOut = 0
+	}
+	detector.Process(samples)
+	c.Assert(store.Outliers(), HasLen, 0)
+}
+
+func (s *DetectorSuite) TestPeersAndThresholds(c *C) {
+	detector := NewDetector(DetectorParams{})
+	store := detector.Store()
+
+	outlier := healthySample("svc1", "10.0.0.3")
+	outlier.ActiveConns = 0
+	outlier.InactConns = 100
+	idle := healthySample("svc2", "10.0.0.4")
+	idle.ActiveConns = 0
+	idle.InactConns = 5
+	samples := []Sample{
+		healthySample("svc1", "10.0.0.1"),
+		healthySample("svc1", "10.0.0.2"),
+		outlier,
+		// reals of other services are not peers.
+		healthySample("svc2", "10.0.0.1"),
+		healthySample("svc2", "10.0.0.2"),
+		healthySample("svc2", "10.0.0.3"),
+		// not enough connections to judge.
+		idle,
+	}
+
+	// the real is flagged after second consecutive detection.
+	detector.Process(samples)
+	c.Assert(store.Outliers(), HasLen, 0)
+	detector.Process(samples)
+	c.Assert(store.Outliers(), DeepEquals, []string{"svc1/10.0.0.3:443"})
+
+	// not enough peers.
+	detector.Process(samples[1:3])
+	c.Assert(store.Outliers(), HasLen, 0)
+}
+
+func (s *DetectorSuite) TestMedian(c *C) {
+	c.Assert(median(nil), Equals, float64(0))
+	c.Assert(median([]float64{3, 1, 2}), Equals, float64(2))
+	c.Assert(median([]float64{4, 1, 2, 3}), Equals, float64(2.5))
+}
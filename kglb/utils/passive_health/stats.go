@@ -0,0 +1,8 @@
+package passive_health
+
+import (
+	"dropbox/vortex2/v2stats"
+)
+
+// Number of reals flagged as outliers by passive health detector.
+var outliersGauge = v2stats.MustDefineGauge("kglb/data_plane/passive_health_outliers")
@@ -0,0 +1,108 @@
+package passive_health
+
+import (
+	"net"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// Returns key of the upstream of the service used by Store. Service is
+// identity of ipvs service (common.GetKeyFromIpvsService), so the real flagged
+// in one service doesn't affect other services with the same real.
+func Key(service string, address net.IP, port uint32) string {
+	return service + "/" + net.JoinHostPort(address.String(), strconv.Itoa(int(port)))
+}
+
+// Thread-safe store of upstreams flagged as outliers. It's shared between
+// data plane (producer) and control plane (consumer).
+type Store struct {
+	mutex sync.RWMutex
+
+	outliers map[string]struct{}
+	// channels to notify about changes.
+	subscribers map[chan struct{}]struct{}
+}
+
+func NewStore() *Store {
+	return &Store{
+		outliers:    make(map[string]struct{}),
+		subscribers: make(map[chan struct{}]struct{}),
+	}
+}
+
+// Returns true when upstream of the service is flagged as outlier.
+func (s *Store) IsOutlier(service string, address net.IP, port uint32) bool {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	_, ok := s.outliers[Key(service, address, port)]
+	return ok
+}
+
+// Returns sorted keys of all outliers.
+func (s *Store) Outliers() []string {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	keys := make([]string, 0, len(s.outliers))
+	for key := range s.outliers {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Replaces set of outliers and notifies subscribers when it has been changed.
+func (s *Store) Set(outliers map[string]struct{}) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	changed := len(outliers) != len(s.outliers)
+	if !changed {
+		for key := range outliers {
+			if _, ok := s.outliers[key]; !ok {
+				changed = true
+				break
+			}
+		}
+	}
+	if !changed {
+		return
+	}
+
+	s.outliers = make(map[string]struct{}, len(outliers))
+	for key := range outliers {
+		s.outliers[key] = struct{}{}
+	}
+
+	for ch := range s.subscribers {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Returns channel receiving notification about every change of outliers set.
+func (s *Store) Subscribe() <-chan struct{} {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	ch := make(chan struct{}, 1)
+	s.subscribers[ch] = struct{}{}
+	return ch
+}
+
+// Removes subscription created by Subscribe call.
+func (s *Store) Unsubscribe(ch <-chan struct{}) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for subscriber := range s.subscribers {
+		if subscriber == ch {
+			delete(s.subscribers, subscriber)
+			return
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package passive_health
+
+import (
+	"net"
+
+	. "gopkg.in/check.v1"
+
+	. "godropbox/gocheck2"
+)
+
+type StoreSuite struct{}
+
+var _ = Suite(&StoreSuite{})
+
+func (s *StoreSuite) TestSubscribe(c *C) {
+	store := NewStore()
+	updates := store.Subscribe()
+
+	key := Key("172.0.0.1:80-tcp", net.ParseIP("10.0.0.1"), 80)
+	c.Assert(key, Equals, "172.0.0.1:80-tcp/10.0.0.1:80")
+	c.Assert(
+		Key("[fc00::1]:80-tcp", net.ParseIP("fc00::1"), 80),
+		Equals,
+		"[fc00::1]:80-tcp/[fc00::1]:80")
+
+	store.Set(map[string]struct{}{key: {}})
+	c.Assert(store.IsOutlier("172.0.0.1:80-tcp", net.ParseIP("10.0.0.1"), 80), IsTrue)
+	// the same real of other service.
+	c.Assert(store.IsOutlier("172.0.0.2:80-tcp", net.ParseIP("10.0.0.1"), 80), IsFalse)
+	select {
+	case <-updates:
+	default:
+		c.Fatal("no notification about the change")
+	}
+
+	// the same set doesn't produce notification.
+	store.Set(map[string]struct{}{key: {}})
+	select {
+	case <-updates:
+		c.Fatal("unexpected notification")
+	default:
+	}
+
+	// unsubscribed channel doesn't receive notifications.
+	store.Unsubscribe(updates)
+	store.Set(map[string]struct{}{})
+	c.Assert(store.IsOutlier("172.0.0.1:80-tcp", net.ParseIP("10.0.0.1"), 80), IsFalse)
+	select {
+	case <-updates:
+		c.Fatal("unexpected notification")
+	default:
+	}
+}
@@ -1,12 +1,41 @@
 package main
 
 import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
 	"sync"
 
 	"dropbox/kglb/common"
+	"dropbox/kglb/data_plane"
 	kglb_pb "dropbox/proto/kglb"
 )
 
+// Parses comma separated bgp peers in "asn@address" format.
+func parseBgpPeers(peers string) ([]data_plane.BgpPeerConfig, error) {
+	var result []data_plane.BgpPeerConfig
+	for _, peer := range strings.Split(peers, ",") {
+		parts := strings.Split(strings.TrimSpace(peer), "@")
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid bgp peer, asn@address is expected: %s", peer)
+		}
+		asn, err := strconv.ParseUint(parts[0], 10, 32)
+		if err != nil || asn == 0 {
+			return nil, fmt.Errorf("invalid asn of bgp peer: %s", peer)
+		}
+		address := net.ParseIP(parts[1])
+		if address == nil {
+			return nil, fmt.Errorf("invalid address of bgp peer: %s", peer)
+		}
+		result = append(result, data_plane.BgpPeerConfig{
+			Address: address,
+			Asn:     uint32(asn),
+		})
+	}
+	return result, nil
+}
+
 // Mock Bgp module.
 type NoOpBgpModule struct {
 	mu    sync.Mutex
@@ -19,7 +48,7 @@ func (b *NoOpBgpModule) Init(asn uint32) error {
 
 func (b *NoOpBgpModule) Advertise(config *kglb_pb.BgpRouteAttributes) error {
 	b.mu.Lock()
-	b.mu.Unlock()
+	defer b.mu.Unlock()
 
 	b.state = append(b.state, config)
 	return nil
@@ -27,7 +56,7 @@ func (b *NoOpBgpModule) Advertise(config *kglb_pb.BgpRouteAttributes) error {
 
 func (b *NoOpBgpModule) Withdraw(config *kglb_pb.BgpRouteAttributes) error {
 	b.mu.Lock()
-	b.mu.Unlock()
+	defer b.mu.Unlock()
 
 	for i, cfg := range b.state {
 		if common.BgpRoutingAttributesComparable.Equal(cfg, config) {
@@ -40,9 +69,9 @@ func (b *NoOpBgpModule) Withdraw(config *kglb_pb.BgpRouteAttributes) error {
 
 func (b *NoOpBgpModule) ListPaths() ([]*kglb_pb.BgpRouteAttributes, error) {
 	b.mu.Lock()
-	b.mu.Unlock()
+	defer b.mu.Unlock()
 
-	return b.state, nil
+	return append([]*kglb_pb.BgpRouteAttributes(nil), b.state...), nil
 }
 
 func (b *NoOpBgpModule) IsSessionEstablished() (bool, error) {
@@ -1,6 +1,8 @@
 package main
 
 import (
+	"fmt"
+
 	"github.com/gogo/protobuf/proto"
 	"github.com/golang/protobuf/jsonpb"
 
@@ -9,7 +11,11 @@ import (
 	pb "dropbox/proto/kglb"
 )
 
-type ConfigProvider struct{}
+type ConfigProvider struct {
+	// implementation of ipvs module, features it doesn't support are
+	// rejected.
+	ipvsModule string
+}
 
 func (c *ConfigProvider) Default() interface{} {
 	return &pb.ControlPlaneConfig{}
@@ -25,13 +31,26 @@ func (c *ConfigProvider) Parse(content []byte) (interface{}, error) {
 
 func (c *ConfigProvider) Validate(cfg interface{}) error {
 	config := cfg.(*pb.ControlPlaneConfig)
-	return common.ValidateControlPlaneConfig(config)
+	if err := common.ValidateControlPlaneConfig(config); err != nil {
+		return err
+	}
+	// sync daemons are managed through netlink module only.
+	if config.GetIpvsSync() != nil && c.ipvsModule != ipvsModuleNetlink {
+		return fmt.Errorf(
+			"ipvs_sync is not supported by %s ipvs module", c.ipvsModule)
+	}
+	return nil
 }
 
 func (c *ConfigProvider) Equals(cfg1 interface{}, cfg2 interface{}) bool {
 	return proto.Equal(cfg1.(*pb.ControlPlaneConfig), cfg2.(*pb.ControlPlaneConfig))
 }
 
-func MakeConfigLoader(configPath string) (config_loader.ConfigLoader, error) {
-	return config_loader.NewOneTimeFileLoader(&ConfigProvider{}, configPath)
+func MakeConfigLoader(
+	configPath string,
+	ipvsModule string) (config_loader.ConfigLoader, error) {
+
+	return config_loader.NewOneTimeFileLoader(
+		&ConfigProvider{ipvsModule: ipvsModule},
+		configPath)
 }
@@ -11,6 +11,8 @@ import (
 
 	"github.com/golang/glog"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"dropbox/kglb/utils/health_checker"
 )
 
 func main() {
@@ -23,16 +25,55 @@ func main() {
 		"config",
 		"",
 		"full path to the configuration.")
+
+	flagOverridesPath := flag.String(
+		"overrides_path",
+		"",
+		"full path to the file to persist host overrides (in-memory only when empty).")
+
+	flagIpvsModule := flag.String(
+		"ipvs_module",
+		ipvsModuleLibipvs,
+		"implementation of ipvs module: libipvs or netlink.")
+
+	flagBgpPeers := flag.String(
+		"bgp_peers",
+		"",
+		"comma separated bgp peers in asn@address format, routes are not advertised when empty.")
+
+	flagBgpRouterId := flag.String(
+		"bgp_router_id",
+		"",
+		"bgp identifier (ipv4 address), it's required with -bgp_peers.")
+
+	flagConnCleanupLimit := flag.Int(
+		"conn_cleanup_limit",
+		0,
+		"max number of upstreams flushed by connection cleanup per minute (600 when zero).")
+
+	flagExecConcurrencyLimit := flag.Int(
+		"exec_concurrency_limit",
+		health_checker.DefaultExecConcurrencyLimit,
+		"max number of commands running at the same time by all exec health checkers.")
 	flag.Parse()
 
 	if len(*flagConfigPath) == 0 {
 		glog.Fatal("-config is required path.")
 	}
 
+	health_checker.SetExecConcurrencyLimit(*flagExecConcurrencyLimit)
+
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	mng, err := NewService(ctx, *flagConfigPath)
+	mng, err := NewService(
+		ctx,
+		*flagConfigPath,
+		*flagOverridesPath,
+		*flagIpvsModule,
+		*flagBgpPeers,
+		*flagBgpRouterId,
+		*flagConnCleanupLimit)
 	if err != nil {
 		glog.Fatal(err)
 	}
@@ -49,6 +90,8 @@ func main() {
 
 	mux := http.NewServeMux()
 	mux.Handle("/stats", promhttp.Handler())
+	mux.HandleFunc("/diagnostics", mng.ServeDiagnostics)
+	mux.HandleFunc("/overrides", mng.ServeHostOverrides)
 
 	srv := &http.Server{
 		Addr:           *flagStatusPort,
@@ -2,6 +2,10 @@ package main
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
 	"time"
 
 	"github.com/golang/glog"
@@ -10,6 +14,9 @@ import (
 	"dropbox/kglb/data_plane"
 	"dropbox/kglb/utils/dns_resolver"
 	"dropbox/kglb/utils/fwmark"
+	"dropbox/kglb/utils/health_manager"
+	"dropbox/kglb/utils/host_override"
+	"dropbox/kglb/utils/passive_health"
 	kglb_pb "dropbox/proto/kglb"
 )
 
@@ -18,6 +25,12 @@ const (
 	defaultFwmarkBase = 10000
 )
 
+// implementations of ipvs module.
+const (
+	ipvsModuleLibipvs = "libipvs"
+	ipvsModuleNetlink = "netlink"
+)
+
 var (
 	// dns resolution timeout.
 	maxDnsResolveTime = 1 * time.Minute
@@ -32,11 +45,31 @@ var (
 type Service struct {
 	controlPlaneMng *control_plane.ControlPlaneServicer
 	dataPlaneMng    *data_plane.Manager
+	// (Optional) it's closed after data plane shutdown.
+	bgpSpeaker *data_plane.BgpSpeaker
+	// it's closed after data plane shutdown.
+	l2Announcer *data_plane.L2Announcer
 }
 
-func NewService(ctx context.Context, configPath string) (*Service, error) {
+func NewService(
+	ctx context.Context,
+	configPath string,
+	overridesPath string,
+	ipvsModule string,
+	bgpPeers string,
+	bgpRouterId string,
+	connCleanupLimit int) (*Service, error) {
+
 	s := &Service{}
-	if err := s.initModules(ctx, configPath); err != nil {
+	err := s.initModules(
+		ctx,
+		configPath,
+		overridesPath,
+		ipvsModule,
+		bgpPeers,
+		bgpRouterId,
+		connCleanupLimit)
+	if err != nil {
 		return nil, err
 	}
 
@@ -57,12 +90,47 @@ func (s *Service) Set(state *kglb_pb.DataPlaneState) error {
 }
 
 // Initialize all required modules and control/data planes.
-func (s *Service) initModules(ctx context.Context, configPath string) error {
+func (s *Service) initModules(
+	ctx context.Context,
+	configPath string,
+	overridesPath string,
+	ipvsModule string,
+	bgpPeers string,
+	bgpRouterId string,
+	connCleanupLimit int) error {
+
 	var err error
 
+	// passive health detector shared by data plane (producer of signals) and
+	// control plane (consumer).
+	passiveHealth := passive_health.NewDetector(passive_health.DetectorParams{})
+
 	// initializing data plane related modules.
 	dpModules := data_plane.ManagerModules{
-		Bgp: &NoOpBgpModule{},
+		Bgp:              &NoOpBgpModule{},
+		PassiveHealth:    passiveHealth,
+		ConnCleanup:      data_plane.NewNetfilterConnCleanup(),
+		ConnCleanupLimit: connCleanupLimit,
+	}
+
+	// routes are advertised by embedded speaker when peers are configured.
+	if bgpPeers != "" {
+		peers, err := parseBgpPeers(bgpPeers)
+		if err != nil {
+			return err
+		}
+		routerId := net.ParseIP(bgpRouterId)
+		if routerId == nil {
+			return fmt.Errorf("invalid bgp router id: %s", bgpRouterId)
+		}
+		s.bgpSpeaker, err = data_plane.NewBgpSpeaker(data_plane.BgpSpeakerParams{
+			RouterId: routerId,
+			Peers:    peers,
+		})
+		if err != nil {
+			return err
+		}
+		dpModules.Bgp = s.bgpSpeaker
 	}
 
 	cacheResolver, err := data_plane.NewCacheResolver()
@@ -72,14 +140,42 @@ func (s *Service) initModules(ctx context.Context, configPath string) error {
 
 	dpModules.Resolver = cacheResolver
 
-	if dpModules.Ipvs, err = data_plane.NewIpvsMqLiang(cacheResolver); err != nil {
-		return err
+	// global ipvs timeouts and sync daemons are managed through netlink
+	// module only.
+	var ipvsTimeouts data_plane.IpvsTimeoutsModule
+	switch ipvsModule {
+	case ipvsModuleLibipvs:
+		if dpModules.Ipvs, err = data_plane.NewIpvsMqLiang(cacheResolver); err != nil {
+			return err
+		}
+	case ipvsModuleNetlink:
+		ipvsNetlink, err := data_plane.NewIpvsNetlink(cacheResolver)
+		if err != nil {
+			return err
+		}
+		dpModules.Ipvs = ipvsNetlink
+		ipvsTimeouts = ipvsNetlink
+		dpModules.SyncDaemon = ipvsNetlink
+	default:
+		return fmt.Errorf("unknown ipvs module: %s", ipvsModule)
 	}
 
+	dpModules.KernelSettings = data_plane.NewKernelSettings(
+		data_plane.NewProcSysFs(""),
+		ipvsTimeouts)
+
 	if dpModules.AddressTable, err = data_plane.NewNetlinkAddress(); err != nil {
 		return err
 	}
 
+	// vrrp transports are created for instances of the state only.
+	dpModules.VrrpTransport = data_plane.NewVrrpTransport
+
+	// l2 announcements are refreshed with default interval, announcer
+	// doesn't open socket until l2 routes are configured.
+	s.l2Announcer = data_plane.NewL2Announcer(0)
+	dpModules.L2 = s.l2Announcer
+
 	if s.dataPlaneMng, err = data_plane.NewManager(dpModules); err != nil {
 		return err
 	}
@@ -87,9 +183,16 @@ func (s *Service) initModules(ctx context.Context, configPath string) error {
 	// initializing control plane related modules.
 	cpModules := control_plane.ServicerModules{
 		DataPlaneClient: s,
+		PassiveHealth:   passiveHealth.Store(),
+		CheckScheduler: health_manager.NewCheckScheduler(
+			health_manager.DefaultSharedCheckMaxAge),
+	}
+
+	if cpModules.HostOverrides, err = host_override.NewStore(overridesPath); err != nil {
+		return err
 	}
 
-	if cpModules.ConfigLoader, err = MakeConfigLoader(configPath); err != nil {
+	if cpModules.ConfigLoader, err = MakeConfigLoader(configPath, ipvsModule); err != nil {
 		return err
 	}
 
@@ -113,11 +216,88 @@ func (s *Service) initModules(ctx context.Context, configPath string) error {
 	return nil
 }
 
+// Serves health checking diagnostics of all balancers in json format.
+func (s *Service) ServeDiagnostics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(s.controlPlaneMng.GetDiagnostics()); err != nil {
+		glog.Errorf("Fails to write diagnostics: %v", err)
+	}
+}
+
+// Manages host overrides:
+// GET lists active overrides in json format.
+// POST sets override: hostname, action, balancer (optional, all balancers by
+// default), ttl (optional, duration like 30m) and reason (optional).
+// DELETE removes override: hostname and balancer (optional).
+func (s *Service) ServeHostOverrides(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	switch r.Method {
+	case http.MethodGet:
+		overrides, err := s.controlPlaneMng.ListHostOverrides()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(overrides); err != nil {
+			glog.Errorf("Fails to write host overrides: %v", err)
+		}
+	case http.MethodPost:
+		override := host_override.HostOverride{
+			Hostname: query.Get("hostname"),
+			Balancer: query.Get("balancer"),
+			Action:   host_override.Action(query.Get("action")),
+			Reason:   query.Get("reason"),
+		}
+		if ttl := query.Get("ttl"); ttl != "" {
+			duration, err := time.ParseDuration(ttl)
+			if err != nil || duration <= 0 {
+				http.Error(w, fmt.Sprintf("invalid ttl: %s", ttl), http.StatusBadRequest)
+				return
+			}
+			override.Expiry = time.Now().Add(duration)
+		}
+		if err := override.Validate(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := s.controlPlaneMng.SetHostOverride(override); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		glog.Infof("Host override has been set from %s: %+v", r.RemoteAddr, override)
+	case http.MethodDelete:
+		hostname := query.Get("hostname")
+		deleted, err := s.controlPlaneMng.DeleteHostOverride(
+			hostname,
+			query.Get("balancer"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if !deleted {
+			http.Error(w, "host override not found", http.StatusNotFound)
+			return
+		}
+		glog.Infof("Host override of %s has been removed from %s", hostname, r.RemoteAddr)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
 func (s *Service) Shutdown() error {
 	err := s.dataPlaneMng.Shutdown()
 	if err != nil {
 		glog.Errorf("Fails to shutdown data plane manager: %v", err)
 	}
+	if s.bgpSpeaker != nil {
+		s.bgpSpeaker.Close()
+	}
+	s.l2Announcer.Close()
 	return err
 }
 
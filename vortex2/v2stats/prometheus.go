@@ -30,6 +30,10 @@ func (g Gauge) Clear() {
 	g.gauges.Delete(g.labels)
 }
 
+type Histogram struct {
+	prometheus.Observer
+}
+
 // Generic interface for gauge metric definition.
 type GaugeDefinition struct {
 	tagNames []string
@@ -94,6 +98,35 @@ func (c *CounterDefinition) Must(kvs KV) Counter {
 	return m
 }
 
+// Generic interface for histogram metric definition.
+type HistogramDefinition struct {
+	tagNames   []string
+	histograms *prometheus.HistogramVec
+}
+
+func (h *HistogramDefinition) V(kvs KV) (Histogram, error) {
+	labels := make(prometheus.Labels)
+	for _, tag := range h.tagNames {
+		if val, ok := kvs[tag]; ok {
+			labels[tag] = val
+		}
+	}
+
+	if m, err := h.histograms.GetMetricWith(labels); err != nil {
+		return Histogram{}, err
+	} else {
+		return Histogram{m}, nil
+	}
+}
+
+func (h *HistogramDefinition) Must(kvs KV) Histogram {
+	m, err := h.V(kvs)
+	if err != nil {
+		panic(err)
+	}
+	return m
+}
+
 func DefineGauge(name string, tagNames ...string) (GaugeDefinition, error) {
 	name = strings.Replace(name, "/", ":", -1)
 	opts := prometheus.GaugeOpts{
@@ -154,3 +187,39 @@ func MustDefineCounter(name string, tagNames ...string) CounterDefinition {
 
 	return m
 }
+
+// Defines histogram with provided buckets (upper inclusive bounds).
+// prometheus.DefBuckets are used when buckets are not provided.
+func DefineHistogram(
+	name string,
+	buckets []float64,
+	tagNames ...string) (HistogramDefinition, error) {
+
+	name = strings.Replace(name, "/", ":", -1)
+	opts := prometheus.HistogramOpts{
+		Name:    name,
+		Help:    "TODO",
+		Buckets: buckets,
+	}
+
+	histograms := prometheus.NewHistogramVec(opts, tagNames)
+	prometheus.MustRegister(histograms)
+
+	return HistogramDefinition{
+		tagNames:   tagNames,
+		histograms: histograms,
+	}, nil
+}
+
+func MustDefineHistogram(
+	name string,
+	buckets []float64,
+	tagNames ...string) HistogramDefinition {
+
+	m, err := DefineHistogram(name, buckets, tagNames...)
+	if err != nil {
+		panic(err)
+	}
+
+	return m
+}